@@ -0,0 +1,139 @@
+package cfg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/url"
+	"os"
+	"time"
+)
+
+const (
+	// StaticPrefix is URL path prefix of static files.
+	StaticPrefix = "/static/"
+	// assetHashLength is a length of static file hash in its URL.
+	assetHashLength = 12
+)
+
+// AssetHashes returns short content hashes of static files by their slash-separated paths.
+// They are added to files URLs, so clients load new versions after upgrade.
+func AssetHashes(fsys fs.FS) (map[string]string, error) {
+	hashes := make(map[string]string)
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		h := sha256.Sum256(data)
+		hashes[name] = hex.EncodeToString(h[:])[:assetHashLength]
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("static files hashes: %w", err)
+	}
+	return hashes, nil
+}
+
+// initStatic sets static files with overrides from static_dir and their hashes to t.
+// Default static files are embedded, so the service doesn't require external files.
+// Absent static_dir is not an error, it's reported by a warning.
+func (c *Config) initStatic(t *TemplateEntry) error {
+	c.static = nil
+	if t == nil || c.Settings.APIOnly {
+		return nil
+	}
+	var layers layerFS
+	if dir := c.Settings.StaticDir; dir != "" {
+		if err := isDirectory(dir, "settings.static_dir", nil); err != nil {
+			c.warnings = append(c.warnings, fmt.Sprintf("%v, default static files are used", err))
+		} else {
+			layers = append(layers, os.DirFS(dir))
+		}
+	}
+	if t.Static != nil {
+		layers = append(layers, t.Static)
+	}
+	switch len(layers) {
+	case 0:
+		c.warnings = append(c.warnings, "static files are not available, they are not served")
+		return nil
+	case 1:
+		c.static = layers[0]
+	default:
+		c.static = layers
+	}
+	assets, err := AssetHashes(c.static)
+	if err != nil {
+		return err
+	}
+	t.Assets = assets
+	return nil
+}
+
+// StaticFiles returns static files with overrides and their content hashes.
+// File system is nil if static files are not available.
+func (c *Config) StaticFiles() (fs.FS, map[string]string) {
+	if c.templates == nil || c.static == nil {
+		return nil, nil
+	}
+	return c.static, c.templates.Assets
+}
+
+// Warnings returns not critical configuration problems found during validation.
+func (c *Config) Warnings() []string {
+	return c.warnings
+}
+
+// staticURL returns URL of the static file with its content hash if it's known.
+func (t *TemplateEntry) staticURL(name string) string {
+	u := StaticPrefix + name
+	if h, ok := t.Assets[name]; ok {
+		u += "?" + url.Values{"v": {h}}.Encode()
+	}
+	return u
+}
+
+// plural returns the number n with the unit name in singular or plural form.
+func plural(n int64, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+// relativeTime returns time t relative to now with the largest whole unit,
+// for example "in 2 hours" or "3 minutes ago".
+func relativeTime(t, now time.Time) string {
+	d, format := t.Sub(now), "in %s"
+	if d < 0 {
+		d, format = -d, "%s ago"
+	}
+	var value string
+	switch {
+	case d < time.Minute:
+		value = "less than a minute"
+	case d < time.Hour:
+		value = plural(int64(d/time.Minute), "minute")
+	case d < 48*time.Hour:
+		value = plural(int64(d/time.Hour), "hour")
+	default:
+		value = plural(int64(d/(24*time.Hour)), "day")
+	}
+	return fmt.Sprintf(format, value)
+}
+
+// relTime returns time t relative to the current time, it is relTime template function.
+func relTime(t time.Time) string {
+	return relativeTime(t, time.Now())
+}
+
+// funcs returns template functions, they are shared by base and page templates.
+func (t *TemplateEntry) funcs() template.FuncMap {
+	return template.FuncMap{"staticURL": t.staticURL, "relTime": relTime}
+}
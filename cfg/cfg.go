@@ -3,20 +3,34 @@ package cfg
 // Package cfg contains structures and functions for configurations reading and validation.
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
-	"embed"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
+	"io/fs"
+	"mime"
 	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	_ "github.com/mattn/go-sqlite3" // SQLite3 driver package
 	"github.com/pelletier/go-toml"
+
+	"github.com/z0rr0/send/db"
+	"github.com/z0rr0/send/encrypt"
+	"github.com/z0rr0/send/notify"
 )
 
 // html templates names
@@ -29,50 +43,141 @@ const (
 )
 
 type server struct {
-	Host    string `toml:"host"`
-	Port    int    `toml:"port"`
-	Timeout int    `toml:"timeout"`
-	Secure  bool   `toml:"secure"`
+	Host          string `toml:"host"`
+	Port          int    `toml:"port"`
+	Socket        string `toml:"socket"`
+	Timeout       int    `toml:"timeout"`
+	HeaderTimeout int    `toml:"header_timeout"`
+	MaxConns      int    `toml:"max_conns"`
+	Secure        bool   `toml:"secure"`
+	Cert          string `toml:"cert"`
+	Key           string `toml:"key"`
+	ClientCA      string `toml:"client_ca"`
 }
 
+// ErrNoTemplate is an error when a page template is not configured.
+var ErrNoTemplate = errors.New("template not configured")
+
+// pageTemplates are names of pages templates, all of them are parsed with BaseTpl.
+var pageTemplates = [...]string{IndexTpl, UploadTpl, DownloadTpl, ErrorTpl}
+
 // Storage is storage configuration params struct.
 type Storage struct {
-	File    string `toml:"file"`
-	Dir     string `toml:"dir"`
-	Timeout int    `toml:"timeout"`
-	Size    int64  `toml:"size"`
-	limit   int64
-	Db      *sql.DB
-	m       sync.Mutex
+	File         string   `toml:"file"`
+	Dir          string   `toml:"dir"`
+	Dirs         []string `toml:"dirs"`
+	Placement    string   `toml:"placement"`
+	Timeout      int      `toml:"timeout"`
+	Size         int64    `toml:"size"`
+	DirSize      int64    `toml:"dir_size"`
+	Migrate      bool     `toml:"migrate"`
+	NameSize     int      `toml:"name_size"`
+	NameAttempts int      `toml:"name_attempts"`
+	FileMode     string   `toml:"file_mode"`
+	BufferSize   int      `toml:"buffer_size"`
+	Consistency  bool     `toml:"check_consistency"`
+	HighMark     int      `toml:"high_watermark"`
+	LowMark      int      `toml:"low_watermark"`
+	limit        int64
+	version      int
+	dirs         []*storageDir
+	next         int // next directory index for round-robin placement
+	mode         os.FileMode
+	Db           *sql.DB
+	m            sync.Mutex
+	throttled    bool // uploads are rejected until used storage reaches low watermark
 }
 
 // TemplateEntry is a struct to handle embeded templates parsing.
 type TemplateEntry struct {
-	Dir string
-	Fs  embed.FS
+	Dir    string
+	Fs     fs.FS
+	Static fs.FS             // default static files, they can be overridden by static_dir
+	Assets map[string]string // static files hashes for staticURL template function
 }
 
 // Parse creates a html template.
 func (t *TemplateEntry) Parse(name string) (*template.Template, error) {
-	return template.ParseFS(t.Fs, filepath.Join(t.Dir, BaseTpl), filepath.Join(t.Dir, name))
+	return template.New(BaseTpl).Funcs(t.funcs()).ParseFS(t.Fs, filepath.Join(t.Dir, BaseTpl), filepath.Join(t.Dir, name))
 }
 
 // String returns base info about Storage.
 func (s *Storage) String() string {
-	return fmt.Sprintf("database=%s, files=%s, limit=%d/%d", s.File, s.Dir, s.limit, s.Size)
+	dirs := make([]string, len(s.dirs))
+	for i, d := range s.dirs {
+		dirs[i] = d.String()
+	}
+	return fmt.Sprintf(
+		"database=%s, schema=%d, files=[%s], placement=%s, limit=%d/%d",
+		s.File, s.version, strings.Join(dirs, ", "), s.placement(), s.limit, s.maxSize(),
+	)
+}
+
+// maxSize returns max storage size in bytes.
+func (s *Storage) maxSize() int64 {
+	return s.Size << 20 // megabytes -> bytes
+}
+
+// maxDirSize returns max size of one storage directory in bytes, zero value means no limit.
+func (s *Storage) maxDirSize() int64 {
+	return s.DirSize << 20 // megabytes -> bytes
+}
+
+// UsedPercent returns used part of the storage limit in percents, it's rounded to the nearest integer.
+func (s *Storage) UsedPercent() int {
+	maxSize := s.maxSize()
+	if maxSize <= 0 {
+		return 0
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	return int((s.limit*100 + maxSize/2) / maxSize)
 }
 
 // Limit updates storage limit and returns and error if it's reached.
+// It is same as Place, but the selected directory is not returned.
 func (s *Storage) Limit(v int64) error {
+	_, err := s.Place(v)
+	return err
+}
+
+// Place selects a storage directory for new data with size v and updates the limits.
+// It returns ErrStorageLimit if total limit is reached or ErrNoSpace if all directories limits are reached.
+func (s *Storage) Place(v int64) (string, error) {
 	s.m.Lock()
 	defer s.m.Unlock()
 
 	limit := s.limit + v
-	if limit > s.Size {
-		return fmt.Errorf("storage limit=%d is reached [%v + %v]", s.Size, s.limit, v)
+	if maxSize := s.maxSize(); limit > maxSize {
+		return "", fmt.Errorf("%w: max=%d [%v + %v]", ErrStorageLimit, maxSize, s.limit, v)
+	}
+	d, err := s.selectDir(v)
+	if err != nil {
+		return "", err
 	}
+	d.limit += v
 	s.limit = limit
-	return nil
+	return d.path, nil
+}
+
+// Release returns size v reserved by Place for the directory dir, it is used if new data was not saved.
+func (s *Storage) Release(dir string, v int64) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for _, d := range s.dirs {
+		if d.path == dir {
+			d.limit -= v
+			s.limit -= v
+			return
+		}
+	}
+}
+
+// Reconcile replaces size reserved by Place for the directory dir by really written size.
+// The difference is released or consumed, so the limit is accurate after the data saving.
+func (s *Storage) Reconcile(dir string, reserved, written int64) {
+	s.Release(dir, reserved-written)
 }
 
 // initLimits sets initial limit by current storage state.
@@ -80,45 +185,472 @@ func (s *Storage) initLimits() error {
 	s.m.Lock()
 	defer s.m.Unlock()
 
-	dirEntries, err := os.ReadDir(s.Dir)
-	if err != nil {
-		return err
-	}
-	s.Size = s.Size << 20 // megabytes -> bytes
-	for _, dirEntry := range dirEntries {
-		fileInfo, e := dirEntry.Info()
-		if e != nil {
-			return e
+	s.limit = 0
+	for _, d := range s.dirs {
+		d.limit = 0
+		dirEntries, err := os.ReadDir(d.path)
+		if err != nil {
+			return err
+		}
+		for _, dirEntry := range dirEntries {
+			fileInfo, e := dirEntry.Info()
+			if e != nil {
+				return e
+			}
+			d.limit += fileInfo.Size()
 		}
-		s.limit += fileInfo.Size()
+		s.limit += d.limit
 	}
 	return nil
 }
 
 // Settings is base service settings.
+// Fields with tag reload="true" can be updated by Config.Reload without restart.
 type Settings struct {
-	TTL      int                           `toml:"ttl"`
-	Times    int                           `toml:"times"`
-	Size     int                           `toml:"size"`
-	Salt     string                        `toml:"salt"`
-	GC       int                           `toml:"gc"`
-	PassLen  int                           `toml:"passlen"`
-	Shutdown int                           `toml:"shutdown"`
-	Tpl      map[string]*template.Template `toml:"-"`
+	TTL             int                           `toml:"ttl" reload:"true"`
+	CategoryTTLs    map[string]CategoryTTL        `toml:"category_ttl" reload:"true"`
+	Times           int                           `toml:"times" reload:"true"`
+	MaxTextTimes    int                           `toml:"max_text_times" reload:"true"`
+	MaxFileTimes    int                           `toml:"max_file_times" reload:"true"`
+	Size            int                           `toml:"size"`
+	Salt            string                        `toml:"salt"`
+	Peppers         []Pepper                      `toml:"peppers"`
+	GC              int                           `toml:"gc"`
+	GCBatch         int                           `toml:"gc_batch"`
+	DeleteGrace     int                           `toml:"delete_grace"`
+	VacuumPeriod    int                           `toml:"vacuum_period"`
+	UndoWindow      int                           `toml:"undo_window" reload:"true"`
+	ConfirmWindow   int                           `toml:"confirm_window" reload:"true"`
+	ResumeWindow    int                           `toml:"resume_window" reload:"true"`
+	PassLen         int                           `toml:"passlen" reload:"true"`
+	Shutdown        int                           `toml:"shutdown"`
+	MultipartMemory int                           `toml:"multipart_memory" reload:"true"`
+	TextStream      int                           `toml:"text_stream" reload:"true"`
+	TextInlineLimit int                           `toml:"text_inline_limit" reload:"true"`
+	MaxTextSize     int                           `toml:"max_text_size" reload:"true"`
+	RequirePassword bool                          `toml:"require_password" reload:"true"`
+	AllowMixed      *bool                         `toml:"allow_mixed" reload:"true"`
+	TrimText        bool                          `toml:"trim_text" reload:"true"`
+	DailyQuota      int                           `toml:"daily_upload_quota" reload:"true"`
+	ContentTypes    []string                      `toml:"content_types" reload:"true"`
+	OverrideTypes   []string                      `toml:"override_types" reload:"true"`
+	Namespaces      []string                      `toml:"namespaces" reload:"true"`
+	Robots          string                        `toml:"robots" reload:"true"`
+	Favicon         string                        `toml:"favicon" reload:"true"`
+	RevealExpiry    bool                          `toml:"reveal_expiry" reload:"true"`
+	NormalizeKeys   bool                          `toml:"normalize_keys" reload:"true"`
+	PublicStats     bool                          `toml:"public_stats" reload:"true"`
+	SignedURLs      bool                          `toml:"signed_urls" reload:"true"`
+	SigningKey      string                        `toml:"signing_key" reload:"true"`
+	RequireConfirm  bool                          `toml:"require_confirm" reload:"true"`
+	Manifest        string                        `toml:"manifest" reload:"true"`
+	PublicFileInfo  bool                          `toml:"public_file_info" reload:"true"`
+	StoreOrigin     bool                          `toml:"store_origin" reload:"true"`
+	NameLength      int                           `toml:"max_name_length" reload:"true"`
+	MasterKey       string                        `toml:"master_key"`
+	Cipher          string                        `toml:"cipher"`
+	LazyReencrypt   bool                          `toml:"lazy_reencrypt" reload:"true"`
+	SlowRequest     int                           `toml:"slow_request_threshold" reload:"true"`
+	RedactKeys      bool                          `toml:"redact_keys" reload:"true"`
+	RequestTimeout  int                           `toml:"request_timeout" reload:"true"`
+	TransferTimeout *int                          `toml:"transfer_timeout" reload:"true"`
+	UpdateCheckURL  string                        `toml:"update_check_url"`
+	ExpiryWebhook   string                        `toml:"expiry_webhook"`
+	DevReload       bool                          `toml:"dev_reload"`
+	APIOnly         bool                          `toml:"api_only"`
+	APIRequireHTTPS bool                          `toml:"api_require_https" reload:"true"`
+	Compress        bool                          `toml:"compress"`
+	Metrics         bool                          `toml:"metrics"`
+	SlowKey         int                           `toml:"slow_key_threshold"`
+	SMTPHost        string                        `toml:"smtp_host"`
+	SMTPPort        int                           `toml:"smtp_port"`
+	SMTPUser        string                        `toml:"smtp_user"`
+	SMTPPassword    string                        `toml:"smtp_password"`
+	SMTPFrom        string                        `toml:"smtp_from"`
+	SMTPLimit       int                           `toml:"smtp_limit"`
+	TemplatesDir    string                        `toml:"templates_dir"`
+	TemplateDirs    []string                      `toml:"template_dirs"`
+	StaticDir       string                        `toml:"static_dir"`
+	Headers         map[string]string             `toml:"headers"`
+	Tpl             map[string]*template.Template `toml:"-"`
+}
+
+// defaultTemplatesDir is a directory of html templates for development mode.
+const defaultTemplatesDir = "html"
+
+// templateEntry returns templates from template_dirs with fallback to default ones.
+// They are embedded templates or templates_dir file system directory in development mode,
+// so they can be changed without restart. Otherwise, t is returned.
+func (s *Settings) templateEntry(t *TemplateEntry) (*TemplateEntry, error) {
+	if t == nil || (!s.DevReload && len(s.TemplateDirs) == 0) {
+		return t, nil
+	}
+	var base fs.FS
+	if s.DevReload {
+		dir := s.TemplatesDir
+		if dir == "" {
+			dir = defaultTemplatesDir
+		}
+		base = os.DirFS(dir)
+	} else {
+		sub, err := fs.Sub(t.Fs, t.Dir)
+		if err != nil {
+			return nil, fmt.Errorf("default templates: %w", err)
+		}
+		base = sub
+	}
+	layers := make(layerFS, 0, len(s.TemplateDirs)+1)
+	for _, dir := range s.TemplateDirs {
+		layers = append(layers, os.DirFS(dir))
+	}
+	layers = append(layers, base)
+	return &TemplateEntry{Dir: ".", Fs: layers, Static: t.Static, Assets: t.Assets}, nil
+}
+
+// parseTemplates returns parsed html templates, they are not used in API-only mode.
+func (s *Settings) parseTemplates(t *TemplateEntry) (map[string]*template.Template, error) {
+	if s.APIOnly {
+		return nil, nil
+	}
+	entry, err := s.templateEntry(t)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTemplates(entry)
+}
+
+// Template returns parsed html template by its name or ErrNoTemplate if it's absent.
+func (s *Settings) Template(name string) (*template.Template, error) {
+	tpl, ok := s.Tpl[name]
+	if !ok || tpl == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoTemplate, name)
+	}
+	return tpl, nil
+}
+
+// defaultRobots is robots.txt content which disallows indexing of all pages.
+const defaultRobots = "User-agent: *\nDisallow: /\n"
+
+// RobotsTxt returns robots.txt content, everything is disallowed by default.
+func (s *Settings) RobotsTxt() string {
+	if s.Robots == "" {
+		return defaultRobots
+	}
+	return s.Robots
+}
+
+// IsMixedAllowed returns true if one upload can contain both text and file, it's allowed by default.
+func (s *Settings) IsMixedAllowed() bool {
+	return s.AllowMixed == nil || *s.AllowMixed
+}
+
+// OriginHash returns hex hash of the uploader's IP address or user agent with the salt,
+// plaintext values are never stored.
+func (s *Settings) OriginHash(value string) string {
+	return hex.EncodeToString(encrypt.Hash([]byte(value + s.Salt)))
+}
+
+// defaultNameLength is max length of uploaded file name if it's not set.
+const defaultNameLength = 255
+
+// MaxNameLength returns max length of uploaded file name in characters.
+func (s *Settings) MaxNameLength() int {
+	if s.NameLength == 0 {
+		return defaultNameLength
+	}
+	return s.NameLength
+}
+
+// TextStreamBytes returns max size of a text file part which is loaded to memory, bigger ones are streamed.
+func (s *Settings) TextStreamBytes() int64 {
+	return int64(s.TextStream) << 10
+}
+
+// defaultMaxTextSize is max size (Kb) of an uploaded text if it's not set.
+const defaultMaxTextSize = 10240
+
+// MaxTextBytes returns max size of the uploaded text, it's the limit of texts streamed to files too.
+func (s *Settings) MaxTextBytes() int64 {
+	if s.MaxTextSize == 0 {
+		return defaultMaxTextSize << 10
+	}
+	return int64(s.MaxTextSize) << 10
+}
+
+// IsInlineText returns true if the text of size bytes is stored in the database,
+// bigger one is encrypted to a file. All texts are inline if settings.text_inline_limit is not set.
+func (s *Settings) IsInlineText(size int64) bool {
+	return s.TextInlineLimit == 0 || size <= int64(s.TextInlineLimit)<<10
+}
+
+// IsAllowedType returns true if the file content type is allowed for uploading.
+// Empty content_types setting allows any file.
+func (s *Settings) IsAllowedType(contentType string) bool {
+	if len(s.ContentTypes) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, ct := range s.ContentTypes {
+		if strings.EqualFold(ct, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// OverrideType validates a content type from a user and returns its normalized value.
+// The type can be used instead of the uploaded file one only if it's in override_types setting.
+func (s *Settings) OverrideType(contentType string) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", fmt.Errorf("failed content type %q: %w", contentType, err)
+	}
+	for _, ct := range s.OverrideTypes {
+		if strings.EqualFold(ct, mediaType) {
+			return mime.FormatMediaType(mediaType, params), nil
+		}
+	}
+	return "", fmt.Errorf("content type %q can not be overridden", mediaType)
+}
+
+// IsLoggedRequest returns true if a finished request with duration d and response status code
+// should be logged. All requests are logged if slow_request_threshold is not set,
+// otherwise only slow ones and server errors.
+func (s *Settings) IsLoggedRequest(d time.Duration, code int) bool {
+	if s.SlowRequest == 0 || code >= http.StatusInternalServerError {
+		return true
+	}
+	return d >= time.Duration(s.SlowRequest)*time.Millisecond
+}
+
+// redactedKey is a placeholder of item keys in logged URLs.
+const redactedKey = ":key"
+
+// LogURL returns the request URL for logs. If redact_keys is set, item keys in the path are
+// replaced by a placeholder and the query is removed, requests are still correlated by their IDs.
+func (s *Settings) LogURL(u *url.URL) string {
+	if !s.RedactKeys {
+		return u.String()
+	}
+	segments := strings.Split(u.Path, "/")
+	for i, segment := range segments {
+		if _, err := uuid.Parse(segment); err == nil {
+			segments[i] = redactedKey
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// UndoPeriod returns a period during which the last reader can read a consumed item again.
+func (s *Settings) UndoPeriod() time.Duration {
+	return time.Duration(s.UndoWindow) * time.Second
+}
+
+// ResumePeriod returns a period during which an interrupted file download can be resumed
+// without a new attempt, the attempt is used only after the successful download.
+func (s *Settings) ResumePeriod() time.Duration {
+	return time.Duration(s.ResumeWindow) * time.Second
+}
+
+// TextTimes returns max number of text reads, it's settings.times if max_text_times is not set.
+func (s *Settings) TextTimes() int {
+	if s.MaxTextTimes > 0 {
+		return s.MaxTextTimes
+	}
+	return s.Times
+}
+
+// FileTimes returns max number of file downloads, it's settings.times if max_file_times is not set.
+func (s *Settings) FileTimes() int {
+	if s.MaxFileTimes > 0 {
+		return s.MaxFileTimes
+	}
+	return s.Times
+}
+
+// ConfirmPeriod returns a period to confirm a pending upload, zero value disables pending uploads.
+func (s *Settings) ConfirmPeriod() time.Duration {
+	return time.Duration(s.ConfirmWindow) * time.Second
+}
+
+// SlowKeyDuration returns a duration of password key derivation after which it is logged.
+func (s *Settings) SlowKeyDuration() time.Duration {
+	return time.Duration(s.SlowKey) * time.Millisecond
+}
+
+// transferPaths are paths of requests with files uploading or downloading.
+var transferPaths = map[string]bool{"/file": true, "/upload": true, "/api/upload": true}
+
+// HandlingTimeout returns a timeout of the request handling context by its path.
+// Files transfers use transfer_timeout, explicit zero value means no limit for them.
+// Other requests use request_timeout. Server timeout is used if a value is not set.
+func (s *Settings) HandlingTimeout(path string, server time.Duration) time.Duration {
+	if transferPaths[path] {
+		if s.TransferTimeout == nil {
+			return server
+		}
+		return time.Duration(*s.TransferTimeout) * time.Second
+	}
+	if s.RequestTimeout > 0 {
+		return time.Duration(s.RequestTimeout) * time.Second
+	}
+	return server
+}
+
+// MultipartMemoryBytes returns max size of multipart form data in memory in bytes.
+func (s *Settings) MultipartMemoryBytes() int64 {
+	return int64(s.MultipartMemory) << 20
+}
+
+// isValid checks that settings values are correct.
+func (s *Settings) isValid() error {
+	v := &ValidationError{}
+	v.add(isGreaterThanZero(s.TTL, "settings.ttl", nil))
+	v.add(isGreaterThanZero(s.Times, "settings.times", nil))
+	v.add(isNotNegative(s.MaxTextTimes, "settings.max_text_times", nil))
+	v.add(isNotNegative(s.MaxFileTimes, "settings.max_file_times", nil))
+	v.add(isGreaterThanZero(s.Size, "settings.size", nil))
+	v.add(isGreaterThanZero(s.GC, "settings.gc", nil))
+	v.add(isGreaterThanZero(s.GCBatch, "settings.gc_batch", nil))
+	v.add(isNotNegative(s.DeleteGrace, "settings.delete_grace", nil))
+	v.add(isNotNegative(s.VacuumPeriod, "settings.vacuum_period", nil))
+	v.add(isNotNegative(s.UndoWindow, "settings.undo_window", nil))
+	v.add(isNotNegative(s.ConfirmWindow, "settings.confirm_window", nil))
+	v.add(isNotNegative(s.ResumeWindow, "settings.resume_window", nil))
+	v.add(isGreaterThanZero(s.PassLen, "settings.passlen", nil))
+	v.add(isGreaterThanZero(s.Shutdown, "settings.shutdown", nil))
+	v.add(isGreaterThanZero(s.MultipartMemory, "settings.multipart_memory", nil))
+	v.add(isGreaterThanZero(s.TextStream, "settings.text_stream", nil))
+	v.add(isNotNegative(s.TextInlineLimit, "settings.text_inline_limit", nil))
+	v.add(isNotNegative(s.MaxTextSize, "settings.max_text_size", nil))
+	v.add(isNotNegative(s.SlowRequest, "settings.slow_request_threshold", nil))
+	v.add(isNotNegative(s.RequestTimeout, "settings.request_timeout", nil))
+	if s.TransferTimeout != nil {
+		v.add(isNotNegative(*s.TransferTimeout, "settings.transfer_timeout", nil))
+	}
+	v.add(isNotNegative(s.NameLength, "settings.max_name_length", nil))
+	v.add(isNotNegative(s.SlowKey, "settings.slow_key_threshold", nil))
+	v.add(isNotNegative(s.DailyQuota, "settings.daily_upload_quota", nil))
+	v.add(isFileOrEmpty(s.Favicon, "settings.favicon", nil))
+	v.add(isFileOrEmpty(s.Manifest, "settings.manifest", nil))
+	v.add(isURLOrEmpty(s.ExpiryWebhook, "settings.expiry_webhook", nil))
+	v.add(isValidPeppers(s.Peppers, nil))
+	v.add(isValidCategoryTTL(s.CategoryTTLs, s.TTL, nil))
+	v.add(isValidNamespaces(s.Namespaces, nil))
+	if !encrypt.IsSuite(s.Cipher) {
+		v.add(fmt.Errorf("settings.cipher=%s is unknown, supported: %s, %s or empty", s.Cipher, encrypt.SuiteAESGCM, encrypt.SuiteChaCha20))
+	}
+	if s.LazyReencrypt && s.Cipher == encrypt.SuiteLegacy {
+		v.add(errors.New("settings.lazy_reencrypt requires not empty settings.cipher"))
+	}
+	for _, dir := range s.TemplateDirs {
+		v.add(isDirectory(dir, "settings.template_dirs", nil))
+	}
+	if s.SMTPHost != "" {
+		v.add(isGreaterThanZero(s.SMTPPort, "settings.smtp_port", nil))
+		v.add(isGreaterThanZero(s.SMTPLimit, "settings.smtp_limit", nil))
+		if _, err := notify.ParseAddress(s.SMTPFrom); err != nil {
+			v.add(fmt.Errorf("settings.smtp_from: %w", err))
+		}
+	}
+	return v.err()
+}
+
+// IsNotifyEnabled returns true if SMTP server is configured for recipients notifications.
+func (s *Settings) IsNotifyEnabled() bool {
+	return s.SMTPHost != ""
 }
 
 // Config is a main configuration structure.
 type Config struct {
-	Server   server   `toml:"server"`
-	Storage  Storage  `toml:"Storage"`
-	Settings Settings `toml:"settings"`
+	Server    server   `toml:"server"`
+	Storage   Storage  `toml:"Storage"`
+	Settings  Settings `toml:"settings"`
+	current   *Settings
+	templates *TemplateEntry
+	static    fs.FS
+	warnings  []string
+	m         sync.RWMutex
+}
+
+// CurrentSettings returns actual settings, they can be updated by Reload.
+func (c *Config) CurrentSettings() *Settings {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.current
 }
 
-// Addr returns service's net address.
+// Addr returns service's net address, it has "unix:" prefix for the unix socket.
 func (c *Config) Addr() string {
+	if c.Server.Socket != "" {
+		return "unix:" + c.Server.Socket
+	}
 	return net.JoinHostPort(c.Server.Host, fmt.Sprint(c.Server.Port))
 }
 
+// ClientAuth returns true if API requests require a verified client certificate.
+func (c *Config) ClientAuth() bool {
+	return c.Server.ClientCA != ""
+}
+
+// TLS returns TLS configuration if server certificate is set, otherwise nil.
+// If client CA is configured, then client certificates are requested and verified,
+// but only API requests require them, so it should be additionally checked by handlers.
+func (c *Config) TLS() (*tls.Config, error) {
+	if c.Server.Cert == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.Server.Cert, c.Server.Key)
+	if err != nil {
+		return nil, fmt.Errorf("load server certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if !c.ClientAuth() {
+		return tlsConfig, nil
+	}
+	data, err := os.ReadFile(c.Server.ClientCA)
+	if err != nil {
+		return nil, fmt.Errorf("read client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates in client CA file %s", c.Server.ClientCA)
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	return tlsConfig, nil
+}
+
+// ReloadTemplates parses html templates again in development mode, otherwise it does nothing.
+// Templates are updated only if all of them are valid, so current ones are kept during editing.
+func (c *Config) ReloadTemplates() error {
+	if !c.Settings.DevReload || c.Settings.APIOnly {
+		return nil
+	}
+	tpl, err := ParseTemplates(c.templates)
+	if err != nil {
+		return fmt.Errorf("reload templates: %w", err)
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	settings := *c.current
+	settings.Tpl = tpl
+	c.current = &settings
+	return nil
+}
+
+// Mailer returns a new notifications sender, it is nil if SMTP server is not configured.
+func (c *Config) Mailer() *notify.Mailer {
+	s := &c.Settings
+	if !s.IsNotifyEnabled() {
+		return nil
+	}
+	return notify.New(s.SMTPHost, s.SMTPPort, s.SMTPUser, s.SMTPPassword, s.SMTPFrom, s.SMTPLimit)
+}
+
 // Close frees resources.
 func (c *Config) Close() error {
 	return c.Storage.Db.Close()
@@ -129,11 +661,29 @@ func (c *Config) Timeout() time.Duration {
 	return time.Duration(c.Server.Timeout) * time.Second
 }
 
+// HeaderTimeout is a timeout to read request headers, it is server timeout if not set.
+func (c *Config) HeaderTimeout() time.Duration {
+	if c.Server.HeaderTimeout == 0 {
+		return c.Timeout()
+	}
+	return time.Duration(c.Server.HeaderTimeout) * time.Second
+}
+
 // GCPeriod is gc period in seconds.
 func (c *Config) GCPeriod() time.Duration {
 	return time.Duration(c.Settings.GC) * time.Second
 }
 
+// GracePeriod is a delay of physical deletion of not available items.
+func (c *Config) GracePeriod() time.Duration {
+	return time.Duration(c.Settings.DeleteGrace) * time.Second
+}
+
+// VacuumPeriod is a period of database vacuum, zero value disables it.
+func (c *Config) VacuumPeriod() time.Duration {
+	return time.Duration(c.Settings.VacuumPeriod) * time.Second
+}
+
 // DbPeriod is gc database period in seconds.
 func (c *Config) DbPeriod() time.Duration {
 	return time.Duration(c.Storage.Timeout) * time.Second
@@ -144,9 +694,44 @@ func (c *Config) MaxFileSize() int {
 	return c.Settings.Size << 20
 }
 
-// Secret returns string with salt.
-func (c *Config) Secret(p string) string {
-	return p + c.Settings.Salt
+// master key sources prefixes
+const (
+	masterKeyEnv  = "env:"
+	masterKeyFile = "file:"
+)
+
+// MasterKey reads the server master key from an environment variable ("env:NAME")
+// or a file ("file:/path/to/key"), it returns nil if the key is not configured.
+// The key is a SHA-256 hash of the source value, so it should not be logged.
+func (c *Config) MasterKey() ([]byte, error) {
+	var (
+		source = c.Settings.MasterKey
+		value  string
+	)
+	switch {
+	case source == "":
+		return nil, nil
+	case strings.HasPrefix(source, masterKeyEnv):
+		name := strings.TrimPrefix(source, masterKeyEnv)
+		value = os.Getenv(name)
+		if value == "" {
+			return nil, fmt.Errorf("empty master key environment variable %s", name)
+		}
+	case strings.HasPrefix(source, masterKeyFile):
+		name := strings.TrimPrefix(source, masterKeyFile)
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("read master key file: %w", err)
+		}
+		value = strings.TrimSpace(string(data))
+		if value == "" {
+			return nil, fmt.Errorf("empty master key file %s", name)
+		}
+	default:
+		return nil, errors.New("settings.master_key should have prefix env: or file:")
+	}
+	key := sha256.Sum256([]byte(value))
+	return key[:], nil
 }
 
 // Shutdown is shutdown timeout.
@@ -154,43 +739,53 @@ func (c *Config) Shutdown() time.Duration {
 	return time.Duration(c.Settings.Shutdown) * time.Second
 }
 
-// isValid checks the Settings are valid.
-func (c *Config) isValid(t *TemplateEntry) error {
-	const (
-		userReadWrite  os.FileMode = 0600
-		userReadSearch os.FileMode = 0500
-	)
-	tpl, err := parseTemplates(t)
+// initTemplates sets static files and parses html templates.
+func (c *Config) initTemplates(t *TemplateEntry) error {
+	err := c.initStatic(t)
 	if err != nil {
 		return err
 	}
-	c.Settings.Tpl = tpl
-
-	fullPath, err := checkDirectory(c.Storage.Dir, userReadWrite)
+	tpl, err := c.Settings.parseTemplates(t)
 	if err != nil {
 		return err
 	}
-	c.Storage.Dir = fullPath
-	err = c.Storage.initLimits()
-	if err != nil {
-		return err
-	}
-
-	err = isGreaterThanZero(c.Storage.Timeout, "Storage.timeout", err)
-	err = isGreaterThanZeroInt64(c.Storage.Size, "Storage.size", err)
-	err = isGreaterThanZero(c.Server.Timeout, "server.timeout", err)
-	err = isGreaterThanZero(c.Server.Port, "server.port", err)
-	err = isGreaterThanZero(c.Settings.TTL, "settings.ttl", err)
-	err = isGreaterThanZero(c.Settings.Times, "settings.times", err)
-	err = isGreaterThanZero(c.Settings.Size, "settings.size", err)
-	err = isGreaterThanZero(c.Settings.GC, "settings.gc", err)
-	err = isGreaterThanZero(c.Settings.PassLen, "settings.gc", err)
-	err = isGreaterThanZero(c.Settings.Shutdown, "settings.shutdown", err)
+	c.Settings.Tpl = tpl
+	c.templates, err = c.Settings.templateEntry(t)
 	return err
 }
 
-// New returns new configuration.
-func New(filename string, t *TemplateEntry) (*Config, error) {
+// isValid checks all configuration parameters, it returns ValidationError with all found problems.
+func (c *Config) isValid(t *TemplateEntry) error {
+	v := &ValidationError{}
+	v.add(c.initTemplates(t))
+	v.add(c.Storage.init())
+	v.add(isGreaterThanZero(c.Storage.Timeout, "Storage.timeout", nil))
+	v.add(isGreaterThanZeroInt64(c.Storage.Size, "Storage.size", nil))
+	v.add(isGreaterThanZero(c.Storage.NameAttempts, "Storage.name_attempts", nil))
+	v.add(isNotNegative(c.Storage.BufferSize, "Storage.buffer_size", nil))
+	v.add(isValidWatermarks(c.Storage.HighMark, c.Storage.LowMark, nil))
+	if c.Storage.NameSize < encrypt.MinFileNameSize {
+		v.add(fmt.Errorf("Storage.name_size=%d should not be less than %d", c.Storage.NameSize, encrypt.MinFileNameSize))
+	}
+	v.add(isGreaterThanZero(c.Server.Timeout, "server.timeout", nil))
+	if c.Server.Socket == "" {
+		v.add(isGreaterThanZero(c.Server.Port, "server.port", nil))
+	}
+	v.add(isValidSocket(c.Server.Socket, nil))
+	v.add(isNotNegative(c.Server.HeaderTimeout, "server.header_timeout", nil))
+	v.add(isNotNegative(c.Server.MaxConns, "server.max_conns", nil))
+	if (c.Server.Cert == "") != (c.Server.Key == "") {
+		v.add(errors.New("server.cert and server.key should be set together"))
+	}
+	if c.ClientAuth() && c.Server.Cert == "" {
+		v.add(errors.New("server.client_ca requires server.cert and server.key"))
+	}
+	v.add(c.Settings.isValid())
+	return v.err()
+}
+
+// read reads and parses configuration file without validation.
+func read(filename string) (*Config, error) {
 	fullPath, err := filepath.Abs(strings.Trim(filename, " "))
 	if err != nil {
 		return nil, fmt.Errorf("config file: %w", err)
@@ -208,6 +803,48 @@ func New(filename string, t *TemplateEntry) (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("config parsing: %w", err)
 	}
+	c.setDefaults()
+	return c, nil
+}
+
+// default values of optional parameters, they keep the behavior of configurations without them
+const (
+	defaultGCBatch = 500
+	// defaultMultipartMemory is net/http default limit of form data in memory (Mb)
+	defaultMultipartMemory = 32
+	// defaultNameSize is a number of random bytes of storage file names
+	defaultNameSize = 64
+	// defaultNameAttempts is a number of attempts to create a storage file with unique name
+	defaultNameAttempts = 10
+	// defaultTextStream is max size of a text part loaded to memory (Kb)
+	defaultTextStream = 1024
+)
+
+// setDefaults sets default values of not configured (zero) optional parameters.
+func (c *Config) setDefaults() {
+	if c.Settings.GCBatch == 0 {
+		c.Settings.GCBatch = defaultGCBatch
+	}
+	if c.Settings.MultipartMemory == 0 {
+		c.Settings.MultipartMemory = defaultMultipartMemory
+	}
+	if c.Storage.NameSize == 0 {
+		c.Storage.NameSize = defaultNameSize
+	}
+	if c.Storage.NameAttempts == 0 {
+		c.Storage.NameAttempts = defaultNameAttempts
+	}
+	if c.Settings.TextStream == 0 {
+		c.Settings.TextStream = defaultTextStream
+	}
+}
+
+// New returns new configuration.
+func New(filename string, t *TemplateEntry) (*Config, error) {
+	c, err := read(filename)
+	if err != nil {
+		return nil, err
+	}
 	err = c.isValid(t)
 	if err != nil {
 		return nil, fmt.Errorf("config validation: %w", err)
@@ -217,6 +854,21 @@ func New(filename string, t *TemplateEntry) (*Config, error) {
 		return nil, fmt.Errorf("db file: %w", err)
 	}
 	c.Storage.Db = database
+	settings := c.Settings
+	c.current = &settings
+	ctx, cancel := context.WithTimeout(context.Background(), c.DbPeriod())
+	defer cancel()
+	if c.Storage.Migrate {
+		c.Storage.version, err = db.Migrate(ctx, database)
+		if err != nil {
+			return nil, fmt.Errorf("db migration: %w", err)
+		}
+		return c, nil
+	}
+	c.Storage.version, err = db.CheckSchema(ctx, database)
+	if err != nil {
+		return nil, fmt.Errorf("db schema: %w", err)
+	}
 	return c, nil
 }
 
@@ -231,6 +883,62 @@ func isGreaterThanZero(x int, name string, err error) error {
 	return nil
 }
 
+// isNotNegative returns error if err is already error or x is less than 0.
+func isNotNegative(x int, name string, err error) error {
+	if err != nil {
+		return err
+	}
+	if x < 0 {
+		return fmt.Errorf("%s=%d should not be negative", name, x)
+	}
+	return nil
+}
+
+// isFileOrEmpty checks that name is empty or a regular file.
+func isFileOrEmpty(name, param string, err error) error {
+	if err != nil || name == "" {
+		return err
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return fmt.Errorf("%s: %w", param, err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s=%s is not a regular file", param, name)
+	}
+	return nil
+}
+
+// isDirectory returns error if err is already error or name is not a directory.
+func isDirectory(name, param string, err error) error {
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return fmt.Errorf("%s: %w", param, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s=%s is not a directory", param, name)
+	}
+	return nil
+}
+
+// isURLOrEmpty returns error if err is already error or not empty value is not HTTP(S) URL.
+func isURLOrEmpty(value, param string, err error) error {
+	if err != nil || value == "" {
+		return err
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("%s: %w", param, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s=%s is not HTTP URL", param, value)
+	}
+	return nil
+}
+
 // isGreaterThanZeroInt64 is same as isGreaterThanZero but for int64.
 // We wait go generics :(
 func isGreaterThanZeroInt64(x int64, name string, err error) error {
@@ -262,14 +970,15 @@ func checkDirectory(name string, mode os.FileMode) (string, error) {
 	return fullPath, nil
 }
 
-func parseTemplates(t *TemplateEntry) (map[string]*template.Template, error) {
+// ParseTemplates parses and validates all pages templates, every page extends the base template.
+// It is the only way to load templates, so the service and tests use the same set.
+func ParseTemplates(t *TemplateEntry) (map[string]*template.Template, error) {
 	if t == nil {
 		// not configured embeded templates
 		return nil, nil
 	}
-	templates := []string{IndexTpl, UploadTpl, DownloadTpl, ErrorTpl}
-	templateMap := make(map[string]*template.Template)
-	for _, name := range templates {
+	templateMap := make(map[string]*template.Template, len(pageTemplates))
+	for _, name := range pageTemplates {
 		tpl, err := t.Parse(name)
 		if err != nil {
 			return nil, fmt.Errorf("failed parse template %s: %w", name, err)
@@ -1,9 +1,15 @@
 package cfg
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"testing/fstest"
 	"time"
 )
 
@@ -53,7 +59,814 @@ func TestConfig(t *testing.T) {
 	if timeout := c.Shutdown(); timeout != 5*time.Second {
 		t.Errorf("failed shutdown: %v", timeout)
 	}
-	if secret := c.Secret("xyz"); secret != "xyzabc" {
+	if secret := c.Secret("xyz", time.Now()); secret != "xyzabc" {
 		t.Errorf("failed secret: %v", secret)
 	}
 }
+
+func TestConfig_Reload(t *testing.T) {
+	c, err := New(testConfig, nil)
+	if err != nil {
+		t.Fatalf("failed read config: %v", err)
+	}
+	defer func() {
+		if e := c.Close(); e != nil {
+			t.Errorf("close error: %v", e)
+		}
+	}()
+	data, err := os.ReadFile(testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := strings.Replace(string(data), "ttl = 604800", "ttl = 3600", 1)
+	content = strings.Replace(content, "port = 8082", "port = 8083", 1)
+	content = strings.Replace(content, `salt = "abc"`, `salt = "xyz"`, 1)
+	fileName := filepath.Join(t.TempDir(), cfgName)
+	if err = os.WriteFile(fileName, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := c.Reload(fileName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := fmt.Sprint(changes.Applied); s != "[settings.ttl]" {
+		t.Errorf("failed applied changes: %v", s)
+	}
+	if s := fmt.Sprint(changes.Ignored); s != "[server.port settings.salt]" {
+		t.Errorf("failed ignored changes: %v", s)
+	}
+	settings := c.CurrentSettings()
+	if settings.TTL != 3600 {
+		t.Errorf("failed ttl=%d", settings.TTL)
+	}
+	if settings.Salt != "abc" {
+		t.Error("salt is changed")
+	}
+	if c.Server.Port != 8082 {
+		t.Errorf("port is changed: %d", c.Server.Port)
+	}
+}
+
+func TestConfig_SecurityHeaders(t *testing.T) {
+	c := &Config{}
+	c.Settings.Headers = map[string]string{"X-Frame-Options": "", "Referrer-Policy": "same-origin"}
+	web, api := c.SecurityHeaders()
+	if _, ok := web["X-Frame-Options"]; ok {
+		t.Error("disabled header is present")
+	}
+	if v := web["Referrer-Policy"]; v != "same-origin" {
+		t.Errorf("failed overwritten header: %v", v)
+	}
+	if v := web["Content-Security-Policy"]; v != defaultCSP {
+		t.Errorf("failed default header: %v", v)
+	}
+	if v := api["X-Content-Type-Options"]; v != "nosniff" {
+		t.Errorf("failed api header: %v", v)
+	}
+	if _, ok := web["Strict-Transport-Security"]; ok {
+		t.Error("HSTS header for not secure server")
+	}
+	c.Server.Secure = true
+	web, api = c.SecurityHeaders()
+	if web["Strict-Transport-Security"] == "" || api["Strict-Transport-Security"] == "" {
+		t.Error("no HSTS header for secure server")
+	}
+}
+
+func TestSettings_IsAllowedType(t *testing.T) {
+	s := &Settings{}
+	if !s.IsAllowedType("application/octet-stream") {
+		t.Error("any type is not allowed for empty settings")
+	}
+	s.ContentTypes = []string{"image/png", "text/plain"}
+	cases := []struct {
+		contentType string
+		allowed     bool
+	}{
+		{contentType: "image/png", allowed: true},
+		{contentType: "Text/Plain; charset=utf-8", allowed: true},
+		{contentType: "image/jpeg"},
+		{contentType: ""},
+	}
+	for i, c := range cases {
+		if ok := s.IsAllowedType(c.contentType); ok != c.allowed {
+			t.Errorf("case=%d: failed result=%v", i, ok)
+		}
+	}
+}
+
+func TestSettings_OverrideType(t *testing.T) {
+	s := &Settings{OverrideTypes: []string{"application/pdf", "text/plain"}}
+	cases := []struct {
+		contentType string
+		expected    string
+		fail        bool
+	}{
+		{contentType: "application/pdf", expected: "application/pdf"},
+		{contentType: "Text/Plain; charset=UTF-8", expected: "text/plain; charset=UTF-8"},
+		{contentType: "text/html", fail: true},
+		{contentType: "text/", fail: true},
+		{contentType: "", fail: true},
+	}
+	for i, c := range cases {
+		ct, err := s.OverrideType(c.contentType)
+		if c.fail {
+			if err == nil {
+				t.Errorf("case=%d: expected error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case=%d: unexpected error: %v", i, err)
+			continue
+		}
+		if ct != c.expected {
+			t.Errorf("case=%d: failed content type=%s", i, ct)
+		}
+	}
+}
+
+func TestConfig_MasterKey(t *testing.T) {
+	const envName = "SEND_TEST_MASTER_KEY"
+	fileName := filepath.Join(t.TempDir(), "master.key")
+	if err := os.WriteFile(fileName, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Setenv(envName, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv(envName)
+
+	c := &Config{}
+	key, err := c.MasterKey()
+	if err != nil || key != nil {
+		t.Errorf("unexpected key=%v, error=%v", key, err)
+	}
+	c.Settings.MasterKey = "env:" + envName
+	envKey, err := c.MasterKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(envKey) != 32 {
+		t.Errorf("failed key length=%d", len(envKey))
+	}
+	c.Settings.MasterKey = "file:" + fileName
+	fileKey, err := c.MasterKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(fileKey) != string(envKey) {
+		t.Error("different keys for the same value")
+	}
+	for _, source := range []string{"secret", "env:SEND_TEST_MISSING_KEY", "file:/bad_file_path.key"} {
+		c.Settings.MasterKey = source
+		if _, err = c.MasterKey(); err == nil {
+			t.Errorf("expected error for %s", source)
+		} else if strings.Contains(err.Error(), "secret") {
+			t.Errorf("key value in error: %v", err)
+		}
+	}
+}
+
+func TestSettings_IsLoggedRequest(t *testing.T) {
+	s := &Settings{}
+	if !s.IsLoggedRequest(time.Millisecond, 200) {
+		t.Error("request is not logged without threshold")
+	}
+	s.SlowRequest = 100
+	cases := []struct {
+		d      time.Duration
+		code   int
+		logged bool
+	}{
+		{d: time.Millisecond, code: 200},
+		{d: time.Millisecond, code: 404},
+		{d: time.Millisecond, code: 500, logged: true},
+		{d: 100 * time.Millisecond, code: 200, logged: true},
+		{d: time.Second, code: 201, logged: true},
+	}
+	for i, c := range cases {
+		if logged := s.IsLoggedRequest(c.d, c.code); logged != c.logged {
+			t.Errorf("case=%d: failed logged=%v", i, logged)
+		}
+	}
+}
+
+func TestConfig_ReloadTemplates(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{BaseTpl, IndexTpl, UploadTpl, DownloadTpl, ErrorTpl} {
+		data, err := os.ReadFile(filepath.Join("..", "html", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := New(testConfig, nil)
+	if err != nil {
+		t.Fatalf("failed read config: %v", err)
+	}
+	defer c.Close()
+	// production mode
+	if err = c.ReloadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	c.Settings.DevReload, c.Settings.TemplatesDir = true, dir
+	if c.templates, err = c.Settings.templateEntry(&TemplateEntry{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.ReloadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	errorFile := filepath.Join(dir, ErrorTpl)
+	content := `{{template "base" .}}{{define "content"}}changed error page{{end}}`
+	if err = os.WriteFile(errorFile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.ReloadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	tpl := c.CurrentSettings().Tpl[ErrorTpl]
+	var sb strings.Builder
+	if err = tpl.ExecuteTemplate(&sb, ErrorTpl, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sb.String(), "changed error page") {
+		t.Errorf("template is not reloaded: %s", sb.String())
+	}
+	// broken template, previous one is kept
+	if err = os.WriteFile(errorFile, []byte(`{{define "content"}}{{.Broken`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.ReloadTemplates(); err == nil {
+		t.Error("expected error for broken template")
+	}
+	if c.CurrentSettings().Tpl[ErrorTpl] != tpl {
+		t.Error("broken templates are applied")
+	}
+}
+
+func TestParseTemplates(t *testing.T) {
+	tpl, err := ParseTemplates(nil)
+	if err != nil || tpl != nil {
+		t.Errorf("unexpected templates=%v, error=%v", tpl, err)
+	}
+	tpl, err = ParseTemplates(&TemplateEntry{Dir: ".", Fs: os.DirFS(filepath.Join("..", "html"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(tpl); n != 4 {
+		t.Errorf("failed number of templates=%d", n)
+	}
+	for _, name := range []string{IndexTpl, UploadTpl, DownloadTpl, ErrorTpl} {
+		page, ok := tpl[name]
+		if !ok {
+			t.Errorf("template %s is not loaded", name)
+			continue
+		}
+		if page.Lookup("base") == nil || page.Lookup("content") == nil {
+			t.Errorf("template %s doesn't extend the base one", name)
+		}
+	}
+}
+
+func TestIsFileOrEmpty(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "favicon.ico")
+	if err := os.WriteFile(name, []byte("icon"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name string
+		err  bool
+	}{
+		{name: ""},
+		{name: name},
+		{name: dir, err: true},
+		{name: filepath.Join(dir, "absent"), err: true},
+	}
+	for i, c := range cases {
+		if err := isFileOrEmpty(c.name, "settings.favicon", nil); (err != nil) != c.err {
+			t.Errorf("case=%d: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestConfig_Listen(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "send.sock")
+	if err := isValidSocket(name, nil); err != nil {
+		t.Fatal(err)
+	}
+	c := &Config{Server: server{Socket: name}}
+	if addr := c.Addr(); addr != "unix:"+name {
+		t.Errorf("failed addr=%s", addr)
+	}
+	listener, err := c.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != socketMode {
+		t.Errorf("failed socket mode=%v", info.Mode())
+	}
+	if err = isValidSocket(name, nil); err != nil {
+		t.Errorf("existing socket is not valid: %v", err)
+	}
+	// socket is used by the listener
+	if _, err = c.Listen(); err == nil {
+		t.Error("expected error for used socket")
+	}
+	if err = listener.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("socket is not removed: %v", err)
+	}
+	regular := filepath.Join(dir, "file")
+	if err = os.WriteFile(regular, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = isValidSocket(regular, nil); err == nil {
+		t.Error("expected error for regular file")
+	}
+	if err = isValidSocket(filepath.Join(dir, "absent", "send.sock"), nil); err == nil {
+		t.Error("expected error for absent directory")
+	}
+}
+
+func TestSettings_parseTemplates(t *testing.T) {
+	te := &TemplateEntry{Dir: ".", Fs: os.DirFS(t.TempDir())}
+	s := &Settings{}
+	if _, err := s.parseTemplates(te); err == nil {
+		t.Error("expected error for absent templates")
+	}
+	s.APIOnly = true
+	tpl, err := s.parseTemplates(te)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tpl != nil {
+		t.Error("templates are parsed in API-only mode")
+	}
+}
+
+func TestSettings_TemplateDirs(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	pages := map[string]string{
+		filepath.Join(first, IndexTpl):  "custom index page",
+		filepath.Join(second, IndexTpl): "ignored index page",
+		filepath.Join(second, ErrorTpl): "custom error page",
+	}
+	for name, text := range pages {
+		content := `{{template "base" .}}{{define "content"}}` + text + `{{end}}`
+		if err := os.WriteFile(name, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &Settings{TemplateDirs: []string{first, second}}
+	tpl, err := s.parseTemplates(&TemplateEntry{Dir: "html", Fs: os.DirFS("..")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		IndexTpl:    "custom index page",
+		ErrorTpl:    "custom error page",
+		DownloadTpl: `id="key"`,
+	}
+	for name, text := range expected {
+		var sb strings.Builder
+		if err = tpl[name].ExecuteTemplate(&sb, name, nil); err != nil {
+			t.Fatal(err)
+		}
+		body := sb.String()
+		if !strings.Contains(body, text) || !strings.Contains(body, "</html>") {
+			t.Errorf("template %s is not loaded from its directory: %s", name, body)
+		}
+	}
+}
+
+func TestSettings_Template(t *testing.T) {
+	tpl, err := ParseTemplates(&TemplateEntry{Dir: ".", Fs: os.DirFS(filepath.Join("..", "html"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(tpl, IndexTpl)
+	s := &Settings{Tpl: tpl}
+	if page, e := s.Template(ErrorTpl); e != nil || page == nil {
+		t.Errorf("unexpected template=%v, error=%v", page, e)
+	}
+	if _, err = s.Template(IndexTpl); !errors.Is(err, ErrNoTemplate) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestSettings_VerifySignature(t *testing.T) {
+	const key = "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee"
+	now := time.Now()
+	s := &Settings{Salt: "salt"}
+	expires := now.Add(time.Minute).Unix()
+	signature := s.SignKey(key, expires)
+	value := fmt.Sprint(expires)
+	cases := []struct {
+		key       string
+		expires   string
+		signature string
+		now       time.Time
+		ok        bool
+	}{
+		{key: key, expires: value, signature: signature, now: now, ok: true},
+		{key: key, expires: value, signature: signature, now: now.Add(2 * time.Minute)},
+		{key: key, expires: fmt.Sprint(expires + 3600), signature: signature, now: now},
+		{key: "aaaaaaaa-bbbb-cccc-dddd-ffffffffffff", expires: value, signature: signature, now: now},
+		{key: key, expires: value, signature: signature[1:] + "0", now: now},
+		{key: key, expires: "bad", signature: signature, now: now},
+		{key: key, expires: value, now: now},
+	}
+	for i, c := range cases {
+		if ok := s.VerifySignature(c.key, c.expires, c.signature, c.now); ok != c.ok {
+			t.Errorf("case=%d: failed result=%v", i, ok)
+		}
+	}
+	// dedicated key
+	s.SigningKey = "key"
+	if s.VerifySignature(key, value, signature, now) {
+		t.Error("signature is valid for another key")
+	}
+}
+
+func TestIsDirectory(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(name, []byte("test"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := isDirectory(dir, "test", nil); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, value := range []string{name, filepath.Join(dir, "absent")} {
+		if err := isDirectory(value, "test", nil); err == nil {
+			t.Errorf("expected error for %s", value)
+		}
+	}
+}
+
+func TestSettings_HandlingTimeout(t *testing.T) {
+	const server = 30 * time.Second
+	noLimit, transfer := 0, 600
+	cases := []struct {
+		path     string
+		request  int
+		transfer *int
+		expected time.Duration
+	}{
+		{path: "/api/text", expected: server},
+		{path: "/api/text", request: 5, transfer: &transfer, expected: 5 * time.Second},
+		{path: "/file", request: 5, expected: server},
+		{path: "/file", request: 5, transfer: &noLimit, expected: 0},
+		{path: "/api/upload", request: 5, transfer: &transfer, expected: 600 * time.Second},
+	}
+	for i, c := range cases {
+		s := &Settings{RequestTimeout: c.request, TransferTimeout: c.transfer}
+		if d := s.HandlingTimeout(c.path, server); d != c.expected {
+			t.Errorf("case=%d: failed timeout=%v", i, d)
+		}
+	}
+}
+
+func TestSettings_Times(t *testing.T) {
+	cases := []struct {
+		text, file   int
+		expectedText int
+		expectedFile int
+	}{
+		{expectedText: 10, expectedFile: 10},
+		{text: 20, expectedText: 20, expectedFile: 10},
+		{file: 2, expectedText: 10, expectedFile: 2},
+		{text: 5, file: 3, expectedText: 5, expectedFile: 3},
+	}
+	for i, c := range cases {
+		s := &Settings{Times: 10, MaxTextTimes: c.text, MaxFileTimes: c.file}
+		if v := s.TextTimes(); v != c.expectedText {
+			t.Errorf("case=%d: failed text times=%d", i, v)
+		}
+		if v := s.FileTimes(); v != c.expectedFile {
+			t.Errorf("case=%d: failed file times=%d", i, v)
+		}
+	}
+}
+
+func TestSettings_IsMixedAllowed(t *testing.T) {
+	allowed, forbidden := true, false
+	cases := []struct {
+		value    *bool
+		expected bool
+	}{
+		{expected: true},
+		{value: &allowed, expected: true},
+		{value: &forbidden},
+	}
+	for i, c := range cases {
+		s := &Settings{AllowMixed: c.value}
+		if v := s.IsMixedAllowed(); v != c.expected {
+			t.Errorf("case=%d: failed result=%v", i, v)
+		}
+	}
+}
+
+func TestAssetHashes(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "js"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"main.css": "body {}", "js/main.js": "let a = 1;"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assets, err := AssetHashes(os.DirFS(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(assets); n != 2 {
+		t.Fatalf("failed number of assets=%d", n)
+	}
+	h := assets["js/main.js"]
+	if len(h) != assetHashLength || h == assets["main.css"] {
+		t.Errorf("failed hashes: %v", assets)
+	}
+	te := &TemplateEntry{Assets: assets}
+	if u := te.staticURL("js/main.js"); u != "/static/js/main.js?v="+h {
+		t.Errorf("failed URL=%s", u)
+	}
+	if u := te.staticURL("unknown.js"); u != "/static/unknown.js" {
+		t.Errorf("failed URL=%s", u)
+	}
+}
+
+func TestRelativeTime(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		d        time.Duration
+		expected string
+	}{
+		{d: 30 * time.Second, expected: "in less than a minute"},
+		{d: time.Minute, expected: "in 1 minute"},
+		{d: 59*time.Minute + 59*time.Second, expected: "in 59 minutes"},
+		{d: 2*time.Hour + 30*time.Minute, expected: "in 2 hours"},
+		{d: 47 * time.Hour, expected: "in 47 hours"},
+		{d: 7 * 24 * time.Hour, expected: "in 7 days"},
+		{d: -3 * time.Minute, expected: "3 minutes ago"},
+		{d: -24 * time.Hour, expected: "24 hours ago"},
+	}
+	for i, c := range cases {
+		if value := relativeTime(now.Add(c.d), now); value != c.expected {
+			t.Errorf("case=%d: failed value=%q", i, value)
+		}
+	}
+	// base and page templates share the functions
+	te := &TemplateEntry{Fs: fstest.MapFS{
+		BaseTpl:     &fstest.MapFile{Data: []byte(`{{define "base"}}{{relTime .}} {{template "content" .}}{{end}}`)},
+		"page.html": &fstest.MapFile{Data: []byte(`{{template "base" .}}{{define "content"}}{{relTime .}}{{end}}`)},
+	}}
+	tpl, err := te.Parse("page.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err = tpl.ExecuteTemplate(&b, "page.html", time.Now().Add(3*time.Hour+time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if value := b.String(); value != "in 3 hours in 3 hours" {
+		t.Errorf("failed template value=%q", value)
+	}
+}
+
+func TestConfig_initStatic(t *testing.T) {
+	embedded := fstest.MapFS{
+		"main.css":   &fstest.MapFile{Data: []byte("body {}")},
+		"js/main.js": &fstest.MapFile{Data: []byte("let a = 1;")},
+	}
+	te := &TemplateEntry{Static: embedded}
+	c := &Config{}
+	if err := c.initStatic(te); err != nil {
+		t.Fatal(err)
+	}
+	defaultHashes := te.Assets
+	if c.static == nil || len(defaultHashes) != 2 || len(c.Warnings()) != 0 {
+		t.Fatalf("failed default assets: %v", defaultHashes)
+	}
+	// override only one file
+	c.Settings.StaticDir = t.TempDir()
+	if err := os.WriteFile(filepath.Join(c.Settings.StaticDir, "main.css"), []byte("body {color: red;}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.initStatic(te); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(te.Assets); n != 2 {
+		t.Fatalf("failed number of assets=%d", n)
+	}
+	if te.Assets["main.css"] == defaultHashes["main.css"] || te.Assets["js/main.js"] != defaultHashes["js/main.js"] {
+		t.Errorf("failed hashes: %v", te.Assets)
+	}
+	data, err := fs.ReadFile(c.static, "main.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "body {color: red;}" {
+		t.Errorf("file is not overridden: %s", data)
+	}
+	if _, err = fs.ReadFile(c.static, "absent.css"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestConfig_initStatic_MissingDir(t *testing.T) {
+	embedded := fstest.MapFS{"main.css": &fstest.MapFile{Data: []byte("body {}")}}
+	missing := filepath.Join(t.TempDir(), "absent")
+	// embedded files are used
+	c := &Config{Settings: Settings{StaticDir: missing}}
+	te := &TemplateEntry{Static: embedded}
+	if err := c.initStatic(te); err != nil {
+		t.Fatal(err)
+	}
+	if len(te.Assets) != 1 || len(c.Warnings()) != 1 {
+		t.Errorf("failed assets=%v or warnings=%v", te.Assets, c.Warnings())
+	}
+	c.templates = te
+	if static, _ := c.StaticFiles(); static == nil {
+		t.Error("embedded static files are not used")
+	}
+	// no static files
+	c = &Config{Settings: Settings{StaticDir: missing}}
+	te = &TemplateEntry{}
+	if err := c.initStatic(te); err != nil {
+		t.Fatal(err)
+	}
+	c.templates = te
+	if static, assets := c.StaticFiles(); static != nil || assets != nil {
+		t.Errorf("unexpected static files: %v", assets)
+	}
+	if n := len(c.Warnings()); n != 2 {
+		t.Errorf("failed warnings: %v", c.Warnings())
+	}
+}
+
+func TestSettings_LogURL(t *testing.T) {
+	const key = "0b3bd0d2-5a22-4c32-a5fb-3c1f4e0e5c4d"
+	cases := []struct {
+		target   string
+		redact   bool
+		expected string
+	}{
+		{target: "/" + key + "?password=secret", expected: "/" + key + "?password=secret"},
+		{target: "/" + key + "?password=secret", redact: true, expected: "/:key"},
+		{target: "/api/text", redact: true, expected: "/api/text"},
+		{target: "/file/" + key + "/name?x=1", redact: true, expected: "/file/:key/name"},
+	}
+	for i, c := range cases {
+		u, err := url.Parse(c.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := &Settings{RedactKeys: c.redact}
+		if v := s.LogURL(u); v != c.expected {
+			t.Errorf("case=%d: failed URL=%s", i, v)
+		}
+	}
+}
+
+func TestIsURLOrEmpty(t *testing.T) {
+	cases := []struct {
+		value string
+		fail  bool
+	}{
+		{value: ""},
+		{value: "https://example.com/hook"},
+		{value: "http://127.0.0.1:8080"},
+		{value: "ftp://example.com", fail: true},
+		{value: "/hook", fail: true},
+		{value: "https://", fail: true},
+	}
+	for i, c := range cases {
+		err := isURLOrEmpty(c.value, "settings.expiry_webhook", nil)
+		if (err != nil) != c.fail {
+			t.Errorf("case=%d: unexpected error: %v", i, err)
+		}
+	}
+}
+
+// configWithout returns a path of the test config copy without parameters with names.
+func configWithout(t *testing.T, names ...string) string {
+	data, err := os.ReadFile(testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(data), "\n")
+	result := make([]string, 0, len(lines))
+	for _, line := range lines {
+		name := strings.TrimSpace(strings.SplitN(line, "=", 2)[0])
+		found := false
+		for _, n := range names {
+			if name == n {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, line)
+		}
+	}
+	fileName := filepath.Join(t.TempDir(), cfgName)
+	if err = os.WriteFile(fileName, []byte(strings.Join(result, "\n")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func TestConfig_Defaults(t *testing.T) {
+	c, err := New(configWithout(t, "gc_batch", "multipart_memory", "name_size", "name_attempts", "text_stream"), nil)
+	if err != nil {
+		t.Fatalf("failed read config: %v", err)
+	}
+	defer func() {
+		if e := c.Close(); e != nil {
+			t.Error(e)
+		}
+	}()
+	if c.Settings.GCBatch != defaultGCBatch {
+		t.Errorf("failed gc_batch=%d", c.Settings.GCBatch)
+	}
+	if c.Settings.MultipartMemory != defaultMultipartMemory {
+		t.Errorf("failed multipart_memory=%d", c.Settings.MultipartMemory)
+	}
+	if c.Storage.NameSize != defaultNameSize || c.Storage.NameAttempts != defaultNameAttempts {
+		t.Errorf("failed name_size=%d, name_attempts=%d", c.Storage.NameSize, c.Storage.NameAttempts)
+	}
+	if c.Settings.TextStream != defaultTextStream {
+		t.Errorf("failed text_stream=%d", c.Settings.TextStream)
+	}
+}
+
+func TestNew_ValidationReport(t *testing.T) {
+	_, err := New(configWithout(t, "ttl", "times", "timeout"), nil)
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	var report *ValidationError
+	if !errors.As(err, &report) {
+		t.Fatalf("unexpected error type %T: %v", err, err)
+	}
+	if n := len(report.Errors); n < 4 {
+		t.Errorf("expected all problems, got %d: %v", n, err)
+	}
+	msg := err.Error()
+	for _, name := range []string{"settings.ttl", "settings.times", "Storage.timeout", "server.timeout"} {
+		if !strings.Contains(msg, name) {
+			t.Errorf("no %s in the report: %v", name, msg)
+		}
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	v := &ValidationError{}
+	if err := v.err(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	v.add(nil)
+	v.add(ErrNoSpace)
+	if err := v.err(); err != ErrNoSpace {
+		t.Errorf("unexpected single error: %v", err)
+	}
+	v.add(&ValidationError{Errors: []error{errors.New("a"), errors.New("b")}})
+	if n := len(v.Errors); n != 3 {
+		t.Errorf("failed flatten, errors=%d", n)
+	}
+	if err := v.err(); !errors.Is(err, ErrNoSpace) {
+		t.Errorf("failed unwrap: %v", err)
+	}
+}
+
+func TestNew_SchemaCheck(t *testing.T) {
+	// test database is prepared by the actual schema, so it's valid without migrations
+	c, err := New(configWithout(t, "migrate"), nil)
+	if err != nil {
+		t.Fatalf("failed read config: %v", err)
+	}
+	defer func() {
+		if e := c.Close(); e != nil {
+			t.Error(e)
+		}
+	}()
+	if c.Storage.Migrate {
+		t.Error("migrations are enabled")
+	}
+	if c.Storage.version < 1 {
+		t.Errorf("failed schema version=%d", c.Storage.version)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package cfg
+
+import "syscall"
+
+// diskFree returns available for unprivileged users space of file system with the path.
+func diskFree(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return int64(uint64(st.Bavail) * uint64(st.Bsize)), nil
+}
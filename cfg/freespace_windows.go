@@ -0,0 +1,11 @@
+//go:build windows
+// +build windows
+
+package cfg
+
+import "errors"
+
+// diskFree is not implemented for windows, free_space placement can not be used there.
+func diskFree(string) (int64, error) {
+	return 0, errors.New("free space check is not supported")
+}
@@ -0,0 +1,37 @@
+package cfg
+
+// defaultCSP is a content security policy for bundled templates,
+// they use external bootstrap styles, local static files and inline event handlers.
+const defaultCSP = "default-src 'self'; " +
+	"style-src 'self' https://cdn.jsdelivr.net; " +
+	"script-src 'self' 'unsafe-inline'; " +
+	"img-src 'self' data: blob:; " +
+	"base-uri 'self'; form-action 'self'; frame-ancestors 'none'"
+
+// SecurityHeaders returns HTTP security headers for web and API responses.
+// Web headers can be overwritten by settings.headers, a header with an empty value is not sent.
+func (c *Config) SecurityHeaders() (map[string]string, map[string]string) {
+	web := map[string]string{
+		"Content-Security-Policy": defaultCSP,
+		"X-Content-Type-Options":  "nosniff",
+		"X-Frame-Options":         "DENY",
+		"Referrer-Policy":         "no-referrer",
+	}
+	api := map[string]string{
+		"X-Content-Type-Options": "nosniff",
+		"X-Frame-Options":        "DENY",
+	}
+	if c.Server.Secure || c.Server.Cert != "" {
+		hsts := "max-age=31536000; includeSubDomains"
+		web["Strict-Transport-Security"] = hsts
+		api["Strict-Transport-Security"] = hsts
+	}
+	for name, value := range c.Settings.Headers {
+		if value == "" {
+			delete(web, name)
+		} else {
+			web[name] = value
+		}
+	}
+	return web, api
+}
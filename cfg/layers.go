@@ -0,0 +1,57 @@
+package cfg
+
+import (
+	"errors"
+	"io/fs"
+	"sort"
+)
+
+// layerFS is a file system of ordered layers, a file is opened from the first layer which contains it.
+type layerFS []fs.FS
+
+// Open opens the named file from the first layer where it exists.
+func (l layerFS) Open(name string) (fs.File, error) {
+	for _, layer := range l {
+		f, err := layer.Open(name)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadDir returns entries of the named directory from all layers sorted by name,
+// a file from an upper layer hides the same one from lower layers.
+func (l layerFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	var (
+		entries []fs.DirEntry
+		found   bool
+		names   = make(map[string]bool)
+	)
+	for _, layer := range l {
+		items, err := fs.ReadDir(layer, name)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		found = true
+		for _, item := range items {
+			if !names[item.Name()] {
+				names[item.Name()] = true
+				entries = append(entries, item)
+			}
+		}
+	}
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
@@ -0,0 +1,49 @@
+package cfg
+
+import "fmt"
+
+// maxNamespaceLength is max length of a namespace name.
+const maxNamespaceLength = 32
+
+// isNamespaceName returns true if the name is not empty and contains only lowercase latin letters,
+// digits, hyphens and underscores.
+func isNamespaceName(name string) bool {
+	if name == "" || len(name) > maxNamespaceLength {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// isValidNamespaces returns error if err is already error or some namespace name is incorrect.
+func isValidNamespaces(namespaces []string, err error) error {
+	if err != nil {
+		return err
+	}
+	for _, name := range namespaces {
+		if !isNamespaceName(name) {
+			return fmt.Errorf("settings.namespaces=%q is incorrect, max %d lowercase letters, digits, hyphens or underscores are allowed", name, maxNamespaceLength)
+		}
+	}
+	return nil
+}
+
+// IsNamespace returns true if the items namespace is allowed.
+// Empty name is the default namespace, it's always allowed.
+func (s *Settings) IsNamespace(name string) bool {
+	if name == "" {
+		return true
+	}
+	for _, ns := range s.Namespaces {
+		if ns == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,30 @@
+package cfg
+
+import "testing"
+
+func TestSettings_IsNamespace(t *testing.T) {
+	s := &Settings{Namespaces: []string{"team-a", "team_b"}}
+	if err := isValidNamespaces(s.Namespaces, nil); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name     string
+		expected bool
+	}{
+		{name: "", expected: true},
+		{name: "team-a", expected: true},
+		{name: "team_b", expected: true},
+		{name: "team-c"},
+		{name: "Team-A"},
+	}
+	for i, c := range cases {
+		if ok := s.IsNamespace(c.name); ok != c.expected {
+			t.Errorf("case=%d: failed result=%v for %q", i, ok, c.name)
+		}
+	}
+	for _, name := range []string{"", "Team", "a/b", "team a", "abcdefghijklmnopqrstuvwxyz0123456"} {
+		if err := isValidNamespaces([]string{name}, nil); err == nil {
+			t.Errorf("expected error for %q", name)
+		}
+	}
+}
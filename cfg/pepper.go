@@ -0,0 +1,48 @@
+package cfg
+
+import (
+	"fmt"
+	"time"
+)
+
+// Pepper is an additional key salt which is used for items created since its activation time.
+// It's active until the next pepper activation.
+type Pepper struct {
+	Value string    `toml:"value"`
+	Since time.Time `toml:"since"`
+}
+
+// isValidPeppers returns error if err is already error or peppers have empty values
+// or their activation windows overlap, they must be sorted by activation time.
+func isValidPeppers(peppers []Pepper, err error) error {
+	if err != nil {
+		return err
+	}
+	for i, p := range peppers {
+		if p.Value == "" || p.Since.IsZero() {
+			return fmt.Errorf("settings.peppers[%d] should have value and since time", i)
+		}
+		if i > 0 && !p.Since.After(peppers[i-1].Since) {
+			return fmt.Errorf("settings.peppers[%d] overlaps the previous one, since time should be greater", i)
+		}
+	}
+	return nil
+}
+
+// pepper returns the salt which was active at the created time.
+// Settings.Salt is used for items created before the first pepper activation.
+func (s *Settings) pepper(created time.Time) string {
+	salt := s.Salt
+	for _, p := range s.Peppers {
+		if created.Before(p.Since) {
+			break
+		}
+		salt = p.Value
+	}
+	return salt
+}
+
+// Secret returns string with the salt which was active at the item's created time.
+func (c *Config) Secret(p string, created time.Time) string {
+	return p + c.Settings.pepper(created)
+}
@@ -0,0 +1,67 @@
+package cfg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pelletier/go-toml"
+)
+
+func TestConfig_Secret(t *testing.T) {
+	data := []byte(`
+[settings]
+salt = "abc"
+
+[[settings.peppers]]
+value = "def"
+since = 2026-01-01T00:00:00Z
+
+[[settings.peppers]]
+value = "ghi"
+since = 2026-07-01T00:00:00Z
+`)
+	conf := &Config{}
+	if err := toml.Unmarshal(data, conf); err != nil {
+		t.Fatal(err)
+	}
+	if err := isValidPeppers(conf.Settings.Peppers, nil); err != nil {
+		t.Fatal(err)
+	}
+	boundary := time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		created  time.Time
+		expected string
+	}{
+		{created: time.Date(2025, 12, 31, 23, 59, 59, 0, time.UTC), expected: "xyzabc"},
+		{created: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), expected: "xyzdef"},
+		{created: boundary.Add(-time.Nanosecond), expected: "xyzdef"},
+		{created: boundary, expected: "xyzghi"},
+		{created: boundary.In(time.FixedZone("UTC+3", 3*3600)), expected: "xyzghi"},
+		{created: boundary.AddDate(1, 0, 0), expected: "xyzghi"},
+	}
+	for i, c := range cases {
+		if secret := conf.Secret("xyz", c.created); secret != c.expected {
+			t.Errorf("case=%d: failed secret=%s", i, secret)
+		}
+	}
+}
+
+func TestIsValidPeppers(t *testing.T) {
+	since := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		peppers []Pepper
+		ok      bool
+	}{
+		{ok: true},
+		{peppers: []Pepper{{Value: "a", Since: since}, {Value: "b", Since: since.Add(time.Hour)}}, ok: true},
+		{peppers: []Pepper{{Value: "a", Since: since}, {Value: "b", Since: since}}},
+		{peppers: []Pepper{{Value: "a", Since: since}, {Value: "b", Since: since.Add(-time.Hour)}}},
+		{peppers: []Pepper{{Value: "", Since: since}}},
+		{peppers: []Pepper{{Value: "a"}}},
+	}
+	for i, c := range cases {
+		if err := isValidPeppers(c.peppers, nil); (err == nil) != c.ok {
+			t.Errorf("case=%d: unexpected error: %v", i, err)
+		}
+	}
+}
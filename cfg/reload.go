@@ -0,0 +1,78 @@
+package cfg
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// Changes is a result of configuration reloading.
+type Changes struct {
+	Applied []string // names of updated settings
+	Ignored []string // names of changed settings that require restart
+}
+
+// String returns a string representation of the configuration changes.
+func (ch *Changes) String() string {
+	return fmt.Sprintf("applied=%v, ignored (restart is required)=%v", ch.Applied, ch.Ignored)
+}
+
+// compareFields checks exported toml-fields of the structures a and b with the same type.
+// Changed fields names are added to changes, they are marked as applied only if reload is true
+// and the field has tag reload="true". Not applied fields of b get values from a.
+func compareFields(a, b reflect.Value, prefix string, reload bool, changes *Changes) {
+	t := a.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("toml")
+		if field.PkgPath != "" || name == "" || name == "-" {
+			// unexported or not configured field
+			continue
+		}
+		fa, fb := a.Field(i), b.Field(i)
+		if reflect.DeepEqual(fa.Interface(), fb.Interface()) {
+			continue
+		}
+		name = prefix + name
+		if reload && field.Tag.Get("reload") == "true" {
+			changes.Applied = append(changes.Applied, name)
+			continue
+		}
+		changes.Ignored = append(changes.Ignored, name)
+		fb.Set(fa)
+	}
+}
+
+// Reload reads the configuration file filename again and atomically updates settings
+// which are safe to change at runtime, templates are parsed again too.
+// Changes of other parameters (server, storage and structural settings) are ignored.
+// Values are not returned to prevent secrets leaks.
+func (c *Config) Reload(filename string, t *TemplateEntry) (*Changes, error) {
+	nc, err := read(filename)
+	if err != nil {
+		return nil, err
+	}
+	settings := &nc.Settings
+	if err = settings.isValid(); err != nil {
+		return nil, fmt.Errorf("config validation: %w", err)
+	}
+	tpl, err := c.Settings.parseTemplates(t)
+	if err != nil {
+		return nil, fmt.Errorf("config validation: %w", err)
+	}
+	settings.Tpl = tpl
+	if fullPath, e := filepath.Abs(strings.Trim(nc.Storage.Dir, " ")); e == nil {
+		// storage directory is saved as an absolute path
+		nc.Storage.Dir = fullPath
+	}
+	changes := &Changes{}
+	compareFields(reflect.ValueOf(c.Server), reflect.ValueOf(&nc.Server).Elem(), "server.", false, changes)
+	compareFields(reflect.ValueOf(&c.Storage).Elem(), reflect.ValueOf(&nc.Storage).Elem(), "storage.", false, changes)
+
+	c.m.Lock()
+	defer c.m.Unlock()
+	compareFields(reflect.ValueOf(c.current).Elem(), reflect.ValueOf(settings).Elem(), "settings.", true, changes)
+	c.current = settings
+	return changes, nil
+}
@@ -0,0 +1,35 @@
+package cfg
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// signingKey returns a key of download URLs signatures, settings.salt is used if it's not set.
+func (s *Settings) signingKey() []byte {
+	if s.SigningKey != "" {
+		return []byte(s.SigningKey)
+	}
+	return []byte(s.Salt)
+}
+
+// SignKey returns hex HMAC-SHA256 signature of the item key and its access expiration unix time.
+// It's used by external systems to issue time-limited download URLs with query parameters
+// "expires" and "signature".
+func (s *Settings) SignKey(key string, expires int64) string {
+	mac := hmac.New(sha256.New, s.signingKey())
+	mac.Write([]byte(key + ":" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature returns true if the signature of the item key is valid and not expired at now.
+func (s *Settings) VerifySignature(key, expires, signature string, now time.Time) bool {
+	ts, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || now.Unix() > ts {
+		return false
+	}
+	return hmac.Equal([]byte(s.SignKey(key, ts)), []byte(signature))
+}
@@ -0,0 +1,79 @@
+package cfg
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// socketMode is permissions of the unix socket, a reverse proxy should be in the service group.
+const socketMode os.FileMode = 0660
+
+// isValidSocket checks that the unix socket can be created, its directory must be writable.
+// An existing file is allowed only if it's a socket.
+func isValidSocket(name string, err error) error {
+	if err != nil || name == "" {
+		return err
+	}
+	if info, e := os.Stat(name); e == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("server.socket=%s is not a socket", name)
+		}
+	} else if !os.IsNotExist(e) {
+		return fmt.Errorf("server.socket: %w", e)
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), ".send-socket-*")
+	if err != nil {
+		return fmt.Errorf("server.socket directory is not writable: %w", err)
+	}
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("server.socket check file close: %w", err)
+	}
+	if err = os.Remove(f.Name()); err != nil {
+		return fmt.Errorf("server.socket check file remove: %w", err)
+	}
+	return nil
+}
+
+// removeStaleSocket deletes the socket file left by a not correctly stopped process.
+// It returns an error if the socket is still used.
+func removeStaleSocket(name string) error {
+	if _, err := os.Stat(name); os.IsNotExist(err) {
+		return nil
+	}
+	conn, err := net.DialTimeout("unix", name, time.Second)
+	if err == nil {
+		if e := conn.Close(); e != nil {
+			return fmt.Errorf("close connection to socket %s: %w", name, e)
+		}
+		return fmt.Errorf("socket %s is already in use", name)
+	}
+	if err = os.Remove(name); err != nil {
+		return fmt.Errorf("remove stale socket: %w", err)
+	}
+	return nil
+}
+
+// Listen creates the server listener, it's a unix socket if server.socket is set or TCP otherwise.
+// The socket file is removed when the listener is closed.
+func (c *Config) Listen() (net.Listener, error) {
+	if c.Server.Socket == "" {
+		return net.Listen("tcp", c.Addr())
+	}
+	if err := removeStaleSocket(c.Server.Socket); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", c.Server.Socket)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(c.Server.Socket, socketMode); err != nil {
+		if e := listener.Close(); e != nil {
+			err = fmt.Errorf("%w, close listener: %v", err, e)
+		}
+		return nil, fmt.Errorf("socket permissions: %w", err)
+	}
+	return listener, nil
+}
@@ -0,0 +1,254 @@
+package cfg
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/z0rr0/send/db"
+	"github.com/z0rr0/send/logging"
+)
+
+// storage directories placement strategies
+const (
+	RoundRobinPlacement = "round_robin"
+	FreeSpacePlacement  = "free_space"
+)
+
+// storage files and directories permissions
+const (
+	defaultFileMode os.FileMode = 0600
+	maxFileMode     os.FileMode = 0640 // owner can read and write, group can only read
+	userReadWrite   os.FileMode = 0600
+	groupReadSearch os.FileMode = 0050
+)
+
+var (
+	// ErrNoSpace is an error when there is no storage directory with enough space for new data.
+	ErrNoSpace = errors.New("no storage directory with enough space")
+	// ErrStorageLimit is an error when new data exceeds total storage size limit.
+	ErrStorageLimit = errors.New("storage limit is reached")
+)
+
+// freeSpace returns available space of file system with the path in bytes.
+// It is a variable to mock file system state in tests.
+var freeSpace = diskFree
+
+// storageDir is a files storage directory with its used space.
+type storageDir struct {
+	path  string
+	limit int64
+}
+
+// String returns base info about storage directory.
+func (d *storageDir) String() string {
+	return fmt.Sprintf("%s (%d)", d.path, d.limit)
+}
+
+// placement returns the strategy of storage directories selection.
+func (s *Storage) placement() string {
+	if s.Placement == "" {
+		return RoundRobinPlacement
+	}
+	return s.Placement
+}
+
+// memoryDir is a virtual directory of the in-memory storage.
+const memoryDir = "memory"
+
+// NewMemoryStorage returns a storage with one virtual directory and the database,
+// it should be used with encrypt.MemoryStorage files and an in-memory database for tests.
+func NewMemoryStorage(database *sql.DB, size int64) *Storage {
+	return &Storage{
+		Dir:  memoryDir,
+		Size: size,
+		mode: defaultFileMode,
+		dirs: []*storageDir{{path: memoryDir}},
+		Db:   database,
+	}
+}
+
+// Mode returns permissions of new storage files.
+func (s *Storage) Mode() os.FileMode {
+	return s.mode
+}
+
+// BufferBytes returns copy buffer size in bytes of files encryption, zero value means the default size.
+func (s *Storage) BufferBytes() int {
+	return s.BufferSize << 10 // kilobytes -> bytes
+}
+
+// initMode parses octal file_mode value, it is 0600 by default.
+// The mode can't be broader than 0640 and the owner should be able to read files.
+func (s *Storage) initMode() error {
+	if s.FileMode == "" {
+		s.mode = defaultFileMode
+		return nil
+	}
+	value, err := strconv.ParseUint(s.FileMode, 8, 32)
+	if err != nil {
+		return fmt.Errorf("failed Storage.file_mode=%s: %w", s.FileMode, err)
+	}
+	mode := os.FileMode(value)
+	if mode&^maxFileMode != 0 || mode&0400 == 0 {
+		return fmt.Errorf("Storage.file_mode=%s should be readable by the owner and not broader than %o", s.FileMode, maxFileMode)
+	}
+	s.mode = mode
+	return nil
+}
+
+// dirMode returns required permissions of storage directories for files with the mode.
+// If group can read files, then it should be able to list the directory too.
+func dirMode(mode os.FileMode) os.FileMode {
+	if mode&0040 != 0 {
+		return userReadWrite | groupReadSearch
+	}
+	return userReadWrite
+}
+
+// initDirs checks main and additional storage directories.
+// Their absolute paths are used for new files.
+func (s *Storage) initDirs(mode os.FileMode) error {
+	switch p := s.placement(); p {
+	case RoundRobinPlacement, FreeSpacePlacement:
+	default:
+		return fmt.Errorf("unknown Storage.placement=%s", p)
+	}
+	if s.DirSize < 0 {
+		return fmt.Errorf("Storage.dir_size=%d should not be negative", s.DirSize)
+	}
+	fullPath, err := checkDirectory(s.Dir, mode)
+	if err != nil {
+		return err
+	}
+	s.Dir = fullPath
+	s.dirs = []*storageDir{{path: fullPath}}
+	exists := map[string]bool{fullPath: true}
+	for _, dir := range s.Dirs {
+		fullPath, err = checkDirectory(dir, mode)
+		if err != nil {
+			return err
+		}
+		if exists[fullPath] {
+			return fmt.Errorf("duplicate storage directory %s", fullPath)
+		}
+		exists[fullPath] = true
+		s.dirs = append(s.dirs, &storageDir{path: fullPath})
+	}
+	return nil
+}
+
+// init checks file mode and storage directories, then it calculates their used space.
+// Every step depends on the previous one, so the first error is returned.
+func (s *Storage) init() error {
+	if err := s.initMode(); err != nil {
+		return err
+	}
+	if err := s.initDirs(dirMode(s.mode)); err != nil {
+		return err
+	}
+	return s.initLimits()
+}
+
+// isAvailable returns true if new data with size v doesn't exceed the directory limit.
+func (s *Storage) isAvailable(d *storageDir, v int64) bool {
+	maxDirSize := s.maxDirSize()
+	return maxDirSize == 0 || d.limit+v <= maxDirSize
+}
+
+// selectDir returns a directory for new data with size v using configured placement strategy.
+// Round-robin uses the next available directory, free space strategy selects a directory
+// with max available space considering file system state and directory limit.
+// It should be called under the storage mutex.
+func (s *Storage) selectDir(v int64) (*storageDir, error) {
+	n := len(s.dirs)
+	if s.placement() == RoundRobinPlacement {
+		for i := 0; i < n; i++ {
+			idx := (s.next + i) % n
+			if d := s.dirs[idx]; s.isAvailable(d, v) {
+				s.next = (idx + 1) % n
+				return d, nil
+			}
+		}
+		return nil, ErrNoSpace
+	}
+	var (
+		selected *storageDir
+		maxFree  int64
+	)
+	maxDirSize := s.maxDirSize()
+	for _, d := range s.dirs {
+		if !s.isAvailable(d, v) {
+			continue
+		}
+		free, err := freeSpace(d.path)
+		if err != nil {
+			return nil, fmt.Errorf("free space of %s: %w", d.path, err)
+		}
+		if maxDirSize > 0 && maxDirSize-d.limit < free {
+			free = maxDirSize - d.limit
+		}
+		if free >= v && (selected == nil || free > maxFree) {
+			selected, maxFree = d, free
+		}
+	}
+	if selected == nil {
+		return nil, ErrNoSpace
+	}
+	return selected, nil
+}
+
+// isValidWatermarks returns error if err is already error or used storage watermarks are not percents,
+// low one should be less than high one if it's set.
+func isValidWatermarks(high, low int, err error) error {
+	if err != nil || high == 0 && low == 0 {
+		return err
+	}
+	if high < 1 || high > 100 {
+		return fmt.Errorf("Storage.high_watermark=%d should be in range [1, 100]", high)
+	}
+	if low < 0 || low >= high {
+		return fmt.Errorf("Storage.low_watermark=%d should be in range [0, %d)", low, high)
+	}
+	return nil
+}
+
+// IsThrottled returns true if new uploads should be rejected because used storage reached high watermark.
+// They are rejected until GC releases the storage to low watermark, so there is no thrashing near capacity.
+// It always returns false if high watermark is not set.
+func (s *Storage) IsThrottled() bool {
+	if s.HighMark == 0 {
+		return false
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	used := s.limit * 100
+	if s.throttled {
+		s.throttled = used > int64(s.LowMark)*s.maxSize()
+	} else {
+		s.throttled = used >= int64(s.HighMark)*s.maxSize()
+	}
+	return s.throttled
+}
+
+// CleanUp removes storage files which are not referenced by any item and items with missing files
+// if storage.check_consistency is enabled, then limits are recalculated by the cleaned storage.
+func (s *Storage) CleanUp(ctx context.Context, l *logging.Log) error {
+	if !s.Consistency {
+		return nil
+	}
+	dirs := make([]string, len(s.dirs))
+	for i, d := range s.dirs {
+		dirs[i] = d.path
+	}
+	files, items, err := db.Reconcile(ctx, s.Db, dirs, s.NameSize, l)
+	if err != nil {
+		return fmt.Errorf("storage consistency: %w", err)
+	}
+	l.Info("storage consistency: removed %d orphan files and %d items with missing files", files, items)
+	return s.initLimits()
+}
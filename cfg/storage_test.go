@@ -0,0 +1,249 @@
+package cfg
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testStorage returns a storage with n temporary directories.
+func testStorage(t *testing.T, n int, placement string, size, dirSize int64) *Storage {
+	s := &Storage{Dir: t.TempDir(), Placement: placement, Size: size, DirSize: dirSize}
+	for i := 1; i < n; i++ {
+		s.Dirs = append(s.Dirs, t.TempDir())
+	}
+	if err := s.initDirs(0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.initLimits(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestStorage_Place_RoundRobin(t *testing.T) {
+	const mb = 1 << 20
+	s := testStorage(t, 3, "", 10, 2)
+	expected := []string{s.Dir, s.Dirs[0], s.Dirs[1], s.Dir, s.Dirs[0], s.Dirs[1]}
+	for i, e := range expected {
+		dir, err := s.Place(mb)
+		if err != nil {
+			t.Fatalf("case=%d: %v", i, err)
+		}
+		if dir != e {
+			t.Errorf("case=%d: failed directory=%s", i, dir)
+		}
+	}
+	// all directories are full
+	if _, err := s.Place(1); !errors.Is(err, ErrNoSpace) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if s.limit != 6*mb {
+		t.Errorf("failed total limit=%d", s.limit)
+	}
+	// total limit
+	s = testStorage(t, 2, RoundRobinPlacement, 1, 0)
+	if _, err := s.Place(mb + 1); !errors.Is(err, ErrStorageLimit) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := s.Limit(mb + 1); !errors.Is(err, ErrStorageLimit) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestStorage_Place_FreeSpace(t *testing.T) {
+	const mb = 1 << 20
+	s := testStorage(t, 3, FreeSpacePlacement, 100, 10)
+	free := map[string]int64{s.Dir: 5 * mb, s.Dirs[0]: 50 * mb, s.Dirs[1]: 20 * mb}
+	defer func() {
+		freeSpace = diskFree
+	}()
+	freeSpace = func(path string) (int64, error) {
+		return free[path], nil
+	}
+	// Dirs[0] has max free space, but it's limited by dir_size
+	expected := []string{s.Dirs[0], s.Dirs[1], s.Dirs[0]}
+	for i, e := range expected {
+		dir, err := s.Place(4 * mb)
+		if err != nil {
+			t.Fatalf("case=%d: %v", i, err)
+		}
+		if dir != e {
+			t.Errorf("case=%d: failed directory=%s", i, dir)
+		}
+		free[dir] -= 4 * mb
+	}
+	// Dir has less free space than limited Dirs[1]
+	if dir, err := s.Place(5 * mb); err != nil || dir != s.Dirs[1] {
+		t.Errorf("failed directory=%s: %v", dir, err)
+	}
+	if _, err := s.Place(6 * mb); !errors.Is(err, ErrNoSpace) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestStorage_initLimits(t *testing.T) {
+	s := &Storage{Dir: t.TempDir(), Dirs: []string{t.TempDir()}, Size: 1}
+	for i, dir := range []string{s.Dir, s.Dirs[0]} {
+		if err := os.WriteFile(filepath.Join(dir, "file"), make([]byte, 10*(i+1)), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.initDirs(0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.initLimits(); err != nil {
+		t.Fatal(err)
+	}
+	if s.limit != 30 || s.dirs[0].limit != 10 || s.dirs[1].limit != 20 {
+		t.Errorf("failed limits: %d, %d, %d", s.limit, s.dirs[0].limit, s.dirs[1].limit)
+	}
+	s = &Storage{Dir: t.TempDir(), Dirs: []string{"."}, Placement: "random"}
+	if err := s.initDirs(0700); err == nil {
+		t.Error("unknown placement is accepted")
+	}
+}
+
+func TestStorage_initMode(t *testing.T) {
+	cases := []struct {
+		value string
+		mode  os.FileMode
+		dir   os.FileMode
+		fail  bool
+	}{
+		{value: "", mode: 0600, dir: 0600},
+		{value: "0640", mode: 0640, dir: 0650},
+		{value: "400", mode: 0400, dir: 0600},
+		{value: "0644", fail: true},
+		{value: "0660", fail: true},
+		{value: "0200", fail: true},
+		{value: "rw", fail: true},
+	}
+	for i, c := range cases {
+		s := &Storage{FileMode: c.value}
+		err := s.initMode()
+		if c.fail {
+			if err == nil {
+				t.Errorf("case=%d: expected error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case=%d: unexpected error: %v", i, err)
+			continue
+		}
+		if m := s.Mode(); m != c.mode {
+			t.Errorf("case=%d: failed mode=%o", i, m)
+		}
+		if m := dirMode(s.Mode()); m != c.dir {
+			t.Errorf("case=%d: failed directory mode=%o", i, m)
+		}
+	}
+	// group can't list the directory
+	s := &Storage{Dir: t.TempDir()}
+	if err := os.Chmod(s.Dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.initDirs(dirMode(0640)); err == nil {
+		t.Error("expected directory permissions error")
+	}
+}
+
+func TestStorage_Release(t *testing.T) {
+	const mb = 1 << 20
+	s := testStorage(t, 1, "", 2, 0)
+	dir, err := s.Place(2 * mb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Place(1); err == nil {
+		t.Error("expected error of full storage")
+	}
+	s.Release(dir, 2*mb)
+	if s.limit != 0 || s.dirs[0].limit != 0 {
+		t.Errorf("failed limits: %d, %d", s.limit, s.dirs[0].limit)
+	}
+	if _, err = s.Place(mb); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestStorage_Reconcile(t *testing.T) {
+	const mb = 1 << 20
+	s := testStorage(t, 1, "", 4, 0)
+	dir, err := s.Place(2 * mb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// stored data is smaller than the reservation, for example compressed
+	s.Reconcile(dir, 2*mb, mb/2)
+	if s.limit != mb/2 || s.dirs[0].limit != mb/2 {
+		t.Errorf("failed limits: %d, %d", s.limit, s.dirs[0].limit)
+	}
+	// stored data is bigger, for example with encryption overhead
+	s.Reconcile(dir, 0, mb/2)
+	if s.limit != mb || s.dirs[0].limit != mb {
+		t.Errorf("failed limits: %d, %d", s.limit, s.dirs[0].limit)
+	}
+	if _, err = s.Place(3 * mb); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestStorage_UsedPercent(t *testing.T) {
+	const mb = 1 << 20
+	s := testStorage(t, 1, "", 4, 0)
+	if p := s.UsedPercent(); p != 0 {
+		t.Errorf("failed empty storage percent=%d", p)
+	}
+	if _, err := s.Place(mb + mb/25); err != nil {
+		t.Fatal(err)
+	}
+	if p := s.UsedPercent(); p != 26 {
+		t.Errorf("failed percent=%d", p)
+	}
+}
+
+func TestStorage_IsThrottled(t *testing.T) {
+	const mb = 1 << 20
+	s := testStorage(t, 1, "", 10, 0)
+	if err := isValidWatermarks(90, 90, nil); err == nil {
+		t.Error("expected error for equal watermarks")
+	}
+	if err := isValidWatermarks(101, 0, nil); err == nil {
+		t.Error("expected error for high watermark")
+	}
+	s.HighMark, s.LowMark = 80, 50
+	if err := isValidWatermarks(s.HighMark, s.LowMark, nil); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := s.Place(7 * mb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		size      int64 // positive value is placed, negative one is released
+		throttled bool
+	}{
+		{size: 0, throttled: false},
+		{size: mb, throttled: true},  // 80%
+		{size: -mb, throttled: true}, // 70%
+		{size: -2*mb + 1, throttled: true},
+		{size: -1, throttled: false},     // 50%
+		{size: 2 * mb, throttled: false}, // 70%
+		{size: 2 * mb, throttled: true},  // 90%
+	}
+	for i, step := range steps {
+		if step.size > 0 {
+			if _, err = s.Place(step.size); err != nil {
+				t.Fatalf("case=%d: %v", i, err)
+			}
+		} else {
+			s.Release(dir, -step.size)
+		}
+		if throttled := s.IsThrottled(); throttled != step.throttled {
+			t.Errorf("case=%d: failed throttled=%v", i, throttled)
+		}
+	}
+}
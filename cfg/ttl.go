@@ -0,0 +1,57 @@
+package cfg
+
+import (
+	"fmt"
+	"sort"
+)
+
+// CategoryTTL is default and max time to live (seconds) of files of one content type category.
+// Zero values mean settings.ttl.
+type CategoryTTL struct {
+	Default int `toml:"default"`
+	Max     int `toml:"max"`
+}
+
+// isValidCategoryTTL returns error if err is already error or category TTL values are negative,
+// a default value is greater than max one or any of them is greater than global TTL.
+func isValidCategoryTTL(categories map[string]CategoryTTL, ttl int, err error) error {
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(categories))
+	for name := range categories {
+		names = append(names, name)
+	}
+	sort.Strings(names) // stable error for several incorrect categories
+	for _, name := range names {
+		c := categories[name]
+		param := "settings.category_ttl." + name
+		err = isNotNegative(c.Default, param+".default", err)
+		err = isNotNegative(c.Max, param+".max", err)
+		if err != nil {
+			return err
+		}
+		if c.Max > ttl || c.Default > ttl {
+			return fmt.Errorf("%s values should not be greater than settings.ttl=%d", param, ttl)
+		}
+		if c.Max > 0 && c.Default > c.Max {
+			return fmt.Errorf("%s.default=%d is greater than max=%d", param, c.Default, c.Max)
+		}
+	}
+	return nil
+}
+
+// CategoryTTL returns default and max time to live (seconds) of files of the category.
+// Global settings.ttl is used as max value if the category is not configured,
+// zero default value means that TTL is required.
+func (s *Settings) CategoryTTL(category string) (int, int) {
+	c, ok := s.CategoryTTLs[category]
+	if !ok {
+		return 0, s.TTL
+	}
+	maxTTL := c.Max
+	if maxTTL == 0 {
+		maxTTL = s.TTL
+	}
+	return c.Default, maxTTL
+}
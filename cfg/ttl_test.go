@@ -0,0 +1,66 @@
+package cfg
+
+import (
+	"testing"
+
+	"github.com/pelletier/go-toml"
+)
+
+func TestSettings_CategoryTTL(t *testing.T) {
+	data := []byte(`
+[settings]
+ttl = 86400
+
+[settings.category_ttl.image]
+default = 3600
+max = 7200
+
+[settings.category_ttl.archive]
+default = 600
+`)
+	conf := &Config{}
+	if err := toml.Unmarshal(data, conf); err != nil {
+		t.Fatal(err)
+	}
+	s := &conf.Settings
+	if err := isValidCategoryTTL(s.CategoryTTLs, s.TTL, nil); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		category string
+		def      int
+		max      int
+	}{
+		{category: "image", def: 3600, max: 7200},
+		{category: "archive", def: 600, max: 86400},
+		{category: "document", max: 86400},
+		{category: "", max: 86400},
+	}
+	for i, c := range cases {
+		if def, max := s.CategoryTTL(c.category); def != c.def || max != c.max {
+			t.Errorf("case=%d: failed default=%d, max=%d", i, def, max)
+		}
+	}
+}
+
+func TestIsValidCategoryTTL(t *testing.T) {
+	cases := []struct {
+		categories map[string]CategoryTTL
+		fail       bool
+	}{
+		{},
+		{categories: map[string]CategoryTTL{"image": {Default: 60, Max: 120}}},
+		{categories: map[string]CategoryTTL{"image": {Default: 120}}},
+		{categories: map[string]CategoryTTL{"image": {Default: -1}}, fail: true},
+		{categories: map[string]CategoryTTL{"image": {Max: -1}}, fail: true},
+		{categories: map[string]CategoryTTL{"image": {Default: 120, Max: 60}}, fail: true},
+		{categories: map[string]CategoryTTL{"image": {Max: 121}}, fail: true},
+		{categories: map[string]CategoryTTL{"image": {Default: 121}}, fail: true},
+	}
+	for i, c := range cases {
+		err := isValidCategoryTTL(c.categories, 120, nil)
+		if (err != nil) != c.fail {
+			t.Errorf("case=%d: unexpected error: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package cfg
+
+import "strings"
+
+// ValidationError is a report of all configuration problems found by validation.
+type ValidationError struct {
+	Errors []error
+}
+
+// Error returns all problems separated by new lines.
+func (v *ValidationError) Error() string {
+	messages := make([]string, len(v.Errors))
+	for i, err := range v.Errors {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Unwrap returns found problems, so errors.Is and errors.As can match any of them.
+func (v *ValidationError) Unwrap() []error {
+	return v.Errors
+}
+
+// add appends not nil error, nested reports are flattened.
+func (v *ValidationError) add(err error) {
+	if err == nil {
+		return
+	}
+	if nested, ok := err.(*ValidationError); ok {
+		v.Errors = append(v.Errors, nested.Errors...)
+		return
+	}
+	v.Errors = append(v.Errors, err)
+}
+
+// err returns nil if there are no problems, a single problem as is or the full report.
+func (v *ValidationError) err() error {
+	switch len(v.Errors) {
+	case 0:
+		return nil
+	case 1:
+		return v.Errors[0]
+	}
+	return v
+}
@@ -0,0 +1,172 @@
+package client
+
+// Package client contains methods to upload data to the send server using its API.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// uploadPath is API URL path for uploading.
+const uploadPath = "/api/upload"
+
+// ErrEmpty is an error when file and text are not set.
+var ErrEmpty = errors.New("empty file and text")
+
+// Upload is data for a new item.
+type Upload struct {
+	File     string // path to the file
+	Text     string
+	TTL      time.Duration
+	Times    int
+	Password string // it is generated by the server if empty
+	OneTime  bool
+}
+
+// Result is a response of the upload API.
+type Result struct {
+	URL        string    `json:"url"`
+	Password   string    `json:"password"`
+	PwdDisable bool      `json:"pwd_disable"`
+	HasText    bool      `json:"has_text"`
+	HasFile    bool      `json:"has_file"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// errItem is an error response of the API.
+type errItem struct {
+	Err string `json:"error"`
+}
+
+// Client is an API client of the send server.
+type Client struct {
+	Server   string       // server URL, for example https://localhost:18080
+	HTTP     *http.Client // http.DefaultClient is used if it's nil
+	Progress io.Writer    // optional writer for uploading progress
+}
+
+// progressReader is a reader wrapper that writes a number of read bytes.
+type progressReader struct {
+	r     io.Reader
+	w     io.Writer
+	n     int64
+	total int64
+}
+
+// Read reads data from the wrapped reader and prints the progress.
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	pr.n += int64(n)
+	if pr.total > 0 {
+		fmt.Fprintf(pr.w, "\r%d/%d bytes (%d%%)", pr.n, pr.total, pr.n*100/pr.total)
+	} else {
+		fmt.Fprintf(pr.w, "\r%d bytes", pr.n)
+	}
+	if errors.Is(err, io.EOF) {
+		fmt.Fprintln(pr.w)
+	}
+	return n, err
+}
+
+// writeForm writes multipart form fields and file content.
+func writeForm(mw *multipart.Writer, u *Upload, f *os.File, progress io.Writer) error {
+	fields := [][2]string{
+		{"ttl", strconv.Itoa(int(u.TTL.Seconds()))},
+		{"times", strconv.Itoa(u.Times)},
+		{"text", u.Text},
+		{"password", u.Password},
+		{"one_time", strconv.FormatBool(u.OneTime)},
+	}
+	for _, field := range fields {
+		if err := mw.WriteField(field[0], field[1]); err != nil {
+			return fmt.Errorf("write field %s: %w", field[0], err)
+		}
+	}
+	if f != nil {
+		name := filepath.Base(f.Name())
+		contentType := mime.TypeByExtension(filepath.Ext(name))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, name))
+		h.Set("Content-Type", contentType)
+		part, err := mw.CreatePart(h)
+		if err != nil {
+			return fmt.Errorf("create file part: %w", err)
+		}
+		var src io.Reader = f
+		if progress != nil {
+			pr := &progressReader{r: f, w: progress}
+			if info, e := f.Stat(); e == nil {
+				pr.total = info.Size()
+			}
+			src = pr
+		}
+		if _, err = io.Copy(part, src); err != nil {
+			return fmt.Errorf("write file part: %w", err)
+		}
+	}
+	return mw.Close()
+}
+
+// Upload sends new item data to the server. The file is streamed without full loading to memory.
+func (c *Client) Upload(ctx context.Context, u *Upload) (*Result, error) {
+	var f *os.File
+	if u.File == "" && u.Text == "" {
+		return nil, ErrEmpty
+	}
+	if u.File != "" {
+		fd, err := os.Open(u.File)
+		if err != nil {
+			return nil, fmt.Errorf("open file: %w", err)
+		}
+		defer fd.Close()
+		f = fd
+	}
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeForm(mw, u, f, c.Progress))
+	}()
+	url := strings.TrimSuffix(c.Server, "/") + uploadPath
+	req, err := http.NewRequestWithContext(ctx, "POST", url, pr)
+	if err != nil {
+		pr.Close()
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	httpClient := c.HTTP
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("upload request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		e := &errItem{}
+		if err = json.NewDecoder(resp.Body).Decode(e); err != nil || e.Err == "" {
+			e.Err = resp.Status
+		}
+		return nil, fmt.Errorf("upload failed, status=%d: %s", resp.StatusCode, e.Err)
+	}
+	result := &Result{}
+	if err = json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, fmt.Errorf("decode upload response: %w", err)
+	}
+	return result, nil
+}
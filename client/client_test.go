@@ -0,0 +1,75 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClient_Upload(t *testing.T) {
+	const content = "file content"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != uploadPath {
+			t.Errorf("failed path=%s", r.URL.Path)
+		}
+		if r.FormValue("ttl") != "3600" || r.FormValue("times") != "2" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = io.WriteString(w, `{"error":"incorrect TTL"}`)
+			return
+		}
+		f, h, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("no file: %v", err)
+			return
+		}
+		defer f.Close()
+		b, err := io.ReadAll(f)
+		if err != nil {
+			t.Error(err)
+		}
+		if string(b) != content || h.Filename != "test.txt" {
+			t.Errorf("failed file name=%s, content=%s", h.Filename, b)
+		}
+		if ct := h.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+			t.Errorf("failed content type=%s", ct)
+		}
+		w.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(w, `{"url":"http://localhost/key","password":"secret","pwd_disable":false}`)
+	}))
+	defer ts.Close()
+
+	fileName := filepath.Join(t.TempDir(), "test.txt")
+	if err := os.WriteFile(fileName, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var progress bytes.Buffer
+	c := &Client{Server: ts.URL + "/", Progress: &progress}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := c.Upload(ctx, &Upload{File: fileName, TTL: time.Hour, Times: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.URL != "http://localhost/key" || result.Password != "secret" {
+		t.Errorf("failed result: %+v", result)
+	}
+	if s := progress.String(); !strings.Contains(s, "12/12 bytes (100%)") {
+		t.Errorf("failed progress: %q", s)
+	}
+	_, err = c.Upload(ctx, &Upload{Text: "text", TTL: time.Minute, Times: 2})
+	if err == nil || !strings.Contains(err.Error(), "incorrect TTL") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err = c.Upload(ctx, &Upload{}); !errors.Is(err, ErrEmpty) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
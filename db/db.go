@@ -4,11 +4,14 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/mattn/go-sqlite3"
+
+	"github.com/z0rr0/send/encrypt"
 	"github.com/z0rr0/send/logging"
 )
 
@@ -34,24 +37,53 @@ func InTransaction(ctx context.Context, db *sql.DB, f func(tx *sql.Tx) error) er
 	return nil
 }
 
+// memoryDBs is a counter of in-memory databases to have unique names.
+var memoryDBs uint64
+
+// NewMemory returns a new migrated in-memory SQLite database, it's useful for tests.
+// The database is shared by all connections of the pool and exists until it is closed.
+func NewMemory(ctx context.Context) (*sql.DB, error) {
+	n := atomic.AddUint64(&memoryDBs, 1)
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:memory%d?mode=memory&cache=shared", n))
+	if err != nil {
+		return nil, fmt.Errorf("open memory db: %w", err)
+	}
+	// at least one connection should be kept to save the database
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxLifetime(0)
+	if _, err = Migrate(ctx, db); err != nil {
+		if e := db.Close(); e != nil {
+			err = fmt.Errorf("%w, close memory db: %v", err, e)
+		}
+		return nil, err
+	}
+	return db, nil
+}
+
 // expired returns already expired items for now timestamp or it they have not active counters.
-func expired(ctx context.Context, tx *sql.Tx) ([]*Item, error) {
-	const expiredSQL = "SELECT `id`, `file_path` " +
+// Items are returned only after the grace period since their expiration or the last update,
+// consumed items are kept during their undo window too, and pending ones during confirmation window.
+// Not more than limit items are returned.
+func expired(ctx context.Context, tx *sql.Tx, limit int, grace time.Duration) ([]*Item, error) {
+	const expiredSQL = "SELECT `id`, `key`, `file_path`, `text_path`, `count_text`, `count_file` " +
 		"FROM `storage` " +
-		"WHERE `expired`<? OR (`count_text`<1 AND `count_file`<1) " +
-		"ORDER BY `id`;"
+		"WHERE `expired`<? OR (`count_text`<1 AND `count_file`<1 AND `updated`<? " +
+		"AND (`reread_until` IS NULL OR `reread_until`<?) AND (`confirm_until` IS NULL OR `confirm_until`<?)) " +
+		"ORDER BY `id` LIMIT ?;"
 	var items []*Item
 	stmt, err := tx.PrepareContext(ctx, expiredSQL)
 	if err != nil {
 		return nil, fmt.Errorf("prepare select expired query: %w", err)
 	}
-	rows, err := tx.StmtContext(ctx, stmt).QueryContext(ctx, time.Now().UTC())
+	now := time.Now().UTC()
+	border := now.Add(-grace)
+	rows, err := tx.StmtContext(ctx, stmt).QueryContext(ctx, border, border, now, now, limit)
 	if err != nil {
 		return nil, fmt.Errorf("exec select expired query: %w", err)
 	}
 	for rows.Next() {
 		item := &Item{}
-		err = rows.Scan(&item.ID, &item.FilePath)
+		err = rows.Scan(&item.ID, &item.Key, &item.FilePath, &item.TextPath, &item.CountText, &item.CountFile)
 		if err != nil {
 			return nil, fmt.Errorf("next select expired query: %w", err)
 		}
@@ -73,15 +105,17 @@ func stringIDs(items []*Item) string {
 	return strings.Join(strIDs, ",")
 }
 
-// deleteFiles removes files of items.
+// deleteFiles removes files of items, including files with texts.
 func deleteFiles(items ...*Item) error {
 	for _, item := range items {
-		if item.FilePath == "" {
-			continue
-		}
-		err := os.Remove(item.FilePath)
-		if err != nil {
-			return fmt.Errorf("deleteItems file of item=%d: %w", item.ID, err)
+		for _, path := range []string{item.FilePath, item.TextPath} {
+			if path == "" {
+				continue
+			}
+			err := encrypt.RemoveFile(path)
+			if err != nil {
+				return fmt.Errorf("deleteItems file of item=%d: %w", item.ID, err)
+			}
 		}
 	}
 	return nil
@@ -102,11 +136,26 @@ func deleteItems(ctx context.Context, tx *sql.Tx, items ...*Item) (int64, error)
 	return result.RowsAffected()
 }
 
-// deleteByDateOrCounters removes expired items.
-func deleteByDateOrCounters(ctx context.Context, db *sql.DB) (int64, error) {
-	var n int64
+// Deleted is a key of an item removed by the garbage collector.
+// Consumed is false if the item was not fully read before its expiration.
+type Deleted struct {
+	Key      string
+	Consumed bool
+}
+
+// DeleteHook is called after every committed batch of items removed by date or counters.
+type DeleteHook func(deleted []Deleted)
+
+// deleteBatch removes not more than batch expired items and their files in one transaction.
+// It returns removed items and number of deleted ones.
+func deleteBatch(ctx context.Context, db *sql.DB, batch int, grace time.Duration) ([]*Item, int64, error) {
+	var (
+		n     int64
+		items []*Item
+	)
 	var txErr = InTransaction(ctx, db, func(tx *sql.Tx) error {
-		items, err := expired(ctx, tx)
+		var err error
+		items, err = expired(ctx, tx, batch, grace)
 		if err != nil {
 			return err
 		}
@@ -120,44 +169,168 @@ func deleteByDateOrCounters(ctx context.Context, db *sql.DB) (int64, error) {
 		return deleteFiles(items...)
 	})
 	if txErr != nil {
-		return 0, fmt.Errorf("failed deleteItems item by date: %w", txErr)
+		return nil, 0, txErr
+	}
+	return items, n, nil
+}
+
+// deleteByDateOrCounters removes expired items by batches until all of them are deleted.
+// Every batch is committed separately to keep database locks short, dbT is a timeout of one batch.
+// Items are physically deleted only after graceT period since they became unavailable.
+// Not nil hook is called with keys of every deleted batch.
+func deleteByDateOrCounters(db *sql.DB, batch int, dbT, graceT time.Duration, hook DeleteHook) (int64, error) {
+	var total int64
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), dbT)
+		items, n, err := deleteBatch(ctx, db, batch, graceT)
+		cancel()
+		if err != nil {
+			return total, fmt.Errorf("failed deleteItems item by date: %w", err)
+		}
+		total += n
+		if hook != nil && len(items) > 0 {
+			deleted := make([]Deleted, len(items))
+			for i, item := range items {
+				deleted[i] = Deleted{Key: item.Key, Consumed: item.notActive()}
+			}
+			hook(deleted)
+		}
+		if len(items) < batch {
+			return total, nil
+		}
+	}
+}
+
+// isSQLite returns true if the database uses SQLite driver.
+func isSQLite(db *sql.DB) bool {
+	_, ok := db.Driver().(*sqlite3.SQLiteDriver)
+	return ok
+}
+
+// databaseSize returns a size of SQLite database in bytes, including free pages.
+func databaseSize(ctx context.Context, db *sql.DB) (int64, error) {
+	var pageCount, pageSize int64
+	if err := db.QueryRowContext(ctx, "PRAGMA page_count;").Scan(&pageCount); err != nil {
+		return 0, fmt.Errorf("page count: %w", err)
+	}
+	if err := db.QueryRowContext(ctx, "PRAGMA page_size;").Scan(&pageSize); err != nil {
+		return 0, fmt.Errorf("page size: %w", err)
+	}
+	return pageCount * pageSize, nil
+}
+
+// vacuum rebuilds SQLite database to return free pages to the file system.
+// It can not be called inside a transaction, and it returns a number of reclaimed bytes.
+func vacuum(ctx context.Context, db *sql.DB) (int64, error) {
+	before, err := databaseSize(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = db.ExecContext(ctx, "VACUUM;"); err != nil {
+		return 0, fmt.Errorf("vacuum: %w", err)
+	}
+	after, err := databaseSize(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	return before - after, nil
+}
+
+// state of the last successful GC sweep, it is read by metrics handler
+var (
+	lastSweep   int64 // unix time in nanoseconds
+	lastDeleted int64
+)
+
+// LastSweep returns time of the last successful GC sweep and number of items deleted by it.
+// Time is zero if there were no successful sweeps yet.
+func LastSweep() (time.Time, int64) {
+	var t time.Time
+	if ns := atomic.LoadInt64(&lastSweep); ns > 0 {
+		t = time.Unix(0, ns).UTC()
+	}
+	return t, atomic.LoadInt64(&lastDeleted)
+}
+
+// sweep removes expired items and saves the state of the successful sweep.
+func sweep(db *sql.DB, batch int, dbT, graceT time.Duration, hook DeleteHook) (int64, error) {
+	n, err := deleteByDateOrCounters(db, batch, dbT, graceT, hook)
+	if err != nil {
+		return n, err
 	}
+	atomic.StoreInt64(&lastDeleted, n)
+	atomic.StoreInt64(&lastSweep, time.Now().UnixNano())
 	return n, nil
 }
 
 // GCMonitor is garbage collection monitoring to delete expired by date or counter items.
-func GCMonitor(ch <-chan Item, shutdown, done chan struct{}, db *sql.DB, tickT, dbT time.Duration, l *logging.Log) {
+// Expired items are deleted by batches with maximum size batch.
+// If graceT is positive, items from ch are not deleted immediately, they wait the grace period,
+// except one-time items which are already removed from the database.
+// Not nil hook is called for items deleted by date or counters, but not for ones from ch.
+// If vacuumT is positive, SQLite database is vacuumed with this period,
+// it's done in the same loop, so it never runs during items deletion.
+func GCMonitor(ch <-chan Item, shutdown, done chan struct{}, db *sql.DB, tickT, dbT, graceT, vacuumT time.Duration, batch int, hook DeleteHook, l *logging.Log) {
 	var (
-		cancel context.CancelFunc
-		ctx    context.Context
-		ticker = time.NewTicker(tickT)
+		cancel  context.CancelFunc
+		ctx     context.Context
+		ticker  = time.NewTicker(tickT)
+		vacuumC <-chan time.Time // nil channel if vacuum is disabled
 	)
+	if vacuumT > 0 {
+		if isSQLite(db) {
+			vacuumTicker := time.NewTicker(vacuumT)
+			defer vacuumTicker.Stop()
+			vacuumC = vacuumTicker.C
+		} else {
+			l.Info("vacuum is skipped for not SQLite database")
+		}
+	}
 	defer func() {
 		ticker.Stop()
 		close(done)
 		l.Info("gc monitor stopped")
 	}()
-	l.Info("GC monitor is running, period=%v", tickT)
+	l.Info("GC monitor is running, period=%v, batch=%d, grace=%v, vacuum=%v", tickT, batch, graceT, vacuumT)
 	for {
 		select {
 		case item := <-ch:
+			if graceT > 0 && !item.FileOnly && !item.OneTime {
+				// it will be deleted by date after the grace period
+				continue
+			}
 			ctx, cancel = context.WithTimeout(context.Background(), dbT)
-			if err := item.Delete(ctx, db); err != nil {
-				l.Error("failed deleteItems %s: %v", item.String(), err)
+			if item.FileOnly {
+				if err := item.DeleteFile(ctx, db); err != nil {
+					l.Error("failed delete file of %s: %v", item.String(), err)
+				} else {
+					l.Info("deleted file of %s", item.String())
+				}
 			} else {
-				l.Info("deleted %s", item.String())
+				if err := item.Delete(ctx, db); err != nil {
+					l.Error("failed deleteItems %s: %v", item.String(), err)
+				} else {
+					l.Info("deleted %s", item.String())
+				}
 			}
 			cancel()
 		case <-ticker.C:
-			ctx, cancel = context.WithTimeout(context.Background(), dbT)
-			if n, err := deleteByDateOrCounters(ctx, db); err != nil {
+			n, err := sweep(db, batch, dbT, graceT, hook)
+			if err != nil {
 				l.Error("failed deleteItems item(s) by date: %v", err)
-			} else {
-				if n > 0 {
-					l.Info("deleted %v expired item(s)", n)
-				}
 			}
+			if n > 0 {
+				l.Info("deleted %v expired item(s)", n)
+			}
+		case <-vacuumC:
+			ctx, cancel = context.WithTimeout(context.Background(), dbT)
+			n, err := vacuum(ctx, db)
 			cancel()
+			if err != nil {
+				l.Error("failed vacuum: %v", err)
+			} else {
+				l.Info("vacuum reclaimed %d byte(s)", n)
+			}
 		case <-shutdown:
 			return
 		}
@@ -0,0 +1,1142 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	_ "github.com/mattn/go-sqlite3" // SQLite3 driver package
+
+	"github.com/z0rr0/send/encrypt"
+)
+
+// testDB returns a new migrated database in a temporary directory.
+func testDB(t *testing.T) *sql.DB {
+	database, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if e := database.Close(); e != nil {
+			t.Error(e)
+		}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err = Migrate(ctx, database); err != nil {
+		t.Fatal(err)
+	}
+	return database
+}
+
+func TestMigrate(t *testing.T) {
+	database := testDB(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// repeated call doesn't apply migrations again
+	version, err := Migrate(ctx, database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) {
+		t.Errorf("failed version=%d, expected %d", version, len(migrations))
+	}
+	var n int
+	err = database.QueryRowContext(ctx, "SELECT COUNT(*) FROM `schema_version`;").Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(migrations) {
+		t.Errorf("failed number of applied migrations=%d", n)
+	}
+	err = database.QueryRowContext(ctx, "SELECT COUNT(*) FROM `storage`;").Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("not empty storage table: %d", n)
+	}
+}
+
+// tableColumns returns names of the storage table columns.
+func tableColumns(t *testing.T, database *sql.DB) []string {
+	rows, err := database.Query("SELECT `name` FROM pragma_table_info('storage') ORDER BY `cid`;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		columns = append(columns, name)
+	}
+	if err = rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return columns
+}
+
+func TestCheckSchema(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	empty, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "empty.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if e := empty.Close(); e != nil {
+			t.Error(e)
+		}
+	}()
+	if _, err = CheckSchema(ctx, empty); err == nil {
+		t.Error("expected error for not migrated database")
+	}
+	migrated := testDB(t)
+	if _, err = CheckSchema(ctx, migrated); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// documented schema is the same as migrated one
+	schema, err := os.ReadFile(filepath.Join("..", "doc", "schema.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = empty.ExecContext(ctx, string(schema)); err != nil {
+		t.Fatal(err)
+	}
+	version, err := CheckSchema(ctx, empty)
+	if err != nil {
+		t.Errorf("unexpected error of documented schema: %v", err)
+	}
+	if version != len(migrations) {
+		t.Errorf("failed version=%d", version)
+	}
+	documented, expected := tableColumns(t, empty), tableColumns(t, migrated)
+	if strings.Join(documented, ",") != strings.Join(expected, ",") {
+		t.Errorf("documented columns %v differ from migrated %v", documented, expected)
+	}
+}
+
+// saveItems saves n new items with the expiration time.
+func saveItems(t *testing.T, database *sql.DB, n int, expired time.Time) []*Item {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	items := make([]*Item, n)
+	now := time.Now().UTC()
+	for i := range items {
+		item := &Item{
+			Key:       uuid.New().String(),
+			Text:      "text",
+			CountText: 1,
+			CountMeta: 1,
+			Created:   now,
+			Updated:   now,
+			Expired:   expired,
+		}
+		if err := item.Save(ctx, database); err != nil {
+			t.Fatal(err)
+		}
+		items[i] = item
+	}
+	return items
+}
+
+// countItems returns number of items in the storage table.
+func countItems(t *testing.T, database *sql.DB) int {
+	var n int
+	err := database.QueryRow("SELECT COUNT(*) FROM `storage`;").Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestDeleteByDateOrCounters(t *testing.T) {
+	const (
+		batch   = 20
+		expired = 250
+		active  = 5
+	)
+	database := testDB(t)
+	now := time.Now().UTC()
+	saveItems(t, database, expired, now.Add(-time.Minute))
+	saveItems(t, database, active, now.Add(time.Hour))
+
+	n, err := deleteByDateOrCounters(database, batch, 5*time.Second, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != expired {
+		t.Errorf("failed number of deleted items=%d", n)
+	}
+	if n := countItems(t, database); n != active {
+		t.Errorf("failed number of active items=%d", n)
+	}
+	// nothing to delete
+	n, err = deleteByDateOrCounters(database, batch, 5*time.Second, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("unexpected deleted items=%d", n)
+	}
+}
+
+func TestVacuum(t *testing.T) {
+	database := testDB(t)
+	if !isSQLite(database) {
+		t.Fatal("not SQLite database")
+	}
+	saveItems(t, database, 500, time.Now().UTC().Add(-time.Minute))
+	if _, err := deleteByDateOrCounters(database, 100, 5*time.Second, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	n, err := vacuum(ctx, database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n <= 0 {
+		t.Errorf("failed reclaimed size=%d", n)
+	}
+	if n, err = vacuum(ctx, database); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("failed reclaimed size of compact database=%d", n)
+	}
+}
+
+func TestLastSweep(t *testing.T) {
+	database := testDB(t)
+	saveItems(t, database, 3, time.Now().UTC().Add(-time.Minute))
+	before := time.Now().UTC()
+	n, err := sweep(database, 10, 5*time.Second, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	swept, deleted := LastSweep()
+	if swept.Before(before) || swept.After(time.Now().UTC()) {
+		t.Errorf("failed sweep time=%v", swept)
+	}
+	if n != 3 || deleted != 3 {
+		t.Errorf("failed number of deleted items=%d, %d", n, deleted)
+	}
+	// empty sweep updates the state too
+	if _, err = sweep(database, 10, 5*time.Second, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	next, deleted := LastSweep()
+	if next.Before(swept) || deleted != 0 {
+		t.Errorf("failed state after empty sweep: %v, %d", next, deleted)
+	}
+}
+
+func TestDeleteByDateOrCounters_Hook(t *testing.T) {
+	database := testDB(t)
+	now := time.Now().UTC()
+	expired := saveItems(t, database, 3, now.Add(-time.Minute))
+	consumed := saveItems(t, database, 1, now.Add(time.Hour))[0]
+	saveItems(t, database, 1, now.Add(time.Hour))
+	_, err := database.Exec("UPDATE `storage` SET `count_text`=0, `updated`=? WHERE `id`=?;", now.Add(-time.Second), consumed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted := make(map[string]bool)
+	batches := 0
+	hook := func(items []Deleted) {
+		batches++
+		for _, item := range items {
+			deleted[item.Key] = item.Consumed
+		}
+	}
+	if _, err = deleteByDateOrCounters(database, 2, 5*time.Second, 0, hook); err != nil {
+		t.Fatal(err)
+	}
+	if batches != 2 || len(deleted) != 4 {
+		t.Fatalf("failed hook calls=%d, items=%d", batches, len(deleted))
+	}
+	for _, item := range expired {
+		if c, ok := deleted[item.Key]; !ok || c {
+			t.Errorf("failed expired item %s: %v, %v", item.Key, ok, c)
+		}
+	}
+	if !deleted[consumed.Key] {
+		t.Error("item is not consumed")
+	}
+}
+
+func TestStatuses(t *testing.T) {
+	database := testDB(t)
+	now := time.Now().UTC()
+	active := saveItems(t, database, 3, now.Add(time.Hour))
+	expiredItems := saveItems(t, database, 1, now.Add(-time.Minute))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	keys := []string{active[0].Key, active[2].Key, expiredItems[0].Key, uuid.New().String()}
+	items, err := Statuses(ctx, database, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(items); n != 2 {
+		t.Errorf("failed number of items=%d", n)
+	}
+	for _, key := range keys[:2] {
+		item, ok := items[key]
+		if !ok {
+			t.Errorf("not found item %s", key)
+			continue
+		}
+		if item.CountText != 1 || item.CountFile != 0 {
+			t.Errorf("failed counters of item %s: %d, %d", key, item.CountText, item.CountFile)
+		}
+	}
+	items, err = Statuses(ctx, database, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(items); n != 0 {
+		t.Errorf("failed number of items=%d", n)
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	const password = "secret"
+	database := testDB(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	now := time.Now().UTC()
+	item := &Item{
+		Key:       uuid.New().String(),
+		Text:      "text",
+		CountText: 1,
+		CountMeta: 1,
+		Created:   now,
+		Updated:   now,
+		Expired:   now.Add(time.Hour),
+	}
+	if err := item.Encrypt(password, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := item.Save(ctx, database); err != nil {
+		t.Fatal(err)
+	}
+	ok, err := VerifyPassword(ctx, database, item.Key, password, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error("failed verification of valid password")
+	}
+	ok, err = VerifyPassword(ctx, database, item.Key, "bad", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("failed verification of invalid password")
+	}
+	_, err = VerifyPassword(ctx, database, uuid.New().String(), password, "")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	restricted := &Item{
+		Key:        uuid.New().String(),
+		Text:       "text",
+		CountText:  1,
+		CountMeta:  1,
+		AllowedIPs: "10.0.0.0/8",
+		Created:    now,
+		Updated:    now,
+		Expired:    now.Add(time.Hour),
+	}
+	if err = restricted.Encrypt(password, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = restricted.Save(ctx, database); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = VerifyPassword(ctx, database, restricted.Key, password, "192.0.2.1"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if ok, err = VerifyPassword(ctx, database, restricted.Key, password, "10.1.2.3"); err != nil || !ok {
+		t.Errorf("failed verification from allowed network: %v, %v", ok, err)
+	}
+	// counters are not changed
+	items, err := Statuses(ctx, database, []string{item.Key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := items[item.Key].CountText; n != 1 {
+		t.Errorf("failed text counter=%d", n)
+	}
+}
+
+// saveFileItem saves a new encrypted item with text and file.
+func saveFileItem(t *testing.T, database *sql.DB, password string, countText, countFile int) *Item {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	now := time.Now().UTC()
+	item := &Item{
+		Key:       uuid.New().String(),
+		Text:      "text",
+		FileMeta:  "{\"name\":\"test.txt\"}",
+		CountText: countText,
+		CountMeta: countText + countFile,
+		CountFile: countFile,
+		Created:   now,
+		Updated:   now,
+		Expired:   now.Add(time.Hour),
+		Storage:   t.TempDir(),
+	}
+	if err := item.Encrypt(password, strings.NewReader("file content")); err != nil {
+		t.Fatal(err)
+	}
+	if err := item.Save(ctx, database); err != nil {
+		t.Fatal(err)
+	}
+	return item
+}
+
+func TestItem_DeleteFile(t *testing.T) {
+	const password = "secret"
+	database := testDB(t)
+	saved := saveFileItem(t, database, password, 2, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var buf bytes.Buffer
+	item, err := Read(ctx, database, saved.Key, password, "", &buf, FlagMeta|FlagFile, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := buf.String(); s != "file content" {
+		t.Errorf("failed file content: %s", s)
+	}
+	ch := make(chan Item, 1)
+	item.CheckCounts(ch)
+	if len(ch) != 1 {
+		t.Fatal("item is not sent to delete file")
+	}
+	fileItem := <-ch
+	if !fileItem.FileOnly {
+		t.Fatal("item is sent for full deletion")
+	}
+	if err = fileItem.DeleteFile(ctx, database); err != nil {
+		t.Fatal(err)
+	}
+	if saved.IsFileExists() {
+		t.Error("file is not deleted")
+	}
+	// repeated deletion does nothing
+	fileItem.FilePath = saved.FilePath
+	if err = fileItem.DeleteFile(ctx, database); err != nil {
+		t.Error(err)
+	}
+	// text is still available
+	for i := 0; i < 2; i++ {
+		item, err = Read(ctx, database, saved.Key, password, "", nil, FlagText|FlagMeta, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.Text != "text" {
+			t.Errorf("failed text: %s", item.Text)
+		}
+		if item.FilePath != "" {
+			t.Errorf("file path is not cleared: %s", item.FilePath)
+		}
+	}
+	item.CheckCounts(ch)
+	if fullItem := <-ch; fullItem.FileOnly {
+		t.Error("item is not sent for full deletion")
+	}
+}
+
+func TestItem_TextPath(t *testing.T) {
+	const password = "secret"
+	database := testDB(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	now := time.Now().UTC()
+	text := strings.Repeat("big text ", 1024)
+	item := &Item{
+		Key:       uuid.New().String(),
+		TextSrc:   strings.NewReader(text),
+		CountText: 1,
+		CountMeta: 1,
+		Created:   now,
+		Updated:   now,
+		Expired:   now.Add(time.Hour),
+		Storage:   t.TempDir(),
+	}
+	if err := item.Encrypt(password, nil); err != nil {
+		t.Fatal(err)
+	}
+	if item.TextPath == "" || item.Text != "" {
+		t.Fatalf("text is not stored to file: path=%s", item.TextPath)
+	}
+	if err := item.Save(ctx, database); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Read(ctx, database, item.Key, "bad", "", nil, FlagText|FlagMeta, 0); !errors.Is(err, encrypt.ErrSecret) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	saved, err := Read(ctx, database, item.Key, password, "", nil, FlagText|FlagMeta, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Text != "" {
+		t.Errorf("big text is loaded to memory, length=%d", len(saved.Text))
+	}
+	var buf strings.Builder
+	if err = saved.DecryptTextFile(password, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != text {
+		t.Errorf("failed text length=%d", buf.Len())
+	}
+	n, err := deleteByDateOrCounters(database, 10, 5*time.Second, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("failed number of deleted items=%d", n)
+	}
+	if _, err = os.Stat(item.TextPath); !os.IsNotExist(err) {
+		t.Errorf("text file is not deleted: %v", err)
+	}
+}
+
+func TestRead_OneTime(t *testing.T) {
+	const (
+		password = "secret"
+		readers  = 5
+	)
+	database := testDB(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	now := time.Now().UTC()
+	item := &Item{
+		Key:       uuid.New().String(),
+		Text:      "text",
+		OneTime:   true,
+		CountText: 1,
+		CountMeta: 1,
+		Created:   now,
+		Updated:   now,
+		Expired:   now.Add(time.Hour),
+	}
+	if err := item.Encrypt(password, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := item.Save(ctx, database); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		wg      sync.WaitGroup
+		success int32
+	)
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if saved, err := Read(ctx, database, item.Key, password, "", nil, FlagText|FlagMeta, 0); err == nil {
+				if saved.Text != "text" {
+					t.Errorf("failed text: %s", saved.Text)
+				}
+				atomic.AddInt32(&success, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if success != 1 {
+		t.Errorf("failed number of successful reads=%d", success)
+	}
+	// the item is deleted without GC
+	if n := countItems(t, database); n != 0 {
+		t.Errorf("one-time item is not deleted: %d", n)
+	}
+}
+
+func TestReread(t *testing.T) {
+	const password = "secret"
+	database := testDB(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	now := time.Now().UTC()
+	item := &Item{
+		Key:       uuid.New().String(),
+		Text:      "text",
+		OneTime:   true,
+		CountText: 1,
+		CountMeta: 1,
+		Created:   now,
+		Updated:   now,
+		Expired:   now.Add(time.Hour),
+	}
+	if err := item.Encrypt(password, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := item.Save(ctx, database); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := Read(ctx, database, item.Key, password, "", nil, FlagText|FlagMeta, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !saved.Undo || saved.Token == "" {
+		t.Fatalf("failed undo token: %+v", saved)
+	}
+	// the consumed item is not available for usual reading
+	if _, err = Read(ctx, database, item.Key, password, "", nil, FlagText|FlagMeta, time.Minute); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err = Reread(ctx, database, item.Key, "bad", password, "", nil, FlagText|FlagMeta); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		reread, e := Reread(ctx, database, item.Key, saved.Token, password, "", nil, FlagText|FlagMeta)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if reread.Text != "text" || !reread.Undo {
+			t.Errorf("failed reread item: %+v", reread)
+		}
+	}
+	// GC keeps the item during the undo window
+	n, err := deleteByDateOrCounters(database, 10, 5*time.Second, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("item is deleted in the undo window: %d", n)
+	}
+	_, err = database.ExecContext(ctx, "UPDATE `storage` SET `reread_until`=? WHERE `id`=?;", now.Add(-time.Second), saved.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Reread(ctx, database, item.Key, saved.Token, password, "", nil, FlagText|FlagMeta); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if n, err = deleteByDateOrCounters(database, 10, 5*time.Second, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("failed number of deleted items=%d", n)
+	}
+}
+
+func TestExists(t *testing.T) {
+	database := testDB(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	now := time.Now().UTC()
+	item := &Item{
+		Key:       uuid.New().String(),
+		Text:      "text",
+		Hint:      "<b>usual word</b>",
+		FileInfo:  "{\"size\":10}",
+		CountText: 1,
+		CountMeta: 1,
+		Created:   now,
+		Updated:   now,
+		Expired:   now.Add(time.Hour),
+	}
+	if err := item.Encrypt("secret", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := item.Save(ctx, database); err != nil {
+		t.Fatal(err)
+	}
+	found, err := Exists(ctx, database, item.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Hint != item.Hint || found.FileInfo != item.FileInfo || found.CountText != 1 {
+		t.Errorf("failed item: hint=%s, file info=%s, text counter=%d", found.Hint, found.FileInfo, found.CountText)
+	}
+	if _, err = Exists(ctx, database, uuid.New().String()); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestItem_Checksum(t *testing.T) {
+	const password = "secret"
+	database := testDB(t)
+	saved := saveFileItem(t, database, password, 1, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	item, err := Read(ctx, database, saved.Key, password, "", nil, FlagText|FlagMeta, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := make(map[string]interface{})
+	if err = json.Unmarshal([]byte(item.FileMeta), &meta); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("file content"))
+	if v := meta["checksum"]; v != hex.EncodeToString(sum[:]) {
+		t.Errorf("failed checksum=%v", v)
+	}
+	if v := meta["name"]; v != "test.txt" {
+		t.Errorf("failed name=%v", v)
+	}
+}
+
+func TestDeleteByDateOrCounters_Grace(t *testing.T) {
+	database := testDB(t)
+	now := time.Now().UTC()
+	items := saveItems(t, database, 1, now.Add(-time.Minute))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	n, err := deleteByDateOrCounters(database, 10, 5*time.Second, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("item is deleted in the grace period: %d", n)
+	}
+	// item is not available in the grace period
+	if _, err = Exists(ctx, database, items[0].Key); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	n, err = deleteByDateOrCounters(database, 10, 5*time.Second, 30*time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("item is not deleted after the grace period: %d", n)
+	}
+}
+
+func TestNearestFileExpiry(t *testing.T) {
+	database := testDB(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// items without files are ignored
+	saveItems(t, database, 2, time.Now().UTC().Add(time.Minute))
+	if _, err := NearestFileExpiry(ctx, database); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	item := saveFileItem(t, database, "secret", 1, 1)
+	saveFileItem(t, database, "secret", 1, 1)
+	expired, err := NearestFileExpiry(ctx, database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !expired.Equal(item.Expired) {
+		t.Errorf("failed expiration time=%v, expected %v", expired, item.Expired)
+	}
+}
+
+func TestExtend(t *testing.T) {
+	const (
+		password = "secret"
+		maxTimes = 5
+		maxTTL   = 3 * time.Hour
+	)
+	database := testDB(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	saved := saveFileItem(t, database, password, 2, 0)
+
+	item, err := Extend(ctx, database, saved.Key, password, "", time.Hour, 3, maxTTL, maxTimes, maxTimes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.CountText != 5 || item.CountFile != 0 || item.CountMeta != 5 {
+		t.Errorf("failed counters: %d, %d, %d", item.CountText, item.CountMeta, item.CountFile)
+	}
+	if d := item.Expired.Sub(saved.Expired); d != time.Hour {
+		t.Errorf("failed expiration delta=%v", d)
+	}
+	if _, err = Extend(ctx, database, saved.Key, password, "", 0, 1, maxTTL, maxTimes, maxTimes); !errors.Is(err, ErrExtend) {
+		t.Errorf("unexpected error for times: %v", err)
+	}
+	if _, err = Extend(ctx, database, saved.Key, password, "", 2*time.Hour, 0, maxTTL, maxTimes, maxTimes); !errors.Is(err, ErrExtend) {
+		t.Errorf("unexpected error for ttl: %v", err)
+	}
+	if _, err = Extend(ctx, database, saved.Key, "bad", "", time.Minute, 0, maxTTL, maxTimes, maxTimes); !errors.Is(err, encrypt.ErrSecret) {
+		t.Errorf("unexpected error for password: %v", err)
+	}
+	// saved values
+	items, err := Statuses(ctx, database, []string{saved.Key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := items[saved.Key].CountText; n != 5 {
+		t.Errorf("failed text counter=%d", n)
+	}
+	// expired item
+	expired := saveItems(t, database, 1, time.Now().UTC().Add(-time.Second))[0]
+	if _, err = Extend(ctx, database, expired.Key, password, "", time.Hour, 1, maxTTL, maxTimes, maxTimes); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unexpected error for expired item: %v", err)
+	}
+}
+
+// opContext returns a context of one operation, which is cancelled after the test.
+// Every PBKDF2 key derivation takes a noticeable time, and rotation derives several keys,
+// so a common timeout for a sequence of such operations is not reliable.
+func opContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestRotate(t *testing.T) {
+	const password, newPassword = "secret", "new secret"
+	database := testDB(t)
+	saved := saveFileItem(t, database, password, 2, 1)
+
+	if _, err := Rotate(opContext(t), database, saved.Key, "bad", newPassword, ""); !errors.Is(err, encrypt.ErrSecret) {
+		t.Errorf("unexpected error for password: %v", err)
+	}
+	item, err := Rotate(opContext(t), database, saved.Key, password, newPassword, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.FilePath == saved.FilePath || item.HashText == saved.HashText {
+		t.Error("item is not re-encrypted")
+	}
+	if item.CountText != 2 || item.CountFile != 1 || !item.Expired.Equal(saved.Expired) {
+		t.Errorf("failed counters or expiration: %d, %d, %v", item.CountText, item.CountFile, item.Expired)
+	}
+	if encrypt.FileExists(saved.FilePath) || !encrypt.FileExists(item.FilePath) {
+		t.Error("file is not replaced")
+	}
+	if _, err = Read(opContext(t), database, saved.Key, password, "", nil, FlagText, 0); !errors.Is(err, encrypt.ErrSecret) {
+		t.Errorf("unexpected error for old password: %v", err)
+	}
+	var dst bytes.Buffer
+	item, err = Read(opContext(t), database, saved.Key, newPassword, "", &dst, FlagText|FlagMeta|FlagFile, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Text != "text" || !strings.Contains(item.FileMeta, "test.txt") || dst.String() != "file content" {
+		t.Errorf("failed values: %s, %s, %s", item.Text, item.FileMeta, dst.String())
+	}
+	if _, err = Rotate(opContext(t), database, uuid.New().String(), password, newPassword, ""); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unexpected error for unknown item: %v", err)
+	}
+}
+
+func TestRead_FileOnlyDecrement(t *testing.T) {
+	const password = "secret"
+	database := testDB(t)
+	saved := saveFileItem(t, database, password, 2, 2)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var dst bytes.Buffer
+	if _, err := Read(ctx, database, saved.Key, password, "", &dst, FlagFile, 0); err != nil {
+		t.Fatal(err)
+	}
+	if s := dst.String(); s != "file content" {
+		t.Errorf("failed file content=%s", s)
+	}
+	var countText, countMeta, countFile int
+	err := database.QueryRowContext(ctx, "SELECT `count_text`, `count_meta`, `count_file` FROM `storage` WHERE `id`=?;", saved.ID).
+		Scan(&countText, &countMeta, &countFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if countText != 2 || countMeta != 4 || countFile != 1 {
+		t.Errorf("failed counters: text=%d, meta=%d, file=%d", countText, countMeta, countFile)
+	}
+}
+
+func TestParseAllowedIPs(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected string
+		err      bool
+	}{
+		{value: "", expected: ""},
+		{value: "10.0.0.0/8, 192.168.1.1", expected: "10.0.0.0/8,192.168.1.1/32"},
+		{value: "10.1.2.3/8 ::1", expected: "10.0.0.0/8,::1/128"},
+		{value: "10.0.0.0/33", err: true},
+		{value: "localhost", err: true},
+		{value: strings.Repeat("10.0.0.1,", maxAllowedNetworks+1), err: true},
+	}
+	for i, c := range cases {
+		value, err := ParseAllowedIPs(c.value)
+		if c.err {
+			if !errors.Is(err, ErrAllowedIPs) {
+				t.Errorf("case=%d: unexpected error: %v", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case=%d: unexpected error: %v", i, err)
+			continue
+		}
+		if value != c.expected {
+			t.Errorf("case=%d: failed value=%s", i, value)
+		}
+	}
+}
+
+func TestItem_isAllowed(t *testing.T) {
+	cases := []struct {
+		allowed  string
+		ip       string
+		expected bool
+	}{
+		{allowed: "", ip: "", expected: true},
+		{allowed: "10.0.0.0/8", ip: "10.1.2.3", expected: true},
+		{allowed: "10.0.0.0/8,192.168.1.1/32", ip: "192.168.1.1", expected: true},
+		{allowed: "10.0.0.0/8", ip: "192.168.1.1"},
+		{allowed: "10.0.0.0/8", ip: ""},
+	}
+	for i, c := range cases {
+		item := &Item{AllowedIPs: c.allowed}
+		if v := item.isAllowed(c.ip); v != c.expected {
+			t.Errorf("case=%d: failed result=%v", i, v)
+		}
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	const password = "secret"
+	database := testDB(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	now := time.Now().UTC()
+	newPending := func(window time.Duration) *Item {
+		item := &Item{
+			Key:       uuid.New().String(),
+			Text:      "text",
+			CountText: 2,
+			CountMeta: 2,
+			Created:   now,
+			Updated:   now,
+			Expired:   now.Add(time.Hour),
+		}
+		if err := item.Encrypt(password, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := item.SetPending(window); err != nil {
+			t.Fatal(err)
+		}
+		if err := item.Save(ctx, database); err != nil {
+			t.Fatal(err)
+		}
+		return item
+	}
+	pending := newPending(time.Hour)
+	if pending.CountText != 0 || pending.PendingText != 2 || pending.ConfirmToken == "" {
+		t.Errorf("failed pending item: %d, %d, %q", pending.CountText, pending.PendingText, pending.ConfirmToken)
+	}
+	if _, err := Read(ctx, database, pending.Key, password, "", nil, FlagText, 0); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unexpected error for pending item: %v", err)
+	}
+	// pending item is not deleted during confirmation window
+	if _, err := deleteByDateOrCounters(database, 10, 5*time.Second, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Confirm(ctx, database, pending.Key, "bad"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unexpected error for bad token: %v", err)
+	}
+	item, err := Confirm(ctx, database, pending.Key, pending.ConfirmToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.CountText != 2 || item.CountFile != 0 || !item.Expired.Equal(pending.Expired) {
+		t.Errorf("failed confirmed item: %d, %d, %v", item.CountText, item.CountFile, item.Expired)
+	}
+	if _, err = Confirm(ctx, database, pending.Key, pending.ConfirmToken); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unexpected error for repeated confirmation: %v", err)
+	}
+	if item, err = Read(ctx, database, pending.Key, password, "", nil, FlagText, 0); err != nil {
+		t.Fatal(err)
+	}
+	if item.Text != "text" {
+		t.Errorf("failed text=%s", item.Text)
+	}
+	// not confirmed item is deleted after its window
+	expired := newPending(-time.Second)
+	if _, err = Confirm(ctx, database, expired.Key, expired.ConfirmToken); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unexpected error for expired confirmation: %v", err)
+	}
+	n, err := deleteByDateOrCounters(database, 10, 5*time.Second, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("failed number of deleted items=%d", n)
+	}
+	if n := countItems(t, database); n != 1 {
+		t.Errorf("failed number of items=%d", n)
+	}
+}
+
+func TestCountActive(t *testing.T) {
+	database := testDB(t)
+	now := time.Now().UTC()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	saveItems(t, database, 2, now.Add(-time.Minute))
+	active := saveItems(t, database, 3, now.Add(time.Hour))
+	_, err := database.ExecContext(ctx, "UPDATE `storage` SET `count_text`=0 WHERE `id`=?;", active[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := CountActive(ctx, database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("failed number of active items=%d", n)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	database := testDB(t)
+	now := time.Now().UTC()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	expired := saveItems(t, database, 1, now.Add(-time.Minute))[0]
+	active := saveItems(t, database, 2, now.Add(time.Hour))
+	_, err := database.ExecContext(ctx, "UPDATE `storage` SET `count_text`=0 WHERE `id`=?;", active[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		key      string
+		expected State
+	}{
+		{key: uuid.New().String(), expected: StateAbsent},
+		{key: expired.Key, expected: StateExpired},
+		{key: active[0].Key, expected: StateActive},
+		{key: active[1].Key, expected: StateConsumed},
+	}
+	for i, c := range cases {
+		state, err := Lookup(ctx, database, c.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state != c.expected {
+			t.Errorf("case=%d: failed state=%v", i, state)
+		}
+	}
+}
+
+func TestItem_Cipher(t *testing.T) {
+	const password = "secret"
+	database := testDB(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := encrypt.SetUpSuite(encrypt.SuiteChaCha20); err != nil {
+		t.Fatal(err)
+	}
+	saved := saveFileItem(t, database, password, 1, 1)
+	if err := encrypt.SetUpSuite(encrypt.SuiteLegacy); err != nil {
+		t.Fatal(err)
+	}
+	var dst bytes.Buffer
+	item, err := Read(ctx, database, saved.Key, password, "", &dst, FlagText|FlagMeta|FlagFile, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Cipher != encrypt.SuiteChaCha20 {
+		t.Errorf("failed cipher=%q", item.Cipher)
+	}
+	if item.Text != "text" || !strings.Contains(item.FileMeta, "test.txt") || dst.String() != "file content" {
+		t.Errorf("failed values: %s, %s, %s", item.Text, item.FileMeta, dst.String())
+	}
+}
+
+func TestSetUpSecret(t *testing.T) {
+	const password = "secret"
+	database := testDB(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	boundary := time.Now().UTC().Add(-time.Minute)
+	SetUpSecret(func(p string, created time.Time) string {
+		if created.Before(boundary) {
+			return p + "old"
+		}
+		return p + "new"
+	})
+	defer SetUpSecret(func(p string, _ time.Time) string { return p })
+	saved := saveFileItem(t, database, password, 2, 1)
+	if msg := saved.passwordMsg(); !mustVerify(t, password+"new", msg) || mustVerify(t, password, msg) {
+		t.Error("item is not encrypted by the secret")
+	}
+	if ok, err := VerifyPassword(ctx, database, saved.Key, password, ""); err != nil || !ok {
+		t.Errorf("failed password verification: %v, %v", ok, err)
+	}
+	var dst bytes.Buffer
+	item, err := Read(ctx, database, saved.Key, password, "", &dst, FlagText|FlagMeta|FlagFile, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Text != "text" || dst.String() != "file content" {
+		t.Errorf("failed values: %s, %s", item.Text, dst.String())
+	}
+	// the secret of old items is selected by their creation time
+	boundary = time.Now().UTC().Add(time.Minute)
+	if ok, err := VerifyPassword(ctx, database, saved.Key, password, ""); err != nil || ok {
+		t.Errorf("password is verified by another secret: %v", err)
+	}
+}
+
+// mustVerify returns encrypt.Verify result of the secret.
+func mustVerify(t *testing.T, secret string, m *encrypt.Msg) bool {
+	ok, err := encrypt.Verify(secret, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ok
+}
+
+func TestNamespaces(t *testing.T) {
+	database := testDB(t)
+	ctx := opContext(t)
+	teamA, teamB := WithNamespace(ctx, "team-a"), WithNamespace(ctx, "team-b")
+	item := &Item{Key: uuid.New().String(), Text: "text", CountText: 1, CountMeta: 1, Expired: time.Now().UTC().Add(time.Hour)}
+	if err := item.Save(teamA, database); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []context.Context{ctx, teamB} {
+		if _, err := Exists(c, database, item.Key); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("failed exists error: %v", err)
+		}
+		if state, err := Lookup(c, database, item.Key); err != nil || state != StateAbsent {
+			t.Errorf("failed state=%v: %v", state, err)
+		}
+		if items, err := Statuses(c, database, []string{item.Key}); err != nil || len(items) != 0 {
+			t.Errorf("failed statuses %v: %v", items, err)
+		}
+	}
+	if _, err := Exists(teamA, database, item.Key); err != nil {
+		t.Errorf("failed exists error: %v", err)
+	}
+	// purge
+	n, err := Purge(ctx, database, "team-b")
+	if err != nil || n != 0 {
+		t.Errorf("failed purge n=%d: %v", n, err)
+	}
+	if n, err = Purge(ctx, database, "team-a"); err != nil || n != 1 {
+		t.Errorf("failed purge n=%d: %v", n, err)
+	}
+	if state, err := Lookup(teamA, database, item.Key); err != nil || state != StateExpired {
+		t.Errorf("failed state=%v: %v", state, err)
+	}
+}
@@ -3,12 +3,16 @@ package db
 import (
 	"context"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
-	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/z0rr0/send/encrypt"
@@ -23,6 +27,8 @@ const (
 	FlagText DecryptFlag = 1 << iota
 	FlagMeta
 	FlagFile
+	// FlagUpgrade re-encrypts the read legacy item by the current cipher suite, it's not a decryption flag.
+	FlagUpgrade
 )
 
 var (
@@ -30,6 +36,12 @@ var (
 	ErrDecrement = errors.New("can not decrement item")
 	// ErrNoAttempts is an error when there are no attempts to read some data
 	ErrNoAttempts = errors.New("no more attempts")
+	// ErrExtend is an error when new item's limits exceed allowed ones.
+	ErrExtend = errors.New("can not extend item")
+	// ErrForbidden is an error when the item can not be read from the client's IP address.
+	ErrForbidden = errors.New("access is not allowed from this address")
+	// ErrAllowedIPs is an error when allowed networks list is incorrect.
+	ErrAllowedIPs = errors.New("incorrect allowed IP addresses or networks")
 
 	// all decryption flags
 	flagSlice = [3]DecryptFlag{FlagText, FlagMeta, FlagFile}
@@ -37,33 +49,72 @@ var (
 
 // Item is base data struct for incoming data.
 type Item struct {
-	ID        int64
-	Key       string
-	Text      string
-	FileMeta  string
-	FilePath  string
-	CountText int
-	CountMeta int
-	CountFile int
-	HashText  string
-	HashFile  string
-	HashMeta  string
-	SaltText  string
-	SaltFile  string
-	SaltMeta  string
-	Created   time.Time
-	Updated   time.Time
-	Expired   time.Time
+	ID          int64
+	Key         string
+	Text        string
+	FileMeta    string
+	FilePath    string
+	TextPath    string
+	OneTime     bool   // item is deleted right after the last read
+	Hint        string // public not encrypted password hint
+	FileInfo    string // public not encrypted file info without its name
+	Master      bool   // data is encrypted with the server master key too
+	Cipher      string // cipher suite of encrypted data
+	AllowedIPs  string // comma-separated CIDRs of networks allowed to read the item, empty if unrestricted
+	OriginIP    string // salted hash of the uploader IP address, it's empty if origin is not stored
+	OriginAgent string // salted hash of the uploader user agent
+	CountText   int
+	CountMeta   int
+	CountFile   int
+	HashText    string
+	HashFile    string
+	HashMeta    string
+	SaltText    string
+	SaltFile    string
+	SaltMeta    string
+	Created     time.Time
+	Updated     time.Time
+	Expired     time.Time
 	// without saving to db
+	TextSrc      io.Reader // big text source, it is encrypted to a file
+	FileSize     int64     // plaintext size of the encrypted file
+	Stored       int64     // total size of the stored text and file, it can differ from plaintext sizes
+	Checksum     string    // hex SHA-256 of the plaintext file, it's added to file metadata
+	FileOnly     bool      // only file should be deleted, text is still available
+	Undo         bool      // consumed item is kept during the undo window, GC deletes it later
+	Token        string    // token to read the consumed item again, it is issued by its last read
+	RereadUntil  time.Time // end of the undo window
+	PendingText  int       // text counter of not confirmed item, it's set by confirmation
+	PendingFile  int       // file counter of not confirmed item
+	ConfirmToken string    // token to confirm the pending item, only its hash is saved
+	ConfirmUntil time.Time // end of the confirmation window, zero for not pending items
+	ResumeToken  string    // token to resume the file download, only its hash is saved
+	ResumeUntil  time.Time // end of the file attempt reservation
 	AutoPassword bool
 	Storage      string
 	ErrLogger    *logging.Log
+	UpgradeErr   error // failed re-encryption by the current cipher suite, the item is read anyway
+	reserved     int   // file attempts which are reserved by not finished downloads
 }
 
 func (item *Item) encryptText(secret string, e error) error {
 	if e != nil {
 		return e
 	}
+	if item.TextSrc != nil {
+		// big text is encrypted to a file with random name
+		m, err := encrypt.File(secret, item.TextSrc, item.Storage, "")
+		if err != nil {
+			return err
+		}
+		item.Text = ""
+		item.TextPath = m.Value
+		item.Stored += m.Written
+		item.HashText = m.Hash
+		item.SaltText = m.Salt
+		item.Master, item.Cipher = m.Master, m.Suite
+		return nil
+	}
 	if item.Text == "" {
 		return nil
 	}
@@ -74,6 +125,7 @@ func (item *Item) encryptText(secret string, e error) error {
 	item.Text = m.Value
 	item.HashText = m.Hash
 	item.SaltText = m.Salt
+	item.Master, item.Cipher = m.Master, m.Suite
 	return nil
 }
 
@@ -81,11 +133,22 @@ func (item *Item) decryptText(secret string, e error) error {
 	if e != nil {
 		return e
 	}
+	if item.TextPath != "" {
+		// big text is not loaded to memory, only the secret is checked, the text is streamed by DecryptTextFile
+		ok, err := encrypt.Verify(secret, &encrypt.Msg{Salt: item.SaltText, Hash: item.HashText})
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return encrypt.ErrSecret
+		}
+		return nil
+	}
 	if item.Text == "" {
 		// nothing to decrypt
 		return nil
 	}
-	m := &encrypt.Msg{Salt: item.SaltText, Value: item.Text, Hash: item.HashText}
+	m := &encrypt.Msg{Salt: item.SaltText, Value: item.Text, Hash: item.HashText, Master: item.Master, Suite: item.Cipher}
 	plainText, err := encrypt.DecryptText(secret, m)
 	if err != nil {
 		return err
@@ -94,6 +157,21 @@ func (item *Item) decryptText(secret string, e error) error {
 	return nil
 }
 
+// addChecksum adds the file checksum to JSON object of file metadata.
+func (item *Item) addChecksum() error {
+	meta := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(item.FileMeta), &meta); err != nil {
+		return fmt.Errorf("decode file meta: %w", err)
+	}
+	meta["checksum"] = item.Checksum
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("encode file meta: %w", err)
+	}
+	item.FileMeta = string(b)
+	return nil
+}
+
 func (item *Item) encryptFileMeta(secret string, e error) error {
 	if e != nil {
 		return e
@@ -101,6 +179,11 @@ func (item *Item) encryptFileMeta(secret string, e error) error {
 	if item.FileMeta == "" {
 		return nil
 	}
+	if item.Checksum != "" {
+		if err := item.addChecksum(); err != nil {
+			return err
+		}
+	}
 	m, err := encrypt.Text(secret, item.FileMeta)
 	if err != nil {
 		return err
@@ -108,6 +191,7 @@ func (item *Item) encryptFileMeta(secret string, e error) error {
 	item.FileMeta = m.Value
 	item.HashMeta = m.Hash
 	item.SaltMeta = m.Salt
+	item.Master, item.Cipher = m.Master, m.Suite
 	return nil
 }
 
@@ -118,7 +202,7 @@ func (item *Item) decryptFileMeta(secret string, e error) error {
 	if item.FileMeta == "" {
 		return nil
 	}
-	m := &encrypt.Msg{Salt: item.SaltMeta, Value: item.FileMeta, Hash: item.HashMeta}
+	m := &encrypt.Msg{Salt: item.SaltMeta, Value: item.FileMeta, Hash: item.HashMeta, Master: item.Master, Suite: item.Cipher}
 	plainText, err := encrypt.DecryptText(secret, m)
 	if err != nil {
 		return err
@@ -144,6 +228,10 @@ func (item *Item) encryptFile(secret string, src io.Reader, e error) error {
 	item.FilePath = m.Value
 	item.HashFile = m.Hash
 	item.SaltFile = m.Salt
+	item.FileSize = m.Size
+	item.Stored += m.Written
+	item.Checksum = m.Checksum
+	item.Master, item.Cipher = m.Master, m.Suite
 	return nil
 }
 
@@ -154,23 +242,43 @@ func (item *Item) decryptFile(secret string, dst io.Writer, e error) error {
 	if (item.FileMeta == "") || (dst == nil) {
 		return nil
 	}
-	m := &encrypt.Msg{Salt: item.SaltFile, Hash: item.HashFile, Value: item.FilePath}
+	m := &encrypt.Msg{Salt: item.SaltFile, Hash: item.HashFile, Value: item.FilePath, Master: item.Master, Suite: item.Cipher}
 	return encrypt.DecryptFile(secret, m, dst)
 }
 
-// Encrypt updates item's fields by encrypted values.
-func (item *Item) Encrypt(secret string, src io.Reader) error {
+// DecryptTextFile writes the big text, which is stored as an encrypted file, to dst.
+// It does nothing for texts stored in the database, they are decrypted by Decrypt.
+func (item *Item) DecryptTextFile(password string, dst io.Writer) error {
+	if item.TextPath == "" {
+		return nil
+	}
+	m := &encrypt.Msg{Salt: item.SaltText, Hash: item.HashText, Value: item.TextPath, Master: item.Master, Suite: item.Cipher}
+	return encrypt.DecryptFile(item.secret(password), m, dst)
+}
+
+// Encrypt updates item's fields by values encrypted by the password with the salt of item's creation time.
+// The file is encrypted before its metadata to add the plaintext checksum there.
+func (item *Item) Encrypt(password string, src io.Reader) error {
 	var err error
+	secret := item.secret(password)
 	err = item.encryptText(secret, err)
+	err = item.encryptFile(secret, src, err)
 	err = item.encryptFileMeta(secret, err)
-	return item.encryptFile(secret, src, err)
+	if err != nil {
+		// remove already encrypted files
+		if e := deleteFiles(item); e != nil {
+			return fmt.Errorf("%w, delete files: %v", err, e)
+		}
+	}
+	return err
 }
 
-// Decrypt updates item's fields by decrypted values.
-func (item *Item) Decrypt(secret string, dst io.Writer, flags DecryptFlag, err error) error {
+// Decrypt updates item's fields by values decrypted by the password with the salt of item's creation time.
+func (item *Item) Decrypt(password string, dst io.Writer, flags DecryptFlag, err error) error {
 	if err != nil {
 		return err
 	}
+	secret := item.secret(password)
 	if flags&FlagText != 0 {
 		err = item.decryptText(secret, err)
 	}
@@ -202,10 +310,14 @@ func (item *Item) String() string {
 	return fmt.Sprintf("Item{%s}", item.Key)
 }
 
+// DeleteFiles removes item's encrypted text and file, it is used if the item is not saved.
+func (item *Item) DeleteFiles() error {
+	return deleteFiles(item)
+}
+
 // IsFileExists checks item's related file exists.
 func (item *Item) IsFileExists() bool {
-	_, err := os.Stat(item.FilePath)
-	return err == nil
+	return encrypt.FileExists(item.FilePath)
 }
 
 // Delete removes items from database and related file from file system.
@@ -222,20 +334,53 @@ func (item *Item) Delete(ctx context.Context, db *sql.DB) error {
 	return deleteFiles(item)
 }
 
-// Save saves the item to thd db database.
+// DeleteFile removes item's file from file system and clears its path in database.
+// The record is kept for text reading. Repeated calls for the same item do nothing.
+func (item *Item) DeleteFile(ctx context.Context, db *sql.DB) error {
+	const updateSQL = "UPDATE `storage` SET `file_path`='', `updated`=? WHERE `id`=? AND `file_path`=?;"
+	if item.FilePath == "" {
+		return nil
+	}
+	var txErr = InTransaction(ctx, db, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, updateSQL, time.Now().UTC(), item.ID, item.FilePath)
+		if err != nil {
+			return fmt.Errorf("exec clear file path: %w", err)
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("check updated rows after file path clear: %w", err)
+		}
+		if n == 0 {
+			// file is already deleted
+			return nil
+		}
+		return deleteFiles(&Item{ID: item.ID, FilePath: item.FilePath})
+	})
+	if txErr != nil {
+		return fmt.Errorf("failed delete file of item: %w", txErr)
+	}
+	item.FilePath = ""
+	return nil
+}
+
+// Save saves the item to thd db database inside the namespace of ctx.
 func (item *Item) Save(ctx context.Context, db *sql.DB) error {
 	const insertSQL = "INSERT INTO `storage` " +
-		"(`key`,`text`,`file_meta`,`file_path`,`count_text`,`count_meta`,`count_file`," +
+		"(`key`,`text`,`file_meta`,`file_path`,`text_path`,`one_time`,`hint`,`file_info`,`master`,`cipher`,`allowed_ips`,`origin_ip`,`origin_agent`," +
+		"`count_text`,`count_meta`,`count_file`," +
 		"`hash_text`,`hash_meta`,`hash_file`,`salt_text`,`salt_meta`,`salt_file`," +
-		"`created`,`updated`,`expired`) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?);"
+		"`confirm_token`,`confirm_until`,`pending_text`,`pending_file`,`namespace`," +
+		"`created`,`updated`,`expired`) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?);"
 	return InTransaction(ctx, db, func(tx *sql.Tx) error {
 		stmt, err := tx.PrepareContext(ctx, insertSQL)
 		if err != nil {
 			return fmt.Errorf("insert statement: %w", err)
 		}
 		result, err := tx.StmtContext(ctx, stmt).ExecContext(ctx,
-			item.Key, item.Text, item.FileMeta, item.FilePath, item.CountText, item.CountMeta, item.CountFile,
+			item.Key, item.Text, item.FileMeta, item.FilePath, item.TextPath, item.OneTime, item.Hint, item.FileInfo, item.Master, item.Cipher, item.AllowedIPs, item.OriginIP, item.OriginAgent,
+			item.CountText, item.CountMeta, item.CountFile,
 			item.HashText, item.HashMeta, item.HashFile, item.SaltText, item.SaltMeta, item.SaltFile,
+			item.confirmHash(), item.confirmUntil(), item.PendingText, item.PendingFile, namespace(ctx),
 			item.Created, item.Created, item.Expired,
 		)
 		if err != nil {
@@ -249,21 +394,40 @@ func (item *Item) Save(ctx context.Context, db *sql.DB) error {
 	})
 }
 
+// readColumns are columns of the item for reading.
+const readColumns = "SELECT `id`,`key`,`text`,`file_meta`,`file_path`,`text_path`,`one_time`,`master`,`cipher`,`allowed_ips`," +
+	"`count_text`,`count_meta`,`count_file`," +
+	"`hash_text`,`hash_meta`,`hash_file`," +
+	"`salt_text`,`salt_meta`,`salt_file`," +
+	"`created`,`updated`,`expired` " +
+	"FROM `storage` "
+
 // read loads an unexpired Item from database by the key.
 func (item *Item) read(ctx context.Context, tx *sql.Tx, key string) error {
-	const readSQL = "SELECT `id`,`key`,`text`,`file_meta`,`file_path`," +
-		"`count_text`,`count_meta`,`count_file`," +
-		"`hash_text`,`hash_meta`,`hash_file`," +
-		"`salt_text`,`salt_meta`,`salt_file`," +
-		"`created`,`updated`,`expired` " +
-		"FROM `storage` " +
-		"WHERE `key`=? AND `expired`>=? AND ((`count_text`>0) OR (`count_file`>0));"
-	stmt, err := tx.PrepareContext(ctx, readSQL)
+	const readSQL = readColumns + "WHERE `key`=? AND `namespace`=? AND `expired`>=? AND ((`count_text`>0) OR (`count_file`>0));"
+	return item.scan(ctx, tx, readSQL, key, namespace(ctx), time.Now().UTC())
+}
+
+// readConsumed loads a consumed Item from database by the key and token during its undo window.
+func (item *Item) readConsumed(ctx context.Context, tx *sql.Tx, key, token string) error {
+	const readSQL = readColumns + "WHERE `key`=? AND `namespace`=? AND `expired`>=? AND `reread_token`=? AND `reread_until`>=?;"
+	now := time.Now().UTC()
+	err := item.scan(ctx, tx, readSQL, key, namespace(ctx), now, tokenHash(token), now)
+	if err != nil {
+		return err
+	}
+	item.Undo = true
+	return nil
+}
+
+// scan loads the item's fields by the query with readColumns.
+func (item *Item) scan(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) error {
+	stmt, err := tx.PrepareContext(ctx, query)
 	if err != nil {
 		return fmt.Errorf("read item statement: %w", err)
 	}
-	return stmt.QueryRowContext(ctx, key, time.Now().UTC()).Scan(
-		&item.ID, &item.Key, &item.Text, &item.FileMeta, &item.FilePath,
+	return stmt.QueryRowContext(ctx, args...).Scan(
+		&item.ID, &item.Key, &item.Text, &item.FileMeta, &item.FilePath, &item.TextPath, &item.OneTime, &item.Master, &item.Cipher, &item.AllowedIPs,
 		&item.CountText, &item.CountMeta, &item.CountFile,
 		&item.HashText, &item.HashMeta, &item.HashFile,
 		&item.SaltText, &item.SaltMeta, &item.SaltFile,
@@ -271,28 +435,146 @@ func (item *Item) read(ctx context.Context, tx *sql.Tx, key string) error {
 	)
 }
 
+// maxAllowedNetworks is max number of allowed networks of one item.
+const maxAllowedNetworks = 32
+
+// ParseAllowedIPs validates comma or space separated IP addresses and CIDRs.
+// It returns comma-separated CIDRs, single addresses are converted to networks with one host.
+func ParseAllowedIPs(value string) (string, error) {
+	fields := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+	if len(fields) > maxAllowedNetworks {
+		return "", fmt.Errorf("%w: max number is %d", ErrAllowedIPs, maxAllowedNetworks)
+	}
+	networks := make([]string, len(fields))
+	for i, field := range fields {
+		if !strings.Contains(field, "/") {
+			ip := net.ParseIP(field)
+			if ip == nil {
+				return "", fmt.Errorf("%w: %q", ErrAllowedIPs, field)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			networks[i] = (&net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}).String()
+			continue
+		}
+		_, network, err := net.ParseCIDR(field)
+		if err != nil {
+			return "", fmt.Errorf("%w: %q", ErrAllowedIPs, field)
+		}
+		networks[i] = network.String()
+	}
+	return strings.Join(networks, ","), nil
+}
+
+// isAllowed returns true if the item can be read from IP address ip.
+func (item *Item) isAllowed(ip string) bool {
+	if item.AllowedIPs == "" {
+		return true
+	}
+	clientIP := net.ParseIP(ip)
+	if clientIP == nil {
+		return false
+	}
+	for _, cidr := range strings.Split(item.AllowedIPs, ",") {
+		if _, network, err := net.ParseCIDR(cidr); err == nil && network.Contains(clientIP) {
+			return true
+		}
+	}
+	return false
+}
+
+// allow checks that the item can be read from IP address ip.
+func (item *Item) allow(ip string, err error) error {
+	if err != nil {
+		return err
+	}
+	if !item.isAllowed(ip) {
+		return ErrForbidden
+	}
+	return nil
+}
+
 // validate checks that there are attempts to read requested data.
 func (item *Item) validate(flags DecryptFlag, err error) error {
 	if err != nil {
 		return err
 	}
 	failed := flags&FlagText != 0 && item.CountText < 1
-	failed = failed || (flags&FlagMeta != 0) && (item.CountMeta < 1)
-	failed = failed || (flags&FlagFile != 0) && (item.CountFile < 1)
+	failed = failed || (flags&FlagMeta != 0) && (item.CountMeta-item.reserved < 1)
+	failed = failed || (flags&FlagFile != 0) && (item.CountFile-item.reserved < 1)
 	if failed {
 		return ErrNoAttempts
 	}
 	return nil
 }
 
+// tokenHash returns hex hash of the undo token, only it is saved to the database.
+func tokenHash(token string) string {
+	return hex.EncodeToString(encrypt.Hash([]byte(token)))
+}
+
+// SetPending makes the item not readable until its confirmation by a new random token during window.
+// Item's counters are moved to pending ones, they are restored by Confirm.
+func (item *Item) SetPending(window time.Duration) error {
+	b, err := encrypt.Random(16)
+	if err != nil {
+		return fmt.Errorf("confirm token: %w", err)
+	}
+	item.ConfirmToken = hex.EncodeToString(b)
+	item.ConfirmUntil = time.Now().UTC().Add(window)
+	item.PendingText, item.PendingFile = item.CountText, item.CountFile
+	item.CountText, item.CountMeta, item.CountFile = 0, 0, 0
+	return nil
+}
+
+// confirmHash returns a hash of the confirmation token, it's empty for not pending items.
+func (item *Item) confirmHash() string {
+	if item.ConfirmToken == "" {
+		return ""
+	}
+	return tokenHash(item.ConfirmToken)
+}
+
+// confirmUntil returns the end of the confirmation window or nil for not pending items.
+func (item *Item) confirmUntil() interface{} {
+	if item.ConfirmUntil.IsZero() {
+		return nil
+	}
+	return item.ConfirmUntil
+}
+
+// keep saves a hash of a new random token to read the consumed item again during undo period.
+func (item *Item) keep(ctx context.Context, tx *sql.Tx, undo time.Duration) error {
+	const updateSQL = "UPDATE `storage` SET `reread_token`=?, `reread_until`=? WHERE `id`=?;"
+	b, err := encrypt.Random(16)
+	if err != nil {
+		return fmt.Errorf("undo token: %w", err)
+	}
+	token := hex.EncodeToString(b)
+	until := time.Now().UTC().Add(undo)
+	if _, err = tx.ExecContext(ctx, updateSQL, tokenHash(token), until, item.ID); err != nil {
+		return fmt.Errorf("exec undo token update: %w", err)
+	}
+	item.Undo, item.Token, item.RereadUntil = true, token, until
+	return nil
+}
+
 // decrement updates item in the database, decrements its counters.
-func (item *Item) decrement(ctx context.Context, tx *sql.Tx, flags DecryptFlag, err error) error {
+// Counters can not become negative, so concurrent readers can not get the same last attempt.
+// One-time item is deleted from the database in the same transaction after its last read,
+// its files are removed later using CheckCounts. If undo period is positive,
+// the consumed item is kept instead, and its last reader gets a token to read it again.
+func (item *Item) decrement(ctx context.Context, tx *sql.Tx, flags DecryptFlag, undo time.Duration, err error) error {
 	if err != nil {
 		return err
 	}
 	const updateSQL = "UPDATE `storage` " +
 		"SET `count_text`=`count_text`-?, `count_meta`=`count_meta`-?, `count_file`=`count_file`-?, `updated`=? " +
-		"WHERE `id`=?;"
+		"WHERE `id`=? AND `count_text`>=? AND `count_meta`>=? AND `count_file`>=?;"
 	counters := make(map[DecryptFlag]int)
 	stmt, err := tx.PrepareContext(ctx, updateSQL)
 	if err != nil {
@@ -305,6 +587,7 @@ func (item *Item) decrement(ctx context.Context, tx *sql.Tx, flags DecryptFlag,
 	}
 	result, err := tx.StmtContext(ctx, stmt).ExecContext(
 		ctx, counters[FlagText], counters[FlagMeta], counters[FlagFile], time.Now().UTC(), item.ID,
+		counters[FlagText], counters[FlagMeta], counters[FlagFile],
 	)
 	if err != nil {
 		return fmt.Errorf("exec update item: %w", err)
@@ -325,6 +608,142 @@ func (item *Item) decrement(ctx context.Context, tx *sql.Tx, flags DecryptFlag,
 	if flags&FlagFile != 0 {
 		item.CountFile--
 	}
+	if !item.notActive() {
+		return nil
+	}
+	if undo > 0 {
+		return item.keep(ctx, tx, undo)
+	}
+	if item.OneTime {
+		if _, err = deleteItems(ctx, tx, item); err != nil {
+			return fmt.Errorf("delete one-time item: %w", err)
+		}
+	}
+	return nil
+}
+
+// increment updates item in the database, moves its expiration time by ttl
+// and increments available counters by times. Exhausted counters are not changed.
+// New limits can not be greater than maxTTL from now and maxTimes.
+func (item *Item) increment(ctx context.Context, tx *sql.Tx, ttl time.Duration, times int, maxTTL time.Duration, maxTextTimes, maxFileTimes int, err error) error {
+	if err != nil {
+		return err
+	}
+	const updateSQL = "UPDATE `storage` " +
+		"SET `count_text`=`count_text`+?, `count_meta`=`count_meta`+?, `count_file`=`count_file`+?, " +
+		"`expired`=?, `updated`=? " +
+		"WHERE `id`=?;"
+	var countText, countFile int
+	if times > 0 && item.OneTime {
+		return fmt.Errorf("%w: one-time item", ErrExtend)
+	}
+	if item.CountText > 0 {
+		countText = times
+	}
+	if item.CountFile > 0 {
+		countFile = times
+	}
+	now := time.Now().UTC()
+	expired := item.Expired.Add(ttl)
+	if expired.After(now.Add(maxTTL)) || item.CountText+countText > maxTextTimes || item.CountFile+countFile > maxFileTimes {
+		return ErrExtend
+	}
+	_, err = tx.ExecContext(ctx, updateSQL, countText, countText+countFile, countFile, expired, now, item.ID)
+	if err != nil {
+		return fmt.Errorf("exec increment item: %w", err)
+	}
+	item.CountText += countText
+	item.CountMeta += countText + countFile
+	item.CountFile += countFile
+	item.Expired, item.Updated = expired, now
+	return nil
+}
+
+// reencryptText decrypts the message by the password and encrypts it again by the new one.
+func reencryptText(password, newPassword string, m *encrypt.Msg) (*encrypt.Msg, error) {
+	plainText, err := encrypt.DecryptText(password, m)
+	if err != nil {
+		return nil, err
+	}
+	return encrypt.Text(newPassword, plainText)
+}
+
+// reencryptFile decrypts the file by the password and streams its content through a pipe
+// to a new file with random name in the same directory, which is encrypted by the new password.
+func reencryptFile(password, newPassword string, m *encrypt.Msg) (*encrypt.Msg, error) {
+	base := filepath.Dir(m.Value)
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(encrypt.DecryptFile(password, m, pw))
+	}()
+	n, err := encrypt.File(newPassword, pr, base, "")
+	if e := pr.Close(); e != nil && err == nil {
+		err = e
+	}
+	return n, err
+}
+
+// rotate re-encrypts item's text, file metadata and file by the new secret.
+// New files are created near old ones, which are not removed here.
+func (item *Item) rotate(password, newPassword string, err error) error {
+	if err != nil {
+		return err
+	}
+	var (
+		m           *encrypt.Msg
+		master      = item.Master
+		cipherSuite = item.Cipher
+	)
+	if item.Text != "" {
+		m, err = reencryptText(password, newPassword, &encrypt.Msg{Salt: item.SaltText, Value: item.Text, Hash: item.HashText, Master: master, Suite: cipherSuite})
+		if err != nil {
+			return fmt.Errorf("rotate text: %w", err)
+		}
+		item.Text, item.HashText, item.SaltText, item.Master, item.Cipher = m.Value, m.Hash, m.Salt, m.Master, m.Suite
+	}
+	if item.FileMeta != "" {
+		m, err = reencryptText(password, newPassword, &encrypt.Msg{Salt: item.SaltMeta, Value: item.FileMeta, Hash: item.HashMeta, Master: master, Suite: cipherSuite})
+		if err != nil {
+			return fmt.Errorf("rotate file meta: %w", err)
+		}
+		item.FileMeta, item.HashMeta, item.SaltMeta, item.Master, item.Cipher = m.Value, m.Hash, m.Salt, m.Master, m.Suite
+	}
+	if item.TextPath != "" {
+		m, err = reencryptFile(password, newPassword, &encrypt.Msg{Salt: item.SaltText, Hash: item.HashText, Value: item.TextPath, Master: master, Suite: cipherSuite})
+		if err != nil {
+			return fmt.Errorf("rotate text file: %w", err)
+		}
+		item.TextPath, item.HashText, item.SaltText, item.Master, item.Cipher = m.Value, m.Hash, m.Salt, m.Master, m.Suite
+	}
+	if item.FilePath != "" {
+		m, err = reencryptFile(password, newPassword, &encrypt.Msg{Salt: item.SaltFile, Hash: item.HashFile, Value: item.FilePath, Master: master, Suite: cipherSuite})
+		if err != nil {
+			return fmt.Errorf("rotate file: %w", err)
+		}
+		item.FilePath, item.HashFile, item.SaltFile, item.Master, item.Cipher = m.Value, m.Hash, m.Salt, m.Master, m.Suite
+	}
+	return nil
+}
+
+// updateSecrets saves encrypted values of the item, its counters and expiration time are not changed.
+func (item *Item) updateSecrets(ctx context.Context, tx *sql.Tx, err error) error {
+	if err != nil {
+		return err
+	}
+	const updateSQL = "UPDATE `storage` " +
+		"SET `text`=?, `text_path`=?, `file_meta`=?, `file_path`=?, `master`=?, `cipher`=?, " +
+		"`hash_text`=?, `hash_meta`=?, `hash_file`=?, `salt_text`=?, `salt_meta`=?, `salt_file`=?, `updated`=? " +
+		"WHERE `id`=?;"
+	now := time.Now().UTC()
+	_, err = tx.ExecContext(ctx, updateSQL,
+		item.Text, item.TextPath, item.FileMeta, item.FilePath, item.Master, item.Cipher,
+		item.HashText, item.HashMeta, item.HashFile, item.SaltText, item.SaltMeta, item.SaltFile, now,
+		item.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("exec update item secrets: %w", err)
+	}
+	item.Updated = now
 	return nil
 }
 
@@ -335,22 +754,70 @@ func (item *Item) notActive() bool {
 
 // CheckCounts validates counters and if they are not positive
 // then quickly sends the item to delete queue.
+// If only file counter is not positive, the item is sent to delete its file but keep the text.
+// Items kept during the undo window are deleted by GC.
 func (item *Item) CheckCounts(ch chan<- Item) {
-	if item.notActive() {
+	switch {
+	case item.Undo:
+		return
+	case item.notActive():
 		// delete item from database without GC waiting
 		ch <- *item
+	case item.CountFile < 1 && item.FilePath != "":
+		fileItem := *item
+		fileItem.FileOnly = true
+		ch <- fileItem
 	}
 }
 
 // Read reads an item by its key from the database.
 // It also decrypts request by flags fields and decrements their counters.
-func Read(ctx context.Context, db *sql.DB, key, password string, dst io.Writer, flags DecryptFlag) (*Item, error) {
-	item := &Item{}
+// If undo period is positive, the consumed item is kept during it for Reread.
+// Restricted item is read only from allowed client's IP address ip.
+// If FlagUpgrade is set, the legacy item with remaining attempts is re-encrypted
+// by the current cipher suite in the same transaction after its counters decrement.
+func Read(ctx context.Context, db *sql.DB, key, password, ip string, dst io.Writer, flags DecryptFlag, undo time.Duration) (*Item, error) {
+	var (
+		item         = &Item{}
+		old, created *Item
+	)
 	err := InTransaction(ctx, db, func(tx *sql.Tx) error {
 		e := item.read(ctx, tx, key)
+		e = item.allow(ip, e)
+		e = item.checkReserved(ctx, tx, flags, e)
 		e = item.validate(flags, e)
+		encrypted := *item
 		e = item.Decrypt(password, dst, flags, e)
-		return item.decrement(ctx, tx, flags, e)
+		e = item.decrement(ctx, tx, flags, undo, e)
+		if e == nil && flags&FlagUpgrade != 0 && item.canUpgrade() {
+			old, created = item.upgrade(ctx, tx, &encrypted, password, flags)
+		}
+		return e
+	})
+	if err != nil {
+		if created != nil {
+			if e := deleteFiles(created); e != nil {
+				return nil, fmt.Errorf("%w, delete new files: %v", err, e)
+			}
+		}
+		return nil, err
+	}
+	if old != nil {
+		if err = deleteFiles(old); err != nil {
+			item.UpgradeErr = fmt.Errorf("delete legacy files: %w", err)
+		}
+	}
+	return item, nil
+}
+
+// Reread reads a consumed item again by the token issued by its last read during the undo window.
+// Counters are not changed. It returns sql.ErrNoRows if the token is wrong or the window is over.
+func Reread(ctx context.Context, db *sql.DB, key, token, password, ip string, dst io.Writer, flags DecryptFlag) (*Item, error) {
+	item := &Item{}
+	err := InTransaction(ctx, db, func(tx *sql.Tx) error {
+		e := item.readConsumed(ctx, tx, key, token)
+		e = item.allow(ip, e)
+		return item.Decrypt(password, dst, flags, e)
 	})
 	if err != nil {
 		return nil, err
@@ -358,18 +825,115 @@ func Read(ctx context.Context, db *sql.DB, key, password string, dst io.Writer,
 	return item, nil
 }
 
-// Exists returns the Item with counter fields if it exists by requested key.
+// Extend checks the password and increases expiration time and available counters of an active item.
+// It returns sql.ErrNoRows if the item is not found or already expired.
+// Restricted item is extended only from allowed client's IP address ip.
+// New text and file counters are limited by maxTextTimes and maxFileTimes.
+func Extend(ctx context.Context, db *sql.DB, key, password, ip string, ttl time.Duration, times int, maxTTL time.Duration, maxTextTimes, maxFileTimes int) (*Item, error) {
+	item := &Item{}
+	err := InTransaction(ctx, db, func(tx *sql.Tx) error {
+		e := item.read(ctx, tx, key)
+		e = item.allow(ip, e)
+		e = item.verify(password, e)
+		return item.increment(ctx, tx, ttl, times, maxTTL, maxTextTimes, maxFileTimes, e)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// Confirm activates the pending item by its token during the confirmation window,
+// pending counters become available and meta counter is their sum as for new items.
+// It returns sql.ErrNoRows if the item is not found, expired, already confirmed or the token is wrong.
+func Confirm(ctx context.Context, db *sql.DB, key, token string) (*Item, error) {
+	const (
+		confirmSQL = "UPDATE `storage` " +
+			"SET `count_text`=`pending_text`, `count_meta`=`pending_text`+`pending_file`, `count_file`=`pending_file`, " +
+			"`pending_text`=0, `pending_file`=0, `confirm_token`='', `confirm_until`=NULL, `updated`=? " +
+			"WHERE `key`=? AND `namespace`=? AND `confirm_token`=? AND `confirm_until`>=? AND `expired`>=?;"
+		countersSQL = "SELECT `count_text`, `count_file`, `expired` FROM `storage` WHERE `key`=?;"
+	)
+	if token == "" {
+		return nil, sql.ErrNoRows
+	}
+	item := &Item{Key: key}
+	err := InTransaction(ctx, db, func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+		result, e := tx.ExecContext(ctx, confirmSQL, now, key, namespace(ctx), tokenHash(token), now, now)
+		if e != nil {
+			return fmt.Errorf("exec confirm: %w", e)
+		}
+		n, e := result.RowsAffected()
+		if e != nil {
+			return fmt.Errorf("confirm affected rows: %w", e)
+		}
+		if n == 0 {
+			return sql.ErrNoRows
+		}
+		item.Updated = now
+		return tx.QueryRowContext(ctx, countersSQL, key).Scan(&item.CountText, &item.CountFile, &item.Expired)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// Rotate checks the password and re-encrypts all data of an active item by the new password,
+// its counters and expiration time are kept. Files are re-encrypted to new ones, which replace
+// old paths in the same transaction, so old files are removed only after the commit.
+// It returns sql.ErrNoRows if the item is not found or already expired.
+// Restricted item is rotated only from allowed client's IP address ip.
+func Rotate(ctx context.Context, db *sql.DB, key, password, newPassword, ip string) (*Item, error) {
+	var (
+		item    = &Item{}
+		old     = &Item{}
+		created = &Item{}
+	)
+	err := InTransaction(ctx, db, func(tx *sql.Tx) error {
+		e := item.read(ctx, tx, key)
+		e = item.allow(ip, e)
+		e = item.verify(password, e)
+		if e != nil {
+			return e
+		}
+		old.ID, old.TextPath, old.FilePath = item.ID, item.TextPath, item.FilePath
+		e = item.rotate(item.secret(password), item.secret(newPassword), e)
+		// new files are tracked even after an error to remove them
+		created.ID = item.ID
+		if item.TextPath != old.TextPath {
+			created.TextPath = item.TextPath
+		}
+		if item.FilePath != old.FilePath {
+			created.FilePath = item.FilePath
+		}
+		return item.updateSecrets(ctx, tx, e)
+	})
+	if err != nil {
+		if e := deleteFiles(created); e != nil {
+			return nil, fmt.Errorf("%w, delete new files: %v", err, e)
+		}
+		return nil, err
+	}
+	if err = deleteFiles(old); err != nil {
+		return nil, fmt.Errorf("delete rotated files: %w", err)
+	}
+	return item, nil
+}
+
+// Exists returns the Item with counter, expiration and public fields if it exists by requested key.
 func Exists(ctx context.Context, db *sql.DB, key string) (*Item, error) {
-	const existsSQL = "SELECT `id`, `count_text`, `count_file` " +
+	const existsSQL = "SELECT `id`, `hint`, `file_info`, `count_text`, `count_file`, `expired` " +
 		"FROM `storage` " +
-		"WHERE `key`=? AND `expired`>=? AND ((`count_text`>0) OR (`count_file`>0)) " +
+		"WHERE `key`=? AND `namespace`=? AND `expired`>=? AND ((`count_text`>0) OR (`count_file`>0)) " +
 		"LIMIT 1;"
 	stmt, err := db.PrepareContext(ctx, existsSQL)
 	if err != nil {
 		return nil, fmt.Errorf("exist statement: %w", err)
 	}
 	item := &Item{}
-	err = stmt.QueryRowContext(ctx, key, time.Now().UTC()).Scan(&item.ID, &item.CountText, &item.CountFile)
+	err = stmt.QueryRowContext(ctx, key, namespace(ctx), time.Now().UTC()).Scan(&item.ID, &item.Hint, &item.FileInfo, &item.CountText, &item.CountFile, &item.Expired)
 	if err != nil {
 		return nil, err
 	}
@@ -379,3 +943,151 @@ func Exists(ctx context.Context, db *sql.DB, key string) (*Item, error) {
 	}
 	return item, nil
 }
+
+// NearestFileExpiry returns expiration time of the stored file or text file which is deleted first.
+// It returns sql.ErrNoRows if there are no items with files.
+func NearestFileExpiry(ctx context.Context, db *sql.DB) (time.Time, error) {
+	const expirySQL = "SELECT `expired` " +
+		"FROM `storage` " +
+		"WHERE `file_path`<>'' OR `text_path`<>'' " +
+		"ORDER BY `expired` LIMIT 1;"
+	var expired time.Time
+	err := db.QueryRowContext(ctx, expirySQL).Scan(&expired)
+	if err != nil {
+		return expired, err
+	}
+	return expired, nil
+}
+
+// State is a state of an item by its key.
+type State int
+
+// item states
+const (
+	StateAbsent   State = iota // item is not found, the key is wrong or the item is already deleted
+	StateActive                // item can be read
+	StateExpired               // item's lifetime is over
+	StateConsumed              // all item's attempts are used
+)
+
+// String returns a name of the state.
+func (s State) String() string {
+	switch s {
+	case StateActive:
+		return "active"
+	case StateExpired:
+		return "expired"
+	case StateConsumed:
+		return "consumed"
+	}
+	return "absent"
+}
+
+// Lookup returns a state of the item by its key without expiration and counters filters,
+// so expired or consumed items can be distinguished from absent ones until they are deleted.
+func Lookup(ctx context.Context, db *sql.DB, key string) (State, error) {
+	const lookupSQL = "SELECT `expired`, `count_text`, `count_file` FROM `storage` WHERE `key`=? AND `namespace`=? LIMIT 1;"
+	item := &Item{}
+	err := db.QueryRowContext(ctx, lookupSQL, key, namespace(ctx)).Scan(&item.Expired, &item.CountText, &item.CountFile)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return StateAbsent, nil
+		}
+		return StateAbsent, fmt.Errorf("lookup item: %w", err)
+	}
+	switch {
+	case item.Expired.Before(time.Now().UTC()):
+		return StateExpired, nil
+	case item.notActive():
+		return StateConsumed, nil
+	}
+	return StateActive, nil
+}
+
+// CountActive returns a number of items which can be read.
+func CountActive(ctx context.Context, db *sql.DB) (int64, error) {
+	const countSQL = "SELECT COUNT(*) FROM `storage` " +
+		"WHERE `expired`>=? AND ((`count_text`>0) OR (`count_file`>0));"
+	var n int64
+	if err := db.QueryRowContext(ctx, countSQL, time.Now().UTC()).Scan(&n); err != nil {
+		return 0, fmt.Errorf("count active items: %w", err)
+	}
+	return n, nil
+}
+
+// Statuses returns existing items with counter fields by requested keys.
+// All items are read by one query, not found keys are absent in the result map.
+func Statuses(ctx context.Context, db *sql.DB, keys []string) (map[string]*Item, error) {
+	const statusSQL = "SELECT `id`, `key`, `file_info`, `count_text`, `count_file` " +
+		"FROM `storage` " +
+		"WHERE `key` IN (%s) AND `namespace`=? AND `expired`>=? AND ((`count_text`>0) OR (`count_file`>0));"
+	items := make(map[string]*Item, len(keys))
+	if len(keys) == 0 {
+		return items, nil
+	}
+	args := make([]interface{}, 0, len(keys)+2)
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	args = append(args, namespace(ctx), time.Now().UTC())
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(keys)), ",")
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(statusSQL, placeholders), args...)
+	if err != nil {
+		return nil, fmt.Errorf("exec status query: %w", err)
+	}
+	for rows.Next() {
+		item := &Item{}
+		err = rows.Scan(&item.ID, &item.Key, &item.FileInfo, &item.CountText, &item.CountFile)
+		if err != nil {
+			return nil, fmt.Errorf("next status query: %w", err)
+		}
+		items[item.Key] = item
+	}
+	err = rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("close rows status query: %w", err)
+	}
+	return items, nil
+}
+
+// VerifyPassword checks the password of an active item by its key without data decryption
+// and counters decrement. It returns sql.ErrNoRows if the item is not found.
+// Restricted item is checked only from allowed client's IP address ip, ErrForbidden is returned otherwise.
+func VerifyPassword(ctx context.Context, db *sql.DB, key, password, ip string) (bool, error) {
+	const verifySQL = "SELECT `hash_text`, `salt_text`, `hash_meta`, `salt_meta`, `created`, `allowed_ips` " +
+		"FROM `storage` " +
+		"WHERE `key`=? AND `namespace`=? AND `expired`>=? AND ((`count_text`>0) OR (`count_file`>0)) " +
+		"LIMIT 1;"
+	item := &Item{}
+	err := db.QueryRowContext(ctx, verifySQL, key, namespace(ctx), time.Now().UTC()).Scan(
+		&item.HashText, &item.SaltText, &item.HashMeta, &item.SaltMeta, &item.Created, &item.AllowedIPs,
+	)
+	if err = item.allow(ip, err); err != nil {
+		return false, err
+	}
+	return encrypt.Verify(item.secret(password), item.passwordMsg())
+}
+
+// passwordMsg returns a message with the salt and hash to check the item's password.
+func (item *Item) passwordMsg() *encrypt.Msg {
+	if item.HashText == "" {
+		// there is only file
+		return &encrypt.Msg{Salt: item.SaltMeta, Hash: item.HashMeta}
+	}
+	return &encrypt.Msg{Salt: item.SaltText, Hash: item.HashText}
+}
+
+// verify returns encrypt.ErrSecret if the password is incorrect.
+func (item *Item) verify(password string, err error) error {
+	if err != nil {
+		return err
+	}
+	ok, err := encrypt.Verify(item.secret(password), item.passwordMsg())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return encrypt.ErrSecret
+	}
+	return nil
+}
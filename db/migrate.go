@@ -0,0 +1,180 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// migrations is an ordered list of database schema changes.
+// Schema version is a number of applied items, so new changes must be only appended.
+var migrations = [][]string{
+	// 1: initial schema
+	{
+		"CREATE TABLE IF NOT EXISTS `storage` (" +
+			"`id` INTEGER PRIMARY KEY AUTOINCREMENT, " +
+			"`key` VARCHAR(64), " +
+			"`text` TEXT, " +
+			"`file_meta` TEXT, " +
+			"`file_path` TEXT, " +
+			"`count_text` INTEGER NOT NULL DEFAULT 1, " +
+			"`count_meta` INTEGER NOT NULL DEFAULT 1, " +
+			"`count_file` INTEGER NOT NULL DEFAULT 1, " +
+			"`hash_text` VARCHAR(64) NOT NULL, " +
+			"`hash_meta` VARCHAR(64) NOT NULL, " +
+			"`hash_file` VARCHAR(64) NOT NULL, " +
+			"`salt_text` VARCHAR(256) NOT NULL, " +
+			"`salt_meta` VARCHAR(256) NOT NULL, " +
+			"`salt_file` VARCHAR(256) NOT NULL, " +
+			"`created` DATETIME NOT NULL, " +
+			"`updated` DATETIME NOT NULL, " +
+			"`expired` DATETIME NOT NULL);",
+		"CREATE UNIQUE INDEX IF NOT EXISTS `key` ON `storage` (`key`);",
+		"CREATE INDEX IF NOT EXISTS `expired` ON `storage` (`expired`,`count_text`,`count_file`);",
+		"CREATE INDEX IF NOT EXISTS `key_expired` ON `storage` (`key`,`expired`);",
+	},
+	// 2: GC index for items without active counters
+	{
+		"CREATE INDEX IF NOT EXISTS `counters` ON `storage` (`count_text`,`count_file`);",
+	},
+	// 3: path to encrypted file with big text
+	{
+		"ALTER TABLE `storage` ADD COLUMN `text_path` TEXT NOT NULL DEFAULT '';",
+	},
+	// 4: one-time items are deleted by the read transaction
+	{
+		"ALTER TABLE `storage` ADD COLUMN `one_time` BOOLEAN NOT NULL DEFAULT 0;",
+	},
+	// 5: public password hint
+	{
+		"ALTER TABLE `storage` ADD COLUMN `hint` TEXT NOT NULL DEFAULT '';",
+	},
+	// 6: public not encrypted file info
+	{
+		"ALTER TABLE `storage` ADD COLUMN `file_info` TEXT NOT NULL DEFAULT '';",
+	},
+	// 7: second encryption layer with the server master key
+	{
+		"ALTER TABLE `storage` ADD COLUMN `master` BOOLEAN NOT NULL DEFAULT 0;",
+	},
+	// 8: undo window of consumed items
+	{
+		"ALTER TABLE `storage` ADD COLUMN `reread_token` VARCHAR(64) NOT NULL DEFAULT '';",
+		"ALTER TABLE `storage` ADD COLUMN `reread_until` DATETIME NULL;",
+	},
+	// 9: networks which are allowed to read the item
+	{
+		"ALTER TABLE `storage` ADD COLUMN `allowed_ips` TEXT NOT NULL DEFAULT '';",
+	},
+	// 10: optional hashed origin of the upload
+	{
+		"ALTER TABLE `storage` ADD COLUMN `origin_ip` VARCHAR(64) NOT NULL DEFAULT '';",
+		"ALTER TABLE `storage` ADD COLUMN `origin_agent` VARCHAR(64) NOT NULL DEFAULT '';",
+	},
+	// 11: daily uploads quota of clients
+	{
+		"CREATE TABLE IF NOT EXISTS `quota` (" +
+			"`source` VARCHAR(64) NOT NULL, " +
+			"`day` VARCHAR(10) NOT NULL, " +
+			"`count` INTEGER NOT NULL DEFAULT 0, " +
+			"PRIMARY KEY (`source`,`day`));",
+		"CREATE INDEX IF NOT EXISTS `quota_day` ON `quota` (`day`);",
+	},
+	// 12: cipher suite of encrypted data, empty value is legacy AES-CFB/OFB
+	{
+		"ALTER TABLE `storage` ADD COLUMN `cipher` VARCHAR(32) NOT NULL DEFAULT '';",
+	},
+	// 13: pending items which are not readable until confirmation
+	{
+		"ALTER TABLE `storage` ADD COLUMN `confirm_token` VARCHAR(64) NOT NULL DEFAULT '';",
+		"ALTER TABLE `storage` ADD COLUMN `confirm_until` DATETIME;",
+		"ALTER TABLE `storage` ADD COLUMN `pending_text` INTEGER NOT NULL DEFAULT 0;",
+		"ALTER TABLE `storage` ADD COLUMN `pending_file` INTEGER NOT NULL DEFAULT 0;",
+	},
+	// 14: namespaces of items, empty value is the default namespace
+	{
+		"ALTER TABLE `storage` ADD COLUMN `namespace` VARCHAR(32) NOT NULL DEFAULT '';",
+		"CREATE INDEX IF NOT EXISTS `namespace` ON `storage` (`namespace`,`expired`);",
+	},
+	// 15: reservations of file attempts by resumable downloads
+	{
+		"ALTER TABLE `storage` ADD COLUMN `resume_token` VARCHAR(64) NOT NULL DEFAULT '';",
+		"ALTER TABLE `storage` ADD COLUMN `resume_until` DATETIME NULL;",
+	},
+}
+
+// schemaVersion returns current database schema version.
+func schemaVersion(ctx context.Context, tx *sql.Tx) (int, error) {
+	const (
+		createSQL  = "CREATE TABLE IF NOT EXISTS `schema_version` (`version` INTEGER NOT NULL, `applied` DATETIME NOT NULL);"
+		versionSQL = "SELECT COALESCE(MAX(`version`), 0) FROM `schema_version`;"
+	)
+	var version int
+	if _, err := tx.ExecContext(ctx, createSQL); err != nil {
+		return 0, fmt.Errorf("create schema_version table: %w", err)
+	}
+	if err := tx.QueryRowContext(ctx, versionSQL).Scan(&version); err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// migrate applies one migration with number version inside the transaction.
+func migrate(ctx context.Context, tx *sql.Tx, version int) error {
+	const insertSQL = "INSERT INTO `schema_version` (`version`, `applied`) VALUES (?, ?);"
+	for _, query := range migrations[version-1] {
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("migration %d: %w", version, err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, insertSQL, version, time.Now().UTC()); err != nil {
+		return fmt.Errorf("save schema version %d: %w", version, err)
+	}
+	return nil
+}
+
+// Migrate creates database schema if it is absent and applies all new migrations.
+// Every migration is done in a separate transaction. It returns actual schema version.
+func Migrate(ctx context.Context, db *sql.DB) (int, error) {
+	var version int
+	err := InTransaction(ctx, db, func(tx *sql.Tx) error {
+		v, e := schemaVersion(ctx, tx)
+		version = v
+		return e
+	})
+	if err != nil {
+		return 0, err
+	}
+	if version > len(migrations) {
+		return version, fmt.Errorf("unknown schema version=%d, max=%d", version, len(migrations))
+	}
+	for version < len(migrations) {
+		err = InTransaction(ctx, db, func(tx *sql.Tx) error {
+			return migrate(ctx, tx, version+1)
+		})
+		if err != nil {
+			return version, err
+		}
+		version++
+	}
+	return version, nil
+}
+
+// CheckSchema returns an error if the database schema is not up to date,
+// it is used if migrations are not applied on startup. It returns actual schema version.
+func CheckSchema(ctx context.Context, db *sql.DB) (int, error) {
+	var version int
+	err := InTransaction(ctx, db, func(tx *sql.Tx) error {
+		v, e := schemaVersion(ctx, tx)
+		version = v
+		return e
+	})
+	if err != nil {
+		return 0, err
+	}
+	if version != len(migrations) {
+		return version, fmt.Errorf("database schema version=%d, expected %d, it should be migrated", version, len(migrations))
+	}
+	return version, nil
+}
@@ -0,0 +1,45 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// namespaceKey is a context key of the items namespace.
+type namespaceKey struct{}
+
+// WithNamespace returns a copy of ctx with the namespace, all items are saved and read only inside it.
+func WithNamespace(ctx context.Context, namespace string) context.Context {
+	return context.WithValue(ctx, namespaceKey{}, namespace)
+}
+
+// namespace returns the items namespace of ctx, it's empty by default.
+func namespace(ctx context.Context) string {
+	ns, _ := ctx.Value(namespaceKey{}).(string)
+	return ns
+}
+
+// Purge expires all active items of the namespace, so they can not be read anymore
+// and GC deletes them with their files. It returns a number of purged items.
+func Purge(ctx context.Context, db *sql.DB, ns string) (int64, error) {
+	const purgeSQL = "UPDATE `storage` SET `expired`=?, `updated`=? WHERE `namespace`=? AND `expired`>=?;"
+	var n int64
+	err := InTransaction(ctx, db, func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+		result, e := tx.ExecContext(ctx, purgeSQL, now.Add(-time.Second), now, ns, now)
+		if e != nil {
+			return fmt.Errorf("exec purge: %w", e)
+		}
+		n, e = result.RowsAffected()
+		if e != nil {
+			return fmt.Errorf("purge affected rows: %w", e)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
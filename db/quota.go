@@ -0,0 +1,55 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// quotaDay returns UTC date of t, quota counters are reset every day.
+func quotaDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// QuotaUsed returns a number of uploads of the source during the day of now.
+// The source is a hashed client identifier.
+func QuotaUsed(ctx context.Context, db *sql.DB, source string, now time.Time) (int, error) {
+	const selectSQL = "SELECT `count` FROM `quota` WHERE `source`=? AND `day`=?;"
+	var n int
+	err := db.QueryRowContext(ctx, selectSQL, source, quotaDay(now)).Scan(&n)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("select quota: %w", err)
+	}
+	return n, nil
+}
+
+// AddQuota increments a number of uploads of the source during the day of now.
+// Counters of previous days are removed.
+func AddQuota(ctx context.Context, db *sql.DB, source string, now time.Time) error {
+	const (
+		deleteSQL = "DELETE FROM `quota` WHERE `day`<?;"
+		upsertSQL = "INSERT INTO `quota` (`source`,`day`,`count`) VALUES (?,?,1) " +
+			"ON CONFLICT (`source`,`day`) DO UPDATE SET `count`=`count`+1;"
+	)
+	day := quotaDay(now)
+	return InTransaction(ctx, db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, deleteSQL, day); err != nil {
+			return fmt.Errorf("delete old quota: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, upsertSQL, source, day); err != nil {
+			return fmt.Errorf("increment quota: %w", err)
+		}
+		return nil
+	})
+}
+
+// QuotaReset returns time of the next quota counters reset after now.
+func QuotaReset(now time.Time) time.Time {
+	y, m, d := now.UTC().Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+}
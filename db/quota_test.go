@@ -0,0 +1,70 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAddQuota(t *testing.T) {
+	const source = "client"
+	database := testDB(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	evening := time.Date(2024, 3, 10, 23, 59, 0, 0, time.UTC)
+	morning := evening.Add(2 * time.Minute)
+
+	for i := 0; i < 3; i++ {
+		if err := AddQuota(ctx, database, source, evening); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := AddQuota(ctx, database, "other", evening); err != nil {
+		t.Fatal(err)
+	}
+	n, err := QuotaUsed(ctx, database, source, evening)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("failed quota=%d", n)
+	}
+	// next day
+	if n, err = QuotaUsed(ctx, database, source, morning); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("quota is not reset=%d", n)
+	}
+	if err = AddQuota(ctx, database, source, morning); err != nil {
+		t.Fatal(err)
+	}
+	if n, err = QuotaUsed(ctx, database, source, morning); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("failed quota of the next day=%d", n)
+	}
+	// previous day counters are removed
+	var rows int
+	if err = database.QueryRowContext(ctx, "SELECT COUNT(*) FROM `quota`;").Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 1 {
+		t.Errorf("failed number of quota rows=%d", rows)
+	}
+}
+
+func TestQuotaReset(t *testing.T) {
+	expected := time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)
+	cases := []time.Time{
+		time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 3, 10, 23, 59, 59, 0, time.UTC),
+		time.Date(2024, 3, 11, 1, 0, 0, 0, time.FixedZone("UTC+3", 3*3600)),
+	}
+	for i, now := range cases {
+		if r := QuotaReset(now); !r.Equal(expected) {
+			t.Errorf("case=%d: failed reset=%v", i, r)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+
+	"github.com/z0rr0/send/encrypt"
+	"github.com/z0rr0/send/logging"
+)
+
+// uuidLength is a length of item keys in canonical UUID form, uploaded files are named by them.
+const uuidLength = 36
+
+// isStorageName returns true if the file name is hex encoded nameSize random bytes
+// or an item key, as names of encrypted files are. Other files of storage directories are not checked.
+func isStorageName(name string, nameSize int) bool {
+	if len(name) == uuidLength {
+		_, err := uuid.Parse(name)
+		return err == nil
+	}
+	if len(name) != 2*nameSize {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// storedFiles returns items with files and absolute paths of all referenced files.
+func storedFiles(ctx context.Context, db *sql.DB) ([]*Item, map[string]bool, error) {
+	const filesSQL = "SELECT `id`, `key`, `file_path`, `text_path` FROM `storage` WHERE `file_path`<>'' OR `text_path`<>'';"
+	var items []*Item
+	paths := make(map[string]bool)
+	rows, err := db.QueryContext(ctx, filesSQL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("exec select files query: %w", err)
+	}
+	for rows.Next() {
+		item := &Item{}
+		if err = rows.Scan(&item.ID, &item.Key, &item.FilePath, &item.TextPath); err != nil {
+			return nil, nil, fmt.Errorf("next select files query: %w", err)
+		}
+		for _, path := range []string{item.FilePath, item.TextPath} {
+			if path == "" {
+				continue
+			}
+			if path, err = filepath.Abs(path); err != nil {
+				return nil, nil, fmt.Errorf("absolute path of item=%d file: %w", item.ID, err)
+			}
+			paths[path] = true
+		}
+		items = append(items, item)
+	}
+	if err = rows.Close(); err != nil {
+		return nil, nil, fmt.Errorf("close rows files query: %w", err)
+	}
+	return items, paths, nil
+}
+
+// isDangling returns true if some file of the item is missing.
+func (item *Item) isDangling() bool {
+	return (item.FilePath != "" && !encrypt.FileExists(item.FilePath)) ||
+		(item.TextPath != "" && !encrypt.FileExists(item.TextPath))
+}
+
+// deleteDangling removes items with missing files and their remaining files.
+func deleteDangling(ctx context.Context, db *sql.DB, items []*Item) (int64, error) {
+	var n int64
+	err := InTransaction(ctx, db, func(tx *sql.Tx) error {
+		var e error
+		if n, e = deleteItems(ctx, tx, items...); e != nil {
+			return e
+		}
+		for _, item := range items {
+			for _, path := range []string{item.FilePath, item.TextPath} {
+				if path != "" && encrypt.FileExists(path) {
+					if e = encrypt.RemoveFile(path); e != nil {
+						return fmt.Errorf("delete file of dangling item=%d: %w", item.ID, e)
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Reconcile removes items whose files are missing and storage files of dirs which are not referenced
+// by any item, for example after a crash during an upload. Storage directories dirs should be absolute paths,
+// only their files with names of nameSize random bytes or item keys are checked. It should be called on startup before requests handling.
+// It returns numbers of removed files and items.
+func Reconcile(ctx context.Context, db *sql.DB, dirs []string, nameSize int, l *logging.Log) (int, int64, error) {
+	items, paths, err := storedFiles(ctx, db)
+	if err != nil {
+		return 0, 0, err
+	}
+	var dangling []*Item
+	for _, item := range items {
+		if item.isDangling() {
+			l.Info("item %s has missing files, it is deleted", item.Key)
+			dangling = append(dangling, item)
+		}
+	}
+	var deleted int64
+	if len(dangling) > 0 {
+		if deleted, err = deleteDangling(ctx, db, dangling); err != nil {
+			return 0, 0, err
+		}
+	}
+	var removed int
+	for _, dir := range dirs {
+		entries, e := os.ReadDir(dir)
+		if e != nil {
+			return removed, deleted, fmt.Errorf("read storage directory: %w", e)
+		}
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			if entry.IsDir() || !isStorageName(entry.Name(), nameSize) || paths[path] {
+				continue
+			}
+			if e = os.Remove(path); e != nil {
+				return removed, deleted, fmt.Errorf("remove orphan file: %w", e)
+			}
+			l.Info("orphan file %s is removed", path)
+			removed++
+		}
+	}
+	return removed, deleted, nil
+}
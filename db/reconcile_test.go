@@ -0,0 +1,67 @@
+package db
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/z0rr0/send/logging"
+)
+
+func TestReconcile(t *testing.T) {
+	const nameSize = 64 // default size of storage file names
+	database := testDB(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	valid := saveFileItem(t, database, "secret", 1, 1)
+	dangling := saveFileItem(t, database, "secret", 1, 1)
+	if err := os.Remove(dangling.FilePath); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Dir(valid.FilePath)
+	// encrypted file of an item which was not saved due to a crash
+	now := time.Now().UTC()
+	unsaved := &Item{
+		Key:       uuid.New().String(),
+		FileMeta:  "{\"name\":\"test.txt\"}",
+		CountFile: 1,
+		CountMeta: 1,
+		Created:   now,
+		Updated:   now,
+		Expired:   now.Add(time.Hour),
+		Storage:   dir,
+	}
+	if err := unsaved.Encrypt("secret", strings.NewReader("file content")); err != nil {
+		t.Fatal(err)
+	}
+	orphan := filepath.Join(dir, strings.Repeat("ab", nameSize))
+	other := filepath.Join(dir, "db.sqlite")
+	for _, name := range []string{orphan, other} {
+		if err := os.WriteFile(name, []byte("data"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, items, err := Reconcile(ctx, database, []string{dir, filepath.Dir(dangling.FilePath)}, nameSize, logging.New("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files != 2 || items != 1 {
+		t.Errorf("failed numbers of removed files=%d and items=%d", files, items)
+	}
+	for name, exists := range map[string]bool{valid.FilePath: true, unsaved.FilePath: false, orphan: false, other: true} {
+		if _, e := os.Stat(name); (e == nil) != exists {
+			t.Errorf("failed file %s state: %v", name, e)
+		}
+	}
+	if _, err = Exists(ctx, database, valid.Key); err != nil {
+		t.Errorf("valid item is not found: %v", err)
+	}
+	if n := countItems(t, database); n != 1 {
+		t.Errorf("failed number of items=%d", n)
+	}
+}
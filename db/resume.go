@@ -0,0 +1,129 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/z0rr0/send/encrypt"
+)
+
+// checkReserved loads a number of file attempts reserved by not finished downloads,
+// they are not available for other readers of the file.
+func (item *Item) checkReserved(ctx context.Context, tx *sql.Tx, flags DecryptFlag, err error) error {
+	if err != nil {
+		return err
+	}
+	if flags&FlagFile == 0 {
+		return nil
+	}
+	const reservedSQL = "SELECT COUNT(*) FROM `storage` WHERE `id`=? AND `resume_until`>=?;"
+	err = tx.QueryRowContext(ctx, reservedSQL, item.ID, time.Now().UTC()).Scan(&item.reserved)
+	if err != nil {
+		return fmt.Errorf("check reserved attempts: %w", err)
+	}
+	return nil
+}
+
+// reserve saves a hash of a new random token to resume the file download during window,
+// counters are not changed.
+func (item *Item) reserve(ctx context.Context, tx *sql.Tx, window time.Duration) error {
+	const updateSQL = "UPDATE `storage` SET `resume_token`=?, `resume_until`=? WHERE `id`=?;"
+	b, err := encrypt.Random(16)
+	if err != nil {
+		return fmt.Errorf("resume token: %w", err)
+	}
+	token := hex.EncodeToString(b)
+	until := time.Now().UTC().Add(window)
+	if _, err = tx.ExecContext(ctx, updateSQL, tokenHash(token), until, item.ID); err != nil {
+		return fmt.Errorf("exec resume token update: %w", err)
+	}
+	item.ResumeToken, item.ResumeUntil = token, until
+	return nil
+}
+
+// readReserved loads an Item from database by the key and token of its active reservation.
+func (item *Item) readReserved(ctx context.Context, tx *sql.Tx, key, token string) error {
+	const readSQL = readColumns + "WHERE `key`=? AND `namespace`=? AND `expired`>=? AND `resume_token`=? AND `resume_until`>=?;"
+	now := time.Now().UTC()
+	err := item.scan(ctx, tx, readSQL, key, namespace(ctx), now, tokenHash(token), now)
+	if err != nil {
+		return err
+	}
+	item.ResumeToken = token
+	return nil
+}
+
+// release removes the reservation of the item.
+// It returns sql.ErrNoRows if the reservation is replaced by another download.
+func (item *Item) release(ctx context.Context, tx *sql.Tx) error {
+	const updateSQL = "UPDATE `storage` SET `resume_token`='', `resume_until`=NULL WHERE `id`=? AND `resume_token`=?;"
+	result, err := tx.ExecContext(ctx, updateSQL, item.ID, tokenHash(item.ResumeToken))
+	if err != nil {
+		return fmt.Errorf("exec release reservation: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("release affected rows: %w", err)
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	item.ResumeToken, item.ResumeUntil = "", time.Time{}
+	return nil
+}
+
+// Begin reads an item by its key for a resumable file download, only requested metadata is decrypted.
+// The file attempt is reserved by a new token during window instead of counters decrement,
+// the download is resumed by this token using Resume, and the attempt is used by Finish.
+// If the file is already reserved by another download, counters are decremented as by Read.
+// Restricted item is read only from allowed client's IP address ip.
+func Begin(ctx context.Context, db *sql.DB, key, password, ip string, flags DecryptFlag, window, undo time.Duration) (*Item, error) {
+	item := &Item{}
+	err := InTransaction(ctx, db, func(tx *sql.Tx) error {
+		e := item.read(ctx, tx, key)
+		e = item.allow(ip, e)
+		e = item.checkReserved(ctx, tx, flags, e)
+		e = item.validate(flags, e)
+		e = item.Decrypt(password, nil, flags, e)
+		if e != nil {
+			return e
+		}
+		if item.reserved > 0 {
+			return item.decrement(ctx, tx, flags, undo, nil)
+		}
+		return item.reserve(ctx, tx, window)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// Resume reads the reserved item again by the token issued by Begin, counters are not changed.
+// It returns sql.ErrNoRows if the token is wrong, or the reservation is over.
+func Resume(ctx context.Context, db *sql.DB, key, token, password, ip string, flags DecryptFlag) (*Item, error) {
+	item := &Item{}
+	err := InTransaction(ctx, db, func(tx *sql.Tx) error {
+		e := item.readReserved(ctx, tx, key, token)
+		e = item.allow(ip, e)
+		return item.Decrypt(password, nil, flags, e)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// Finish releases the reservation of the successfully downloaded item and decrements its counters.
+// It returns sql.ErrNoRows if the reservation was over and the file is reserved by another download.
+func Finish(ctx context.Context, db *sql.DB, item *Item, flags DecryptFlag) error {
+	return InTransaction(ctx, db, func(tx *sql.Tx) error {
+		if err := item.release(ctx, tx); err != nil {
+			return err
+		}
+		return item.decrement(ctx, tx, flags, 0, nil)
+	})
+}
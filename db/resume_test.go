@@ -0,0 +1,116 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fileCounter returns the file counter of the item with id.
+func fileCounter(t *testing.T, database *sql.DB, id int64) int {
+	var n int
+	err := database.QueryRow("SELECT `count_file` FROM `storage` WHERE `id`=?;", id).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestBegin_Finish(t *testing.T) {
+	const (
+		password = "secret"
+		flags    = FlagMeta | FlagFile
+	)
+	database := testDB(t)
+	saved := saveFileItem(t, database, password, 1, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	item, err := Begin(ctx, database, saved.Key, password, "", flags, time.Minute, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.ResumeToken == "" || item.ResumeUntil.IsZero() || !strings.Contains(item.FileMeta, "test.txt") {
+		t.Fatalf("failed reservation: token=%q, until=%v, meta=%q", item.ResumeToken, item.ResumeUntil, item.FileMeta)
+	}
+	if n := fileCounter(t, database, saved.ID); n != 1 {
+		t.Errorf("counter is changed by reservation: %d", n)
+	}
+	// the reserved attempt is not available for other readers
+	if _, err = Read(ctx, database, saved.Key, password, "", nil, flags, 0); !errors.Is(err, ErrNoAttempts) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err = Begin(ctx, database, saved.Key, password, "", flags, time.Minute, 0); !errors.Is(err, ErrNoAttempts) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err = Resume(ctx, database, saved.Key, "bad", password, "", flags); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// interrupted download is resumed without a new attempt
+	resumed, err := Resume(ctx, database, saved.Key, item.ResumeToken, password, "", flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed.ID != saved.ID || resumed.ResumeToken != item.ResumeToken {
+		t.Errorf("failed resumed item: %+v", resumed)
+	}
+	if err = Finish(ctx, database, resumed, flags); err != nil {
+		t.Fatal(err)
+	}
+	if resumed.CountFile != 0 || resumed.ResumeToken != "" {
+		t.Errorf("failed finished item: %+v", resumed)
+	}
+	if n := fileCounter(t, database, saved.ID); n != 0 {
+		t.Errorf("failed counter after finish: %d", n)
+	}
+	if _, err = Resume(ctx, database, saved.Key, item.ResumeToken, password, "", flags); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestBegin_Timeout(t *testing.T) {
+	const (
+		password = "secret"
+		flags    = FlagMeta | FlagFile
+	)
+	database := testDB(t)
+	saved := saveFileItem(t, database, password, 1, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	item, err := Begin(ctx, database, saved.Key, password, "", flags, time.Minute, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = database.ExecContext(ctx, "UPDATE `storage` SET `resume_until`=? WHERE `id`=?;", time.Now().UTC().Add(-time.Second), saved.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Resume(ctx, database, saved.Key, item.ResumeToken, password, "", flags); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// the expired reservation doesn't use the attempt
+	if n := fileCounter(t, database, saved.ID); n != 1 {
+		t.Errorf("counter is changed by expired reservation: %d", n)
+	}
+	other, err := Begin(ctx, database, saved.Key, password, "", flags, time.Minute, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.ResumeToken == item.ResumeToken {
+		t.Error("token is not changed")
+	}
+	// the old reservation is replaced
+	if err = Finish(ctx, database, item, flags); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err = Finish(ctx, database, other, flags); err != nil {
+		t.Fatal(err)
+	}
+	if n := fileCounter(t, database, saved.ID); n != 0 {
+		t.Errorf("failed counter after finish: %d", n)
+	}
+}
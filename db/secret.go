@@ -0,0 +1,31 @@
+package db
+
+import (
+	"sync"
+	"time"
+)
+
+// SecretFunc returns an encryption secret of the password for an item created at the time.
+type SecretFunc func(password string, created time.Time) string
+
+var (
+	// secretFunc builds secrets of items, the password is used as is by default.
+	secretFunc SecretFunc = func(password string, _ time.Time) string { return password }
+	// lock for secretFunc update
+	secretMu sync.RWMutex
+)
+
+// SetUpSecret sets a function which adds the server salt to passwords of items,
+// it must return the same secret for the same password and creation time.
+func SetUpSecret(f SecretFunc) {
+	secretMu.Lock()
+	secretFunc = f
+	secretMu.Unlock()
+}
+
+// secret returns an encryption secret of the password for the item by its creation time.
+func (item *Item) secret(password string) string {
+	secretMu.RLock()
+	defer secretMu.RUnlock()
+	return secretFunc(password, item.Created)
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/z0rr0/send/encrypt"
+)
+
+// canUpgrade returns true if the read item is encrypted by the legacy cipher suite,
+// and it can be re-encrypted by the current one. Items which are consumed or partially consumed
+// by this read, kept for undo, or reserved by not finished downloads are skipped.
+func (item *Item) canUpgrade() bool {
+	if item.Cipher != encrypt.SuiteLegacy || encrypt.CurrentSuite() == encrypt.SuiteLegacy {
+		return false
+	}
+	if item.Undo || item.reserved > 0 || item.notActive() {
+		return false
+	}
+	if (item.Text != "" || item.TextPath != "") && item.CountText < 1 {
+		return false
+	}
+	return item.FilePath == "" || item.CountFile > 0
+}
+
+// upgrade re-encrypts the read item by the current cipher suite with the same password,
+// encrypted is a copy of the item before its decryption by flags. New secrets are saved
+// in the transaction and copied to the item, so its files are read by new paths,
+// and decrypted values are kept. It returns the item's old files, which should be removed
+// after the commit, and new ones, which should be removed if the commit fails.
+// Errors don't fail the read, the item keeps its legacy data, and the error is saved as UpgradeErr.
+func (item *Item) upgrade(ctx context.Context, tx *sql.Tx, encrypted *Item, password string, flags DecryptFlag) (*Item, *Item) {
+	var (
+		secret  = item.secret(password)
+		old     = &Item{ID: item.ID, TextPath: encrypted.TextPath, FilePath: encrypted.FilePath}
+		created = &Item{ID: item.ID}
+	)
+	err := encrypted.rotate(secret, secret, nil)
+	if encrypted.TextPath != old.TextPath {
+		created.TextPath = encrypted.TextPath
+	}
+	if encrypted.FilePath != old.FilePath {
+		created.FilePath = encrypted.FilePath
+	}
+	err = encrypted.updateSecrets(ctx, tx, err)
+	if err != nil {
+		if e := deleteFiles(created); e != nil {
+			err = fmt.Errorf("%w, delete new files: %v", err, e)
+		}
+		item.UpgradeErr = err
+		return nil, nil
+	}
+	item.TextPath, item.FilePath, item.Master, item.Cipher = encrypted.TextPath, encrypted.FilePath, encrypted.Master, encrypted.Cipher
+	item.HashText, item.HashMeta, item.HashFile = encrypted.HashText, encrypted.HashMeta, encrypted.HashFile
+	item.SaltText, item.SaltMeta, item.SaltFile = encrypted.SaltText, encrypted.SaltMeta, encrypted.SaltFile
+	item.Updated = encrypted.Updated
+	if flags&FlagText == 0 {
+		item.Text = encrypted.Text
+	}
+	if flags&FlagMeta == 0 {
+		item.FileMeta = encrypted.FileMeta
+	}
+	return old, created
+}
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/z0rr0/send/encrypt"
+)
+
+func TestRead_Upgrade(t *testing.T) {
+	const password = "secret"
+	database := testDB(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	saved := saveFileItem(t, database, password, 2, 2)
+	consumed := saveFileItem(t, database, password, 2, 1)
+	if err := encrypt.SetUpSuite(encrypt.SuiteAESGCM); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := encrypt.SetUpSuite(encrypt.SuiteLegacy); err != nil {
+			t.Error(err)
+		}
+	}()
+	// not requested re-encryption
+	item, err := Read(ctx, database, saved.Key, password, "", nil, FlagText|FlagMeta, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Cipher != encrypt.SuiteLegacy || item.FilePath != saved.FilePath {
+		t.Errorf("item is re-encrypted: %q", item.Cipher)
+	}
+	item, err = Read(ctx, database, saved.Key, password, "", nil, FlagMeta|FlagFile|FlagUpgrade, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.UpgradeErr != nil || item.Cipher != encrypt.SuiteAESGCM || item.FilePath == saved.FilePath {
+		t.Fatalf("item is not re-encrypted: %q, %v", item.Cipher, item.UpgradeErr)
+	}
+	if encrypt.FileExists(saved.FilePath) || !encrypt.FileExists(item.FilePath) {
+		t.Error("failed files after re-encryption")
+	}
+	// the file is decrypted by new secrets after the read as by the file handler
+	var dst bytes.Buffer
+	if err = item.Decrypt(password, &dst, FlagFile, nil); err != nil {
+		t.Fatal(err)
+	}
+	if s := dst.String(); s != "file content" || !strings.Contains(item.FileMeta, "test.txt") {
+		t.Errorf("failed values: %s, %s", s, item.FileMeta)
+	}
+	dst.Reset()
+	item, err = Read(ctx, database, saved.Key, password, "", &dst, FlagText|FlagMeta|FlagFile, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Cipher != encrypt.SuiteAESGCM || item.Text != "text" || dst.String() != "file content" {
+		t.Errorf("failed re-encrypted item: %q, %s, %s", item.Cipher, item.Text, dst.String())
+	}
+	// the last file attempt is read without re-encryption
+	item, err = Read(ctx, database, consumed.Key, password, "", nil, FlagMeta|FlagFile|FlagUpgrade, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Cipher != encrypt.SuiteLegacy || item.FilePath != consumed.FilePath {
+		t.Errorf("consumed file is re-encrypted: %q", item.Cipher)
+	}
+}
@@ -1,25 +1,62 @@
 CREATE TABLE IF NOT EXISTS `storage`
 (
-    `id`         INTEGER PRIMARY KEY AUTOINCREMENT,
-    `key`        VARCHAR(64),
-    `text`       TEXT,
-    `file_meta`  TEXT,
-    `file_path`  TEXT,
-    `count_text` INTEGER      NOT NULL DEFAULT 1,
-    `count_meta` INTEGER      NOT NULL DEFAULT 1,
-    `count_file` INTEGER      NOT NULL DEFAULT 1,
-    `hash_text`  VARCHAR(64)  NOT NULL,
-    `hash_meta`  VARCHAR(64)  NOT NULL,
-    `hash_file`  VARCHAR(64)  NOT NULL,
-    `salt_text`  VARCHAR(256) NOT NULL,
-    `salt_meta`  VARCHAR(256) NOT NULL,
-    `salt_file`  VARCHAR(256) NOT NULL,
-    `created`    DATETIME     NOT NULL,
-    `updated`    DATETIME     NOT NULL,
-    `expired`    DATETIME     NOT NULL
+    `id`            INTEGER PRIMARY KEY AUTOINCREMENT,
+    `key`           VARCHAR(64),
+    `text`          TEXT,
+    `file_meta`     TEXT,
+    `file_path`     TEXT,
+    `count_text`    INTEGER      NOT NULL DEFAULT 1,
+    `count_meta`    INTEGER      NOT NULL DEFAULT 1,
+    `count_file`    INTEGER      NOT NULL DEFAULT 1,
+    `hash_text`     VARCHAR(64)  NOT NULL,
+    `hash_meta`     VARCHAR(64)  NOT NULL,
+    `hash_file`     VARCHAR(64)  NOT NULL,
+    `salt_text`     VARCHAR(256) NOT NULL,
+    `salt_meta`     VARCHAR(256) NOT NULL,
+    `salt_file`     VARCHAR(256) NOT NULL,
+    `created`       DATETIME     NOT NULL,
+    `updated`       DATETIME     NOT NULL,
+    `expired`       DATETIME     NOT NULL,
+    `text_path`     TEXT         NOT NULL DEFAULT '',
+    `one_time`      BOOLEAN      NOT NULL DEFAULT 0,
+    `hint`          TEXT         NOT NULL DEFAULT '',
+    `file_info`     TEXT         NOT NULL DEFAULT '',
+    `master`        BOOLEAN      NOT NULL DEFAULT 0,
+    `reread_token`  VARCHAR(64)  NOT NULL DEFAULT '',
+    `reread_until`  DATETIME     NULL,
+    `allowed_ips`   TEXT         NOT NULL DEFAULT '',
+    `origin_ip`     VARCHAR(64)  NOT NULL DEFAULT '',
+    `origin_agent`  VARCHAR(64)  NOT NULL DEFAULT '',
+    `cipher`        VARCHAR(32)  NOT NULL DEFAULT '',
+    `confirm_token` VARCHAR(64)  NOT NULL DEFAULT '',
+    `confirm_until` DATETIME,
+    `pending_text`  INTEGER      NOT NULL DEFAULT 0,
+    `pending_file`  INTEGER      NOT NULL DEFAULT 0,
+    `namespace`     VARCHAR(32)  NOT NULL DEFAULT '',
+    `resume_token`  VARCHAR(64)  NOT NULL DEFAULT '',
+    `resume_until`  DATETIME     NULL
 );
 CREATE UNIQUE INDEX IF NOT EXISTS `key` ON `storage` (`key`);
 CREATE INDEX IF NOT EXISTS `expired` ON `storage` (`expired`,`count_text`,`count_file`);
+CREATE INDEX IF NOT EXISTS `key_expired` ON `storage` (`key`,`expired`);
+CREATE INDEX IF NOT EXISTS `counters` ON `storage` (`count_text`,`count_file`);
+CREATE INDEX IF NOT EXISTS `namespace` ON `storage` (`namespace`,`expired`);
+
+CREATE TABLE IF NOT EXISTS `quota`
+(
+    `source` VARCHAR(64) NOT NULL,
+    `day`    VARCHAR(10) NOT NULL,
+    `count`  INTEGER     NOT NULL DEFAULT 0,
+    PRIMARY KEY (`source`,`day`)
+);
+CREATE INDEX IF NOT EXISTS `quota_day` ON `quota` (`day`);
+
+CREATE TABLE IF NOT EXISTS `schema_version`
+(
+    `version` INTEGER  NOT NULL,
+    `applied` DATETIME NOT NULL
+);
+INSERT INTO `schema_version` (`version`, `applied`) VALUES (15, CURRENT_TIMESTAMP);
 
 /*
 id - unique identifier
@@ -37,5 +74,21 @@ salt_meta - random salt for file name
 salt_file - random salt for file content
 created - timestamp of item create
 updated - timestamp of item update
-updated - timestamp of item expiration
- */
\ No newline at end of file
+expired - timestamp of item expiration
+text_path - path to an encrypted file with big text
+one_time - item is deleted by its last read
+hint - public password hint
+file_info - public not encrypted file info, JSON {size, content_type}
+master - data has the second encryption layer with the server master key
+reread_token, reread_until - hash of the token and the end of the undo window of a consumed item
+allowed_ips - comma-separated networks which are allowed to read the item
+origin_ip, origin_agent - optional salted hashes of the uploader
+cipher - cipher suite of encrypted data, empty value is legacy AES-CFB/OFB
+confirm_token, confirm_until - hash of the token and the end of the confirmation window of a pending item
+pending_text, pending_file - counters of a pending item, they are available after confirmation
+namespace - namespace of the item, empty value is the default namespace
+resume_token, resume_until - hash of the token and the end of a file attempt reservation by a resumable download
+ */
+
+-- It is the current schema of version 15 (number of db.migrations items), it's tracked in the `schema_version` table.
+-- Older databases are updated by db.Migrate on startup (storage.migrate = true), new migrations must be added here too.
@@ -5,46 +5,142 @@ package encrypt
 import (
 	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"golang.org/x/crypto/pbkdf2"
 	"golang.org/x/crypto/sha3"
 
 	"github.com/z0rr0/send/encrypt/stream"
-	"github.com/z0rr0/send/encrypt/text"
+	"github.com/z0rr0/send/logging"
 )
 
 const (
 	// saltSize is Random of salt.
 	saltSize = 128
-	// fileNameSize is used for storage file name.
-	fileNameSize = 64
-	// fileCreateAttempts is a number of attempts to create new file with unique name.
-	fileCreateAttempts = 10
 	// pbkdf2Iter is number of pbkdf2 iterations.
 	pbkdf2Iter = 65536
 	// key length for AES-256.
 	aesKeyLength = 32
 	// hashLength is length of file hash.
 	hashLength = 32
+	// MinFileNameSize is minimal number of random bytes for storage file name.
+	MinFileNameSize = 16
+)
+
+var (
+	// ErrSecret is an error when the secret hash is incorrect.
+	ErrSecret = errors.New("failed secret")
+	// ErrFileCreate is an error when a new file with unique name can not be created.
+	ErrFileCreate = errors.New("can not create new file")
+	// ErrMasterKey is an error when data is encrypted with the server master key, but it is not set.
+	ErrMasterKey = errors.New("master key is not set")
+	// ErrStorageFull is an error when there is no space on the device for the encrypted file.
+	ErrStorageFull = errors.New("no space left on storage device")
+
+	// fileNameSize is number of random bytes used for storage file name.
+	fileNameSize = 64
+	// fileCreateAttempts is a number of attempts to create new file with unique name.
+	fileCreateAttempts = 10
+	// fileMode is permissions of new storage files.
+	fileMode os.FileMode = 0600
+	// collisions is a number of storage file names collisions.
+	collisions uint64
+	// bufferSize is a copy buffer size of files encryption and decryption.
+	bufferSize = stream.DefaultBufferSize
+	// master is a server key for the second encryption layer, it's not used if empty.
+	master []byte
+	// random is a source of salts and text IVs, only tests replace it to get deterministic ciphertexts.
+	random io.Reader = rand.Reader
+	// lock for files settings update
+	mu sync.RWMutex
 )
 
-// ErrSecret is an error when the secret hash is incorrect.
-var ErrSecret = errors.New("failed secret")
+// SetUpFiles sets number of random bytes for storage file names,
+// a number of attempts to create a file with unique name and new files permissions.
+func SetUpFiles(nameSize, attempts int, mode os.FileMode) {
+	mu.Lock()
+	fileNameSize, fileCreateAttempts, fileMode = nameSize, attempts, mode
+	mu.Unlock()
+}
+
+// SetUpBuffer sets a copy buffer size in bytes of files encryption and decryption,
+// stream.DefaultBufferSize is used if it is not positive.
+func SetUpBuffer(size int) {
+	if size < 1 {
+		size = stream.DefaultBufferSize
+	}
+	mu.Lock()
+	bufferSize = size
+	mu.Unlock()
+}
+
+// fileBuffer returns a copy buffer size of files encryption and decryption.
+func fileBuffer() int {
+	mu.RLock()
+	defer mu.RUnlock()
+	return bufferSize
+}
+
+// SetUpMaster sets the server master key for the second encryption layer of new data.
+// Empty key disables the layer, but data encrypted with it can't be decrypted then.
+func SetUpMaster(key []byte) {
+	mu.Lock()
+	master = append([]byte(nil), key...)
+	mu.Unlock()
+}
+
+// masterKey returns a unique key of the server encryption layer for the message with the salt.
+// It returns nil if the master key is not set.
+func masterKey(salt []byte) []byte {
+	mu.RLock()
+	defer mu.RUnlock()
+	if len(master) == 0 {
+		return nil
+	}
+	mac := hmac.New(sha256.New, master)
+	mac.Write(salt)
+	return mac.Sum(nil)
+}
+
+// fileSettings returns storage file name size, number of attempts to create a file and its permissions.
+func fileSettings() (int, int, os.FileMode) {
+	mu.RLock()
+	defer mu.RUnlock()
+	return fileNameSize, fileCreateAttempts, fileMode
+}
+
+// Collisions returns a number of storage file names collisions.
+// Random names collisions are not expected, so any positive value means a random generator problem.
+func Collisions() uint64 {
+	return atomic.LoadUint64(&collisions)
+}
 
 // Msg is struct with base parameter/results of encryption/decryption.
+// Size is a number of plaintext bytes and Checksum is a hex SHA-256 of plaintext,
+// they are filled only for files. Master is true if the data has the second server key layer.
+// Suite is a cipher suite of the data, it's used by both layers.
 type Msg struct {
-	Salt  string
-	Value string
-	Hash  string
-	s     []byte
-	v     []byte
-	h     []byte
+	Salt     string
+	Value    string
+	Hash     string
+	Size     int64 // plaintext size
+	Written  int64 // size of the stored file
+	Checksum string
+	Master   bool
+	Suite    string
+	s        []byte
+	v        []byte
+	h        []byte
 }
 
 func (m *Msg) encode(withValue bool) {
@@ -89,40 +185,44 @@ func Random(n int) ([]byte, error) {
 }
 
 // createFile creates a new file with name or Random value (if name is empty) inside base path.
-func createFile(base, name string) (*os.File, error) {
-	var attempts = fileCreateAttempts
+// It returns a writer of the file and its full path.
+func createFile(base, name string) (io.WriteCloser, string, error) {
+	nameSize, attempts, mode := fileSettings()
 	if name != "" {
 		attempts = 1
 	}
+	fs := storage()
 	for i := 0; i < attempts; i++ {
 		if name == "" {
 			// no custom name, generate random one
-			value, err := Random(fileNameSize)
+			value, err := Random(nameSize)
 			if err != nil {
-				return nil, fmt.Errorf("random file name: %w", err)
+				return nil, "", fmt.Errorf("random file name: %w", err)
 			}
 			name = hex.EncodeToString(value)
 		}
 		fullPath := filepath.Join(base, name)
-		f, err := os.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		f, err := fs.Create(fullPath, mode)
 		if err != nil {
 			if !os.IsExist(err) {
 				// unexpected error
-				return nil, fmt.Errorf("random file creation: %w", err)
+				return nil, "", fmt.Errorf("random file creation: %w", err)
 			}
 			// name duplication error - do new attempt
+			n := atomic.AddUint64(&collisions, 1)
+			logging.New("encrypt").Error("file name collision %s, attempt=%d, total=%d", fullPath, i+1, n)
 			name = ""
 		} else {
-			return f, nil
+			return f, fullPath, nil
 		}
 	}
-	return nil, fmt.Errorf("can not create new file after %d attempts", fileCreateAttempts)
+	return nil, "", fmt.Errorf("%w after %d attempts", ErrFileCreate, attempts)
 }
 
-// Salt returns Random bytes.
+// Salt returns random bytes.
 func Salt() ([]byte, error) {
-	salt, err := Random(saltSize)
-	if err != nil {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(random, salt); err != nil {
 		return nil, fmt.Errorf("read rand: %w", err)
 	}
 	return salt, nil
@@ -136,11 +236,30 @@ func Hash(data []byte) []byte {
 }
 
 // Key calculates and returns secret key and its SHA512 hash.
+// Derivation duration is saved to metrics if they are enabled.
 func Key(secret string, salt []byte) ([]byte, []byte) {
+	var start time.Time
+	enabled, slow, l := metricsSettings()
+	if enabled {
+		start = time.Now()
+	}
 	key := pbkdf2.Key([]byte(secret), salt, pbkdf2Iter, aesKeyLength, sha3.New512)
+	if enabled {
+		observeKey(time.Since(start), slow, l)
+	}
 	return key, Hash(append(key, salt...))
 }
 
+// Verify checks the secret using Msg.Salt and Msg.Hash without decryption.
+func Verify(secret string, m *Msg) (bool, error) {
+	err := m.decode(false)
+	if err != nil {
+		return false, err
+	}
+	_, hash := Key(secret, m.s)
+	return hmac.Equal(hash, m.h), nil
+}
+
 // Text encrypts plaintText using the secret.
 // Cipher message will be returned as Msg.Value.
 func Text(secret, plainText string) (*Msg, error) {
@@ -149,11 +268,19 @@ func Text(secret, plainText string) (*Msg, error) {
 		return nil, err
 	}
 	key, h := Key(secret, salt)
-	cipherText, err := text.Encrypt([]byte(plainText), key)
+	cs := CurrentSuite()
+	cipherText, err := encryptText(cs, []byte(plainText), key)
 	if err != nil {
 		return nil, err
 	}
-	m := &Msg{v: cipherText, s: salt, h: h}
+	m := &Msg{v: cipherText, s: salt, h: h, Suite: cs}
+	if mk := masterKey(salt); mk != nil {
+		m.v, err = encryptText(cs, cipherText, mk)
+		if err != nil {
+			return nil, fmt.Errorf("master key encryption: %w", err)
+		}
+		m.Master = true
+	}
 	m.encode(true)
 	return m, nil
 }
@@ -169,7 +296,17 @@ func DecryptText(secret string, m *Msg) (string, error) {
 	if !hmac.Equal(hash, m.h) {
 		return "", ErrSecret
 	}
-	plainText, err := text.Decrypt(m.v, key)
+	if m.Master {
+		mk := masterKey(m.s)
+		if mk == nil {
+			return "", ErrMasterKey
+		}
+		m.v, err = decryptText(m.Suite, m.v, mk)
+		if err != nil {
+			return "", fmt.Errorf("master key decryption: %w", err)
+		}
+	}
+	plainText, err := decryptText(m.Suite, m.v, key)
 	if err != nil {
 		return "", err
 	}
@@ -177,25 +314,83 @@ func DecryptText(secret string, m *Msg) (string, error) {
 }
 
 // File encrypts content from src to a new file using the secret.
-// Salt and key hash are returned as Msg.Salt and Msg.Hash.
+// Salt and key hash are returned as Msg.Salt and Msg.Hash,
+// plaintext checksum is calculated during the encryption without a second pass.
 // The name if new file will be stored in m.Value.
 func File(secret string, src io.Reader, base, name string) (*Msg, error) {
 	salt, err := Salt()
 	if err != nil {
 		return nil, err
 	}
-	dst, err := createFile(base, name)
+	dst, fullPath, err := createFile(base, name)
 	if err != nil {
 		return nil, fmt.Errorf("open file for ecryption: %w", err)
 	}
 	key, h := Key(secret, salt)
-	err = stream.Encrypt(src, dst, key)
+	cs := CurrentSuite()
+	checksum := sha256.New()
+	written := &countWriter{w: dst}
+	var (
+		w     io.Writer = written
+		outer io.WriteCloser
+	)
+	mk := masterKey(salt)
+	if mk != nil {
+		// outer server layer
+		outer, err = newWriter(cs, written, mk)
+		if err != nil {
+			return nil, removeFailed(dst, fullPath, fmt.Errorf("master key encryption: %w", err))
+		}
+		w = outer
+	}
+	n, err := encryptStream(cs, io.TeeReader(src, checksum), w, key)
 	if err != nil {
-		return nil, err
+		return nil, removeFailed(dst, fullPath, err)
+	}
+	if outer != nil {
+		if err = outer.Close(); err != nil {
+			return nil, removeFailed(dst, fullPath, fmt.Errorf("master key encryption: %w", err))
+		}
+	}
+	if err = dst.Close(); err != nil {
+		return nil, removeFailed(nil, fullPath, err)
+	}
+	m := &Msg{
+		s: salt, h: h, Value: fullPath, Size: n, Written: written.n,
+		Checksum: hex.EncodeToString(checksum.Sum(nil)), Master: mk != nil, Suite: cs,
 	}
-	m := &Msg{s: salt, h: h, Value: dst.Name()}
 	m.encode(false)
-	return m, dst.Close()
+	return m, nil
+}
+
+// countWriter is a writer wrapper that counts written bytes.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write writes data and counts its size.
+func (cw *countWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	return n, err
+}
+
+// removeFailed closes not nil dst and removes the partially written file with fullPath.
+// The returned error wraps ErrStorageFull if err is caused by a full device.
+func removeFailed(dst io.Closer, fullPath string, err error) error {
+	if errors.Is(err, syscall.ENOSPC) {
+		err = fmt.Errorf("%w: %v", ErrStorageFull, err)
+	}
+	if dst != nil {
+		if e := dst.Close(); e != nil {
+			err = fmt.Errorf("%w, close file: %v", err, e)
+		}
+	}
+	if e := storage().Remove(fullPath); e != nil {
+		err = fmt.Errorf("%w, remove file: %v", err, e)
+	}
+	return err
 }
 
 // DecryptFile writes decrypted content of file with path from Msg.Value,
@@ -205,17 +400,38 @@ func DecryptFile(secret string, m *Msg, dst io.Writer) error {
 	if err != nil {
 		return err
 	}
-	src, err := os.Open(m.Value)
-	if err != nil {
-		return fmt.Errorf("open file for decryption: %w", err)
-	}
 	key, hash := Key(secret, m.s)
 	if !hmac.Equal(hash, m.h) {
 		return ErrSecret
 	}
-	err = stream.Decrypt(src, dst, key)
+	var mk []byte
+	if m.Master {
+		if mk = masterKey(m.s); mk == nil {
+			return ErrMasterKey
+		}
+	}
+	src, err := storage().Open(m.Value)
 	if err != nil {
-		return err
+		return fmt.Errorf("open file for decryption: %w", err)
+	}
+	var r io.Reader = src
+	if mk != nil {
+		r, err = newReader(m.Suite, src, mk)
+		if err != nil {
+			return closeWithError(src, fmt.Errorf("master key decryption: %w", err))
+		}
+	}
+	_, err = decryptStream(m.Suite, r, dst, key)
+	if err != nil {
+		return closeWithError(src, err)
 	}
 	return src.Close()
 }
+
+// closeWithError closes the file and returns the error err joined with a close error.
+func closeWithError(f io.Closer, err error) error {
+	if e := f.Close(); e != nil {
+		return fmt.Errorf("%w, close file: %v", err, e)
+	}
+	return err
+}
@@ -2,9 +2,19 @@ package encrypt
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/z0rr0/send/logging"
 )
 
 func TestText(t *testing.T) {
@@ -30,6 +40,31 @@ func TestText(t *testing.T) {
 	}
 }
 
+func TestVerify(t *testing.T) {
+	const secret = "secret"
+	m, err := Text(secret, "some text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := Verify(secret, &Msg{Salt: m.Salt, Hash: m.Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error("failed verification of valid secret")
+	}
+	ok, err = Verify("bad", &Msg{Salt: m.Salt, Hash: m.Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("failed verification of invalid secret")
+	}
+	if _, err = Verify(secret, &Msg{Salt: "bad", Hash: m.Hash}); err == nil {
+		t.Error("expected error for bad salt")
+	}
+}
+
 func TestFile(t *testing.T) {
 	const (
 		secret    = "secret"
@@ -46,6 +81,12 @@ func TestFile(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	if m1.Size != int64(len(plainText)) || m1.Written != m1.Size {
+		t.Errorf("failed size=%d, written=%d", m1.Size, m1.Written)
+	}
+	if sum := sha256.Sum256([]byte(plainText)); m1.Checksum != hex.EncodeToString(sum[:]) {
+		t.Errorf("failed checksum=%s", m1.Checksum)
+	}
 	fileName := m1.Value
 	t.Logf("created file name = %s", fileName)
 	defer func() {
@@ -70,6 +111,59 @@ func TestFile(t *testing.T) {
 	}
 }
 
+func TestCreateFile(t *testing.T) {
+	base := t.TempDir()
+	SetUpFiles(MinFileNameSize, 3, 0600)
+	defer SetUpFiles(64, 10, 0600)
+
+	f, fullPath, err := createFile(base, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(filepath.Base(fullPath)); n != MinFileNameSize*2 {
+		t.Errorf("failed file name length=%d", n)
+	}
+	if err = f.Close(); err != nil {
+		t.Error(err)
+	}
+	// custom name collision
+	before := Collisions()
+	_, _, err = createFile(base, filepath.Base(fullPath))
+	if !errors.Is(err, ErrFileCreate) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if n := Collisions() - before; n != 1 {
+		t.Errorf("failed number of collisions=%d", n)
+	}
+	// permission error is not ErrFileCreate
+	_, _, err = createFile(filepath.Join(base, "not_exists"), "")
+	if err == nil || errors.Is(err, ErrFileCreate) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestCreateFile_Mode(t *testing.T) {
+	base := t.TempDir()
+	defer SetUpFiles(64, 10, 0600)
+	for _, mode := range []os.FileMode{0600, 0640, 0400} {
+		SetUpFiles(MinFileNameSize, 3, mode)
+		f, fullPath, err := createFile(base, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = f.Close(); err != nil {
+			t.Error(err)
+		}
+		info, err := os.Stat(fullPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m := info.Mode().Perm(); m != mode {
+			t.Errorf("failed file permissions=%o, expected=%o", m, mode)
+		}
+	}
+}
+
 func BenchmarkSalt(b *testing.B) {
 	for n := 0; n < b.N; n++ {
 		salt, err := Salt()
@@ -99,3 +193,210 @@ func BenchmarkKey(b *testing.B) {
 		}
 	}
 }
+
+func TestMaster(t *testing.T) {
+	const (
+		secret    = "secret"
+		plainText = "some text"
+	)
+	SetUpMaster([]byte("master key"))
+	defer SetUpMaster(nil)
+
+	mt, err := Text(secret, plainText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mt.Master {
+		t.Error("text is not encrypted with master key")
+	}
+	base := t.TempDir()
+	mf, err := File(secret, bytes.NewBufferString(plainText), base, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mf.Master {
+		t.Error("file is not encrypted with master key")
+	}
+	// decrypt with master key
+	decrypted, err := DecryptText(secret, &Msg{Value: mt.Value, Salt: mt.Salt, Hash: mt.Hash, Master: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted != plainText {
+		t.Errorf("failed decrypted=%s", decrypted)
+	}
+	var dst bytes.Buffer
+	if err = DecryptFile(secret, &Msg{Value: mf.Value, Salt: mf.Salt, Hash: mf.Hash, Master: true}, &dst); err != nil {
+		t.Fatal(err)
+	}
+	if s := dst.String(); s != plainText {
+		t.Errorf("failed decrypted file=%s", s)
+	}
+	// another master key
+	SetUpMaster([]byte("another key"))
+	decrypted, err = DecryptText(secret, &Msg{Value: mt.Value, Salt: mt.Salt, Hash: mt.Hash, Master: true})
+	if err == nil && decrypted == plainText {
+		t.Error("text is decrypted with another master key")
+	}
+	// without master key
+	SetUpMaster(nil)
+	_, err = DecryptText(secret, &Msg{Value: mt.Value, Salt: mt.Salt, Hash: mt.Hash, Master: true})
+	if !errors.Is(err, ErrMasterKey) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err = DecryptFile(secret, &Msg{Value: mf.Value, Salt: mf.Salt, Hash: mf.Hash, Master: true}, &dst)
+	if !errors.Is(err, ErrMasterKey) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// old data is still available after the master key setup
+	m, err := Text(secret, plainText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Master {
+		t.Error("text is encrypted with empty master key")
+	}
+	SetUpMaster([]byte("master key"))
+	decrypted, err = DecryptText(secret, &Msg{Value: m.Value, Salt: m.Salt, Hash: m.Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted != plainText {
+		t.Errorf("failed decrypted=%s", decrypted)
+	}
+}
+
+func TestKeyDerivation(t *testing.T) {
+	salt, err := Salt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := KeyDerivation()
+	Key("secret", salt)
+	if n := KeyDerivation().Count; n != before.Count {
+		t.Errorf("disabled metrics are saved: %d", n)
+	}
+	SetUpMetrics(true, time.Nanosecond, logging.New("test"))
+	defer SetUpMetrics(false, 0, nil)
+
+	Key("secret", salt)
+	stats := KeyDerivation()
+	if stats.Count != before.Count+1 || stats.Sum <= before.Sum {
+		t.Errorf("failed metrics: %+v", stats)
+	}
+	for i := 1; i < len(stats.Counts); i++ {
+		if stats.Counts[i] < stats.Counts[i-1] || stats.Counts[i] > stats.Count {
+			t.Errorf("not cumulative counts: %v", stats.Counts)
+		}
+	}
+}
+
+// zeroReader is a fixed random source for deterministic encryption.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// setRandom replaces the random source for a test.
+func setRandom(t *testing.T, r io.Reader) {
+	prev := random
+	random = r
+	t.Cleanup(func() { random = prev })
+}
+
+func TestRandomSource(t *testing.T) {
+	const (
+		secret    = "secret"
+		plainText = "some text"
+	)
+	setRandom(t, zeroReader{})
+
+	a, err := Text(secret, plainText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Text(secret, plainText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Value != b.Value || a.Salt != b.Salt || a.Hash != b.Hash {
+		t.Errorf("not deterministic encryption: %+v != %+v", a, b)
+	}
+	if a.Salt != strings.Repeat("0", 2*saltSize) {
+		t.Errorf("failed salt=%s", a.Salt)
+	}
+	decrypted, err := DecryptText(secret, &Msg{Value: a.Value, Salt: a.Salt, Hash: a.Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted != plainText {
+		t.Errorf("failed decrypted text=%s", decrypted)
+	}
+	random = rand.Reader
+	c, err := Text(secret, plainText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Salt == a.Salt || c.Value == a.Value {
+		t.Error("random source is not restored")
+	}
+}
+
+// failingStorage is a memory storage which files can't be bigger than limit bytes.
+type failingStorage struct {
+	*MemoryStorage
+	limit int
+	err   error
+}
+
+// failingFile is a file which returns an error when its limit is reached.
+type failingFile struct {
+	io.WriteCloser
+	size int
+	fs   *failingStorage
+}
+
+func (ff *failingFile) Write(p []byte) (int, error) {
+	if ff.size+len(p) > ff.fs.limit {
+		return 0, &os.PathError{Op: "write", Path: "test", Err: ff.fs.err}
+	}
+	ff.size += len(p)
+	return ff.WriteCloser.Write(p)
+}
+
+func (fs *failingStorage) Create(name string, mode os.FileMode) (io.WriteCloser, error) {
+	f, err := fs.MemoryStorage.Create(name, mode)
+	if err != nil {
+		return nil, err
+	}
+	return &failingFile{WriteCloser: f, fs: fs}, nil
+}
+
+func TestFile_WriteError(t *testing.T) {
+	cases := []struct {
+		err  error
+		full bool
+	}{
+		{err: syscall.ENOSPC, full: true},
+		{err: syscall.EIO},
+	}
+	defer SetUpStorage(DiskStorage{})
+	for i, c := range cases {
+		fs := &failingStorage{MemoryStorage: NewMemoryStorage(), limit: 100, err: c.err}
+		SetUpStorage(fs)
+		m, err := File("secret", strings.NewReader(strings.Repeat("a", 1000)), "storage", "")
+		if err == nil {
+			t.Fatalf("case=%d: expected error, file=%s", i, m.Value)
+		}
+		if errors.Is(err, ErrStorageFull) != c.full {
+			t.Errorf("case=%d: unexpected error: %v", i, err)
+		}
+		if n := fs.Len(); n != 0 {
+			t.Errorf("case=%d: partial file is not removed, files=%d", i, n)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package encrypt
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/z0rr0/send/logging"
+)
+
+// KeyBuckets are upper bounds of key derivation duration histogram buckets.
+var KeyBuckets = [...]time.Duration{
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+}
+
+var (
+	// metricsEnabled enables key derivation timing.
+	metricsEnabled bool
+	// slowKey is a duration of key derivation after which it is logged, it's not logged if zero.
+	slowKey time.Duration
+	// metricsLog is a logger of slow key derivation.
+	metricsLog *logging.Log
+	// lock for metrics settings update
+	metricsMu sync.RWMutex
+
+	// keyCounts are numbers of key derivations by histogram buckets, the last one is for slower ones.
+	keyCounts [len(KeyBuckets) + 1]uint64
+	// keySum is a total duration of key derivations in nanoseconds.
+	keySum int64
+)
+
+// KeyStats is a snapshot of key derivation duration histogram.
+type KeyStats struct {
+	Counts [len(KeyBuckets)]uint64 // cumulative numbers of derivations not slower than KeyBuckets
+	Count  uint64
+	Sum    time.Duration
+}
+
+// SetUpMetrics enables key derivation timing. Derivations slower than slow are logged by l,
+// zero slow value disables logging.
+func SetUpMetrics(enabled bool, slow time.Duration, l *logging.Log) {
+	metricsMu.Lock()
+	metricsEnabled, slowKey, metricsLog = enabled, slow, l
+	metricsMu.Unlock()
+}
+
+// metricsSettings returns metrics flag, slow key derivation threshold and its logger.
+func metricsSettings() (bool, time.Duration, *logging.Log) {
+	metricsMu.RLock()
+	defer metricsMu.RUnlock()
+	return metricsEnabled, slowKey, metricsLog
+}
+
+// observeKey saves duration d of key derivation to the histogram.
+func observeKey(d, slow time.Duration, l *logging.Log) {
+	i := 0
+	for i < len(KeyBuckets) && d > KeyBuckets[i] {
+		i++
+	}
+	atomic.AddUint64(&keyCounts[i], 1)
+	atomic.AddInt64(&keySum, int64(d))
+	if slow > 0 && d >= slow && l != nil {
+		l.Info("slow key derivation %v", d)
+	}
+}
+
+// KeyDerivation returns a snapshot of key derivation duration histogram.
+func KeyDerivation() *KeyStats {
+	stats := &KeyStats{Sum: time.Duration(atomic.LoadInt64(&keySum))}
+	for i := range keyCounts {
+		stats.Count += atomic.LoadUint64(&keyCounts[i])
+		if i < len(stats.Counts) {
+			stats.Counts[i] = stats.Count
+		}
+	}
+	return stats
+}
@@ -5,7 +5,8 @@ package pwgen
 import (
 	crand "crypto/rand"
 	"encoding/binary"
-	"math/rand"
+	"fmt"
+	"io"
 )
 
 // Alphabet is all allowed for password generation symbols
@@ -30,15 +31,49 @@ func (CryptoRandSource) Int63() int64 {
 func (CryptoRandSource) Seed(int64) {}
 
 // New returns a new random string with length `n`.
+// It uses crypto/rand source and panics if random data can not be read.
 func New(n int) string {
-	source := &CryptoRandSource{}
-	random := rand.New(source)
+	p, err := NewGenerator().Generate(n)
+	if err != nil {
+		// fail - can't continue
+		panic(err)
+	}
+	return p
+}
 
-	lenAlphabet := len(Alphabet)
-	container := make([]byte, n)
+// Generator creates passwords from symbols of Alphabet using random bytes from Source.
+// Custom Source can be used for deterministic results in tests.
+type Generator struct {
+	Alphabet string
+	Source   io.Reader
+}
+
+// NewGenerator returns a new password generator with default alphabet and crypto/rand source.
+func NewGenerator() *Generator {
+	return &Generator{Alphabet: Alphabet, Source: crand.Reader}
+}
+
+// Generate returns a new random password with length n.
+// Random bytes which can't be uniformly mapped to the alphabet are skipped.
+func (g *Generator) Generate(n int) (string, error) {
+	lenAlphabet := len(g.Alphabet)
+	if lenAlphabet == 0 || lenAlphabet > 256 {
+		return "", fmt.Errorf("alphabet length=%d is not in [1; 256]", lenAlphabet)
+	}
+	// max byte value + 1 which can be used without modulo bias
+	limit := 256 - 256%lenAlphabet
+	container := make([]byte, 0, n)
+	b := make([]byte, n)
 
-	for i := range container {
-		container[i] = Alphabet[random.Intn(lenAlphabet)]
+	for len(container) < n {
+		if _, err := io.ReadFull(g.Source, b[:n-len(container)]); err != nil {
+			return "", fmt.Errorf("read random bytes: %w", err)
+		}
+		for _, v := range b[:n-len(container)] {
+			if int(v) < limit {
+				container = append(container, g.Alphabet[int(v)%lenAlphabet])
+			}
+		}
 	}
-	return string(container)
+	return string(container), nil
 }
@@ -1,6 +1,9 @@
 package pwgen
 
-import "testing"
+import (
+	"bytes"
+	"testing"
+)
 
 func TestNew(t *testing.T) {
 	var p string
@@ -25,3 +28,38 @@ func BenchmarkNew(b *testing.B) {
 		}
 	}
 }
+
+func TestGenerator_Generate(t *testing.T) {
+	cases := []struct {
+		alphabet string
+		source   []byte
+		n        int
+		expected string
+		fail     bool
+	}{
+		{alphabet: "abc", source: []byte{0, 1, 2, 3, 4, 5}, n: 6, expected: "abcabc"},
+		// 255 is skipped as modulo biased value, 256-256%3=255
+		{alphabet: "abc", source: []byte{255, 255, 7, 8}, n: 2, expected: "bc"},
+		{alphabet: Alphabet, source: []byte{0, 42, 43}, n: 3, expected: "#z#"},
+		{alphabet: "abc", source: []byte{0}, n: 2, fail: true},
+		{alphabet: "", source: []byte{0}, n: 1, fail: true},
+		{alphabet: "abc", n: 0},
+	}
+	for i, c := range cases {
+		g := &Generator{Alphabet: c.alphabet, Source: bytes.NewReader(c.source)}
+		p, err := g.Generate(c.n)
+		if c.fail {
+			if err == nil {
+				t.Errorf("case=%d: expected error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case=%d: unexpected error: %v", i, err)
+			continue
+		}
+		if p != c.expected {
+			t.Errorf("case=%d: failed password=%s", i, p)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package encrypt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// FileStorage is an interface of encrypted files storage.
+// Create must return an error satisfying os.IsExist if the file already exists,
+// Open and Remove must return an error satisfying os.IsNotExist if the file is absent.
+type FileStorage interface {
+	Create(name string, mode os.FileMode) (io.WriteCloser, error)
+	Open(name string) (io.ReadCloser, error)
+	Remove(name string) error
+	Exists(name string) bool
+}
+
+var (
+	// files is the current encrypted files storage.
+	files FileStorage = DiskStorage{}
+	// lock for files storage update
+	filesMu sync.RWMutex
+)
+
+// SetUpStorage sets a storage of encrypted files, it is the file system by default.
+func SetUpStorage(fs FileStorage) {
+	filesMu.Lock()
+	files = fs
+	filesMu.Unlock()
+}
+
+// storage returns the current files storage.
+func storage() FileStorage {
+	filesMu.RLock()
+	defer filesMu.RUnlock()
+	return files
+}
+
+// RemoveFile deletes the encrypted file from the storage.
+func RemoveFile(name string) error {
+	return storage().Remove(name)
+}
+
+// FileExists returns true if the encrypted file exists in the storage.
+func FileExists(name string) bool {
+	return storage().Exists(name)
+}
+
+// DiskStorage is a file system storage of encrypted files.
+type DiskStorage struct{}
+
+// Create creates a new file with permissions mode.
+func (DiskStorage) Create(name string, mode os.FileMode) (io.WriteCloser, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return nil, err
+	}
+	// umask can restrict permissions of the created file
+	if err = f.Chmod(mode); err != nil {
+		err = closeWithError(f, fmt.Errorf("file permissions: %w", err))
+		if e := os.Remove(name); e != nil {
+			err = fmt.Errorf("%w, remove file: %v", err, e)
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// Open opens the file for reading.
+func (DiskStorage) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+// Remove deletes the file.
+func (DiskStorage) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// Exists returns true if the file exists.
+func (DiskStorage) Exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// MemoryStorage is an in-memory storage of encrypted files, it's useful for tests.
+type MemoryStorage struct {
+	files map[string][]byte
+	m     sync.Mutex
+}
+
+// NewMemoryStorage returns a new empty in-memory files storage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{files: make(map[string][]byte)}
+}
+
+// memoryFile is a writer of a new in-memory file, its content is saved on Close.
+type memoryFile struct {
+	bytes.Buffer
+	name string
+	ms   *MemoryStorage
+}
+
+// Close saves the file content to the storage.
+func (mf *memoryFile) Close() error {
+	mf.ms.m.Lock()
+	defer mf.ms.m.Unlock()
+	if _, ok := mf.ms.files[mf.name]; !ok {
+		return &os.PathError{Op: "close", Path: mf.name, Err: os.ErrNotExist}
+	}
+	mf.ms.files[mf.name] = mf.Bytes()
+	return nil
+}
+
+// Create reserves a new file name, permissions mode is ignored.
+func (ms *MemoryStorage) Create(name string, _ os.FileMode) (io.WriteCloser, error) {
+	ms.m.Lock()
+	defer ms.m.Unlock()
+	if _, ok := ms.files[name]; ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	}
+	ms.files[name] = nil
+	return &memoryFile{name: name, ms: ms}, nil
+}
+
+// Open returns a reader of the file content.
+func (ms *MemoryStorage) Open(name string) (io.ReadCloser, error) {
+	ms.m.Lock()
+	defer ms.m.Unlock()
+	data, ok := ms.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Remove deletes the file.
+func (ms *MemoryStorage) Remove(name string) error {
+	ms.m.Lock()
+	defer ms.m.Unlock()
+	if _, ok := ms.files[name]; !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	delete(ms.files, name)
+	return nil
+}
+
+// Exists returns true if the file exists.
+func (ms *MemoryStorage) Exists(name string) bool {
+	ms.m.Lock()
+	defer ms.m.Unlock()
+	_, ok := ms.files[name]
+	return ok
+}
+
+// Len returns a number of stored files.
+func (ms *MemoryStorage) Len() int {
+	ms.m.Lock()
+	defer ms.m.Unlock()
+	return len(ms.files)
+}
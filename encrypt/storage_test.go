@@ -0,0 +1,44 @@
+package encrypt
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	const (
+		secret    = "secret"
+		plainText = "some text"
+	)
+	ms := NewMemoryStorage()
+	SetUpStorage(ms)
+	defer SetUpStorage(DiskStorage{})
+
+	m, err := File(secret, bytes.NewBufferString(plainText), "memory", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !FileExists(m.Value) || ms.Len() != 1 {
+		t.Fatalf("file %s is not saved", m.Value)
+	}
+	if _, err = ms.Create(m.Value, 0600); !os.IsExist(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	var dst bytes.Buffer
+	if err = DecryptFile(secret, &Msg{Value: m.Value, Salt: m.Salt, Hash: m.Hash}, &dst); err != nil {
+		t.Fatal(err)
+	}
+	if s := dst.String(); s != plainText {
+		t.Errorf("failed decrypted value=%s", s)
+	}
+	if err = RemoveFile(m.Value); err != nil {
+		t.Fatal(err)
+	}
+	if err = RemoveFile(m.Value); !os.IsNotExist(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if FileExists(m.Value) {
+		t.Error("file is not removed")
+	}
+}
@@ -0,0 +1,177 @@
+package stream
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ChunkSize is a size of plaintext chunks which are sealed by AEAD ciphers.
+const ChunkSize = 64 << 10
+
+// ErrAuth is an error when a chunk of AEAD stream is modified, reordered or truncated.
+var ErrAuth = errors.New("stream authentication failed")
+
+// chunkNonce fills the nonce by the chunk number and the last chunk flag.
+// The key is unique for each cipher-text, so other nonce bytes are zero.
+func chunkNonce(nonce []byte, counter uint64, last bool) {
+	n := len(nonce)
+	binary.BigEndian.PutUint64(nonce[n-9:n-1], counter)
+	if last {
+		nonce[n-1] = 1
+	} else {
+		nonce[n-1] = 0
+	}
+}
+
+// sealWriter encrypts data by chunks with ChunkSize bytes,
+// every chunk has its own authentication tag, the last one is marked by its nonce.
+type sealWriter struct {
+	dst     io.Writer
+	aead    cipher.AEAD
+	buf     []byte
+	nonce   []byte
+	counter uint64
+	closed  bool
+}
+
+// NewSealWriter returns a writer which encrypts data by AEAD cipher and writes it to dst.
+// It must be closed to write the last chunk, dst is not closed. The key must be unique for each cipher-text.
+func NewSealWriter(dst io.Writer, aead cipher.AEAD) io.WriteCloser {
+	return &sealWriter{
+		dst:   dst,
+		aead:  aead,
+		buf:   make([]byte, 0, ChunkSize+aead.Overhead()),
+		nonce: make([]byte, aead.NonceSize()),
+	}
+}
+
+// seal encrypts and writes buffered plaintext as a chunk.
+func (sw *sealWriter) seal(last bool) error {
+	chunkNonce(sw.nonce, sw.counter, last)
+	sw.counter++
+	out := sw.aead.Seal(sw.buf[:0], sw.nonce, sw.buf, nil)
+	sw.buf = sw.buf[:0]
+	if _, err := sw.dst.Write(out); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Write encrypts and writes full chunks. A full chunk is kept until next data,
+// because the last one is sealed by Close.
+func (sw *sealWriter) Write(b []byte) (int, error) {
+	if sw.closed {
+		return 0, errors.New("write to closed seal writer")
+	}
+	var written int
+	for len(b) > 0 {
+		if len(sw.buf) == ChunkSize {
+			if err := sw.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(sw.buf[len(sw.buf):ChunkSize], b)
+		sw.buf = sw.buf[:len(sw.buf)+n]
+		b = b[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close encrypts and writes the last chunk, it can be empty.
+func (sw *sealWriter) Close() error {
+	if sw.closed {
+		return nil
+	}
+	sw.closed = true
+	return sw.seal(true)
+}
+
+// openReader decrypts chunks of sealWriter.
+type openReader struct {
+	src     *bufio.Reader
+	aead    cipher.AEAD
+	chunk   []byte
+	plain   []byte
+	nonce   []byte
+	counter uint64
+	done    bool
+}
+
+// NewOpenReader returns a reader which decrypts data from src by AEAD cipher.
+// It returns ErrAuth if the data is modified or truncated.
+func NewOpenReader(src io.Reader, aead cipher.AEAD) io.Reader {
+	return &openReader{
+		src:   bufio.NewReader(src),
+		aead:  aead,
+		chunk: make([]byte, ChunkSize+aead.Overhead()),
+		nonce: make([]byte, aead.NonceSize()),
+	}
+}
+
+// open reads and decrypts next chunk.
+func (r *openReader) open() error {
+	n, err := io.ReadFull(r.src, r.chunk)
+	switch {
+	case err == nil:
+		if _, e := r.src.Peek(1); e == io.EOF {
+			r.done = true
+		}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		r.done = true
+	case errors.Is(err, io.EOF):
+		return ErrAuth // no last chunk
+	default:
+		return err
+	}
+	chunkNonce(r.nonce, r.counter, r.done)
+	r.counter++
+	r.plain, err = r.aead.Open(r.chunk[:0], r.nonce, r.chunk[:n], nil)
+	if err != nil {
+		return ErrAuth
+	}
+	return nil
+}
+
+// Read returns decrypted data of authenticated chunks.
+func (r *openReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// EncryptAEAD encrypts content from src-reader to the dst by AEAD cipher using a copy buffer with bufSize bytes.
+// It returns a number of encrypted plaintext bytes.
+func EncryptAEAD(src io.Reader, dst io.Writer, aead cipher.AEAD, bufSize int) (int64, error) {
+	writer := NewSealWriter(dst, aead)
+	n, err := copyBuffer(writer, src, bufSize)
+	if err != nil {
+		return n, fmt.Errorf("copy for ecryption: %w", err)
+	}
+	if err = writer.Close(); err != nil {
+		return n, fmt.Errorf("last chunk ecryption: %w", err)
+	}
+	return n, nil
+}
+
+// DecryptAEAD decrypts content of src to the dst by AEAD cipher using a copy buffer with bufSize bytes.
+// It returns a number of decrypted plaintext bytes.
+func DecryptAEAD(src io.Reader, dst io.Writer, aead cipher.AEAD, bufSize int) (int64, error) {
+	n, err := copyBuffer(dst, NewOpenReader(src, aead), bufSize)
+	if err != nil {
+		return n, fmt.Errorf("copy for decryption: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,82 @@
+package stream
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+func testAEADs(t *testing.T) map[string]cipher.AEAD {
+	key := buildKey([]byte("abc"))
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chacha, err := chacha20poly1305.New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]cipher.AEAD{"gcm": gcm, "chacha20": chacha}
+}
+
+func TestEncryptAEAD(t *testing.T) {
+	sizes := []int{0, 1, 100, ChunkSize - 1, ChunkSize, ChunkSize + 1, 3 * ChunkSize}
+	for name, aead := range testAEADs(t) {
+		for _, size := range sizes {
+			plaintext := bytes.Repeat([]byte{'a'}, size)
+			var encrypted, decrypted bytes.Buffer
+			n, err := EncryptAEAD(bytes.NewReader(plaintext), &encrypted, aead, 1<<10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			chunks := (size + ChunkSize - 1) / ChunkSize
+			if chunks == 0 {
+				chunks = 1 // empty last chunk
+			}
+			if n != int64(size) || encrypted.Len() != size+chunks*aead.Overhead() {
+				t.Errorf("%s size=%d: failed encrypted size=%d, length=%d", name, size, n, encrypted.Len())
+			}
+			n, err = DecryptAEAD(&encrypted, &decrypted, aead, 0)
+			if err != nil {
+				t.Fatalf("%s size=%d: %v", name, size, err)
+			}
+			if n != int64(size) || !bytes.Equal(decrypted.Bytes(), plaintext) {
+				t.Errorf("%s size=%d: failed decryption", name, size)
+			}
+		}
+	}
+}
+
+func TestDecryptAEAD_Auth(t *testing.T) {
+	plaintext := bytes.Repeat([]byte("secret stream content "), ChunkSize/10)
+	for name, aead := range testAEADs(t) {
+		var encrypted bytes.Buffer
+		if _, err := EncryptAEAD(bytes.NewReader(plaintext), &encrypted, aead, 0); err != nil {
+			t.Fatal(err)
+		}
+		data := encrypted.Bytes()
+		chunk := ChunkSize + aead.Overhead()
+		modified := append([]byte(nil), data...)
+		modified[len(modified)/2] ^= 1
+		cases := map[string][]byte{
+			"modified":  modified,
+			"truncated": data[:2*chunk],
+			"empty":     nil,
+			"reordered": append(append(append([]byte(nil), data[chunk:2*chunk]...), data[:chunk]...), data[2*chunk:]...),
+		}
+		for c, value := range cases {
+			var decrypted bytes.Buffer
+			if _, err := DecryptAEAD(bytes.NewReader(value), &decrypted, aead, 0); !errors.Is(err, ErrAuth) {
+				t.Errorf("%s %s: unexpected error: %v", name, c, err)
+			}
+		}
+	}
+}
@@ -9,36 +9,76 @@ import (
 	"io"
 )
 
-// Encrypt encrypts content from src-reader to the dst by a key.
-func Encrypt(src io.Reader, dst io.Writer, key []byte) error {
+// DefaultBufferSize is a default size of copy buffer for encryption and decryption.
+const DefaultBufferSize = 32 << 10
+
+// onlyReader hides optional io.WriterTo of a reader, so the copy buffer is always used.
+type onlyReader struct {
+	io.Reader
+}
+
+// onlyWriter hides optional io.ReaderFrom of a writer, so the copy buffer is always used.
+type onlyWriter struct {
+	io.Writer
+}
+
+// copyBuffer copies src to dst using a buffer with size bytes, DefaultBufferSize is used if size is not positive.
+func copyBuffer(dst io.Writer, src io.Reader, size int) (int64, error) {
+	if size < 1 {
+		size = DefaultBufferSize
+	}
+	return io.CopyBuffer(onlyWriter{dst}, onlyReader{src}, make([]byte, size))
+}
+
+// NewWriter returns a writer which encrypts data by a key and writes it to dst.
+// The key must be unique for each cipher-text.
+func NewWriter(dst io.Writer, key []byte) (io.Writer, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		return fmt.Errorf("ecrypt cipher: %w", err)
+		return nil, fmt.Errorf("ecrypt cipher: %w", err)
 	}
 	// the key is unique for each cipher-text, then it's ok to use a zero IV.
 	var iv [aes.BlockSize]byte
 	stream := cipher.NewOFB(block, iv[:])
-
-	writer := &cipher.StreamWriter{S: stream, W: dst}
-	if _, err := io.Copy(writer, src); err != nil {
-		return fmt.Errorf("copy for ecryption: %w", err)
-	}
-	return nil
+	return &cipher.StreamWriter{S: stream, W: dst}, nil
 }
 
-// Decrypt decrypts content of src to the dst by a key.
-func Decrypt(src io.Reader, dst io.Writer, key []byte) error {
+// NewReader returns a reader which decrypts data from src by a key.
+func NewReader(src io.Reader, key []byte) (io.Reader, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		return fmt.Errorf("decrypt cipher: %w", err)
+		return nil, fmt.Errorf("decrypt cipher: %w", err)
 	}
 	// if the key is unique for each cipher-text, then it's ok to use a zero IV.
 	var iv [aes.BlockSize]byte
 	stream := cipher.NewOFB(block, iv[:])
+	return &cipher.StreamReader{S: stream, R: src}, nil
+}
+
+// Encrypt encrypts content from src-reader to the dst by a key using a copy buffer with bufSize bytes.
+// It returns a number of encrypted plaintext bytes.
+func Encrypt(src io.Reader, dst io.Writer, key []byte, bufSize int) (int64, error) {
+	writer, err := NewWriter(dst, key)
+	if err != nil {
+		return 0, err
+	}
+	n, err := copyBuffer(writer, src, bufSize)
+	if err != nil {
+		return n, fmt.Errorf("copy for ecryption: %w", err)
+	}
+	return n, nil
+}
 
-	reader := &cipher.StreamReader{S: stream, R: src}
-	if _, err := io.Copy(dst, reader); err != nil {
-		return fmt.Errorf("copy for decryption: %dst", err)
+// Decrypt decrypts content of src to the dst by a key using a copy buffer with bufSize bytes.
+// It returns a number of decrypted plaintext bytes.
+func Decrypt(src io.Reader, dst io.Writer, key []byte, bufSize int) (int64, error) {
+	reader, err := NewReader(src, key)
+	if err != nil {
+		return 0, err
+	}
+	n, err := copyBuffer(dst, reader, bufSize)
+	if err != nil {
+		return n, fmt.Errorf("copy for decryption: %w", err)
 	}
-	return nil
+	return n, nil
 }
@@ -2,6 +2,7 @@ package stream
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"testing"
 )
@@ -31,10 +32,13 @@ func TestEncrypt(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = Encrypt(&src, &dst, key)
+	n, err := Encrypt(&src, &dst, key, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if n != int64(len(secret)) {
+		t.Errorf("failed encrypted size=%d", n)
+	}
 	encrypted, err := dst.ReadString('\n')
 	if err != nil && err != io.EOF {
 		t.Error(err)
@@ -49,10 +53,13 @@ func TestEncrypt(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = Decrypt(&src, &dst, key)
+	n, err = Decrypt(&src, &dst, key, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if n != int64(len(secret)) {
+		t.Errorf("failed decrypted size=%d", n)
+	}
 	decrypted, err := dst.ReadString('\n')
 	if err != nil && err != io.EOF {
 		t.Error(err)
@@ -62,6 +69,24 @@ func TestEncrypt(t *testing.T) {
 	}
 }
 
+func TestEncrypt_BufferSize(t *testing.T) {
+	key := buildKey([]byte("abc"))
+	plaintext := bytes.Repeat([]byte("secret stream content "), 1000)
+	for _, bufSize := range []int{-1, 0, 1, 7, 1 << 10, 1 << 20} {
+		var encrypted, decrypted bytes.Buffer
+		if _, err := Encrypt(bytes.NewReader(plaintext), &encrypted, key, bufSize); err != nil {
+			t.Fatal(err)
+		}
+		n, err := Decrypt(&encrypted, &decrypted, key, bufSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(len(plaintext)) || !bytes.Equal(decrypted.Bytes(), plaintext) {
+			t.Errorf("failed decryption with buffer=%d", bufSize)
+		}
+	}
+}
+
 func BenchmarkEncrypt(b *testing.B) {
 	const secret = "secret stream content"
 	var (
@@ -74,7 +99,7 @@ func BenchmarkEncrypt(b *testing.B) {
 		if err != nil {
 			b.Fatal(err)
 		}
-		err = Encrypt(&src, &dst, key)
+		_, err = Encrypt(&src, &dst, key, 0)
 		if err != nil {
 			b.Fatal(err)
 		}
@@ -86,7 +111,7 @@ func BenchmarkEncrypt(b *testing.B) {
 		if err != nil {
 			b.Fatal(err)
 		}
-		err = Decrypt(&src, &dst, key)
+		_, err = Decrypt(&src, &dst, key, 0)
 		if err != nil {
 			b.Fatal(err)
 		}
@@ -96,3 +121,29 @@ func BenchmarkEncrypt(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkThroughput(b *testing.B) {
+	const size = 16 << 20
+	var (
+		key       = buildKey([]byte("abc"))
+		plaintext = bytes.Repeat([]byte("secret stream content "), size/22)
+	)
+	for _, bufSize := range []int{4 << 10, 16 << 10, 32 << 10, 64 << 10, 128 << 10, 256 << 10, 1 << 20} {
+		b.Run(fmt.Sprintf("encrypt_%dK", bufSize>>10), func(b *testing.B) {
+			b.SetBytes(int64(len(plaintext)))
+			for n := 0; n < b.N; n++ {
+				if _, err := Encrypt(bytes.NewReader(plaintext), io.Discard, key, bufSize); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("decrypt_%dK", bufSize>>10), func(b *testing.B) {
+			b.SetBytes(int64(len(plaintext)))
+			for n := 0; n < b.N; n++ {
+				if _, err := Decrypt(bytes.NewReader(plaintext), io.Discard, key, bufSize); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package encrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/z0rr0/send/encrypt/stream"
+	"github.com/z0rr0/send/encrypt/text"
+)
+
+// cipher suites, the suite of encrypted data is saved in Msg.Suite.
+const (
+	// SuiteLegacy is AES-256 in CFB mode for texts and OFB mode for files without authentication.
+	SuiteLegacy = ""
+	// SuiteAESGCM is AES-256-GCM, texts and file chunks are authenticated.
+	SuiteAESGCM = "aes-256-gcm"
+	// SuiteChaCha20 is ChaCha20-Poly1305, it's faster than AES on CPUs without AES-NI.
+	SuiteChaCha20 = "chacha20-poly1305"
+)
+
+var (
+	// ErrSuite is an error when the cipher suite is unknown.
+	ErrSuite = errors.New("unknown cipher suite")
+	// Suites are all supported cipher suites.
+	Suites = []string{SuiteLegacy, SuiteAESGCM, SuiteChaCha20}
+
+	// suite is the cipher suite of new encrypted data.
+	suite = SuiteLegacy
+)
+
+// IsSuite returns true if name is a supported cipher suite.
+func IsSuite(name string) bool {
+	for _, s := range Suites {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SetUpSuite sets the cipher suite of new data, already encrypted data is decrypted by its own suite.
+func SetUpSuite(name string) error {
+	if !IsSuite(name) {
+		return fmt.Errorf("%w: %s", ErrSuite, name)
+	}
+	mu.Lock()
+	suite = name
+	mu.Unlock()
+	return nil
+}
+
+// CurrentSuite returns the cipher suite of new data.
+func CurrentSuite() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return suite
+}
+
+// newAEAD returns AEAD cipher of the suite by the key.
+func newAEAD(name string, key []byte) (cipher.AEAD, error) {
+	switch name {
+	case SuiteAESGCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("new aes cipher: %w", err)
+		}
+		return cipher.NewGCM(block)
+	case SuiteChaCha20:
+		return chacha20poly1305.New(key)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrSuite, name)
+}
+
+// encryptText encrypts plainText by the key using the suite.
+func encryptText(name string, plainText, key []byte) ([]byte, error) {
+	if name == SuiteLegacy {
+		return text.EncryptRand(plainText, key, random)
+	}
+	aead, err := newAEAD(name, key)
+	if err != nil {
+		return nil, err
+	}
+	return text.Seal(plainText, aead, random)
+}
+
+// decryptText decrypts cipherText by the key using the suite.
+func decryptText(name string, cipherText, key []byte) ([]byte, error) {
+	if name == SuiteLegacy {
+		return text.Decrypt(cipherText, key)
+	}
+	aead, err := newAEAD(name, key)
+	if err != nil {
+		return nil, err
+	}
+	return text.Open(cipherText, aead)
+}
+
+// nopCloser is a writer without closing.
+type nopCloser struct {
+	io.Writer
+}
+
+// Close does nothing.
+func (nopCloser) Close() error {
+	return nil
+}
+
+// newWriter returns a writer which encrypts data by the key using the suite.
+// It must be closed to flush the last authenticated chunk.
+func newWriter(name string, dst io.Writer, key []byte) (io.WriteCloser, error) {
+	if name == SuiteLegacy {
+		w, err := stream.NewWriter(dst, key)
+		if err != nil {
+			return nil, err
+		}
+		return nopCloser{w}, nil
+	}
+	aead, err := newAEAD(name, key)
+	if err != nil {
+		return nil, err
+	}
+	return stream.NewSealWriter(dst, aead), nil
+}
+
+// newReader returns a reader which decrypts data from src by the key using the suite.
+func newReader(name string, src io.Reader, key []byte) (io.Reader, error) {
+	if name == SuiteLegacy {
+		return stream.NewReader(src, key)
+	}
+	aead, err := newAEAD(name, key)
+	if err != nil {
+		return nil, err
+	}
+	return stream.NewOpenReader(src, aead), nil
+}
+
+// encryptStream encrypts content of src to dst by the key using the suite.
+// It returns a number of encrypted plaintext bytes.
+func encryptStream(name string, src io.Reader, dst io.Writer, key []byte) (int64, error) {
+	if name == SuiteLegacy {
+		return stream.Encrypt(src, dst, key, fileBuffer())
+	}
+	aead, err := newAEAD(name, key)
+	if err != nil {
+		return 0, err
+	}
+	return stream.EncryptAEAD(src, dst, aead, fileBuffer())
+}
+
+// decryptStream decrypts content of src to dst by the key using the suite.
+func decryptStream(name string, src io.Reader, dst io.Writer, key []byte) (int64, error) {
+	if name == SuiteLegacy {
+		return stream.Decrypt(src, dst, key, fileBuffer())
+	}
+	aead, err := newAEAD(name, key)
+	if err != nil {
+		return 0, err
+	}
+	return stream.DecryptAEAD(src, dst, aead, fileBuffer())
+}
@@ -0,0 +1,75 @@
+package encrypt
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSuites(t *testing.T) {
+	const secret = "secret"
+	plainText := strings.Repeat("some text ", 10000)
+	base := t.TempDir()
+	defer func() {
+		SetUpMaster(nil)
+		if err := SetUpSuite(SuiteLegacy); err != nil {
+			t.Error(err)
+		}
+	}()
+	for i, name := range Suites {
+		for _, master := range []string{"", "master key"} {
+			SetUpMaster([]byte(master))
+			if err := SetUpSuite(name); err != nil {
+				t.Fatal(err)
+			}
+			mt, err := Text(secret, plainText)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mf, err := File(secret, strings.NewReader(plainText), base, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mt.Suite != name || mf.Suite != name {
+				t.Errorf("suite=%q: failed saved suites %q and %q", name, mt.Suite, mf.Suite)
+			}
+			// new data is encrypted by another suite, old one is decrypted by its own
+			if err = SetUpSuite(Suites[(i+1)%len(Suites)]); err != nil {
+				t.Fatal(err)
+			}
+			decrypted, err := DecryptText(secret, &Msg{Value: mt.Value, Salt: mt.Salt, Hash: mt.Hash, Master: mt.Master, Suite: name})
+			if err != nil {
+				t.Fatalf("suite=%q: %v", name, err)
+			}
+			if decrypted != plainText {
+				t.Errorf("suite=%q: failed decrypted text", name)
+			}
+			var dst bytes.Buffer
+			err = DecryptFile(secret, &Msg{Value: mf.Value, Salt: mf.Salt, Hash: mf.Hash, Master: mf.Master, Suite: name}, &dst)
+			if err != nil {
+				t.Fatalf("suite=%q: %v", name, err)
+			}
+			if dst.String() != plainText {
+				t.Errorf("suite=%q: failed decrypted file", name)
+			}
+		}
+	}
+}
+
+func TestSetUpSuite(t *testing.T) {
+	if err := SetUpSuite("aes-128-cbc"); !errors.Is(err, ErrSuite) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if s := CurrentSuite(); s != SuiteLegacy {
+		t.Errorf("suite is changed to %q", s)
+	}
+	m, err := Text("secret", "some text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Suite = "unknown"
+	if _, err = DecryptText("secret", m); !errors.Is(err, ErrSuite) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -16,6 +16,11 @@ var ErrEmpty = errors.New("empty text")
 
 // Encrypt encrypts text using AES cipher by a key.
 func Encrypt(plainText []byte, key []byte) ([]byte, error) {
+	return EncryptRand(plainText, key, rand.Reader)
+}
+
+// EncryptRand encrypts text using AES cipher by a key, IV is read from random.
+func EncryptRand(plainText []byte, key []byte, random io.Reader) ([]byte, error) {
 	if len(plainText) == 0 {
 		return nil, ErrEmpty
 	}
@@ -25,7 +30,7 @@ func Encrypt(plainText []byte, key []byte) ([]byte, error) {
 	}
 	cipherText := make([]byte, aes.BlockSize+len(plainText))
 	iv := cipherText[:aes.BlockSize]
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+	if _, err := io.ReadFull(random, iv); err != nil {
 		return nil, fmt.Errorf("iv random generation: %w", err)
 	}
 	stream := cipher.NewCFBEncrypter(block, iv)
@@ -51,3 +56,32 @@ func Decrypt(cipherText []byte, key []byte) ([]byte, error) {
 	stream.XORKeyStream(cipherText, cipherText)
 	return cipherText, nil
 }
+
+// Seal encrypts text by AEAD cipher, a random nonce is read from random and prepended to the result.
+func Seal(plainText []byte, aead cipher.AEAD, random io.Reader) ([]byte, error) {
+	if len(plainText) == 0 {
+		return nil, ErrEmpty
+	}
+	nonceSize := aead.NonceSize()
+	nonce := make([]byte, nonceSize, nonceSize+len(plainText)+aead.Overhead())
+	if _, err := io.ReadFull(random, nonce); err != nil {
+		return nil, fmt.Errorf("nonce random generation: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plainText, nil), nil
+}
+
+// Open returns decrypted and authenticated value of Seal result.
+func Open(cipherText []byte, aead cipher.AEAD) ([]byte, error) {
+	if len(cipherText) == 0 {
+		return nil, ErrEmpty
+	}
+	nonceSize := aead.NonceSize()
+	if len(cipherText) < nonceSize+aead.Overhead() {
+		return nil, errors.New("invalid decryption cipher text length")
+	}
+	plainText, err := aead.Open(nil, cipherText[:nonceSize], cipherText[nonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("authenticated decryption: %w", err)
+	}
+	return plainText, nil
+}
@@ -3,7 +3,11 @@ package text
 import (
 	"bytes"
 	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
 	"testing"
+
+	"golang.org/x/crypto/chacha20poly1305"
 )
 
 func buildKey(k []byte) []byte {
@@ -89,3 +93,45 @@ func BenchmarkEncrypt(b *testing.B) {
 		}
 	}
 }
+
+func TestSeal(t *testing.T) {
+	block, err := aes.NewCipher(buildKey([]byte("abc")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chacha, err := chacha20poly1305.New(buildKey([]byte("abc")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, aead := range []cipher.AEAD{gcm, chacha} {
+		plainText := []byte("some secret text")
+		e, err := Seal(plainText, aead, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := len(e); n != len(plainText)+aead.NonceSize()+aead.Overhead() {
+			t.Errorf("case=%d: unexpected length=%d", i, n)
+		}
+		d, err := Open(e, aead)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(d, plainText) {
+			t.Errorf("case=%d: failed compare decrypt", i)
+		}
+		e[len(e)-1] ^= 1
+		if _, err = Open(e, aead); err == nil {
+			t.Errorf("case=%d: modified text is decrypted", i)
+		}
+		if _, err = Open(e[:aead.NonceSize()], aead); err == nil {
+			t.Errorf("case=%d: short text is decrypted", i)
+		}
+		if _, err = Seal(nil, aead, rand.Reader); err != ErrEmpty {
+			t.Errorf("case=%d: unexpected error: %v", i, err)
+		}
+	}
+}
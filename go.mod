@@ -7,4 +7,5 @@ require (
 	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/pelletier/go-toml v1.8.1
 	golang.org/x/crypto v0.0.0-20210220033148-5ea612d1eb83
+	golang.org/x/sys v0.7.0 // indirect
 )
@@ -3,15 +3,25 @@ package handle
 import (
 	"context"
 	"database/sql"
+	_ "embed" // OpenAPI specification
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"time"
+
+	"github.com/google/uuid"
 
 	"github.com/z0rr0/send/db"
 	"github.com/z0rr0/send/encrypt"
 )
 
+// openAPI is OpenAPI specification of the service API.
+//
+//go:embed openapi.json
+var openAPI []byte
+
 // Version is application details info.
 type Version struct {
 	Version     string `json:"version"`
@@ -29,8 +39,16 @@ func (v *Version) String() string {
 
 // versionHandler is API handler for app info.
 func versionHandler(_ context.Context, w http.ResponseWriter, p *Params) (int, error) {
-	encoder := json.NewEncoder(w)
-	err := encoder.Encode(p.Version)
+	err := writeJSON(w, p.Request, p.Version)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// openAPIHandler returns OpenAPI specification.
+func openAPIHandler(_ context.Context, w http.ResponseWriter, _ *Params) (int, error) {
+	_, err := w.Write(openAPI)
 	if err != nil {
 		return http.StatusInternalServerError, err
 	}
@@ -46,34 +64,22 @@ type TextMeta struct {
 // textAPIHandler is API handler to return item's text and file meta info.
 func textAPIHandler(ctx context.Context, w http.ResponseWriter, p *Params) (int, error) {
 	var fileMeta *FileMeta
-	encoder := json.NewEncoder(w)
+	noStore(w)
 	password, key, e := validatePassKey(p)
 	if e != nil {
-		w.WriteHeader(e.Code)
-		if err := encoder.Encode(e); err != nil {
-			return http.StatusInternalServerError, err
-		}
-		return e.Code, nil
+		return downloadErrHandler(w, p, e)
 	}
-	item, err := db.Read(ctx, p.DB, key, password, nil, db.FlagText|db.FlagMeta)
+	item, err := readItem(ctx, w, p, key, password, db.FlagText|db.FlagMeta)
 	if err != nil {
 		switch {
+		case errors.Is(err, db.ErrForbidden):
+			return downloadErrHandler(w, p, &ErrItem{Err: "forbidden", Code: http.StatusForbidden})
 		case errors.Is(err, db.ErrNoAttempts):
 			fallthrough
 		case errors.Is(err, sql.ErrNoRows):
-			w.WriteHeader(http.StatusNotFound)
-			err = encoder.Encode(&ErrItem{Err: "not found"})
-			if err != nil {
-				return http.StatusInternalServerError, err
-			}
-			return http.StatusNotFound, nil
+			return notFoundHandler(ctx, w, p, key, &ErrItem{Err: "not found", Code: http.StatusNotFound})
 		case errors.Is(err, encrypt.ErrSecret):
-			w.WriteHeader(http.StatusBadRequest)
-			err = encoder.Encode(&ErrItem{Err: "failed password or key"})
-			if err != nil {
-				return http.StatusInternalServerError, err
-			}
-			return http.StatusBadRequest, nil
+			return downloadErrHandler(w, p, &ErrItem{Err: "failed password or key", Code: http.StatusBadRequest})
 		}
 		p.Log.Error("read item key=%v error: %v", key, err)
 		return http.StatusInternalServerError, err
@@ -86,7 +92,218 @@ func textAPIHandler(ctx context.Context, w http.ResponseWriter, p *Params) (int,
 			return http.StatusInternalServerError, err
 		}
 	}
-	err = encoder.Encode(&TextMeta{Text: item.Text, File: fileMeta})
+	if item.TextPath != "" {
+		return writeTextStream(w, p, item, password, fileMeta)
+	}
+	err = writeJSON(w, p.Request, &TextMeta{Text: item.Text, File: fileMeta})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// jsonStringWriter writes data escaped as a content of JSON string.
+// UTF-8 sequences are written as is, so they can be split between writes.
+type jsonStringWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+// Write escapes quotes, backslashes and control characters of b and writes the result.
+func (jw *jsonStringWriter) Write(b []byte) (int, error) {
+	const hex = "0123456789abcdef"
+	jw.buf = jw.buf[:0]
+	for _, c := range b {
+		switch {
+		case c == '"' || c == '\\':
+			jw.buf = append(jw.buf, '\\', c)
+		case c == '\n':
+			jw.buf = append(jw.buf, '\\', 'n')
+		case c == '\r':
+			jw.buf = append(jw.buf, '\\', 'r')
+		case c == '\t':
+			jw.buf = append(jw.buf, '\\', 't')
+		case c < 0x20:
+			jw.buf = append(jw.buf, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xF])
+		default:
+			jw.buf = append(jw.buf, c)
+		}
+	}
+	if _, err := jw.w.Write(jw.buf); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// writeTextStream writes TextMeta JSON with the big text, which is decrypted from its file
+// directly to the response, so the text is not loaded to memory.
+func writeTextStream(w http.ResponseWriter, p *Params, item *db.Item, password string, fileMeta *FileMeta) (int, error) {
+	tail := []byte("\"}\n")
+	if fileMeta != nil {
+		b, err := json.Marshal(fileMeta)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		tail = append(append([]byte("\",\"file\":"), b...), "}\n"...)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := io.WriteString(w, "{\"text\":\""); err != nil {
+		p.Log.Info("text key=%v response is interrupted: %v", item.Key, err)
+		return http.StatusOK, nil
+	}
+	if err := item.DecryptTextFile(password, &jsonStringWriter{w: w}); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("text key=%v streaming: %w", item.Key, err)
+	}
+	if _, err := w.Write(tail); err != nil {
+		p.Log.Info("text key=%v response is interrupted: %v", item.Key, err)
+	}
+	return http.StatusOK, nil
+}
+
+// VerifyResult is data struct of API response for password verification request.
+type VerifyResult struct {
+	Valid bool `json:"valid"`
+}
+
+// verifyAPIHandler is API handler to check item's password without data reading and counters decrement.
+func verifyAPIHandler(ctx context.Context, w http.ResponseWriter, p *Params) (int, error) {
+	password, key, e := validatePassKey(p)
+	if e != nil {
+		return downloadErrHandler(w, p, e)
+	}
+	valid, err := db.VerifyPassword(ctx, p.DB, key, password, p.clientIP())
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrForbidden):
+			return downloadErrHandler(w, p, &ErrItem{Err: "forbidden", Code: http.StatusForbidden})
+		case errors.Is(err, sql.ErrNoRows):
+			return notFoundHandler(ctx, w, p, key, &ErrItem{Err: "not found", Code: http.StatusNotFound})
+		}
+		p.Log.Error("verify item key=%v error: %v", key, err)
+		return http.StatusInternalServerError, err
+	}
+	err = writeJSON(w, p.Request, &VerifyResult{Valid: valid})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// ExtendResult is data struct of API response with item's limits after extension or password rotation.
+type ExtendResult struct {
+	Expired time.Time `json:"expired"`
+	Text    int       `json:"text"`
+	File    int       `json:"file"`
+}
+
+// validateDelta checks that optional field is in the range [0; max].
+func validateDelta(name, value string, max int) (int, error) {
+	if value == "" || value == "0" {
+		return 0, nil
+	}
+	return validateInt(name, value, max)
+}
+
+// extendAPIHandler is API handler to increase expiration time and available counters of an active item.
+// Request fields ttl and times are deltas, they and new item's limits are bounded by the settings.
+func extendAPIHandler(ctx context.Context, w http.ResponseWriter, p *Params) (int, error) {
+	password, key, e := validatePassKey(p)
+	if e != nil {
+		return downloadErrHandler(w, p, e)
+	}
+	ttl, err := validateDelta("ttl", p.Request.PostFormValue("ttl"), p.Settings.TTL)
+	if err != nil {
+		return downloadErrHandler(w, p, &ErrItem{Err: err.Error(), Code: http.StatusBadRequest})
+	}
+	times, err := validateDelta("times", p.Request.PostFormValue("times"), p.Settings.Times)
+	if err != nil {
+		return downloadErrHandler(w, p, &ErrItem{Err: err.Error(), Code: http.StatusBadRequest})
+	}
+	if ttl == 0 && times == 0 {
+		return downloadErrHandler(w, p, &ErrItem{Err: "empty ttl and times", Code: http.StatusBadRequest})
+	}
+	item, err := db.Extend(
+		ctx, p.DB, key, password, p.clientIP(),
+		time.Duration(ttl)*time.Second, times,
+		time.Duration(p.Settings.TTL)*time.Second, p.Settings.TextTimes(), p.Settings.FileTimes(),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrForbidden):
+			return downloadErrHandler(w, p, &ErrItem{Err: "forbidden", Code: http.StatusForbidden})
+		case errors.Is(err, sql.ErrNoRows):
+			return notFoundHandler(ctx, w, p, key, &ErrItem{Err: "not found", Code: http.StatusNotFound})
+		case errors.Is(err, encrypt.ErrSecret):
+			return downloadErrHandler(w, p, &ErrItem{Err: "failed password or key", Code: http.StatusBadRequest})
+		case errors.Is(err, db.ErrExtend):
+			return downloadErrHandler(w, p, &ErrItem{Err: "limits exceed allowed values", Code: http.StatusBadRequest})
+		}
+		p.Log.Error("extend item key=%v error: %v", key, err)
+		return http.StatusInternalServerError, err
+	}
+	result := &ExtendResult{Expired: item.Expired, Text: item.CountText, File: item.CountFile}
+	err = writeJSON(w, p.Request, result)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// confirmAPIHandler is API handler to activate a pending item by its confirmation token.
+func confirmAPIHandler(ctx context.Context, w http.ResponseWriter, p *Params) (int, error) {
+	if p.Request.Method != "POST" {
+		return downloadErrHandler(w, p, &ErrItem{Err: "failed HTTP method", Code: http.StatusMethodNotAllowed})
+	}
+	key, err := parseKey(p.Request.PostFormValue("key"), p.Settings.NormalizeKeys)
+	if err != nil {
+		return downloadErrHandler(w, p, &ErrItem{Err: "bad key", Code: http.StatusBadRequest})
+	}
+	token := p.Request.PostFormValue("token")
+	if token == "" {
+		return downloadErrHandler(w, p, &ErrItem{Err: "empty token", Code: http.StatusBadRequest})
+	}
+	item, err := db.Confirm(ctx, p.DB, key, token)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return downloadErrHandler(w, p, &ErrItem{Err: "not found", Code: http.StatusNotFound})
+		}
+		p.Log.Error("confirm item key=%v error: %v", key, err)
+		return http.StatusInternalServerError, err
+	}
+	result := &ExtendResult{Expired: item.Expired, Text: item.CountText, File: item.CountFile}
+	err = writeJSON(w, p.Request, result)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// rotateAPIHandler is API handler to re-encrypt an active item by a new password.
+// Counters and expiration time of the item are kept.
+func rotateAPIHandler(ctx context.Context, w http.ResponseWriter, p *Params) (int, error) {
+	password, key, e := validatePassKey(p)
+	if e != nil {
+		return downloadErrHandler(w, p, e)
+	}
+	newPassword := p.Request.PostFormValue("new_password")
+	if newPassword == "" {
+		return downloadErrHandler(w, p, &ErrItem{Err: "empty new password", Code: http.StatusBadRequest})
+	}
+	item, err := db.Rotate(ctx, p.DB, key, password, newPassword, p.clientIP())
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrForbidden):
+			return downloadErrHandler(w, p, &ErrItem{Err: "forbidden", Code: http.StatusForbidden})
+		case errors.Is(err, sql.ErrNoRows):
+			return notFoundHandler(ctx, w, p, key, &ErrItem{Err: "not found", Code: http.StatusNotFound})
+		case errors.Is(err, encrypt.ErrSecret):
+			return downloadErrHandler(w, p, &ErrItem{Err: "failed password or key", Code: http.StatusBadRequest})
+		}
+		p.Log.Error("rotate item key=%v error: %v", key, err)
+		return http.StatusInternalServerError, err
+	}
+	result := &ExtendResult{Expired: item.Expired, Text: item.CountText, File: item.CountFile}
+	err = writeJSON(w, p.Request, result)
 	if err != nil {
 		return http.StatusInternalServerError, err
 	}
@@ -103,10 +320,69 @@ func uploadAPIHandler(ctx context.Context, w http.ResponseWriter, p *Params) (in
 		// already handled
 		return data.code, nil
 	}
-	encoder := json.NewEncoder(w)
-	err = encoder.Encode(data)
+	err = writeJSON(w, p.Request, data)
 	if err != nil {
 		return http.StatusInternalServerError, err
 	}
 	return data.code, nil
 }
+
+// maxStatusKeys is max number of keys in one status request.
+const maxStatusKeys = 100
+
+// ItemStatus is data struct of API response for status request.
+type ItemStatus struct {
+	Key      string `json:"key"`
+	Exists   bool   `json:"exists"`
+	Text     int    `json:"text"`
+	File     int    `json:"file"`
+	Category string `json:"category,omitempty"` // coarse file category if public file info is stored
+}
+
+// statusAPIHandler is API handler to return existence and available counters of items by their keys.
+// It doesn't decrement counters. Request body is a JSON array of keys.
+func statusAPIHandler(ctx context.Context, w http.ResponseWriter, p *Params) (int, error) {
+	var keys []string
+	if p.Request.Method != "POST" {
+		return downloadErrHandler(w, p, &ErrItem{Err: "failed HTTP method", Code: http.StatusMethodNotAllowed})
+	}
+	body := http.MaxBytesReader(w, p.Request.Body, maxStatusKeys*64)
+	if err := json.NewDecoder(body).Decode(&keys); err != nil {
+		return downloadErrHandler(w, p, &ErrItem{Err: "failed JSON array of keys", Code: http.StatusBadRequest})
+	}
+	n := len(keys)
+	if n == 0 || n > maxStatusKeys {
+		e := &ErrItem{Err: fmt.Sprintf("number of keys should be in [1; %d]", maxStatusKeys), Code: http.StatusBadRequest}
+		return downloadErrHandler(w, p, e)
+	}
+	for _, key := range keys {
+		if _, err := uuid.Parse(key); err != nil {
+			return downloadErrHandler(w, p, &ErrItem{Err: "bad key", Code: http.StatusBadRequest})
+		}
+	}
+	items, err := db.Statuses(ctx, p.DB, keys)
+	if err != nil {
+		p.Log.Error("read items statuses: %v", err)
+		return http.StatusInternalServerError, err
+	}
+	result := make([]*ItemStatus, n)
+	for i, key := range keys {
+		status := &ItemStatus{Key: key}
+		if item, ok := items[key]; ok {
+			status.Exists, status.Text, status.File = true, item.CountText, item.CountFile
+			if item.CountFile > 0 && item.FileInfo != "" {
+				if fi, e := DecodeInfo(item.FileInfo); e != nil {
+					p.Log.Error("file info decode item key=%v error: %v", key, e)
+				} else {
+					status.Category = fi.Category()
+				}
+			}
+		}
+		result[i] = status
+	}
+	err = writeJSON(w, p.Request, result)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
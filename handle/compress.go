@@ -0,0 +1,122 @@
+package handle
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// compressedTypes are media types of compressed responses.
+var compressedTypes = []string{"text/", "application/json", "application/javascript", "application/xml", "image/svg+xml"}
+
+// AcceptsGzip returns true if the client accepts gzip content encoding.
+func AcceptsGzip(r *http.Request) bool {
+	for _, value := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		parts := strings.Split(value, ";")
+		if strings.TrimSpace(parts[0]) != "gzip" {
+			continue
+		}
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+				return err == nil && q > 0
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// GzipWriter is a http.ResponseWriter wrapper that compresses the response body by gzip.
+// Compression is chosen by the content type before the header sending, so file downloads
+// with Content-Disposition header and already encoded responses are sent as is without buffering.
+type GzipWriter struct {
+	http.ResponseWriter
+	gz   *gzip.Writer
+	code int
+	sent bool
+}
+
+// NewGzipWriter returns a new wrapper of w, it must be closed after the response writing.
+func NewGzipWriter(w http.ResponseWriter) *GzipWriter {
+	return &GzipWriter{ResponseWriter: w}
+}
+
+// compressible returns true if the response body should be compressed.
+func (gw *GzipWriter) compressible() bool {
+	h := gw.Header()
+	if gw.code != http.StatusOK && gw.code < http.StatusBadRequest {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Disposition") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	contentType := h.Get("Content-Type")
+	for _, prefix := range compressedTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// send sends the response header, it starts compression if the response is compressible.
+func (gw *GzipWriter) send() {
+	gw.sent = true
+	if gw.compressible() {
+		h := gw.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		gw.gz = gzip.NewWriter(gw.ResponseWriter)
+	}
+	gw.ResponseWriter.WriteHeader(gw.code)
+}
+
+// WriteHeader saves the status code. The header is sent on the first write
+// if the content type is not set yet, so it can be detected by the body.
+func (gw *GzipWriter) WriteHeader(code int) {
+	if gw.code != 0 {
+		return
+	}
+	gw.code = code
+	if gw.Header().Get("Content-Type") != "" {
+		gw.send()
+	}
+}
+
+// Write writes compressed or original data to the response.
+func (gw *GzipWriter) Write(b []byte) (int, error) {
+	if gw.code == 0 {
+		gw.code = http.StatusOK
+	}
+	if !gw.sent {
+		if gw.Header().Get("Content-Type") == "" {
+			gw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		gw.send()
+	}
+	if gw.gz != nil {
+		return gw.gz.Write(b)
+	}
+	return gw.ResponseWriter.Write(b)
+}
+
+// Close sends not sent header and flushes compressed data.
+func (gw *GzipWriter) Close() error {
+	if gw.code != 0 && !gw.sent {
+		// response without body
+		gw.sent = true
+		gw.ResponseWriter.WriteHeader(gw.code)
+	}
+	if gw.gz == nil {
+		return nil
+	}
+	return gw.gz.Close()
+}
+
+// Unwrap returns the original response writer.
+func (gw *GzipWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
@@ -0,0 +1,97 @@
+package handle
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	cases := []struct {
+		header   string
+		expected bool
+	}{
+		{header: "", expected: false},
+		{header: "gzip", expected: true},
+		{header: "deflate, gzip;q=0.5", expected: true},
+		{header: "gzip;q=0", expected: false},
+		{header: "br, deflate", expected: false},
+	}
+	for i, c := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", c.header)
+		if v := AcceptsGzip(r); v != c.expected {
+			t.Errorf("case=%d: failed result=%v", i, v)
+		}
+	}
+}
+
+func TestGzipWriter(t *testing.T) {
+	body := strings.Repeat("some text ", 100)
+	cases := []struct {
+		headers    map[string]string
+		code       int
+		compressed bool
+	}{
+		{headers: map[string]string{"Content-Type": "application/json"}, code: http.StatusOK, compressed: true},
+		{headers: map[string]string{}, code: http.StatusNotFound, compressed: true},
+		{headers: map[string]string{"Content-Type": "application/octet-stream"}, code: http.StatusOK},
+		{
+			headers: map[string]string{"Content-Type": "text/plain", "Content-Disposition": "attachment"},
+			code:    http.StatusOK,
+		},
+		{headers: map[string]string{"Content-Type": "text/css", "Content-Range": "bytes 0-9/100"}, code: http.StatusPartialContent},
+	}
+	for i, c := range cases {
+		w := httptest.NewRecorder()
+		gw := NewGzipWriter(w)
+		for name, value := range c.headers {
+			gw.Header().Set(name, value)
+		}
+		gw.WriteHeader(c.code)
+		if _, err := io.WriteString(gw, body); err != nil {
+			t.Fatal(err)
+		}
+		if err := gw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != c.code {
+			t.Errorf("case=%d: failed code=%d", i, w.Code)
+		}
+		encoding := w.Header().Get("Content-Encoding")
+		if c.compressed != (encoding == "gzip") {
+			t.Errorf("case=%d: failed encoding=%s", i, encoding)
+			continue
+		}
+		var r io.Reader = w.Body
+		if c.compressed {
+			gr, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r = gr
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != body {
+			t.Errorf("case=%d: failed body", i)
+		}
+	}
+}
+
+func TestGzipWriter_NoBody(t *testing.T) {
+	w := httptest.NewRecorder()
+	gw := NewGzipWriter(w)
+	gw.WriteHeader(http.StatusNoContent)
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 || w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("failed response: code=%d, body=%q", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,84 @@
+package handle
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// errDigest is an error when the request body doesn't match its digest header.
+var errDigest = errors.New("request body digest mismatch")
+
+// bodyDigest is a request body wrapper which calculates a hash of read data
+// to compare it with the value from Digest or Content-MD5 header.
+type bodyDigest struct {
+	io.ReadCloser
+	h        hash.Hash
+	expected []byte
+}
+
+// digestValue returns base64 decoded value of the algorithm from Digest header, e.g. "sha-256=X48E9q...".
+func digestValue(header, algorithm string) (string, bool) {
+	for _, item := range strings.Split(header, ",") {
+		i := strings.Index(item, "=")
+		if i < 0 {
+			continue
+		}
+		if strings.EqualFold(strings.TrimSpace(item[:i]), algorithm) {
+			return strings.TrimSpace(item[i+1:]), true
+		}
+	}
+	return "", false
+}
+
+// newBodyDigest returns a wrapper of the request body if it has a supported digest header,
+// SHA-256 from Digest header is preferred. It returns nil if there is no digest.
+func newBodyDigest(r *http.Request) (*bodyDigest, error) {
+	var h hash.Hash
+	digest := r.Header.Get("Digest")
+	value, ok := digestValue(digest, "sha-256")
+	switch {
+	case ok:
+		h = sha256.New()
+	case digest != "":
+		if value, ok = digestValue(digest, "md5"); !ok {
+			return nil, errors.New("unsupported digest algorithm, sha-256 is expected")
+		}
+		h = md5.New()
+	default:
+		if value = r.Header.Get("Content-MD5"); value == "" {
+			return nil, nil
+		}
+		h = md5.New()
+	}
+	expected, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(expected) != h.Size() {
+		return nil, errors.New("incorrect digest value")
+	}
+	return &bodyDigest{ReadCloser: r.Body, h: h, expected: expected}, nil
+}
+
+// Read reads data from the body and adds it to the hash.
+func (d *bodyDigest) Read(b []byte) (int, error) {
+	n, err := d.ReadCloser.Read(b)
+	d.h.Write(b[:n])
+	return n, err
+}
+
+// verify reads the rest of the body and compares its hash with the expected value.
+func (d *bodyDigest) verify() error {
+	if _, err := io.Copy(io.Discard, d); err != nil {
+		return fmt.Errorf("read request body: %w", err)
+	}
+	if subtle.ConstantTimeCompare(d.h.Sum(nil), d.expected) != 1 {
+		return errDigest
+	}
+	return nil
+}
@@ -4,11 +4,9 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"fmt"
 	"net/http"
 	"strings"
-
-	"github.com/google/uuid"
+	"time"
 
 	"github.com/z0rr0/send/cfg"
 	"github.com/z0rr0/send/db"
@@ -17,34 +15,55 @@ import (
 // DownloadData id item's data for download page.
 type DownloadData struct {
 	Key       string
+	Hint      string
 	CountText bool
 	CountFile bool
+	File      *FileInfo // public file data, it is nil if it's not stored
+	Confirm   bool      // reading form is shown only after a click on the page
+	Expires   string    // signed URL expiration time, it's passed to reading requests
+	Signature string    // signed URL signature
+	ExpiresAt time.Time // item expiration time
 }
 
 // downloadHandler generates the download page.
+// It only checks that the item exists and never decrements its counters,
+// so GET requests of link previews and scanners don't consume attempts.
 func downloadHandler(ctx context.Context, w http.ResponseWriter, p *Params) (int, error) {
-	key := strings.Trim(p.Request.URL.Path, "/ ")
-	_, err := uuid.Parse(key)
+	noIndex(w)
+	noStore(w)
+	key, err := parseKey(strings.Trim(p.Request.URL.Path, "/ "), p.Settings.NormalizeKeys)
 	if err != nil {
+		p.Log.Info("malformed key in path %q: %v", p.Request.URL.Path, err)
 		return downloadErrHandler(w, p, nil)
 	}
 	item, err := db.Exists(ctx, p.DB, key)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			p.Log.Info("check item exists %s: %v", key, err)
-			return downloadErrHandler(w, p, nil)
+			return notFoundHandler(ctx, w, p, key, &ErrItem{Err: "Not found", Code: http.StatusNotFound})
 		}
 		p.Log.Error("check item exists %s: %v", key, err)
 		return downloadErrHandler(w, p, &ErrItem{Err: "Internal error", Code: 500})
 	}
 	data := &DownloadData{
 		Key:       key,
+		Hint:      item.Hint,
 		CountText: item.CountText > 0,
 		CountFile: item.CountFile > 0,
+		Confirm:   p.Settings.RequireConfirm,
+		ExpiresAt: item.Expired,
+	}
+	if p.Settings.SignedURLs {
+		data.Expires, data.Signature = p.Request.FormValue("expires"), p.Request.FormValue("signature")
+	}
+	if data.CountFile && item.FileInfo != "" {
+		data.File, err = DecodeInfo(item.FileInfo)
+		if err != nil {
+			p.Log.Error("file info decode item key=%v error: %v", key, err)
+		}
 	}
-	err = p.Settings.Tpl[cfg.DownloadTpl].ExecuteTemplate(w, cfg.DownloadTpl, data)
+	err = executeTemplate(w, p, cfg.DownloadTpl, data)
 	if err != nil {
-		return http.StatusInternalServerError, fmt.Errorf("failed execute template=%s: %w", cfg.DownloadTpl, err)
+		return http.StatusInternalServerError, err
 	}
 	return http.StatusOK, nil
 }
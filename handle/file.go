@@ -2,22 +2,159 @@ package handle
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"path"
 	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/z0rr0/send/db"
 	"github.com/z0rr0/send/encrypt"
 )
 
+// content dispositions of file response
+const (
+	attachmentDisposition = "attachment"
+	inlineDisposition     = "inline"
+)
+
+// inlineTypes are safe content types which can be shown by browser inline.
+// Types like HTML or SVG are not allowed to prevent XSS.
+var inlineTypes = map[string]bool{
+	"application/pdf": true,
+	"audio/mpeg":      true,
+	"audio/ogg":       true,
+	"image/gif":       true,
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/webp":      true,
+	"text/plain":      true,
+	"video/mp4":       true,
+	"video/webm":      true,
+}
+
+// coarse file categories, they don't reveal exact content types
+const (
+	CategoryImage    = "image"
+	CategoryDocument = "document"
+	CategoryArchive  = "archive"
+	CategoryOther    = "other"
+)
+
+// categoryTypes are not image content types of documents and archives.
+var categoryTypes = map[string]string{
+	"application/pdf":                                 CategoryDocument,
+	"application/msword":                              CategoryDocument,
+	"application/rtf":                                 CategoryDocument,
+	"application/vnd.ms-excel":                        CategoryDocument,
+	"application/vnd.ms-powerpoint":                   CategoryDocument,
+	"application/vnd.oasis.opendocument.presentation": CategoryDocument,
+	"application/vnd.oasis.opendocument.spreadsheet":  CategoryDocument,
+	"application/vnd.oasis.opendocument.text":         CategoryDocument,
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": CategoryDocument,
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         CategoryDocument,
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   CategoryDocument,
+	"text/plain":                   CategoryDocument,
+	"text/csv":                     CategoryDocument,
+	"text/markdown":                CategoryDocument,
+	"application/zip":              CategoryArchive,
+	"application/gzip":             CategoryArchive,
+	"application/x-gzip":           CategoryArchive,
+	"application/x-tar":            CategoryArchive,
+	"application/x-bzip2":          CategoryArchive,
+	"application/x-xz":             CategoryArchive,
+	"application/x-7z-compressed":  CategoryArchive,
+	"application/x-rar-compressed": CategoryArchive,
+	"application/vnd.rar":          CategoryArchive,
+	"application/zstd":             CategoryArchive,
+}
+
 // FileMeta is base file data.
 type FileMeta struct {
 	Name        string `json:"name"`
 	Size        int64  `json:"size"`
 	ContentType string `json:"content_type"`
+	Disposition string `json:"disposition,omitempty"`
+	Checksum    string `json:"checksum,omitempty"` // hex SHA-256 of the plaintext
+}
+
+// FileInfo is public file data, it's stored without encryption.
+// Size is zero if the sender hid it, then it's only in encrypted file metadata.
+type FileInfo struct {
+	Size        int64  `json:"size,omitempty"`
+	ContentType string `json:"content_type"`
+}
+
+// Info returns public file data without its name.
+func (f *FileMeta) Info() *FileInfo {
+	return &FileInfo{Size: f.Size, ContentType: f.ResponseContentType()}
+}
+
+// Encode converts public file data to a json string.
+func (fi *FileInfo) Encode() (string, error) {
+	b, err := json.Marshal(fi)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// HumanSize returns the file size with units.
+func (fi *FileInfo) HumanSize() string {
+	const unit = 1024
+	if fi.Size < unit {
+		return fmt.Sprintf("%d B", fi.Size)
+	}
+	div, exp := int64(unit), 0
+	for n := fi.Size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(fi.Size)/float64(div), "KMGTPE"[exp])
+}
+
+// Category returns a coarse category of the file by its content type.
+func (fi *FileInfo) Category() string {
+	mediaType, _, err := mime.ParseMediaType(fi.ContentType)
+	if err != nil {
+		return CategoryOther
+	}
+	if strings.HasPrefix(mediaType, "image/") {
+		return CategoryImage
+	}
+	if category, ok := categoryTypes[mediaType]; ok {
+		return category
+	}
+	return CategoryOther
+}
+
+// DecodeInfo returns a parsed from json string public file data.
+func DecodeInfo(fileInfo string) (*FileInfo, error) {
+	fi := &FileInfo{}
+	err := json.Unmarshal([]byte(fileInfo), fi)
+	if err != nil {
+		return nil, err
+	}
+	return fi, nil
+}
+
+// IsInlineAllowed returns true if the file can be shown inline.
+func (f *FileMeta) IsInlineAllowed() bool {
+	mediaType, _, err := mime.ParseMediaType(f.ResponseContentType())
+	if err != nil {
+		return false
+	}
+	return inlineTypes[mediaType]
 }
 
 // Encode converts file metadata to a json string.
@@ -38,15 +175,93 @@ func (f *FileMeta) ResponseContentType() string {
 }
 
 // ResponseContentDisposition returns HTTP content-disposition.
+// Inline value is used only if it was requested and the content type is safe.
 func (f *FileMeta) ResponseContentDisposition() string {
-	return fmt.Sprintf("attachment; filename=\"%s\"", f.Name)
+	disposition := attachmentDisposition
+	if f.Disposition == inlineDisposition && f.IsInlineAllowed() {
+		disposition = inlineDisposition
+	}
+	value := mime.FormatMediaType(disposition, map[string]string{"filename": f.Name})
+	if value == "" {
+		// the name can't be encoded
+		return disposition
+	}
+	return value
+}
+
+// ResponseDigest returns HTTP digest header value with SHA-256 of the file.
+// It is empty if the checksum is unknown, for example for old items.
+func (f *FileMeta) ResponseDigest() string {
+	b, err := hex.DecodeString(f.Checksum)
+	if err != nil || len(b) == 0 {
+		return ""
+	}
+	return "sha-256=" + base64.StdEncoding.EncodeToString(b)
 }
 
 // ResponseContentLength returns HTTP content-length.
+// It is empty if the size is unknown, then chunked transfer encoding is used.
 func (f *FileMeta) ResponseContentLength() string {
+	if f.Size < 1 {
+		return ""
+	}
 	return strconv.FormatInt(f.Size, 10)
 }
 
+// countWriter is a writer wrapper that counts written bytes and saves a write error.
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+// Write writes p to the wrapped writer and counts written bytes.
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	if err != nil {
+		cw.err = err
+	}
+	return n, err
+}
+
+// checksumPrefix is a prefix of the expected file checksum, only SHA-256 is supported.
+const checksumPrefix = "sha256:"
+
+// parseChecksum returns lower case hex value of the expected SHA-256 file checksum
+// from "sha256:hex" value, it's empty if value is not set.
+func parseChecksum(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(strings.ToLower(value), checksumPrefix) {
+		return "", errors.New("unsupported checksum algorithm, only sha256 is allowed")
+	}
+	checksum := strings.ToLower(value[len(checksumPrefix):])
+	if b, err := hex.DecodeString(checksum); err != nil || len(b) != sha256.Size {
+		return "", errors.New("incorrect sha256 checksum")
+	}
+	return checksum, nil
+}
+
+// cleanFileName returns a base name of the file without directory components.
+// Both slash and backslash separators are handled, empty, too long names and names
+// with control characters are rejected.
+func cleanFileName(name string, maxLength int) (string, error) {
+	name = path.Base(strings.ReplaceAll(strings.TrimSpace(name), "\\", "/"))
+	switch name {
+	case ".", "..", "/":
+		return "", errors.New("empty file name")
+	}
+	if strings.IndexFunc(name, unicode.IsControl) >= 0 || !utf8.ValidString(name) {
+		return "", errors.New("incorrect file name")
+	}
+	if n := utf8.RuneCountInString(name); n > maxLength {
+		return "", fmt.Errorf("too long file name, max length is %d", maxLength)
+	}
+	return name, nil
+}
+
 // DecodeMeta returns a parsed from json string file metadata.
 func DecodeMeta(fileMeta string) (*FileMeta, error) {
 	f := &FileMeta{}
@@ -59,6 +274,7 @@ func DecodeMeta(fileMeta string) (*FileMeta, error) {
 
 // fileHandler is handler to return content of the file.
 func fileHandler(ctx context.Context, w http.ResponseWriter, p *Params) (int, error) {
+	noStore(w)
 	ajax := p.Request.PostFormValue("ajax") == "true"
 	password, key, e := validatePassKey(p)
 	if e != nil {
@@ -67,15 +283,19 @@ func fileHandler(ctx context.Context, w http.ResponseWriter, p *Params) (int, er
 		return downloadErrHandler(w, p, e)
 	}
 	// read/decrement fileMeta+file, but decrypt only fileMeta data due to dst=nil
-	item, err := db.Read(ctx, p.DB, key, password, nil, db.FlagMeta|db.FlagFile)
+	const flags = db.FlagMeta | db.FlagFile
+	item, err := readFile(ctx, w, p, key, password, flags)
 	if err != nil {
 		e = &ErrItem{Err: "internal error", Code: http.StatusInternalServerError, ajax: ajax}
 		switch {
+		case errors.Is(err, db.ErrForbidden):
+			e.Code, e.Err = http.StatusForbidden, "forbidden"
+			return downloadErrHandler(w, p, e)
 		case errors.Is(err, db.ErrNoAttempts):
 			fallthrough
 		case errors.Is(err, sql.ErrNoRows):
 			e.Code, e.Err = http.StatusNotFound, "not found"
-			return downloadErrHandler(w, p, e)
+			return notFoundHandler(ctx, w, p, key, e)
 		case errors.Is(err, encrypt.ErrSecret):
 			e.Code, e.Err, e.Key = http.StatusBadRequest, "failed secret", key
 			return downloadErrHandler(w, p, e)
@@ -84,7 +304,7 @@ func fileHandler(ctx context.Context, w http.ResponseWriter, p *Params) (int, er
 		return downloadErrHandler(w, p, e)
 	}
 	defer item.CheckCounts(p.DelItem)
-	// password is already valid and item was decremented for file and fileMeta
+	// password is already valid and item was decremented or reserved for file and fileMeta
 	if item.FileMeta == "" {
 		return downloadErrHandler(w, p, &ErrItem{Err: "no content", Code: http.StatusNoContent, ajax: ajax})
 	}
@@ -95,10 +315,40 @@ func fileHandler(ctx context.Context, w http.ResponseWriter, p *Params) (int, er
 	}
 	w.Header().Set("Content-Type", fileMeta.ResponseContentType())
 	w.Header().Set("Content-Disposition", fileMeta.ResponseContentDisposition())
-	w.Header().Set("Content-Length", fileMeta.ResponseContentLength())
-	err = item.Decrypt(password, w, db.FlagFile, nil)
+	if contentLength := fileMeta.ResponseContentLength(); contentLength != "" {
+		w.Header().Set("Content-Length", contentLength)
+	}
+	if digest := fileMeta.ResponseDigest(); digest != "" {
+		w.Header().Set("Digest", digest)
+	}
+	cw := &countWriter{w: w}
+	err = item.Decrypt(password, cw, db.FlagFile, nil)
 	if err != nil {
-		return http.StatusInternalServerError, err
+		return streamError(w, p, cw, key, ajax, err)
+	}
+	if fileMeta.Size > 0 && cw.n != fileMeta.Size {
+		p.Log.Error("file key=%v size mismatch: written=%d, expected=%d", key, cw.n, fileMeta.Size)
+	}
+	finishFile(ctx, p, item, key, flags)
+	return http.StatusOK, nil
+}
+
+// streamError handles a failed file streaming.
+// If nothing is sent yet, the client gets a usual error response.
+// Otherwise, the status and part of the body are already sent,
+// so the error is only logged, and the response is interrupted.
+func streamError(w http.ResponseWriter, p *Params, cw *countWriter, key string, ajax bool, err error) (int, error) {
+	switch {
+	case cw.err != nil:
+		p.Log.Info("file key=%v download is interrupted by client after %d bytes: %v", key, cw.n, err)
+	case cw.n == 0:
+		p.Log.Error("file key=%v decryption failed: %v", key, err)
+		w.Header().Del("Content-Disposition")
+		w.Header().Del("Content-Length")
+		w.Header().Del("Digest")
+		return downloadErrHandler(w, p, &ErrItem{Err: "internal error", Code: http.StatusInternalServerError, ajax: ajax})
+	default:
+		p.Log.Error("file key=%v streaming failed after %d bytes: %v", key, cw.n, err)
 	}
 	return http.StatusOK, nil
 }
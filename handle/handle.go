@@ -5,15 +5,21 @@ package handle
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 
 	"github.com/z0rr0/send/cfg"
 	"github.com/z0rr0/send/db"
 	"github.com/z0rr0/send/logging"
+	"github.com/z0rr0/send/notify"
 )
 
 type handlerType func(context.Context, http.ResponseWriter, *Params) (int, error)
@@ -21,14 +27,137 @@ type handlerType func(context.Context, http.ResponseWriter, *Params) (int, error
 // Params is a struct with common handling arguments.
 // Except Request field, it is read-only struct.
 type Params struct {
-	Log      *logging.Log
-	DB       *sql.DB
-	Settings *cfg.Settings
-	Request  *http.Request
-	Version  *Version
-	DelItem  chan<- db.Item
-	Storage  *cfg.Storage
-	Secure   bool
+	Log        *logging.Log
+	DB         *sql.DB
+	Settings   *cfg.Settings
+	Request    *http.Request
+	Version    *Version
+	DelItem    chan<- db.Item
+	Storage    *cfg.Storage
+	Secure     bool
+	ClientAuth bool
+	Updates    *UpdateChecker
+	Stats      *StatsCache
+	Mailer     *notify.Mailer
+}
+
+// remoteIP returns IP address of the client without port.
+func (p *Params) remoteIP() string {
+	host, _, err := net.SplitHostPort(p.Request.RemoteAddr)
+	if err != nil {
+		return p.Request.RemoteAddr
+	}
+	return host
+}
+
+// clientIP returns IP address of the client. Forwarded headers are trusted only if the request
+// came from a local reverse proxy, the last X-Forwarded-For address is added by this proxy.
+func (p *Params) clientIP() string {
+	if !p.isLocalProxy() {
+		return p.remoteIP()
+	}
+	forwarded := strings.Split(p.Request.Header.Get("X-Forwarded-For"), ",")
+	if ip := net.ParseIP(strings.TrimSpace(forwarded[len(forwarded)-1])); ip != nil {
+		return ip.String()
+	}
+	if ip := net.ParseIP(strings.TrimSpace(p.Request.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return p.remoteIP()
+}
+
+// isAuthorized returns false if a verified client certificate is required but not provided.
+func (p *Params) isAuthorized() bool {
+	if !p.ClientAuth || !p.IsAPI() {
+		return true
+	}
+	return p.Request.TLS != nil && len(p.Request.TLS.VerifiedChains) > 0
+}
+
+// isLocalProxy returns true if the request came from a reverse proxy on the same host,
+// by loopback address or unix socket.
+func (p *Params) isLocalProxy() bool {
+	if p.Request.RemoteAddr == "" || p.Request.RemoteAddr == "@" {
+		return true // unix socket
+	}
+	ip := net.ParseIP(p.remoteIP())
+	return ip != nil && ip.IsLoopback()
+}
+
+// isHTTPS returns true if the request arrived over TLS directly or by a trusted local proxy.
+func (p *Params) isHTTPS() bool {
+	if p.Request.TLS != nil {
+		return true
+	}
+	return p.isLocalProxy() && strings.EqualFold(p.Request.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// isSigned returns true if the request has valid not expired signature of the item key.
+// Requests with incorrect keys are not checked, they are rejected by handlers.
+func (p *Params) isSigned(now time.Time) bool {
+	key := strings.Trim(p.Request.URL.Path, "/ ")
+	if signedPaths[p.Request.URL.Path] {
+		key = p.Request.FormValue("key")
+	}
+	key, err := parseKey(key, p.Settings.NormalizeKeys)
+	if err != nil {
+		return true
+	}
+	return p.Settings.VerifySignature(key, p.Request.FormValue("expires"), p.Request.FormValue("signature"), now)
+}
+
+// StatusWriter is a http.ResponseWriter wrapper that saves response status code
+// and counts written body bytes.
+type StatusWriter struct {
+	http.ResponseWriter
+	code int
+	n    int64
+}
+
+// NewStatusWriter returns a new wrapper of w.
+func NewStatusWriter(w http.ResponseWriter) *StatusWriter {
+	return &StatusWriter{ResponseWriter: w}
+}
+
+// WriteHeader sends HTTP response header with the status code and saves it.
+func (sw *StatusWriter) WriteHeader(code int) {
+	if sw.code == 0 {
+		sw.code = code
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+// Write writes data to the response and counts written bytes.
+func (sw *StatusWriter) Write(b []byte) (int, error) {
+	if sw.code == 0 {
+		sw.code = http.StatusOK
+	}
+	n, err := sw.ResponseWriter.Write(b)
+	sw.n += int64(n)
+	return n, err
+}
+
+// Unwrap returns the original response writer.
+func (sw *StatusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// Written returns true if the response header is already sent.
+func (sw *StatusWriter) Written() bool {
+	return sw.code != 0
+}
+
+// Status returns sent HTTP status code, it is 200 if nothing is sent yet.
+func (sw *StatusWriter) Status() int {
+	if sw.code == 0 {
+		return http.StatusOK
+	}
+	return sw.code
+}
+
+// Size returns number of written body bytes.
+func (sw *StatusWriter) Size() int64 {
+	return sw.n
 }
 
 // IsAPI returns true if params are for API requests.
@@ -36,10 +165,62 @@ func (p *Params) IsAPI() bool {
 	return strings.HasPrefix(p.Request.URL.Path, "/api")
 }
 
+// IsJSON returns true if JSON response is expected, it's API request, API-only mode or JSON is accepted.
+func (p *Params) IsJSON() bool {
+	return p.IsAPI() || p.Settings.APIOnly || strings.Contains(p.Request.Header.Get("Accept"), "application/json")
+}
+
+// writeJSON writes v as JSON response, it's indented if the request has query parameter pretty=1.
+// Content type header is set only if the response status is not sent yet.
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	if pretty := r.URL.Query().Get("pretty"); pretty == "1" || pretty == "true" {
+		encoder.SetIndent("", "  ")
+	}
+	return encoder.Encode(v)
+}
+
 // IndexData is index page data.
 type IndexData struct {
-	MaxSize int
-	Error   string
+	MaxSize          int
+	MaxTTL           int
+	MaxTimes         int // text reads
+	MaxFileTimes     int
+	PasswordRequired bool
+	Notify           bool
+	Confirm          bool
+	PublicFileInfo   bool
+	ContentTypes     []string
+	Error            string
+}
+
+// newIndexData returns index page data with limits from the settings.
+func newIndexData(s *cfg.Settings) *IndexData {
+	return &IndexData{
+		MaxSize:          s.Size,
+		MaxTTL:           s.TTL,
+		MaxTimes:         s.TextTimes(),
+		MaxFileTimes:     s.FileTimes(),
+		PasswordRequired: s.RequirePassword,
+		Notify:           s.IsNotifyEnabled(),
+		Confirm:          s.ConfirmWindow > 0,
+		PublicFileInfo:   s.PublicFileInfo,
+		ContentTypes:     s.ContentTypes,
+	}
+}
+
+// Accept returns allowed content types as a value of file input accept attribute.
+func (iData *IndexData) Accept() string {
+	return strings.Join(iData.ContentTypes, ",")
+}
+
+// MaxInputTimes returns max value of times input, the real limit depends on uploaded data.
+func (iData *IndexData) MaxInputTimes() int {
+	if iData.MaxFileTimes > iData.MaxTimes {
+		return iData.MaxFileTimes
+	}
+	return iData.MaxTimes
 }
 
 // HasError returns true if there is an error message.
@@ -65,54 +246,232 @@ func (e *ErrItem) HasKey() bool {
 	return e.Key != ""
 }
 
+// bearerPrefix is a scheme prefix of Authorization header with the item password.
+const bearerPrefix = "Bearer "
+
+// requestCredentials returns the item key and password from the form fields.
+// API clients can send them by basic authentication or send the password by
+// Authorization header with Bearer scheme, form fields have priority.
+func requestCredentials(r *http.Request) (string, string) {
+	key, password := r.PostFormValue("key"), r.PostFormValue("password")
+	user, basicPassword, ok := r.BasicAuth()
+	if ok {
+		if key == "" {
+			key = user
+		}
+		if password == "" {
+			password = basicPassword
+		}
+	}
+	header := r.Header.Get("Authorization")
+	if password == "" && len(header) > len(bearerPrefix) && strings.EqualFold(header[:len(bearerPrefix)], bearerPrefix) {
+		password = strings.TrimSpace(header[len(bearerPrefix):])
+	}
+	return key, password
+}
+
 func validatePassKey(p *Params) (string, string, *ErrItem) {
 	if p.Request.Method != "POST" {
 		return "", "", &ErrItem{Err: "failed HTTP method", Code: http.StatusMethodNotAllowed}
 	}
-	password := p.Request.PostFormValue("password")
+	key, password := requestCredentials(p.Request)
 	if password == "" {
 		return "", "", &ErrItem{Err: "empty password", Code: http.StatusBadRequest}
 	}
-	key := p.Request.PostFormValue("key")
 	if key == "" {
 		return "", "", &ErrItem{Err: "empty key", Code: http.StatusBadRequest}
 	}
-	if _, err := uuid.Parse(key); err != nil {
+	key, err := parseKey(key, p.Settings.NormalizeKeys)
+	if err != nil {
 		return "", "", &ErrItem{Err: "bad key", Code: http.StatusBadRequest}
 	}
 	return password, key, nil
 }
 
+// parseKey checks that key is UUID. If normalize is true, the key is returned in canonical
+// lower case form, so imperfectly copied keys with other case or UUID format are found too.
+func parseKey(key string, normalize bool) (string, error) {
+	u, err := uuid.Parse(key)
+	if err != nil {
+		return "", err
+	}
+	if normalize {
+		return u.String(), nil
+	}
+	return key, nil
+}
+
+// cookie name prefixes with tokens to read consumed items again and to resume file downloads
+const (
+	undoCookiePrefix   = "undo_"
+	resumeCookiePrefix = "resume_"
+)
+
+// readItem reads the item by its key and decrements its counters.
+// A consumed item is read again during its undo window if the client has a cookie
+// with the token, this cookie is set by the read which consumed the item.
+// If lazy_reencrypt setting is enabled, the legacy item is re-encrypted by the current cipher suite.
+func readItem(ctx context.Context, w http.ResponseWriter, p *Params, key, password string, flags db.DecryptFlag) (*db.Item, error) {
+	if c, err := p.Request.Cookie(undoCookiePrefix + key); err == nil {
+		item, err := db.Reread(ctx, p.DB, key, c.Value, password, p.clientIP(), nil, flags)
+		if !errors.Is(err, sql.ErrNoRows) {
+			return item, err
+		}
+	}
+	if p.Settings.LazyReencrypt {
+		flags |= db.FlagUpgrade
+	}
+	item, err := db.Read(ctx, p.DB, key, password, p.clientIP(), nil, flags, p.Settings.UndoPeriod())
+	if err != nil {
+		return nil, err
+	}
+	if item.UpgradeErr != nil {
+		p.Log.Error("re-encrypt item key=%v: %v", key, item.UpgradeErr)
+	}
+	setTokenCookie(w, p, undoCookiePrefix+key, item.Token, item.RereadUntil)
+	return item, nil
+}
+
+// readFile reads the item for a file download. If the resume window is enabled, the file attempt
+// is only reserved, and the client gets a cookie with the token to resume an interrupted download
+// without a new attempt. The attempt is used by finishFile after the successful download.
+func readFile(ctx context.Context, w http.ResponseWriter, p *Params, key, password string, flags db.DecryptFlag) (*db.Item, error) {
+	window := p.Settings.ResumePeriod()
+	if window == 0 {
+		return readItem(ctx, w, p, key, password, flags)
+	}
+	if c, err := p.Request.Cookie(resumeCookiePrefix + key); err == nil {
+		item, err := db.Resume(ctx, p.DB, key, c.Value, password, p.clientIP(), flags)
+		if !errors.Is(err, sql.ErrNoRows) {
+			return item, err
+		}
+	}
+	if c, err := p.Request.Cookie(undoCookiePrefix + key); err == nil {
+		item, err := db.Reread(ctx, p.DB, key, c.Value, password, p.clientIP(), nil, flags)
+		if !errors.Is(err, sql.ErrNoRows) {
+			return item, err
+		}
+	}
+	item, err := db.Begin(ctx, p.DB, key, password, p.clientIP(), flags, window, p.Settings.UndoPeriod())
+	if err != nil {
+		return nil, err
+	}
+	setTokenCookie(w, p, resumeCookiePrefix+key, item.ResumeToken, item.ResumeUntil)
+	setTokenCookie(w, p, undoCookiePrefix+key, item.Token, item.RereadUntil)
+	return item, nil
+}
+
+// finishFile uses the reserved file attempt after the successful download.
+func finishFile(ctx context.Context, p *Params, item *db.Item, key string, flags db.DecryptFlag) {
+	if item.ResumeToken == "" {
+		return
+	}
+	if err := db.Finish(ctx, p.DB, item, flags); err != nil {
+		p.Log.Error("finish file key=%v download: %v", key, err)
+	}
+}
+
+// setTokenCookie sets a cookie with the token until its expiration, nothing is done for empty token.
+func setTokenCookie(w http.ResponseWriter, p *Params, name, token string, until time.Time) {
+	if token == "" {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    token,
+		Path:     "/",
+		Expires:  until,
+		Secure:   p.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// notFoundHandler logs a state of the not available item and returns error e.
+// The response is the same for absent, expired and consumed items to avoid keys enumeration,
+// expired or consumed items are reported only if reveal_expiry setting is enabled.
+func notFoundHandler(ctx context.Context, w http.ResponseWriter, p *Params, key string, e *ErrItem) (int, error) {
+	state, err := db.Lookup(ctx, p.DB, key)
+	if err != nil {
+		p.Log.Error("lookup item key=%v: %v", key, err)
+		return downloadErrHandler(w, p, e)
+	}
+	p.Log.Info("item key=%v is not available, state=%v", key, state)
+	if p.Settings.RevealExpiry && (state == db.StateExpired || state == db.StateConsumed) {
+		e.Err, e.Code = "this link has expired", http.StatusGone
+	}
+	return downloadErrHandler(w, p, e)
+}
+
 // downloadErrHandler is a handler method to return some error page/message.
+// Error is returned as a plain text for ajax requests, JSON for API requests, and HTML page otherwise.
 func downloadErrHandler(w http.ResponseWriter, p *Params, ei *ErrItem) (int, error) {
 	var err error
 	if ei == nil {
 		ei = &ErrItem{Err: "Not found", Code: 404}
 	}
-	w.WriteHeader(ei.Code)
-	if ei.ajax {
+	noIndex(w)
+	noStore(w)
+	if !ei.ajax && !p.IsJSON() {
+		if _, err = p.Settings.Template(cfg.ErrorTpl); err != nil {
+			// error page is not available, plain text is used
+			p.Log.Error("error page: %v", err)
+			ei.ajax = true
+		}
+	}
+	switch {
+	case ei.ajax:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(ei.Code)
 		_, err = fmt.Fprint(w, ei.Err)
 		if err != nil {
 			return http.StatusInternalServerError, err
 		}
 		return ei.Code, nil
+	case p.IsJSON():
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(ei.Code)
+		err = writeJSON(w, p.Request, ei)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		return ei.Code, nil
 	}
-	err = p.Settings.Tpl[cfg.ErrorTpl].ExecuteTemplate(w, cfg.ErrorTpl, ei)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(ei.Code)
+	err = executeTemplate(w, p, cfg.ErrorTpl, ei)
 	if err != nil {
-		return http.StatusInternalServerError, fmt.Errorf("failed execute template=%s: %w", cfg.ErrorTpl, err)
+		return http.StatusInternalServerError, err
 	}
 	return ei.Code, nil
 }
 
+// namespaceHeader is a request header with items namespace, it should be set by a reverse proxy.
+const namespaceHeader = "X-Send-Namespace"
+
 // Main is a common HTTP handler.
 func Main(ctx context.Context, w http.ResponseWriter, p *Params) int {
 	var handlers = map[string]handlerType{
-		"/":            indexHandler,
-		"/upload":      uploadHandler,
-		"/file":        fileHandler,
-		"/api/version": versionHandler,
-		"/api/text":    textAPIHandler,
-		"/api/upload":  uploadAPIHandler,
+		"/":                                 indexHandler,
+		"/upload":                           uploadHandler,
+		"/file":                             fileHandler,
+		"/api/version":                      versionHandler,
+		"/api/version/latest":               latestVersionHandler,
+		"/api/text":                         textAPIHandler,
+		"/api/upload":                       uploadAPIHandler,
+		"/api/status":                       statusAPIHandler,
+		"/api/verify":                       verifyAPIHandler,
+		"/api/extend":                       extendAPIHandler,
+		"/api/rotate":                       rotateAPIHandler,
+		"/api/confirm":                      confirmAPIHandler,
+		"/api/stats":                        statsAPIHandler,
+		"/api/openapi.json":                 openAPIHandler,
+		"/robots.txt":                       robotsHandler,
+		"/favicon.ico":                      faviconHandler,
+		"/manifest.json":                    manifestHandler,
+		"/apple-touch-icon.png":             noContentHandler,
+		"/apple-touch-icon-precomposed.png": noContentHandler,
+		"/metrics":                          metricsHandler,
 		// "/UUID":     downloadHandler,
 	}
 	handler, ok := handlers[p.Request.URL.Path]
@@ -120,20 +479,211 @@ func Main(ctx context.Context, w http.ResponseWriter, p *Params) int {
 		// download by UUID, 32 hex: 8-4-4-4-12
 		handler = downloadHandler
 	}
+	if p.Settings.APIOnly {
+		handler = apiOnlyHandler(p.Request.URL.Path, handler, ok)
+	}
+	if p.Settings.APIRequireHTTPS && httpsPaths[p.Request.URL.Path] && !p.isHTTPS() {
+		handler = insecureHandler
+	}
+	if p.Settings.SignedURLs && (signedPaths[p.Request.URL.Path] || !ok) && !p.isSigned(time.Now()) {
+		handler = invalidSignatureHandler
+	}
+	if !p.isAuthorized() {
+		handler = forbiddenHandler
+	}
+	if ns := p.Request.Header.Get(namespaceHeader); p.Settings.IsNamespace(ns) {
+		ctx = db.WithNamespace(ctx, ns)
+	} else {
+		handler = unknownNamespaceHandler
+	}
 	code, err := handler(ctx, w, p)
 	if err != nil {
 		p.Log.Error("error: %v", err)
+		if errors.Is(err, cfg.ErrNoTemplate) {
+			// templates are checked before the response writing
+			ei := &ErrItem{Err: "template not configured", Code: http.StatusInternalServerError}
+			if _, e := downloadErrHandler(w, p, ei); e != nil {
+				p.Log.Error("error response: %v", e)
+			}
+		}
 		return http.StatusInternalServerError
 	}
 	return code
 }
 
-// indexHandler is a title web page.
-func indexHandler(_ context.Context, w http.ResponseWriter, p *Params) (int, error) {
-	data := &IndexData{MaxSize: p.Settings.Size}
-	err := p.Settings.Tpl[cfg.IndexTpl].ExecuteTemplate(w, cfg.IndexTpl, data)
+// executeTemplate writes the page with name and data to w.
+// It returns cfg.ErrNoTemplate if the template is not configured.
+func executeTemplate(w io.Writer, p *Params, name string, data interface{}) error {
+	tpl, err := p.Settings.Template(name)
 	if err != nil {
-		return 0, fmt.Errorf("failed execute template=%s: %w", cfg.IndexTpl, err)
+		return err
+	}
+	if err = tpl.ExecuteTemplate(w, name, data); err != nil {
+		return fmt.Errorf("failed execute template=%s: %w", name, err)
+	}
+	return nil
+}
+
+// httpsPaths are paths of API requests with secret data, they can require HTTPS.
+var httpsPaths = map[string]bool{
+	"/api/upload": true, "/api/text": true, "/api/verify": true, "/api/extend": true, "/api/rotate": true,
+	"/api/confirm": true,
+}
+
+// signedPaths are paths of items reading requests, they require signatures if signed URLs are enabled.
+// The download page is checked too.
+var signedPaths = map[string]bool{"/file": true, "/api/text": true}
+
+// webPaths are paths of html pages, they are disabled in API-only mode.
+var webPaths = map[string]bool{"/": true, "/upload": true}
+
+// apiOnlyHandler replaces web pages handlers in API-only mode,
+// the root page returns API info, and others are not found.
+func apiOnlyHandler(path string, handler handlerType, known bool) handlerType {
+	switch {
+	case path == "/":
+		return apiRootHandler
+	case !known || webPaths[path]:
+		return notFoundPageHandler
+	}
+	return handler
+}
+
+// apiEndpoints are paths of API methods for the root response.
+var apiEndpoints = []string{
+	"/api/upload", "/api/text", "/file", "/api/status", "/api/verify", "/api/extend", "/api/rotate", "/api/confirm",
+	"/api/version", "/api/version/latest", "/api/stats",
+}
+
+// APILimits are upload limits of the service.
+type APILimits struct {
+	MaxSize          int      `json:"max_size"` // megabytes
+	MaxTTL           int      `json:"max_ttl"`  // seconds
+	MaxTimes         int      `json:"max_times"`
+	MaxFileTimes     int      `json:"max_file_times"`
+	PasswordRequired bool     `json:"password_required"`
+	ContentTypes     []string `json:"content_types,omitempty"`
+}
+
+// APIRoot is data struct of the root response in API-only mode or for JSON clients.
+type APIRoot struct {
+	Version   string     `json:"version"`
+	OpenAPI   string     `json:"openapi"`
+	Endpoints []string   `json:"endpoints"`
+	Limits    *APILimits `json:"limits"`
+}
+
+// apiRootHandler returns API info with links to its methods and upload limits.
+func apiRootHandler(_ context.Context, w http.ResponseWriter, p *Params) (int, error) {
+	data := newIndexData(p.Settings)
+	root := &APIRoot{
+		OpenAPI:   "/api/openapi.json",
+		Endpoints: apiEndpoints,
+		Limits: &APILimits{
+			MaxSize:          data.MaxSize,
+			MaxTTL:           data.MaxTTL,
+			MaxTimes:         data.MaxTimes,
+			MaxFileTimes:     data.MaxFileTimes,
+			PasswordRequired: data.PasswordRequired,
+			ContentTypes:     data.ContentTypes,
+		},
+	}
+	if p.Version != nil {
+		root.Version = p.Version.Version
+	}
+	if err := writeJSON(w, p.Request, root); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// notFoundPageHandler returns not found error for disabled pages.
+func notFoundPageHandler(_ context.Context, w http.ResponseWriter, p *Params) (int, error) {
+	return downloadErrHandler(w, p, nil)
+}
+
+// forbiddenHandler returns an error for requests without required client certificate.
+func forbiddenHandler(_ context.Context, w http.ResponseWriter, p *Params) (int, error) {
+	return downloadErrHandler(w, p, &ErrItem{Err: "client certificate is required", Code: http.StatusForbidden})
+}
+
+// insecureHandler returns an error for API requests that didn't arrive over HTTPS.
+func insecureHandler(_ context.Context, w http.ResponseWriter, p *Params) (int, error) {
+	return downloadErrHandler(w, p, &ErrItem{Err: "HTTPS is required", Code: http.StatusBadRequest})
+}
+
+// invalidSignatureHandler returns an error for requests with invalid or expired URL signature.
+func invalidSignatureHandler(_ context.Context, w http.ResponseWriter, p *Params) (int, error) {
+	return downloadErrHandler(w, p, &ErrItem{Err: "invalid or expired signature", Code: http.StatusForbidden})
+}
+
+// unknownNamespaceHandler returns an error for requests with not allowed items namespace.
+func unknownNamespaceHandler(_ context.Context, w http.ResponseWriter, p *Params) (int, error) {
+	return downloadErrHandler(w, p, &ErrItem{Err: "unknown namespace", Code: http.StatusBadRequest})
+}
+
+// robotsHandler returns robots.txt content.
+func robotsHandler(_ context.Context, w http.ResponseWriter, p *Params) (int, error) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if _, err := io.WriteString(w, p.Settings.RobotsTxt()); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// assetMaxAge is a cache period of browser assets in seconds.
+const assetMaxAge = 86400
+
+// serveAsset sends the file with a browser asset, the response is empty if the file is not set.
+func serveAsset(w http.ResponseWriter, p *Params, name string) (int, error) {
+	if name == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return http.StatusNoContent, nil
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", assetMaxAge))
+	http.ServeFile(w, p.Request, name)
+	return http.StatusOK, nil
+}
+
+// faviconHandler returns the configured favicon.
+func faviconHandler(_ context.Context, w http.ResponseWriter, p *Params) (int, error) {
+	return serveAsset(w, p, p.Settings.Favicon)
+}
+
+// manifestHandler returns the configured web app manifest.
+func manifestHandler(_ context.Context, w http.ResponseWriter, p *Params) (int, error) {
+	return serveAsset(w, p, p.Settings.Manifest)
+}
+
+// noContentHandler returns an empty response for not configured browser assets,
+// so their requests don't fall to the download page.
+func noContentHandler(_ context.Context, w http.ResponseWriter, _ *Params) (int, error) {
+	w.WriteHeader(http.StatusNoContent)
+	return http.StatusNoContent, nil
+}
+
+// noIndex disables indexing of the page with a shared link by search engines.
+func noIndex(w http.ResponseWriter) {
+	w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+}
+
+// noStore disables caching of the response by browsers and intermediaries,
+// it's used for decrypted content and pages of secret items.
+func noStore(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "no-store, no-cache")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Expires", "0")
+}
+
+// indexHandler is a title web page, API info is returned if JSON is accepted.
+func indexHandler(ctx context.Context, w http.ResponseWriter, p *Params) (int, error) {
+	w.Header().Add("Vary", "Accept")
+	if p.IsJSON() {
+		return apiRootHandler(ctx, w, p)
+	}
+	data := newIndexData(p.Settings)
+	if err := executeTemplate(w, p, cfg.IndexTpl, data); err != nil {
+		return 0, err
 	}
 	return http.StatusOK, nil
 }
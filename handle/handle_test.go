@@ -1,10 +1,780 @@
 package handle
 
-//import (
-//	"testing"
-//	"net/http/httptest"
-//)
-//
-//func TestMain_Index(t *testing.T) {
-//	httptest.NewRequest()
-//}
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/z0rr0/send/cfg"
+	"github.com/z0rr0/send/logging"
+)
+
+// testSettings returns settings with templates from html directory.
+func testSettings(t *testing.T) *cfg.Settings {
+	te := &cfg.TemplateEntry{Dir: ".", Fs: os.DirFS("../html")}
+	tpl, err := cfg.ParseTemplates(te)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &cfg.Settings{TTL: 3600, Times: 10, Size: 1, PassLen: 10, MultipartMemory: 1, TextStream: 1, Tpl: tpl}
+}
+
+// testParams returns handling params for the request.
+func testParams(t *testing.T, r *http.Request) *Params {
+	return &Params{Log: logging.New("test"), Settings: testSettings(t), Request: r}
+}
+
+func TestDownloadErrHandler(t *testing.T) {
+	cases := []struct {
+		path        string
+		accept      string
+		ajax        bool
+		contentType string
+		body        string
+	}{
+		{path: "/file", ajax: true, contentType: "text/plain; charset=utf-8", body: "not found"},
+		{path: "/api/text", contentType: "application/json", body: "{\"error\":\"not found\"}\n"},
+		{path: "/file", accept: "application/json", contentType: "application/json", body: "{\"error\":\"not found\"}\n"},
+		{path: "/file", accept: "text/html", contentType: "text/html; charset=utf-8", body: "not found</h3>"},
+	}
+	for i, c := range cases {
+		r := httptest.NewRequest("POST", c.path, nil)
+		if c.accept != "" {
+			r.Header.Set("Accept", c.accept)
+		}
+		w := httptest.NewRecorder()
+		code, err := downloadErrHandler(w, testParams(t, r), &ErrItem{Err: "not found", Code: http.StatusNotFound, ajax: c.ajax})
+		if err != nil {
+			t.Fatalf("case=%d: %v", i, err)
+		}
+		if code != http.StatusNotFound || w.Code != http.StatusNotFound {
+			t.Errorf("case=%d: failed code=%d, response code=%d", i, code, w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != c.contentType {
+			t.Errorf("case=%d: failed content type=%s", i, ct)
+		}
+		if body := w.Body.String(); !strings.Contains(body, c.body) {
+			t.Errorf("case=%d: failed body=%s", i, body)
+		}
+	}
+}
+
+func TestTextAPIHandler_Errors(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/text", nil)
+	w := httptest.NewRecorder()
+	code, err := textAPIHandler(r.Context(), w, testParams(t, r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != http.StatusMethodNotAllowed {
+		t.Errorf("failed code=%d", code)
+	}
+	e := &ErrItem{}
+	if err = json.NewDecoder(w.Body).Decode(e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Err != "failed HTTP method" {
+		t.Errorf("failed error message=%s", e.Err)
+	}
+}
+
+func TestMain_ClientAuth(t *testing.T) {
+	cases := []struct {
+		path       string
+		clientAuth bool
+		tls        *tls.ConnectionState
+		code       int
+	}{
+		{path: "/api/version", code: http.StatusOK},
+		{path: "/api/version", clientAuth: true, code: http.StatusForbidden},
+		{path: "/api/version", clientAuth: true, tls: &tls.ConnectionState{}, code: http.StatusForbidden},
+		{
+			path: "/api/version", clientAuth: true, code: http.StatusOK,
+			tls: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}},
+		},
+		{path: "/", clientAuth: true, code: http.StatusOK},
+	}
+	for i, c := range cases {
+		r := httptest.NewRequest("GET", c.path, nil)
+		r.TLS = c.tls
+		w := httptest.NewRecorder()
+		p := testParams(t, r)
+		p.Version = &Version{Version: "test"}
+		p.ClientAuth = c.clientAuth
+		if code := Main(r.Context(), w, p); code != c.code {
+			t.Errorf("case=%d: failed code=%d", i, code)
+		}
+	}
+}
+
+func TestMain_RequireHTTPS(t *testing.T) {
+	cases := []struct {
+		require bool
+		remote  string
+		proto   string
+		tls     *tls.ConnectionState
+		code    int
+	}{
+		{remote: "127.0.0.1:1234", code: http.StatusMethodNotAllowed},
+		{require: true, remote: "127.0.0.1:1234", code: http.StatusBadRequest},
+		{require: true, remote: "127.0.0.1:1234", proto: "http", code: http.StatusBadRequest},
+		{require: true, remote: "127.0.0.1:1234", proto: "https", code: http.StatusMethodNotAllowed},
+		{require: true, remote: "@", proto: "https", code: http.StatusMethodNotAllowed},
+		{require: true, remote: "192.0.2.1:1234", proto: "https", code: http.StatusBadRequest},
+		{require: true, remote: "192.0.2.1:1234", tls: &tls.ConnectionState{}, code: http.StatusMethodNotAllowed},
+	}
+	for i, c := range cases {
+		r := httptest.NewRequest("GET", "/api/text", nil)
+		r.RemoteAddr = c.remote
+		r.TLS = c.tls
+		if c.proto != "" {
+			r.Header.Set("X-Forwarded-Proto", c.proto)
+		}
+		w := httptest.NewRecorder()
+		p := testParams(t, r)
+		p.Settings.APIRequireHTTPS = c.require
+		if code := Main(r.Context(), w, p); code != c.code {
+			t.Errorf("case=%d: failed code=%d", i, code)
+		}
+		if c.code == http.StatusBadRequest && !strings.Contains(w.Body.String(), "HTTPS is required") {
+			t.Errorf("case=%d: failed body=%s", i, w.Body.String())
+		}
+	}
+}
+
+func TestIndexHandler_JSON(t *testing.T) {
+	cases := []struct {
+		accept      string
+		contentType string
+	}{
+		{contentType: "text/html; charset=utf-8"},
+		{accept: "text/html,application/xhtml+xml", contentType: "text/html; charset=utf-8"},
+		{accept: "application/json", contentType: "application/json"},
+	}
+	for i, c := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		if c.accept != "" {
+			r.Header.Set("Accept", c.accept)
+		}
+		w := httptest.NewRecorder()
+		p := testParams(t, r)
+		p.Version = &Version{Version: "test"}
+		if code := Main(r.Context(), w, p); code != http.StatusOK {
+			t.Errorf("case=%d: failed code=%d", i, code)
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, c.contentType) {
+			t.Errorf("case=%d: failed content type=%s", i, ct)
+		}
+		if v := w.Header().Get("Vary"); v != "Accept" {
+			t.Errorf("case=%d: failed vary header=%s", i, v)
+		}
+		if c.contentType != "application/json" {
+			continue
+		}
+		root := &APIRoot{}
+		if err := json.NewDecoder(w.Body).Decode(root); err != nil {
+			t.Fatal(err)
+		}
+		if root.Version != "test" || len(root.Endpoints) == 0 || root.Limits == nil || root.Limits.MaxTTL != p.Settings.TTL {
+			t.Errorf("case=%d: failed root data: %+v", i, root)
+		}
+	}
+}
+
+func TestMain_MissingTemplate(t *testing.T) {
+	cases := []struct {
+		absent      []string
+		contentType string
+	}{
+		{absent: []string{cfg.IndexTpl}, contentType: "text/html; charset=utf-8"},
+		{absent: []string{cfg.IndexTpl, cfg.ErrorTpl}, contentType: "text/plain; charset=utf-8"},
+	}
+	for i, c := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		w := httptest.NewRecorder()
+		p := testParams(t, r)
+		for _, name := range c.absent {
+			delete(p.Settings.Tpl, name)
+		}
+		if code := Main(r.Context(), w, p); code != http.StatusInternalServerError || w.Code != code {
+			t.Errorf("case=%d: failed code=%d, response code=%d", i, code, w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != c.contentType {
+			t.Errorf("case=%d: failed content type=%s", i, ct)
+		}
+		if body := w.Body.String(); !strings.Contains(body, "template not configured") {
+			t.Errorf("case=%d: failed body=%s", i, body)
+		}
+	}
+}
+
+func TestStatusAPIHandler_Errors(t *testing.T) {
+	tooMany := make([]string, maxStatusKeys+1)
+	for i := range tooMany {
+		tooMany[i] = "\"" + uuid.New().String() + "\""
+	}
+	cases := []struct {
+		method string
+		body   string
+		code   int
+	}{
+		{method: "GET", code: http.StatusMethodNotAllowed},
+		{method: "POST", body: "{}", code: http.StatusBadRequest},
+		{method: "POST", body: "[]", code: http.StatusBadRequest},
+		{method: "POST", body: "[\"bad\"]", code: http.StatusBadRequest},
+		{method: "POST", body: "[" + strings.Join(tooMany, ",") + "]", code: http.StatusBadRequest},
+	}
+	for i, c := range cases {
+		r := httptest.NewRequest(c.method, "/api/status", strings.NewReader(c.body))
+		w := httptest.NewRecorder()
+		code, err := statusAPIHandler(r.Context(), w, testParams(t, r))
+		if err != nil {
+			t.Fatalf("case=%d: %v", i, err)
+		}
+		if code != c.code {
+			t.Errorf("case=%d: failed code=%d", i, code)
+		}
+	}
+}
+
+func TestOpenAPIHandler(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/openapi.json", nil)
+	w := httptest.NewRecorder()
+	code := Main(r.Context(), w, testParams(t, r))
+	if code != http.StatusOK {
+		t.Fatalf("failed code=%d", code)
+	}
+	spec := make(map[string]interface{})
+	if err := json.NewDecoder(w.Body).Decode(&spec); err != nil {
+		t.Fatal(err)
+	}
+	if v := spec["openapi"]; v != "3.0.3" {
+		t.Errorf("failed openapi version=%v", v)
+	}
+}
+
+func TestMain_APIOnly(t *testing.T) {
+	cases := []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{method: "GET", path: "/", code: http.StatusOK},
+		{method: "GET", path: "/api/version", code: http.StatusOK},
+		{method: "GET", path: "/api/text", code: http.StatusMethodNotAllowed},
+		{method: "POST", path: "/upload", code: http.StatusNotFound},
+		{method: "GET", path: "/" + uuid.New().String(), code: http.StatusNotFound},
+		{method: "GET", path: "/static/main.css", code: http.StatusNotFound},
+	}
+	for i, c := range cases {
+		r := httptest.NewRequest(c.method, c.path, nil)
+		w := httptest.NewRecorder()
+		p := testParams(t, r)
+		p.Version = &Version{Version: "v1.0.0"}
+		p.Settings.APIOnly, p.Settings.Tpl = true, nil
+		if code := Main(r.Context(), w, p); code != c.code {
+			t.Errorf("case=%d: failed code=%d", i, code)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("case=%d: failed content type=%s", i, ct)
+		}
+	}
+}
+
+func TestFaviconHandler(t *testing.T) {
+	icon := filepath.Join(t.TempDir(), "favicon.ico")
+	if err := os.WriteFile(icon, []byte("icon"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		path    string
+		favicon string
+		code    int
+		body    string
+	}{
+		{path: "/favicon.ico", code: http.StatusNoContent},
+		{path: "/favicon.ico", favicon: icon, code: http.StatusOK, body: "icon"},
+		{path: "/manifest.json", code: http.StatusNoContent},
+		{path: "/apple-touch-icon.png", code: http.StatusNoContent},
+	}
+	for i, c := range cases {
+		r := httptest.NewRequest("GET", c.path, nil)
+		w := httptest.NewRecorder()
+		p := testParams(t, r)
+		p.Settings.Favicon = c.favicon
+		if code := Main(r.Context(), w, p); code != c.code || w.Code != c.code {
+			t.Errorf("case=%d: failed code=%d", i, code)
+		}
+		if body := w.Body.String(); body != c.body {
+			t.Errorf("case=%d: failed body=%q", i, body)
+		}
+	}
+}
+
+func TestRequestCredentials(t *testing.T) {
+	const key = "4b6ac1a2-4b3e-4c37-9c6e-3d3f5d1b8f27"
+	cases := []struct {
+		form     url.Values
+		user     string
+		basic    string
+		bearer   string
+		key      string
+		password string
+	}{
+		{form: url.Values{"key": {key}, "password": {"form"}}, key: key, password: "form"},
+		{form: url.Values{"key": {key}}, bearer: "Bearer token", key: key, password: "token"},
+		{form: url.Values{"key": {key}}, bearer: "bearer  token ", key: key, password: "token"},
+		{user: key, basic: "basic", key: key, password: "basic"},
+		{form: url.Values{"key": {key}, "password": {"form"}}, user: "user", basic: "basic", key: key, password: "form"},
+		{form: url.Values{"key": {key}, "password": {"form"}}, bearer: "Bearer token", key: key, password: "form"},
+		{form: url.Values{"key": {key}}, bearer: "Token token", key: key},
+		{form: url.Values{"key": {key}}, bearer: "Bearer ", key: key},
+	}
+	for i, c := range cases {
+		r := postForm("/api/text", c.form)
+		if c.user != "" {
+			r.SetBasicAuth(c.user, c.basic)
+		}
+		if c.bearer != "" {
+			r.Header.Set("Authorization", c.bearer)
+		}
+		k, password := requestCredentials(r)
+		if k != c.key || password != c.password {
+			t.Errorf("case=%d: failed key=%q password=%q", i, k, password)
+		}
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	r := httptest.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	p := testParams(t, r)
+	if code := Main(r.Context(), w, p); code != http.StatusNotFound {
+		t.Errorf("failed code=%d", code)
+	}
+	p.Settings.Metrics = true
+	w = httptest.NewRecorder()
+	if code := Main(r.Context(), w, p); code != http.StatusOK {
+		t.Fatalf("failed code=%d", code)
+	}
+	body := w.Body.String()
+	lines := []string{
+		"# TYPE send_key_derivation_seconds histogram",
+		"send_key_derivation_seconds_bucket{le=\"0.01\"}",
+		"# TYPE send_gc_last_sweep_timestamp_seconds gauge",
+		"# TYPE send_gc_last_sweep_deleted gauge",
+	}
+	for _, line := range lines {
+		if !strings.Contains(body, line) {
+			t.Errorf("not found %q in metrics:\n%s", line, body)
+		}
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	cases := []struct {
+		target   string
+		expected string
+	}{
+		{target: "/api/version", expected: "{\"version\":\"v1.0.0\"}\n"},
+		{target: "/api/version?pretty=0", expected: "{\"version\":\"v1.0.0\"}\n"},
+		{target: "/api/version?pretty=1", expected: "{\n  \"version\": \"v1.0.0\"\n}\n"},
+	}
+	for i, c := range cases {
+		r := httptest.NewRequest("GET", c.target, nil)
+		w := httptest.NewRecorder()
+		if err := writeJSON(w, r, map[string]string{"version": "v1.0.0"}); err != nil {
+			t.Fatal(err)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("case=%d: failed content type=%s", i, ct)
+		}
+		if body := w.Body.String(); body != c.expected {
+			t.Errorf("case=%d: failed body=%q", i, body)
+		}
+	}
+}
+
+func TestIndexHandler(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	p := testParams(t, r)
+	p.Settings.RequirePassword = true
+	p.Settings.ContentTypes = []string{"image/png", "image/jpeg"}
+	code, err := indexHandler(r.Context(), w, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != http.StatusOK {
+		t.Errorf("failed code=%d", code)
+	}
+	body := w.Body.String()
+	expected := []string{"accept=\"image/png,image/jpeg\"", "max=\"10\"", "aria-describedby=\"passwordHelp\" required>", "maximum size 1 Mb", "value='600'"}
+	for _, s := range expected {
+		if !strings.Contains(body, s) {
+			t.Errorf("not found %q in the page", s)
+		}
+	}
+	if strings.Contains(body, "value='86400'") {
+		t.Error("TTL option is greater than max value")
+	}
+}
+
+// failedWriter is a writer that always returns an error.
+type failedWriter struct{}
+
+func (failedWriter) Write([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestStreamError(t *testing.T) {
+	streamErr := errors.New("read failed")
+	cases := []struct {
+		n    int64
+		w    io.Writer
+		code int
+		body string
+	}{
+		{code: http.StatusInternalServerError, body: "internal error"},
+		{n: 10, code: http.StatusOK},
+		{w: failedWriter{}, code: http.StatusOK},
+	}
+	for i, c := range cases {
+		r := httptest.NewRequest("POST", "/file", nil)
+		w := httptest.NewRecorder()
+		w.Header().Set("Content-Disposition", "attachment; filename=\"test.txt\"")
+		w.Header().Set("Content-Length", "10")
+		cw := &countWriter{w: w, n: c.n}
+		if c.w != nil {
+			cw.w = c.w
+			_, _ = cw.Write([]byte("data"))
+		}
+		code, err := streamError(w, testParams(t, r), cw, "key", true, streamErr)
+		if err != nil {
+			t.Fatalf("case=%d: %v", i, err)
+		}
+		if code != c.code {
+			t.Errorf("case=%d: failed code=%d", i, code)
+		}
+		if body := w.Body.String(); body != c.body {
+			t.Errorf("case=%d: failed body=%s", i, body)
+		}
+		if c.body != "" && w.Header().Get("Content-Disposition") != "" {
+			t.Errorf("case=%d: file header is not removed", i)
+		}
+	}
+}
+
+func TestFileMeta_ResponseContentDisposition(t *testing.T) {
+	cases := []struct {
+		name        string
+		contentType string
+		disposition string
+		expected    string
+	}{
+		{contentType: "image/png", expected: "attachment; filename=test"},
+		{contentType: "image/png", disposition: inlineDisposition, expected: "inline; filename=test"},
+		{contentType: "text/plain; charset=utf-8", disposition: inlineDisposition, expected: "inline; filename=test"},
+		{contentType: "text/html", disposition: inlineDisposition, expected: "attachment; filename=test"},
+		{contentType: "image/svg+xml", disposition: inlineDisposition, expected: "attachment; filename=test"},
+		{disposition: inlineDisposition, expected: "attachment; filename=test"},
+		{name: "my file.txt", expected: "attachment; filename=\"my file.txt\""},
+		{name: "a\"; filename=b.exe", expected: "attachment; filename=\"a\\\"; filename=b.exe\""},
+		{name: "файл.txt", expected: "attachment; filename*=utf-8''%D1%84%D0%B0%D0%B9%D0%BB.txt"},
+	}
+	for i, c := range cases {
+		name := c.name
+		if name == "" {
+			name = "test"
+		}
+		fm := &FileMeta{Name: name, ContentType: c.contentType, Disposition: c.disposition}
+		if v := fm.ResponseContentDisposition(); v != c.expected {
+			t.Errorf("case=%d: failed disposition=%s", i, v)
+		}
+	}
+}
+
+func TestFileMeta_ResponseDigest(t *testing.T) {
+	fm := &FileMeta{Checksum: "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"}
+	if v := fm.ResponseDigest(); v != "sha-256=uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek=" {
+		t.Errorf("failed digest=%s", v)
+	}
+	fm.Checksum = ""
+	if v := fm.ResponseDigest(); v != "" {
+		t.Errorf("unexpected digest=%s", v)
+	}
+}
+
+func TestStatusWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := NewStatusWriter(rec)
+	if w.Written() || w.Status() != http.StatusOK {
+		t.Errorf("failed initial state: %v, %d", w.Written(), w.Status())
+	}
+	w.WriteHeader(http.StatusCreated)
+	w.WriteHeader(http.StatusInternalServerError) // superfluous call
+	for i := 0; i < 2; i++ {
+		if _, err := w.Write([]byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !w.Written() || w.Status() != http.StatusCreated {
+		t.Errorf("failed status: %v, %d", w.Written(), w.Status())
+	}
+	if n := w.Size(); n != 8 {
+		t.Errorf("failed size=%d", n)
+	}
+	if rec.Code != http.StatusCreated || rec.Body.String() != "datadata" {
+		t.Errorf("failed response: %d, %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRobotsHandler(t *testing.T) {
+	cases := []struct {
+		robots   string
+		expected string
+	}{
+		{expected: "User-agent: *\nDisallow: /\n"},
+		{robots: "User-agent: *\nAllow: /$\n", expected: "User-agent: *\nAllow: /$\n"},
+	}
+	for i, c := range cases {
+		r := httptest.NewRequest("GET", "/robots.txt", nil)
+		w := httptest.NewRecorder()
+		p := testParams(t, r)
+		p.Settings.Robots = c.robots
+		if code := Main(r.Context(), w, p); code != http.StatusOK {
+			t.Errorf("case=%d: failed code=%d", i, code)
+		}
+		if body := w.Body.String(); body != c.expected {
+			t.Errorf("case=%d: failed body=%q", i, body)
+		}
+	}
+	// not found download page is not indexed too
+	r := httptest.NewRequest("GET", "/bad-key", nil)
+	w := httptest.NewRecorder()
+	if code := Main(r.Context(), w, testParams(t, r)); code != http.StatusNotFound {
+		t.Errorf("failed code=%d", code)
+	}
+	if v := w.Header().Get("X-Robots-Tag"); v != "noindex, nofollow" {
+		t.Errorf("failed robots header=%s", v)
+	}
+}
+
+func TestFileInfo(t *testing.T) {
+	fm := &FileMeta{Name: "secret.pdf", Size: 1536, ContentType: ""}
+	s, err := fm.Info().Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(s, fm.Name) {
+		t.Errorf("file name is public: %s", s)
+	}
+	fi, err := DecodeInfo(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size != fm.Size || fi.ContentType != "application/octet-stream" {
+		t.Errorf("failed file info: %+v", fi)
+	}
+	cases := map[int64]string{0: "0 B", 1023: "1023 B", 1536: "1.5 KB", 5 << 20: "5.0 MB"}
+	for size, expected := range cases {
+		fi.Size = size
+		if v := fi.HumanSize(); v != expected {
+			t.Errorf("failed size=%d: %s", size, v)
+		}
+	}
+}
+
+func TestExtendAPIHandler_Errors(t *testing.T) {
+	key := uuid.New().String()
+	cases := []struct {
+		body string
+		code int
+	}{
+		{body: "password=secret&ttl=10", code: http.StatusBadRequest},
+		{body: "password=secret&key=" + key, code: http.StatusBadRequest},
+		{body: "password=secret&key=" + key + "&ttl=-1", code: http.StatusBadRequest},
+		{body: "password=secret&key=" + key + "&times=1000000", code: http.StatusBadRequest},
+	}
+	for i, c := range cases {
+		r := httptest.NewRequest("POST", "/api/extend", strings.NewReader(c.body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		code, err := extendAPIHandler(r.Context(), w, testParams(t, r))
+		if err != nil {
+			t.Fatalf("case=%d: %v", i, err)
+		}
+		if code != c.code {
+			t.Errorf("case=%d: failed code=%d", i, code)
+		}
+	}
+}
+
+func TestCleanFileName(t *testing.T) {
+	cases := []struct {
+		name     string
+		expected string
+		fail     bool
+	}{
+		{name: "test.txt", expected: "test.txt"},
+		{name: "../../etc/passwd", expected: "passwd"},
+		{name: "/etc/passwd", expected: "passwd"},
+		{name: "..\\..\\windows\\system.ini", expected: "system.ini"},
+		{name: " dir/файл.txt ", expected: "файл.txt"},
+		{name: strings.Repeat("я", 10) + ".txt", fail: true},
+		{name: "../..", fail: true},
+		{name: "dir/", expected: "dir"},
+		{name: "/", fail: true},
+		{name: "", fail: true},
+		{name: "a\r\nb.txt", fail: true},
+		{name: "a\x00.txt", fail: true},
+		{name: "a\xff.txt", fail: true},
+		{name: "a\";b.txt", expected: "a\";b.txt"},
+	}
+	for i, c := range cases {
+		name, err := cleanFileName(c.name, 12)
+		if c.fail {
+			if err == nil {
+				t.Errorf("case=%d: expected error, name=%s", i, name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case=%d: unexpected error: %v", i, err)
+			continue
+		}
+		if name != c.expected {
+			t.Errorf("case=%d: failed name=%s", i, name)
+		}
+	}
+}
+
+func TestStaticHandler(t *testing.T) {
+	fsys := os.DirFS("../html/static")
+	assets, err := cfg.AssetHashes(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := assets["main.js"]
+	cases := []struct {
+		target       string
+		code         int
+		cacheControl string
+	}{
+		{target: "/static/main.js?v=" + h, code: http.StatusOK, cacheControl: "public, max-age=31536000, immutable"},
+		{target: "/static/main.js?v=old", code: http.StatusOK, cacheControl: "no-cache"},
+		{target: "/static/main.js", code: http.StatusOK, cacheControl: "no-cache"},
+		{target: "/static/unknown.js", code: http.StatusNotFound},
+	}
+	handler := StaticHandler(fsys, assets)
+	for i, c := range cases {
+		r := httptest.NewRequest("GET", c.target, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != c.code {
+			t.Errorf("case=%d: failed code=%d", i, w.Code)
+		}
+		if v := w.Header().Get("Cache-Control"); v != c.cacheControl {
+			t.Errorf("case=%d: failed Cache-Control=%s", i, v)
+		}
+	}
+	// revalidation
+	r := httptest.NewRequest("GET", "/static/main.js", nil)
+	r.Header.Set("If-None-Match", "\""+h+"\"")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("failed revalidation code=%d", w.Code)
+	}
+}
+
+func TestFileInfo_Category(t *testing.T) {
+	cases := map[string]string{
+		"image/png":                 "image",
+		"image/svg+xml":             "image",
+		"application/pdf":           "document",
+		"text/plain; charset=utf-8": "document",
+		"application/zip":           "archive",
+		"application/x-tar":         "archive",
+		"application/octet-stream":  "other",
+		"video/mp4":                 "other",
+		"bad type":                  "other",
+	}
+	for contentType, expected := range cases {
+		fi := &FileInfo{ContentType: contentType}
+		if c := fi.Category(); c != expected {
+			t.Errorf("failed category=%s for %s", c, contentType)
+		}
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	const key = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	cases := []struct {
+		key       string
+		normalize bool
+		expected  string
+		fail      bool
+	}{
+		{key: key, expected: key},
+		{key: key, normalize: true, expected: key},
+		{key: strings.ToUpper(key), expected: strings.ToUpper(key)},
+		{key: strings.ToUpper(key), normalize: true, expected: key},
+		{key: "{" + key + "}", normalize: true, expected: key},
+		{key: "urn:uuid:" + key, normalize: true, expected: key},
+		{key: strings.ReplaceAll(key, "-", ""), normalize: true, expected: key},
+		{key: key + "/extra", normalize: true, fail: true},
+		{key: "", normalize: true, fail: true},
+	}
+	for i, c := range cases {
+		value, err := parseKey(c.key, c.normalize)
+		if (err != nil) != c.fail {
+			t.Errorf("case=%d: unexpected error: %v", i, err)
+		}
+		if value != c.expected {
+			t.Errorf("case=%d: failed key=%s", i, value)
+		}
+	}
+}
+
+func TestParams_clientIP(t *testing.T) {
+	cases := []struct {
+		remote    string
+		forwarded string
+		realIP    string
+		expected  string
+	}{
+		{remote: "192.0.2.1:1234", expected: "192.0.2.1"},
+		{remote: "192.0.2.1:1234", forwarded: "10.1.2.3", realIP: "10.1.2.4", expected: "192.0.2.1"},
+		{remote: "127.0.0.1:1234", expected: "127.0.0.1"},
+		{remote: "127.0.0.1:1234", forwarded: "10.1.2.3", expected: "10.1.2.3"},
+		{remote: "127.0.0.1:1234", forwarded: "192.0.2.1, 10.1.2.3", expected: "10.1.2.3"},
+		{remote: "127.0.0.1:1234", forwarded: "bad", realIP: "10.1.2.4", expected: "10.1.2.4"},
+		{remote: "@", realIP: "2001:db8::1", expected: "2001:db8::1"},
+		{remote: "@", expected: "@"},
+	}
+	for i, c := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = c.remote
+		if c.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		if c.realIP != "" {
+			r.Header.Set("X-Real-IP", c.realIP)
+		}
+		if ip := testParams(t, r).clientIP(); ip != c.expected {
+			t.Errorf("case=%d: failed ip=%s", i, ip)
+		}
+	}
+}
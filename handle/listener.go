@@ -0,0 +1,68 @@
+package handle
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/z0rr0/send/logging"
+)
+
+// LimitListener is a net.Listener wrapper which limits number of concurrent connections.
+// Unlike blocking accept, new connections are closed immediately if the limit is reached,
+// so clients get a connection refusal instead of hanging in the queue.
+type LimitListener struct {
+	net.Listener
+	sem     chan struct{}
+	refused uint64
+	log     *logging.Log
+}
+
+// NewLimitListener returns a listener that accepts at most n concurrent connections from l.
+func NewLimitListener(l net.Listener, n int, log *logging.Log) *LimitListener {
+	return &LimitListener{Listener: l, sem: make(chan struct{}, n), log: log}
+}
+
+// Accept waits for the next connection which doesn't exceed the limit.
+func (ll *LimitListener) Accept() (net.Conn, error) {
+	for {
+		c, err := ll.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case ll.sem <- struct{}{}:
+			return &limitConn{Conn: c, release: ll.release}, nil
+		default:
+			n := atomic.AddUint64(&ll.refused, 1)
+			if e := c.Close(); e != nil {
+				ll.log.Error("close refused connection: %v", e)
+			}
+			ll.log.Info("connection from %v is refused, limit=%d is reached, total refused=%d", c.RemoteAddr(), cap(ll.sem), n)
+		}
+	}
+}
+
+// Refused returns number of refused connections.
+func (ll *LimitListener) Refused() uint64 {
+	return atomic.LoadUint64(&ll.refused)
+}
+
+// release frees a place for a new connection.
+func (ll *LimitListener) release() {
+	<-ll.sem
+}
+
+// limitConn is a connection which releases its listener place after closing.
+type limitConn struct {
+	net.Conn
+	release func()
+	once    sync.Once
+}
+
+// Close closes the connection and releases its place once.
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
@@ -0,0 +1,67 @@
+package handle
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/z0rr0/send/logging"
+)
+
+func TestLimitListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ll := NewLimitListener(l, 1, logging.New("test"))
+	defer ll.Close()
+
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			c, e := ll.Accept()
+			if e != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+	dial := func() net.Conn {
+		c, e := net.Dial("tcp", l.Addr().String())
+		if e != nil {
+			t.Fatal(e)
+		}
+		return c
+	}
+	first := dial()
+	defer first.Close()
+	serverConn := <-accepted
+
+	// the second connection is closed by the listener
+	second := dial()
+	defer second.Close()
+	if err = second.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	// EOF or connection reset
+	if _, err = second.Read(make([]byte, 1)); err == nil {
+		t.Error("refused connection is not closed")
+	}
+	if n := ll.Refused(); n != 1 {
+		t.Errorf("failed number of refused connections=%d", n)
+	}
+	// a place is released after closing
+	if err = serverConn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	third := dial()
+	defer third.Close()
+	select {
+	case c := <-accepted:
+		if err = c.Close(); err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("connection is not accepted after release")
+	}
+}
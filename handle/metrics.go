@@ -0,0 +1,50 @@
+package handle
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/z0rr0/send/db"
+	"github.com/z0rr0/send/encrypt"
+)
+
+// metricsHandler returns service metrics in Prometheus text format.
+func metricsHandler(_ context.Context, w http.ResponseWriter, p *Params) (int, error) {
+	if !p.Settings.Metrics {
+		return downloadErrHandler(w, p, nil)
+	}
+	var b strings.Builder
+	stats := encrypt.KeyDerivation()
+	b.WriteString("# HELP send_key_derivation_seconds Duration of password key derivation.\n")
+	b.WriteString("# TYPE send_key_derivation_seconds histogram\n")
+	for i, bucket := range encrypt.KeyBuckets {
+		le := strconv.FormatFloat(bucket.Seconds(), 'g', -1, 64)
+		fmt.Fprintf(&b, "send_key_derivation_seconds_bucket{le=%q} %d\n", le, stats.Counts[i])
+	}
+	fmt.Fprintf(&b, "send_key_derivation_seconds_bucket{le=\"+Inf\"} %d\n", stats.Count)
+	fmt.Fprintf(&b, "send_key_derivation_seconds_sum %g\n", stats.Sum.Seconds())
+	fmt.Fprintf(&b, "send_key_derivation_seconds_count %d\n", stats.Count)
+	b.WriteString("# HELP send_file_name_collisions_total Number of storage file names collisions.\n")
+	b.WriteString("# TYPE send_file_name_collisions_total counter\n")
+	fmt.Fprintf(&b, "send_file_name_collisions_total %d\n", encrypt.Collisions())
+	swept, deleted := db.LastSweep()
+	var sweptSeconds int64
+	if !swept.IsZero() {
+		sweptSeconds = swept.Unix()
+	}
+	b.WriteString("# HELP send_gc_last_sweep_timestamp_seconds Unix time of the last successful GC sweep, 0 if there were no sweeps.\n")
+	b.WriteString("# TYPE send_gc_last_sweep_timestamp_seconds gauge\n")
+	fmt.Fprintf(&b, "send_gc_last_sweep_timestamp_seconds %d\n", sweptSeconds)
+	b.WriteString("# HELP send_gc_last_sweep_deleted Number of items deleted by the last successful GC sweep.\n")
+	b.WriteString("# TYPE send_gc_last_sweep_deleted gauge\n")
+	fmt.Fprintf(&b, "send_gc_last_sweep_deleted %d\n", deleted)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if _, err := w.Write([]byte(b.String())); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
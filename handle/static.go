@@ -0,0 +1,31 @@
+package handle
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/z0rr0/send/cfg"
+)
+
+// staticMaxAge is cache max age (seconds) of versioned static files, their URLs change with content.
+const staticMaxAge = 365 * 24 * 3600
+
+// StaticHandler serves static files from fsys. Requests with the current content hash
+// from assets are cached by clients forever, others are revalidated by ETag.
+func StaticHandler(fsys fs.FS, assets map[string]string) http.Handler {
+	fileServer := http.StripPrefix(strings.TrimSuffix(cfg.StaticPrefix, "/"), http.FileServer(http.FS(fsys)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, ok := assets[strings.TrimPrefix(r.URL.Path, cfg.StaticPrefix)]
+		if ok {
+			w.Header().Set("ETag", "\""+h+"\"")
+			if r.URL.Query().Get("v") == h {
+				w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d, immutable", staticMaxAge))
+			} else {
+				w.Header().Set("Cache-Control", "no-cache")
+			}
+		}
+		fileServer.ServeHTTP(w, r)
+	})
+}
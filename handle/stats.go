@@ -0,0 +1,76 @@
+package handle
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/z0rr0/send/db"
+)
+
+// statsCachePeriod is a period of public stats cache.
+const statsCachePeriod = time.Minute
+
+// PublicStats is data struct of API response with coarse service stats,
+// it doesn't contain exact numbers and any items data.
+type PublicStats struct {
+	Items       int64 `json:"items"`        // number of active items rounded to a power of ten
+	StorageUsed int   `json:"storage_used"` // percent of the storage limit
+}
+
+// StatsCache keeps a number of active items to not count them for every request.
+type StatsCache struct {
+	items   int64
+	expired time.Time
+	m       sync.Mutex
+}
+
+// NewStatsCache returns a new empty stats cache.
+func NewStatsCache() *StatsCache {
+	return &StatsCache{}
+}
+
+// Items returns the cached number of active items, it's counted again if the cache is expired.
+// Concurrent calls wait one database query.
+func (sc *StatsCache) Items(ctx context.Context, database *sql.DB) (int64, error) {
+	sc.m.Lock()
+	defer sc.m.Unlock()
+
+	now := time.Now()
+	if now.Before(sc.expired) {
+		return sc.items, nil
+	}
+	n, err := db.CountActive(ctx, database)
+	if err != nil {
+		return 0, err
+	}
+	sc.items, sc.expired = n, now.Add(statsCachePeriod)
+	return n, nil
+}
+
+// roundPow10 returns the power of ten nearest to n in logarithmic scale, zero is kept.
+func roundPow10(n int64) int64 {
+	if n <= 0 {
+		return 0
+	}
+	return int64(math.Pow(10, math.Round(math.Log10(float64(n)))))
+}
+
+// statsAPIHandler returns public coarse stats, it is available if public_stats setting is enabled.
+func statsAPIHandler(ctx context.Context, w http.ResponseWriter, p *Params) (int, error) {
+	if !p.Settings.PublicStats || p.Stats == nil {
+		return downloadErrHandler(w, p, &ErrItem{Err: "stats are disabled", Code: http.StatusNotFound})
+	}
+	n, err := p.Stats.Items(ctx, p.DB)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	stats := &PublicStats{Items: roundPow10(n), StorageUsed: p.Storage.UsedPercent()}
+	if err = writeJSON(w, p.Request, stats); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
@@ -0,0 +1,79 @@
+package handle
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/z0rr0/send/encrypt"
+)
+
+func TestRoundPow10(t *testing.T) {
+	cases := []struct {
+		n, expected int64
+	}{
+		{n: 0, expected: 0},
+		{n: 1, expected: 1},
+		{n: 3, expected: 1},
+		{n: 4, expected: 10},
+		{n: 31, expected: 10},
+		{n: 32, expected: 100},
+		{n: 250, expected: 100},
+		{n: 4567, expected: 10000},
+	}
+	for i, c := range cases {
+		if v := roundPow10(c.n); v != c.expected {
+			t.Errorf("case=%d: failed value=%d", i, v)
+		}
+	}
+}
+
+func TestStatsAPIHandler(t *testing.T) {
+	params := memoryParams(t, encrypt.NewMemoryStorage())
+	cache := NewStatsCache()
+	stats := func(enabled bool) (int, *PublicStats) {
+		r := httptest.NewRequest("GET", "/api/stats", nil)
+		w := httptest.NewRecorder()
+		p := params(r)
+		p.Settings.PublicStats, p.Stats = enabled, cache
+		code := Main(r.Context(), w, p)
+		result := &PublicStats{}
+		if code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(result); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return code, result
+	}
+	if code, _ := stats(false); code != http.StatusNotFound {
+		t.Errorf("failed code=%d for disabled stats", code)
+	}
+	upload := func(n int) {
+		for i := 0; i < n; i++ {
+			r := postForm("/api/upload", url.Values{"text": {"text"}, "ttl": {"600"}, "times": {"1"}})
+			w := httptest.NewRecorder()
+			if code := Main(r.Context(), w, params(r)); code != http.StatusCreated {
+				t.Fatalf("failed upload code=%d: %s", code, w.Body.String())
+			}
+		}
+	}
+	upload(5)
+	code, result := stats(true)
+	if code != http.StatusOK {
+		t.Fatalf("failed code=%d", code)
+	}
+	if result.Items != 10 || result.StorageUsed != 0 {
+		t.Errorf("failed stats: %+v", result)
+	}
+	// cached value is returned
+	upload(30)
+	if _, result = stats(true); result.Items != 10 {
+		t.Errorf("failed cached items=%d", result.Items)
+	}
+	cache.expired = cache.expired.Add(-statsCachePeriod)
+	if _, result = stats(true); result.Items != 100 {
+		t.Errorf("failed items=%d", result.Items)
+	}
+}
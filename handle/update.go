@@ -0,0 +1,198 @@
+package handle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// update check statuses
+const (
+	UpdateLatest   = "latest"
+	UpdateOutdated = "outdated"
+	UpdateUnknown  = "unknown"
+)
+
+// update check periods
+const (
+	updateCachePeriod = time.Hour
+	updateRetryPeriod = 5 * time.Minute
+	updateTimeout     = 10 * time.Second
+)
+
+// LatestVersion is data struct of API response for the upgrade check.
+type LatestVersion struct {
+	Current string     `json:"current"`
+	Latest  string     `json:"latest,omitempty"`
+	Status  string     `json:"status"`
+	Checked *time.Time `json:"checked,omitempty"`
+}
+
+// releaseInfo is a response of the update check URL,
+// it can be a Version-like object or GitHub latest release one.
+type releaseInfo struct {
+	Version string `json:"version"`
+	TagName string `json:"tag_name"`
+}
+
+// UpdateChecker fetches the latest published version and caches it.
+// Only a GET request without any service details is sent to the URL.
+type UpdateChecker struct {
+	URL     string
+	Client  *http.Client
+	latest  string
+	checked time.Time
+	expired time.Time
+	running bool
+	m       sync.Mutex
+}
+
+// NewUpdateChecker returns a new checker, it is nil if the URL is empty.
+func NewUpdateChecker(url string) *UpdateChecker {
+	if url == "" {
+		return nil
+	}
+	return &UpdateChecker{URL: url, Client: &http.Client{Timeout: updateTimeout}}
+}
+
+// fetch requests the latest version from the URL.
+func (uc *UpdateChecker) fetch(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", uc.URL, nil)
+	if err != nil {
+		return "", fmt.Errorf("update check request: %w", err)
+	}
+	resp, err := uc.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("update check: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("update check failed status=%d", resp.StatusCode)
+	}
+	info := &releaseInfo{}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(info); err != nil {
+		return "", fmt.Errorf("decode update check response: %w", err)
+	}
+	if info.Version != "" {
+		return info.Version, nil
+	}
+	if info.TagName != "" {
+		return info.TagName, nil
+	}
+	return "", errors.New("empty version in update check response")
+}
+
+// update fetches the latest version and saves it to the cache.
+// Failed checks are retried earlier than successful ones.
+func (uc *UpdateChecker) update() {
+	ctx, cancel := context.WithTimeout(context.Background(), updateTimeout)
+	defer cancel()
+
+	latest, err := uc.fetch(ctx)
+	now := time.Now().UTC()
+
+	uc.m.Lock()
+	defer uc.m.Unlock()
+	uc.running = false
+	if err != nil {
+		uc.latest, uc.expired = "", now.Add(updateRetryPeriod)
+		return
+	}
+	uc.latest, uc.checked, uc.expired = latest, now, now.Add(updateCachePeriod)
+}
+
+// Latest compares current version with the cached latest one.
+// It doesn't block and starts a new background check if the cache is expired,
+// so status is unknown until the first successful check.
+func (uc *UpdateChecker) Latest(current string) *LatestVersion {
+	uc.m.Lock()
+	defer uc.m.Unlock()
+
+	if !uc.running && time.Now().After(uc.expired) {
+		uc.running = true
+		go uc.update()
+	}
+	result := &LatestVersion{Current: current, Latest: uc.latest, Status: UpdateUnknown}
+	if uc.latest == "" {
+		return result
+	}
+	checked := uc.checked
+	result.Checked = &checked
+	if cmp, ok := compareVersions(current, uc.latest); ok {
+		result.Status = UpdateLatest
+		if cmp < 0 {
+			result.Status = UpdateOutdated
+		}
+	}
+	return result
+}
+
+// parseVersion returns numeric parts of a version like "v1.2.3",
+// suffixes after "-" or "+" are ignored.
+func parseVersion(version string) ([]int, bool) {
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexAny(version, "-+"); i >= 0 {
+		version = version[:i]
+	}
+	if version == "" {
+		return nil, false
+	}
+	parts := strings.Split(version, ".")
+	result := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		result[i] = n
+	}
+	return result, true
+}
+
+// compareVersions returns -1, 0 or 1 if version a is less, equal or greater than b.
+// The second value is false if some version can not be parsed.
+func compareVersions(a, b string) (int, bool) {
+	va, ok := parseVersion(a)
+	if !ok {
+		return 0, false
+	}
+	vb, ok := parseVersion(b)
+	if !ok {
+		return 0, false
+	}
+	for i := 0; i < len(va) || i < len(vb); i++ {
+		var x, y int
+		if i < len(va) {
+			x = va[i]
+		}
+		if i < len(vb) {
+			y = vb[i]
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+	}
+	return 0, true
+}
+
+// latestVersionHandler is API handler to check that the running build is not outdated.
+func latestVersionHandler(_ context.Context, w http.ResponseWriter, p *Params) (int, error) {
+	if p.Updates == nil {
+		return downloadErrHandler(w, p, &ErrItem{Err: "update check is disabled", Code: http.StatusNotFound})
+	}
+	err := writeJSON(w, p.Request, p.Updates.Latest(p.Version.Version))
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
@@ -0,0 +1,107 @@
+package handle
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCompareVersions(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected int
+		ok       bool
+	}{
+		{a: "v1.2.3", b: "1.2.3", expected: 0, ok: true},
+		{a: "v1.2", b: "v1.2.1", expected: -1, ok: true},
+		{a: "v1.10.0", b: "v1.9.9", expected: 1, ok: true},
+		{a: "v1.2.3-4-gabcdef", b: "v1.2.4", expected: -1, ok: true},
+		{a: "", b: "v1.0.0"},
+		{a: "v1.0.0", b: "latest"},
+	}
+	for i, c := range cases {
+		cmp, ok := compareVersions(c.a, c.b)
+		if ok != c.ok || cmp != c.expected {
+			t.Errorf("case=%d: failed result=%d, ok=%v", i, cmp, ok)
+		}
+	}
+}
+
+func TestUpdateChecker_Latest(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.RawQuery != "" {
+			t.Errorf("unexpected query=%s", r.URL.RawQuery)
+		}
+		_, _ = io.WriteString(w, `{"tag_name":"v1.2.0"}`)
+	}))
+	defer ts.Close()
+
+	uc := NewUpdateChecker(ts.URL)
+	if v := uc.Latest("v1.1.0"); v.Status != UpdateUnknown {
+		t.Errorf("failed initial status=%s", v.Status)
+	}
+	var v *LatestVersion
+	for i := 0; i < 100; i++ {
+		if v = uc.Latest("v1.1.0"); v.Status != UpdateUnknown {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if v.Status != UpdateOutdated || v.Latest != "v1.2.0" || v.Checked == nil {
+		t.Errorf("failed version: %+v", v)
+	}
+	if v = uc.Latest("v1.2.0"); v.Status != UpdateLatest {
+		t.Errorf("failed status=%s", v.Status)
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("failed number of requests=%d", n)
+	}
+	if NewUpdateChecker("") != nil {
+		t.Error("checker without URL")
+	}
+}
+
+func TestUpdateChecker_Failed(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	uc := NewUpdateChecker(ts.URL)
+	uc.update()
+	if v := uc.Latest("v1.0.0"); v.Status != UpdateUnknown || v.Latest != "" {
+		t.Errorf("failed version: %+v", v)
+	}
+	if uc.expired.IsZero() {
+		t.Error("failed check is not cached")
+	}
+}
+
+func TestLatestVersionHandler(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/version/latest", nil)
+	w := httptest.NewRecorder()
+	p := testParams(t, r)
+	p.Version = &Version{Version: "v1.0.0"}
+	code := Main(r.Context(), w, p)
+	if code != http.StatusNotFound {
+		t.Errorf("failed code=%d", code)
+	}
+	p.Updates = &UpdateChecker{latest: "v1.0.0", expired: time.Now().Add(time.Hour)}
+	w = httptest.NewRecorder()
+	if code = Main(r.Context(), w, p); code != http.StatusOK {
+		t.Fatalf("failed code=%d", code)
+	}
+	v := &LatestVersion{}
+	if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Status != UpdateLatest || v.Current != "v1.0.0" {
+		t.Errorf("failed version: %+v", v)
+	}
+}
@@ -2,24 +2,53 @@ package handle
 
 import (
 	"context"
-	"encoding/json"
+	"database/sql"
 	"errors"
 	"fmt"
+	"io"
+	"math"
+	"mime/multipart"
 	"net/http"
+	"path"
 	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/z0rr0/send/cfg"
 	"github.com/z0rr0/send/db"
+	"github.com/z0rr0/send/encrypt"
 	"github.com/z0rr0/send/encrypt/pwgen"
+	"github.com/z0rr0/send/notify"
 )
 
+// maxHintLength is max number of characters in a password hint.
+const maxHintLength = 128
+
 // UploadData is upload result page data.
 type UploadData struct {
-	URL        string `json:"url"`
-	Password   string `json:"password"`
-	PwdDisable bool   `json:"pwd_disable"`
-	code       int
+	URL          string         `json:"url"`
+	Password     string         `json:"password"`
+	PwdDisable   bool           `json:"pwd_disable"`
+	HasText      bool           `json:"has_text"`
+	HasFile      bool           `json:"has_file"`
+	Mixed        bool           `json:"mixed"` // text and file are read independently
+	Counters     UploadCounters `json:"counters"`
+	ExpiresAt    time.Time      `json:"expires_at"`
+	ConfirmToken string         `json:"confirm_token,omitempty"` // pending item is readable only after confirmation
+	ConfirmUntil *time.Time     `json:"confirm_until,omitempty"`
+	code         int
+}
+
+// UploadCounters are independent reading attempts of uploaded text and file.
+type UploadCounters struct {
+	Text int `json:"text"`
+	File int `json:"file"`
+}
+
+// Key returns the item key from its URL.
+func (u *UploadData) Key() string {
+	return path.Base(u.URL)
 }
 
 // isValid returns true if validation is ok.
@@ -30,6 +59,8 @@ func (u *UploadData) isValid() bool {
 type validUploadData struct {
 	item     *db.Item
 	password string
+	notify   string // recipient email
+	pending  bool   // item is readable only after confirmation
 	code     int
 }
 
@@ -46,56 +77,268 @@ func validateInt(name, value string, max int) (int, error) {
 	return v, nil
 }
 
+// timesLimit returns max number of reads of an uploaded item,
+// the same value is used for text and file, so the item with both of them has the lowest limit.
+func timesLimit(s *cfg.Settings, hasText, hasFile bool) int {
+	switch {
+	case !hasFile:
+		return s.TextTimes()
+	case !hasText:
+		return s.FileTimes()
+	}
+	textTimes, fileTimes := s.TextTimes(), s.FileTimes()
+	if fileTimes < textTimes {
+		return fileTimes
+	}
+	return textTimes
+}
+
+// isMissingFile returns true if the error is about absent file in the form.
+func isMissingFile(err error) bool {
+	return errors.Is(err, http.ErrMissingFile) || errors.Is(err, http.ErrNotMultipart)
+}
+
+// errTextSize is an error when the uploaded text is bigger than settings.max_text_size.
+var errTextSize = errors.New("too big text")
+
+// readText returns a text from the form value or file part with name "text".
+// If the file part is bigger than settings.text_stream, it's not loaded to memory,
+// the file and its size are returned to stream the text during encryption.
+// Loaded text is trimmed if settings.trim_text is enabled, a streamed one is kept as is.
+func readText(p *Params) (string, multipart.File, int64, error) {
+	text := p.Request.PostFormValue("text")
+	if text != "" {
+		if int64(len(text)) > p.Settings.MaxTextBytes() {
+			return "", nil, 0, errTextSize
+		}
+		return trimText(p, text), nil, 0, nil
+	}
+	f, h, err := p.Request.FormFile("text")
+	if err != nil {
+		if isMissingFile(err) {
+			return "", nil, 0, nil
+		}
+		return "", nil, 0, err
+	}
+	if h.Size > p.Settings.MaxTextBytes() {
+		if e := f.Close(); e != nil {
+			p.Log.Error("close incoming text: %v", e)
+		}
+		return "", nil, 0, errTextSize
+	}
+	if h.Size > p.Settings.TextStreamBytes() {
+		return "", f, h.Size, nil
+	}
+	defer func() {
+		if e := f.Close(); e != nil {
+			p.Log.Error("close incoming text: %v", e)
+		}
+	}()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	return trimText(p, string(b)), nil, 0, nil
+}
+
+// trimText removes leading and trailing white spaces of the text if it's configured.
+func trimText(p *Params, text string) string {
+	if p.Settings.TrimText {
+		return strings.TrimSpace(text)
+	}
+	return text
+}
+
+// noSpaceCode returns HTTP status code for full storage.
+// If some stored file expires later, the service is temporary unavailable,
+// and Retry-After header is set to its expiration time.
+func noSpaceCode(ctx context.Context, w http.ResponseWriter, p *Params) int {
+	expired, err := db.NearestFileExpiry(ctx, p.DB)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			p.Log.Error("nearest file expiry: %v", err)
+		}
+		return http.StatusInsufficientStorage
+	}
+	retry := int64(math.Ceil(time.Until(expired).Seconds()))
+	if retry < 1 {
+		// already expired, it will be deleted soon
+		retry = 1
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(retry, 10))
+	return http.StatusServiceUnavailable
+}
+
+// quotaExceeded returns true if the client has used its daily uploads quota,
+// Retry-After header is set to the quota reset time then.
+func quotaExceeded(ctx context.Context, w http.ResponseWriter, p *Params, now time.Time) (bool, error) {
+	if p.Settings.DailyQuota == 0 {
+		return false, nil
+	}
+	n, err := db.QuotaUsed(ctx, p.DB, p.Settings.OriginHash(p.clientIP()), now)
+	if err != nil {
+		return false, err
+	}
+	if n < p.Settings.DailyQuota {
+		return false, nil
+	}
+	retry := int64(math.Ceil(db.QuotaReset(now).Sub(now).Seconds()))
+	w.Header().Set("Retry-After", strconv.FormatInt(retry, 10))
+	return true, nil
+}
+
+// validateNotify checks optional recipient email and the notifications limit of the sender.
+func validateNotify(p *Params) (string, error) {
+	email := p.Request.PostFormValue("notify_email")
+	if email == "" {
+		return "", nil
+	}
+	if p.Mailer == nil {
+		return "", errors.New("notifications are disabled")
+	}
+	email, err := notify.ParseAddress(email)
+	if err != nil {
+		return "", err
+	}
+	if err = p.Mailer.Allow(p.clientIP()); err != nil {
+		return "", err
+	}
+	return email, nil
+}
+
 // failedUpload returns index.html page with error message.
 func failedUpload(w http.ResponseWriter, code int, data *IndexData, p *Params, isAPI bool) error {
-	var err error
-	w.WriteHeader(code)
 	if isAPI {
-		encoder := json.NewEncoder(w)
-		return encoder.Encode(&ErrItem{Err: data.Error})
+		w.WriteHeader(code)
+		return writeJSON(w, p.Request, &ErrItem{Err: data.Error})
 	}
-	err = p.Settings.Tpl[cfg.IndexTpl].ExecuteTemplate(w, cfg.IndexTpl, data)
-	if err != nil {
-		return fmt.Errorf("failed execute template=%s: %w", cfg.IndexTpl, err)
+	if _, err := p.Settings.Template(cfg.IndexTpl); err != nil {
+		return err
 	}
-	return nil
+	w.WriteHeader(code)
+	return executeTemplate(w, p, cfg.IndexTpl, data)
 }
 
 // validateUpload checks incoming request data
 // and returns new db.Item pointer, password and validation error.
-func validateUpload(w http.ResponseWriter, p *Params, isAPI bool) (*validUploadData, error) {
+func validateUpload(ctx context.Context, w http.ResponseWriter, p *Params, isAPI bool) (*validUploadData, error) {
 	var (
 		fileMeta             string
+		fileInfo             string
+		category             string
+		fileSize             int64
 		autoPassword         bool
 		countText, countFile int
 	)
-	data := &IndexData{MaxSize: p.Settings.Size}
+	data := newIndexData(p.Settings)
 	vd := &validUploadData{code: http.StatusBadRequest}
 	if p.Request.Method != "POST" {
 		data.Error = "failed HTTP method"
 		vd.code = http.StatusMethodNotAllowed
 		return vd, failedUpload(w, vd.code, data, p, isAPI)
 	}
+	exceeded, err := quotaExceeded(ctx, w, p, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if exceeded {
+		data.Error = "daily uploads quota is exceeded"
+		vd.code = http.StatusTooManyRequests
+		return vd, failedUpload(w, vd.code, data, p, isAPI)
+	}
+	if p.Storage.IsThrottled() {
+		data.Error = "storage is almost full, try again later"
+		vd.code = http.StatusServiceUnavailable
+		w.Header().Set("Retry-After", strconv.Itoa(p.Settings.GC))
+		return vd, failedUpload(w, vd.code, data, p, isAPI)
+	}
+	// optional request body digest to detect corruption by proxies
+	digest, err := newBodyDigest(p.Request)
+	if err != nil {
+		data.Error = err.Error()
+		return vd, failedUpload(w, vd.code, data, p, isAPI)
+	}
+	if digest != nil {
+		p.Request.Body = digest
+	}
+	// multipart form data, big files are stored in temporary files
+	err = p.Request.ParseMultipartForm(p.Settings.MultipartMemoryBytes())
+	if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		data.Error = "failed form parsing"
+		p.Log.Error("%s: %v", data.Error, err)
+		return vd, failedUpload(w, vd.code, data, p, isAPI)
+	}
+	defer func() {
+		if form := p.Request.MultipartForm; form != nil {
+			if e := form.RemoveAll(); e != nil {
+				p.Log.Error("remove multipart form temporary files: %v", e)
+			}
+		}
+	}()
+	if digest != nil {
+		if err = digest.verify(); err != nil {
+			data.Error = errDigest.Error()
+			p.Log.Info("%s: %v", data.Error, err)
+			return vd, failedUpload(w, vd.code, data, p, isAPI)
+		}
+	}
 	// file
 	f, h, err := p.Request.FormFile("file")
 	if err != nil {
-		if !errors.Is(err, http.ErrMissingFile) {
+		if !isMissingFile(err) {
 			data.Error = "failed file upload"
 			return vd, failedUpload(w, vd.code, data, p, isAPI)
 		}
 		// ErrMissingFile will be checked later with text-field
 	} else {
-		err = p.Storage.Limit(h.Size)
+		contentType := h.Header.Get("Content-Type")
+		if ct := p.Request.PostFormValue("content_type"); ct != "" {
+			contentType, err = p.Settings.OverrideType(ct)
+			if err != nil {
+				data.Error = "not allowed content type override"
+				p.Log.Info("%s: %v", data.Error, err)
+				return vd, failedUpload(w, vd.code, data, p, isAPI)
+			}
+		}
+		if !p.Settings.IsAllowedType(contentType) {
+			data.Error = "not allowed file content type"
+			return vd, failedUpload(w, vd.code, data, p, isAPI)
+		}
+		fileName, err := cleanFileName(h.Filename, p.Settings.MaxNameLength())
 		if err != nil {
-			data.Error = "no space in file storage"
-			p.Log.Error("%s: %v", data.Error, err)
+			data.Error = err.Error()
+			return vd, failedUpload(w, vd.code, data, p, isAPI)
+		}
+		fileSize = h.Size
+		fm := &FileMeta{Name: fileName, Size: fileSize, ContentType: contentType}
+		category = (&FileInfo{ContentType: contentType}).Category()
+		switch disposition := p.Request.PostFormValue("disposition"); disposition {
+		case "", attachmentDisposition:
+		case inlineDisposition:
+			if !fm.IsInlineAllowed() {
+				data.Error = "inline disposition is not allowed for the content type"
+				return vd, failedUpload(w, vd.code, data, p, isAPI)
+			}
+			fm.Disposition = disposition
+		default:
+			data.Error = "incorrect disposition"
 			return vd, failedUpload(w, vd.code, data, p, isAPI)
 		}
-		fm := &FileMeta{Name: h.Filename, Size: h.Size, ContentType: h.Header.Get("Content-Type")}
 		fileMeta, err = fm.Encode()
 		if err != nil {
 			return nil, err
 		}
+		if p.Settings.PublicFileInfo {
+			info := fm.Info()
+			if p.Request.PostFormValue("hide_size") == "true" {
+				// the sender's choice wins over the global setting
+				info.Size = 0
+			}
+			fileInfo, err = info.Encode()
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 	defer func() {
 		if e := p.Request.Body.Close(); e != nil {
@@ -108,39 +351,135 @@ func validateUpload(w http.ResponseWriter, p *Params, isAPI bool) (*validUploadD
 		}
 	}()
 	// text
-	text := p.Request.PostFormValue("text")
-	if fileMeta == "" && text == "" {
+	text, textFile, textSize, err := readText(p)
+	if errors.Is(err, errTextSize) {
+		vd.code, data.Error = http.StatusRequestEntityTooLarge, err.Error()
+		return vd, failedUpload(w, vd.code, data, p, isAPI)
+	}
+	if err != nil {
+		data.Error = "failed text upload"
+		p.Log.Error("%s: %v", data.Error, err)
+		return vd, failedUpload(w, vd.code, data, p, isAPI)
+	}
+	if textFile != nil {
+		defer func() {
+			if e := textFile.Close(); e != nil {
+				p.Log.Error("close incoming text file: %v", e)
+			}
+		}()
+	}
+	if text != "" && !p.Settings.IsInlineText(int64(len(text))) {
+		// big text is stored to a file as a streamed one
+		textSize = int64(len(text))
+	}
+	hasText := text != "" || textFile != nil
+	if fileMeta == "" && !hasText {
 		data.Error = "empty text and file fields"
 		return vd, failedUpload(w, vd.code, data, p, isAPI)
 	}
-	// ttl
-	ttl, err := validateInt("TTL", p.Request.PostFormValue("ttl"), p.Settings.TTL)
+	if fileMeta != "" && hasText && !p.Settings.IsMixedAllowed() {
+		data.Error = "text and file can not be uploaded together"
+		return vd, failedUpload(w, vd.code, data, p, isAPI)
+	}
+	// expected checksum of the file, it's verified after encryption
+	checksum, err := parseChecksum(p.Request.PostFormValue("checksum"))
+	if err != nil {
+		data.Error = err.Error()
+		return vd, failedUpload(w, vd.code, data, p, isAPI)
+	}
+	if checksum != "" && fileMeta == "" {
+		data.Error = "checksum requires a file"
+		return vd, failedUpload(w, vd.code, data, p, isAPI)
+	}
+	// ttl, its default and max values depend on the file category
+	ttl, maxTTL := p.Settings.CategoryTTL(category)
+	if value := p.Request.PostFormValue("ttl"); value != "" || ttl == 0 {
+		ttl, err = validateInt("TTL", value, maxTTL)
+		if err != nil {
+			data.Error = "incorrect TTL"
+			return vd, failedUpload(w, vd.code, data, p, isAPI)
+		}
+	}
+	// times, every part of one-time item can be read only once
+	times := 1
+	oneTime := p.Request.PostFormValue("one_time") == "true"
+	if !oneTime {
+		times, err = validateInt("times", p.Request.PostFormValue("times"), timesLimit(p.Settings, hasText, fileMeta != ""))
+		if err != nil {
+			data.Error = "incorrect times"
+			return vd, failedUpload(w, vd.code, data, p, isAPI)
+		}
+	}
+	// pending item is not readable until confirmation
+	pending := p.Request.PostFormValue("pending") == "true"
+	if pending && p.Settings.ConfirmWindow == 0 {
+		data.Error = "pending uploads are disabled"
+		return vd, failedUpload(w, vd.code, data, p, isAPI)
+	}
+	if pending && p.Request.PostFormValue("notify_email") != "" {
+		// the recipient would get a link which is not readable yet
+		data.Error = "pending upload can not be notified"
+		return vd, failedUpload(w, vd.code, data, p, isAPI)
+	}
+	// password hint, it is public
+	hint := strings.TrimSpace(p.Request.PostFormValue("hint"))
+	if utf8.RuneCountInString(hint) > maxHintLength {
+		data.Error = fmt.Sprintf("too long hint, max length is %d", maxHintLength)
+		return vd, failedUpload(w, vd.code, data, p, isAPI)
+	}
+	// client networks restriction, it is not encrypted
+	allowedIPs, err := db.ParseAllowedIPs(p.Request.PostFormValue("allowed_ips"))
 	if err != nil {
-		data.Error = "incorrect TTL"
+		data.Error = err.Error()
 		return vd, failedUpload(w, vd.code, data, p, isAPI)
 	}
-	// times
-	times, err := validateInt("times", p.Request.PostFormValue("times"), p.Settings.Times)
+	// recipient notification, only URL is sent
+	notifyEmail, err := validateNotify(p)
 	if err != nil {
-		data.Error = "incorrect times"
+		data.Error = err.Error()
+		if errors.Is(err, notify.ErrLimit) {
+			vd.code = http.StatusTooManyRequests
+		}
 		return vd, failedUpload(w, vd.code, data, p, isAPI)
 	}
 	// password
 	password := p.Request.PostFormValue("password")
 	if password == "" {
+		if p.Settings.RequirePassword {
+			data.Error = "empty password"
+			return vd, failedUpload(w, vd.code, data, p, isAPI)
+		}
 		// auto generation
 		password = pwgen.New(p.Settings.PassLen)
 		autoPassword = true
 	}
+	// storage directory for the file and text files, it's reserved after all validations
+	var storageDir string
+	if fileMeta != "" || textSize > 0 {
+		storageDir, err = p.Storage.Place(fileSize + textSize)
+		if err != nil {
+			if !errors.Is(err, cfg.ErrStorageLimit) && !errors.Is(err, cfg.ErrNoSpace) {
+				return nil, fmt.Errorf("storage placement: %w", err)
+			}
+			data.Error = "no space in file storage"
+			p.Log.Error("%s: %v", data.Error, err)
+			vd.code = noSpaceCode(ctx, w, p)
+			return vd, failedUpload(w, vd.code, data, p, isAPI)
+		}
+	}
 	// db item prepare
 	switch {
 	case fileMeta == "":
 		countText, countFile = times, 0
-	case text == "":
+	case !hasText:
 		countText, countFile = 0, times
 	default:
 		countText, countFile = times, times
 	}
+	if fileMeta != "" && p.Request.PostFormValue("burn_file_first") == "true" {
+		// file is deleted after the first download, but text is available
+		countFile = 1
+	}
 	now := time.Now().UTC()
 	item := &db.Item{
 		Key:          p.Log.ID,
@@ -152,22 +491,72 @@ func validateUpload(w http.ResponseWriter, p *Params, isAPI bool) (*validUploadD
 		Created:      now,
 		Updated:      now,
 		Expired:      now.Add(time.Duration(ttl) * time.Second),
-		Storage:      p.Storage.Dir,
+		OneTime:      oneTime,
+		Hint:         hint,
+		FileInfo:     fileInfo,
+		AllowedIPs:   allowedIPs,
+		Storage:      storageDir,
 		AutoPassword: autoPassword,
 	}
+	switch {
+	case textFile != nil:
+		item.TextSrc = textFile
+	case textSize > 0:
+		item.TextSrc = strings.NewReader(text)
+	}
 	err = item.Encrypt(password, f)
 	if err != nil {
+		if storageDir != "" {
+			p.Storage.Release(storageDir, fileSize+textSize)
+		}
+		if errors.Is(err, encrypt.ErrStorageFull) {
+			data.Error = "no space in file storage"
+			vd.code = http.StatusInsufficientStorage
+			p.Log.Error("%s: %v", data.Error, err)
+			return vd, failedUpload(w, vd.code, data, p, isAPI)
+		}
+		if errors.Is(err, encrypt.ErrFileCreate) {
+			data.Error = "failed storage file creation"
+			vd.code = http.StatusInternalServerError
+			p.Log.Error("%s: %v", data.Error, err)
+			return vd, failedUpload(w, vd.code, data, p, isAPI)
+		}
 		return nil, fmt.Errorf("failed encryption: %w", err)
 	}
+	if fileMeta != "" && item.FileSize != fileSize {
+		// stored file meta size must be equal to the real plaintext size
+		discardItem(p, item, fileSize+textSize)
+		return nil, fmt.Errorf("encrypted file size=%d differs from uploaded=%d", item.FileSize, fileSize)
+	}
+	if checksum != "" && item.Checksum != checksum {
+		// received data is corrupted, it's not saved
+		discardItem(p, item, fileSize+textSize)
+		data.Error = "checksum mismatch"
+		vd.code = http.StatusUnprocessableEntity
+		return vd, failedUpload(w, vd.code, data, p, isAPI)
+	}
+	if storageDir != "" {
+		p.Storage.Reconcile(storageDir, fileSize+textSize, item.Stored)
+	}
 	vd.item = item
 	vd.code = http.StatusCreated
 	vd.password = password
+	vd.notify = notifyEmail
+	vd.pending = pending
 	return vd, nil
 }
 
+// discardItem removes files of not saved item and releases their storage size reserved for the item.
+func discardItem(p *Params, item *db.Item, reserved int64) {
+	if err := item.DeleteFiles(); err != nil {
+		p.Log.Error("delete files of item %s: %v", item.Key, err)
+	}
+	p.Storage.Release(item.Storage, reserved)
+}
+
 // uploadCommon is a handler for API and web upload methods.
 func uploadCommon(ctx context.Context, w http.ResponseWriter, p *Params, isAPI bool) (*UploadData, error) {
-	validData, err := validateUpload(w, p, isAPI)
+	validData, err := validateUpload(ctx, w, p, isAPI)
 	if err != nil {
 		return nil, err
 	}
@@ -176,15 +565,41 @@ func uploadCommon(ctx context.Context, w http.ResponseWriter, p *Params, isAPI b
 		// failed validation, it's already handled
 		return data, nil
 	}
+	if p.Settings.StoreOrigin {
+		// only for abuse investigation, plaintext values are not saved
+		validData.item.OriginIP = p.Settings.OriginHash(p.clientIP())
+		validData.item.OriginAgent = p.Settings.OriginHash(p.Request.UserAgent())
+	}
+	counters := UploadCounters{Text: validData.item.CountText, File: validData.item.CountFile}
+	if validData.pending {
+		if err = validData.item.SetPending(p.Settings.ConfirmPeriod()); err != nil {
+			discardItem(p, validData.item, validData.item.Stored)
+			return nil, err
+		}
+		data.ConfirmToken, data.ConfirmUntil = validData.item.ConfirmToken, &validData.item.ConfirmUntil
+	}
 	err = validData.item.Save(ctx, p.DB)
 	if err != nil {
+		discardItem(p, validData.item, validData.item.Stored)
 		return nil, err
 	}
+	if p.Settings.DailyQuota > 0 {
+		if e := db.AddQuota(ctx, p.DB, p.Settings.OriginHash(p.clientIP()), time.Now()); e != nil {
+			p.Log.Error("add upload quota: %v", e)
+		}
+	}
 	if !validData.item.AutoPassword {
 		data.Password = "*****"
 		data.PwdDisable = true
 	}
 	data.URL = validData.item.GetURL(p.Request, p.Secure).String()
+	if validData.notify != "" {
+		p.Mailer.Notify(validData.notify, data.URL, p.Log)
+	}
+	data.HasText, data.HasFile = counters.Text > 0, counters.File > 0
+	data.Mixed = data.HasText && data.HasFile
+	data.Counters = counters
+	data.ExpiresAt = validData.item.Expired
 	return data, nil
 }
 
@@ -197,9 +612,9 @@ func uploadHandler(ctx context.Context, w http.ResponseWriter, p *Params) (int,
 	if !data.isValid() {
 		return data.code, nil
 	}
-	err = p.Settings.Tpl[cfg.UploadTpl].ExecuteTemplate(w, cfg.UploadTpl, data)
+	err = executeTemplate(w, p, cfg.UploadTpl, data)
 	if err != nil {
-		return http.StatusInternalServerError, fmt.Errorf("failed execute template=%s: %w", cfg.UploadTpl, err)
+		return http.StatusInternalServerError, err
 	}
 	return data.code, nil
 }
@@ -0,0 +1,1304 @@
+package handle
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/z0rr0/send/cfg"
+	"github.com/z0rr0/send/db"
+	"github.com/z0rr0/send/encrypt"
+	"github.com/z0rr0/send/logging"
+	"github.com/z0rr0/send/notify"
+)
+
+// memoryParams returns handling params with in-memory database and files storage.
+func memoryParams(t *testing.T, files *encrypt.MemoryStorage) func(r *http.Request) *Params {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	database, err := db.NewMemory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypt.SetUpStorage(files)
+	t.Cleanup(func() {
+		encrypt.SetUpStorage(encrypt.DiskStorage{})
+		if e := database.Close(); e != nil {
+			t.Error(e)
+		}
+	})
+	storage := cfg.NewMemoryStorage(database, 10)
+	delItem := make(chan db.Item, 10)
+	return func(r *http.Request) *Params {
+		p := testParams(t, r)
+		// new item key is a request ID
+		p.Log, p.DB, p.Storage, p.DelItem = logging.New(""), database, storage, delItem
+		return p
+	}
+}
+
+// postForm returns a new POST request with url-encoded form values.
+func postForm(target string, values url.Values) *http.Request {
+	r := httptest.NewRequest("POST", target, strings.NewReader(values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+// multipartRequest returns a new upload request with multipart form fields,
+// the file part is added only if fileName is not empty, its default content type is application/octet-stream.
+func multipartRequest(t *testing.T, fields map[string]string, fileName, contentType, content string) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, value := range fields {
+		if err := mw.WriteField(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fileName != "" {
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, fileName))
+		h.Set("Content-Type", contentType)
+		part, err := mw.CreatePart(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = part.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("POST", "/api/upload", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+// uploadMultipart uploads fields and the file with name and content by multipart form, it returns the new item key.
+func uploadMultipart(t *testing.T, params func(r *http.Request) *Params, fields map[string]string, fileName, content string) string {
+	r := multipartRequest(t, fields, fileName, "", content)
+	w := httptest.NewRecorder()
+	if code := Main(r.Context(), w, params(r)); code != http.StatusCreated {
+		t.Fatalf("failed upload code=%d: %s", code, w.Body.String())
+	}
+	data := &UploadData{}
+	if err := json.NewDecoder(w.Body).Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	return data.Key()
+}
+
+func TestMain_UploadDownload(t *testing.T) {
+	const (
+		password    = "secret"
+		text        = "some text"
+		fileContent = "file content"
+	)
+	files := encrypt.NewMemoryStorage()
+	params := memoryParams(t, files)
+
+	// upload
+	fields := map[string]string{"text": text, "ttl": "3600", "times": "2", "password": password}
+	r := multipartRequest(t, fields, "test.txt", "", fileContent)
+	w := httptest.NewRecorder()
+	if code := Main(r.Context(), w, params(r)); code != http.StatusCreated {
+		t.Fatalf("failed upload code=%d: %s", code, w.Body.String())
+	}
+	data := &UploadData{}
+	if err := json.NewDecoder(w.Body).Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(data.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := path.Base(u.Path)
+	if !data.HasText || !data.HasFile || !data.Mixed || data.Counters != (UploadCounters{Text: 2, File: 2}) {
+		t.Errorf("failed content flags: %+v", data)
+	}
+	if d := time.Until(data.ExpiresAt); d <= 0 || d > time.Hour {
+		t.Errorf("failed expiration time=%v", data.ExpiresAt)
+	}
+	if n := files.Len(); n != 1 {
+		t.Errorf("failed number of stored files=%d", n)
+	}
+	// text and file meta
+	r = postForm("/api/text", url.Values{"key": {key}, "password": {password}})
+	w = httptest.NewRecorder()
+	if code := Main(r.Context(), w, params(r)); code != http.StatusOK {
+		t.Fatalf("failed text code=%d: %s", code, w.Body.String())
+	}
+	textMeta := &TextMeta{}
+	if err = json.NewDecoder(w.Body).Decode(textMeta); err != nil {
+		t.Fatal(err)
+	}
+	if textMeta.Text != text || textMeta.File == nil || textMeta.File.Name != "test.txt" {
+		t.Errorf("failed text meta: %+v", textMeta)
+	}
+	// file
+	r = postForm("/file", url.Values{"key": {key}, "password": {password}})
+	w = httptest.NewRecorder()
+	if code := Main(r.Context(), w, params(r)); code != http.StatusOK {
+		t.Fatalf("failed file code=%d: %s", code, w.Body.String())
+	}
+	if s := w.Body.String(); s != fileContent {
+		t.Errorf("failed file content=%s", s)
+	}
+	// failed password
+	r = postForm("/file", url.Values{"key": {key}, "password": {"bad"}})
+	w = httptest.NewRecorder()
+	if code := Main(r.Context(), w, params(r)); code != http.StatusBadRequest {
+		t.Errorf("failed code=%d", code)
+	}
+}
+
+func TestUploadAPIHandler_Notify(t *testing.T) {
+	params := memoryParams(t, encrypt.NewMemoryStorage())
+	mailer := notify.New("localhost", 1, "", "", "send@localhost", 1)
+	cases := []struct {
+		email  string
+		mailer *notify.Mailer
+		code   int
+	}{
+		{email: "user@example.com", code: http.StatusBadRequest},
+		{email: "User <user@example.com>", mailer: mailer, code: http.StatusBadRequest},
+		{email: "user@example.com", mailer: mailer, code: http.StatusCreated},
+		{email: "user@example.com", mailer: mailer, code: http.StatusTooManyRequests},
+	}
+	for i, c := range cases {
+		r := postForm("/api/upload", url.Values{"text": {"text"}, "ttl": {"600"}, "times": {"1"}, "notify_email": {c.email}})
+		w := httptest.NewRecorder()
+		p := params(r)
+		p.Mailer = c.mailer
+		if code := Main(r.Context(), w, p); code != c.code {
+			t.Errorf("case=%d: failed code=%d: %s", i, code, w.Body.String())
+		}
+	}
+	// sending to not existing SMTP server is failed, the error is only logged
+	mailer.Wait()
+}
+
+func TestUploadAPIHandler_FileName(t *testing.T) {
+	const password = "secret"
+	params := memoryParams(t, encrypt.NewMemoryStorage())
+	cases := []struct {
+		name     string
+		expected string
+		code     int
+	}{
+		{name: "../../etc/passwd", expected: "passwd", code: http.StatusCreated},
+		{name: strings.Repeat("a", 256), code: http.StatusBadRequest},
+	}
+	fields := map[string]string{"text": "text", "ttl": "600", "times": "1", "password": password}
+	for i, c := range cases {
+		if c.code != http.StatusCreated {
+			r := multipartRequest(t, fields, c.name, "", "content")
+			w := httptest.NewRecorder()
+			if code := Main(r.Context(), w, params(r)); code != c.code {
+				t.Errorf("case=%d: failed code=%d: %s", i, code, w.Body.String())
+			}
+			continue
+		}
+		key := uploadMultipart(t, params, fields, c.name, "content")
+		r := postForm("/api/text", url.Values{"key": {key}, "password": {password}})
+		w := httptest.NewRecorder()
+		if code := Main(r.Context(), w, params(r)); code != http.StatusOK {
+			t.Fatalf("case=%d: failed text code=%d", i, code)
+		}
+		textMeta := &TextMeta{}
+		if err := json.NewDecoder(w.Body).Decode(textMeta); err != nil {
+			t.Fatal(err)
+		}
+		if textMeta.File == nil || textMeta.File.Name != c.expected {
+			t.Errorf("case=%d: failed file meta: %+v", i, textMeta.File)
+		}
+	}
+}
+
+func TestTextAPIHandler_Undo(t *testing.T) {
+	const password = "secret"
+	params := memoryParams(t, encrypt.NewMemoryStorage())
+	r := postForm("/api/upload", url.Values{"text": {"text"}, "ttl": {"600"}, "one_time": {"true"}, "password": {password}})
+	w := httptest.NewRecorder()
+	if code := Main(r.Context(), w, params(r)); code != http.StatusCreated {
+		t.Fatalf("failed upload code=%d: %s", code, w.Body.String())
+	}
+	data := &UploadData{}
+	if err := json.NewDecoder(w.Body).Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	key := path.Base(data.URL)
+	read := func(cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		r := postForm("/api/text", url.Values{"key": {key}, "password": {password}})
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		p := params(r)
+		p.Settings.UndoWindow = 60
+		Main(r.Context(), w, p)
+		return w
+	}
+	w = read()
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed code=%d: %s", w.Code, w.Body.String())
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != undoCookiePrefix+key || !cookies[0].HttpOnly {
+		t.Fatalf("failed cookies: %v", cookies)
+	}
+	// other clients don't have the token
+	if w = read(); w.Code != http.StatusNotFound {
+		t.Errorf("failed code=%d", w.Code)
+	}
+	if w = read(&http.Cookie{Name: undoCookiePrefix + key, Value: "bad"}); w.Code != http.StatusNotFound {
+		t.Errorf("failed code=%d", w.Code)
+	}
+	w = read(cookies[0])
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed code=%d: %s", w.Code, w.Body.String())
+	}
+	textMeta := &TextMeta{}
+	if err := json.NewDecoder(w.Body).Decode(textMeta); err != nil {
+		t.Fatal(err)
+	}
+	if textMeta.Text != "text" {
+		t.Errorf("failed text=%s", textMeta.Text)
+	}
+}
+
+func TestDownloadHandler_NoDecrement(t *testing.T) {
+	const password = "secret"
+	params := memoryParams(t, encrypt.NewMemoryStorage())
+	r := postForm("/api/upload", url.Values{"text": {"text"}, "ttl": {"600"}, "one_time": {"true"}, "password": {password}})
+	w := httptest.NewRecorder()
+	if code := Main(r.Context(), w, params(r)); code != http.StatusCreated {
+		t.Fatalf("failed upload code=%d: %s", code, w.Body.String())
+	}
+	data := &UploadData{}
+	if err := json.NewDecoder(w.Body).Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	key := path.Base(data.URL)
+	query := url.Values{"key": {key}, "password": {password}}.Encode()
+	targets := map[string]int{
+		"/" + key:            http.StatusOK,
+		"/file?" + query:     http.StatusMethodNotAllowed,
+		"/api/text?" + query: http.StatusMethodNotAllowed,
+	}
+	for i := 0; i < 3; i++ {
+		for target, expected := range targets {
+			for _, method := range []string{"GET", "HEAD"} {
+				r = httptest.NewRequest(method, target, nil)
+				w = httptest.NewRecorder()
+				if code := Main(r.Context(), w, params(r)); code != expected {
+					t.Errorf("failed %s %s code=%d", method, target, code)
+				}
+			}
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	item, err := db.Exists(ctx, params(r).DB, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.CountText != 1 {
+		t.Errorf("failed text counter=%d", item.CountText)
+	}
+	// the only attempt is still available
+	r = postForm("/api/text", url.Values{"key": {key}, "password": {password}})
+	w = httptest.NewRecorder()
+	if code := Main(r.Context(), w, params(r)); code != http.StatusOK {
+		t.Errorf("failed text code=%d: %s", code, w.Body.String())
+	}
+}
+
+func TestTextAPIHandler_AllowedIPs(t *testing.T) {
+	const password = "secret"
+	params := memoryParams(t, encrypt.NewMemoryStorage())
+	r := postForm("/api/upload", url.Values{"text": {"text"}, "ttl": {"600"}, "allowed_ips": {"bad"}})
+	w := httptest.NewRecorder()
+	if code := Main(r.Context(), w, params(r)); code != http.StatusBadRequest {
+		t.Errorf("failed code=%d", code)
+	}
+	values := url.Values{"text": {"text"}, "ttl": {"600"}, "times": {"2"}, "password": {password}, "allowed_ips": {"10.0.0.0/8"}}
+	r = postForm("/api/upload", values)
+	w = httptest.NewRecorder()
+	if code := Main(r.Context(), w, params(r)); code != http.StatusCreated {
+		t.Fatalf("failed upload code=%d: %s", code, w.Body.String())
+	}
+	data := &UploadData{}
+	if err := json.NewDecoder(w.Body).Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	key := path.Base(data.URL)
+	for _, target := range []string{"/api/verify", "/api/extend", "/api/rotate"} {
+		values := url.Values{"key": {key}, "password": {password}, "times": {"1"}, "new_password": {password}}
+		r = postForm(target, values)
+		r.RemoteAddr = "192.0.2.1:1234"
+		w = httptest.NewRecorder()
+		if code := Main(r.Context(), w, params(r)); code != http.StatusForbidden {
+			t.Errorf("failed %s code=%d: %s", target, code, w.Body.String())
+		}
+	}
+	for _, c := range []struct {
+		remoteAddr string
+		forwarded  string
+		code       int
+	}{
+		{remoteAddr: "192.0.2.1:1234", code: http.StatusForbidden},
+		{remoteAddr: "192.0.2.1:1234", code: http.StatusForbidden},
+		{remoteAddr: "192.0.2.1:1234", forwarded: "10.1.2.3", code: http.StatusForbidden},
+		{remoteAddr: "127.0.0.1:1234", forwarded: "192.0.2.1", code: http.StatusForbidden},
+		{remoteAddr: "10.1.2.3:1234", code: http.StatusOK},
+		{remoteAddr: "@", forwarded: "192.0.2.1, 10.1.2.3", code: http.StatusOK},
+	} {
+		r = postForm("/api/text", url.Values{"key": {key}, "password": {password}})
+		r.RemoteAddr = c.remoteAddr
+		if c.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		w = httptest.NewRecorder()
+		if code := Main(r.Context(), w, params(r)); code != c.code {
+			t.Errorf("failed code=%d for %s: %s", code, c.remoteAddr, w.Body.String())
+		}
+	}
+}
+
+func TestDownloadHandler_RevealExpiry(t *testing.T) {
+	const password = "secret"
+	params := memoryParams(t, encrypt.NewMemoryStorage())
+	r := postForm("/api/upload", url.Values{"text": {"text"}, "ttl": {"600"}, "times": {"1"}, "password": {password}})
+	w := httptest.NewRecorder()
+	if code := Main(r.Context(), w, params(r)); code != http.StatusCreated {
+		t.Fatalf("failed upload code=%d: %s", code, w.Body.String())
+	}
+	data := &UploadData{}
+	if err := json.NewDecoder(w.Body).Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	key := path.Base(data.URL)
+	r = postForm("/api/text", url.Values{"key": {key}, "password": {password}})
+	w = httptest.NewRecorder()
+	if code := Main(r.Context(), w, params(r)); code != http.StatusOK {
+		t.Fatalf("failed text code=%d", code)
+	}
+	cases := []struct {
+		key    string
+		reveal bool
+		code   int
+	}{
+		{key: key, code: http.StatusNotFound},
+		{key: key, reveal: true, code: http.StatusGone},
+		{key: "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee", reveal: true, code: http.StatusNotFound},
+	}
+	for i, c := range cases {
+		for _, target := range []string{"/" + c.key, "/api/text", "/file", "/api/verify", "/api/extend", "/api/rotate"} {
+			values := url.Values{"key": {c.key}, "password": {password}, "times": {"1"}, "new_password": {"new"}}
+			r = postForm(target, values)
+			w = httptest.NewRecorder()
+			p := params(r)
+			p.Settings.RevealExpiry = c.reveal
+			if code := Main(r.Context(), w, p); code != c.code {
+				t.Errorf("case=%d: failed %s code=%d", i, target, code)
+			}
+		}
+	}
+}
+
+func TestRotateAPIHandler(t *testing.T) {
+	const password, newPassword = "secret", "new secret"
+	params := memoryParams(t, encrypt.NewMemoryStorage())
+	r := postForm("/api/upload", url.Values{"text": {"text"}, "ttl": {"600"}, "times": {"2"}, "password": {password}})
+	w := httptest.NewRecorder()
+	if code := Main(r.Context(), w, params(r)); code != http.StatusCreated {
+		t.Fatalf("failed upload code=%d: %s", code, w.Body.String())
+	}
+	data := &UploadData{}
+	if err := json.NewDecoder(w.Body).Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	key := path.Base(data.URL)
+	cases := []struct {
+		values url.Values
+		code   int
+	}{
+		{values: url.Values{"key": {key}, "password": {password}}, code: http.StatusBadRequest},
+		{values: url.Values{"key": {key}, "password": {"bad"}, "new_password": {newPassword}}, code: http.StatusBadRequest},
+		{values: url.Values{"key": {uuid.New().String()}, "password": {password}, "new_password": {newPassword}}, code: http.StatusNotFound},
+		{values: url.Values{"key": {key}, "password": {password}, "new_password": {newPassword}}, code: http.StatusOK},
+	}
+	for i, c := range cases {
+		r = postForm("/api/rotate", c.values)
+		w = httptest.NewRecorder()
+		if code := Main(r.Context(), w, params(r)); code != c.code {
+			t.Errorf("case=%d: failed code=%d: %s", i, code, w.Body.String())
+		}
+	}
+	result := &ExtendResult{}
+	if err := json.NewDecoder(w.Body).Decode(result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Text != 2 {
+		t.Errorf("failed text counter=%d", result.Text)
+	}
+	for pass, code := range map[string]int{password: http.StatusBadRequest, newPassword: http.StatusOK} {
+		r = postForm("/api/text", url.Values{"key": {key}, "password": {pass}})
+		w = httptest.NewRecorder()
+		if c := Main(r.Context(), w, params(r)); c != code {
+			t.Errorf("failed text code=%d for password=%s", c, pass)
+		}
+	}
+}
+
+func TestUploadAPIHandler_TrimText(t *testing.T) {
+	params := memoryParams(t, encrypt.NewMemoryStorage())
+	cases := []struct {
+		text     string
+		trim     bool
+		code     int
+		expected string
+	}{
+		{text: " \n\t ", code: http.StatusCreated, expected: " \n\t "},
+		{text: " \n\t ", trim: true, code: http.StatusBadRequest},
+		{text: "  text\n", code: http.StatusCreated, expected: "  text\n"},
+		{text: "  text\n", trim: true, code: http.StatusCreated, expected: "text"},
+	}
+	for i, c := range cases {
+		r := postForm("/api/upload", url.Values{"text": {c.text}, "ttl": {"600"}, "times": {"1"}, "password": {"secret"}})
+		w := httptest.NewRecorder()
+		p := params(r)
+		p.Settings.TrimText = c.trim
+		if code := Main(r.Context(), w, p); code != c.code {
+			t.Errorf("case=%d: failed upload code=%d: %s", i, code, w.Body.String())
+			continue
+		}
+		if c.code != http.StatusCreated {
+			if !strings.Contains(w.Body.String(), "empty text and file fields") {
+				t.Errorf("case=%d: failed error: %s", i, w.Body.String())
+			}
+			continue
+		}
+		data := &UploadData{}
+		if err := json.NewDecoder(w.Body).Decode(data); err != nil {
+			t.Fatal(err)
+		}
+		r = postForm("/api/text", url.Values{"key": {path.Base(data.URL)}, "password": {"secret"}})
+		w = httptest.NewRecorder()
+		if code := Main(r.Context(), w, params(r)); code != http.StatusOK {
+			t.Fatalf("case=%d: failed text code=%d: %s", i, code, w.Body.String())
+		}
+		textMeta := &TextMeta{}
+		if err := json.NewDecoder(w.Body).Decode(textMeta); err != nil {
+			t.Fatal(err)
+		}
+		if textMeta.Text != c.expected {
+			t.Errorf("case=%d: failed text=%q", i, textMeta.Text)
+		}
+	}
+}
+
+func TestConfirmAPIHandler(t *testing.T) {
+	const password = "secret"
+	params := memoryParams(t, encrypt.NewMemoryStorage())
+	values := url.Values{"text": {"text"}, "ttl": {"600"}, "times": {"2"}, "password": {password}, "pending": {"true"}}
+	r := postForm("/api/upload", values)
+	w := httptest.NewRecorder()
+	if code := Main(r.Context(), w, params(r)); code != http.StatusBadRequest {
+		t.Errorf("failed code=%d for disabled pending uploads", code)
+	}
+	r = postForm("/api/upload", values)
+	w = httptest.NewRecorder()
+	p := params(r)
+	p.Settings.ConfirmWindow = 600
+	if code := Main(r.Context(), w, p); code != http.StatusCreated {
+		t.Fatalf("failed upload code=%d: %s", code, w.Body.String())
+	}
+	data := &UploadData{}
+	if err := json.NewDecoder(w.Body).Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	if data.ConfirmToken == "" || data.ConfirmUntil == nil || data.Counters.Text != 2 {
+		t.Fatalf("failed pending upload data: %+v", data)
+	}
+	key := path.Base(data.URL)
+	read := func() int {
+		r = postForm("/api/text", url.Values{"key": {key}, "password": {password}})
+		w = httptest.NewRecorder()
+		return Main(r.Context(), w, params(r))
+	}
+	if code := read(); code != http.StatusNotFound {
+		t.Errorf("failed code=%d for pending item", code)
+	}
+	cases := []struct {
+		values url.Values
+		code   int
+	}{
+		{values: url.Values{"key": {key}}, code: http.StatusBadRequest},
+		{values: url.Values{"key": {"bad"}, "token": {data.ConfirmToken}}, code: http.StatusBadRequest},
+		{values: url.Values{"key": {key}, "token": {"bad"}}, code: http.StatusNotFound},
+		{values: url.Values{"key": {key}, "token": {data.ConfirmToken}}, code: http.StatusOK},
+	}
+	for i, c := range cases {
+		r = postForm("/api/confirm", c.values)
+		w = httptest.NewRecorder()
+		if code := Main(r.Context(), w, params(r)); code != c.code {
+			t.Errorf("case=%d: failed code=%d: %s", i, code, w.Body.String())
+		}
+	}
+	result := &ExtendResult{}
+	if err := json.NewDecoder(w.Body).Decode(result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Text != 2 {
+		t.Errorf("failed text counter=%d", result.Text)
+	}
+	if code := read(); code != http.StatusOK {
+		t.Errorf("failed code=%d for confirmed item", code)
+	}
+	// web page contains the confirmation form
+	r = postForm("/upload", values)
+	w = httptest.NewRecorder()
+	p = params(r)
+	p.Settings.ConfirmWindow = 600
+	if code := Main(r.Context(), w, p); code != http.StatusCreated {
+		t.Fatalf("failed web upload code=%d: %s", code, w.Body.String())
+	}
+	if body := w.Body.String(); !strings.Contains(body, "name=\"token\" value=\"") || !strings.Contains(body, "Activate") {
+		t.Errorf("failed confirmation form: %s", body)
+	}
+}
+
+func TestUploadAPIHandler_Times(t *testing.T) {
+	params := memoryParams(t, encrypt.NewMemoryStorage())
+	cases := []struct {
+		text, file bool
+		times      string
+		code       int
+	}{
+		{text: true, times: "5", code: http.StatusCreated},
+		{text: true, times: "6", code: http.StatusBadRequest},
+		{file: true, times: "2", code: http.StatusCreated},
+		{file: true, times: "3", code: http.StatusBadRequest},
+		{text: true, file: true, times: "2", code: http.StatusCreated},
+		{text: true, file: true, times: "3", code: http.StatusBadRequest},
+	}
+	for i, c := range cases {
+		fields := map[string]string{"ttl": "600", "times": c.times}
+		if c.text {
+			fields["text"] = "text"
+		}
+		fileName := ""
+		if c.file {
+			fileName = "test.txt"
+		}
+		r := multipartRequest(t, fields, fileName, "", "file content")
+		w := httptest.NewRecorder()
+		p := params(r)
+		p.Settings.MaxTextTimes, p.Settings.MaxFileTimes = 5, 2
+		if code := Main(r.Context(), w, p); code != c.code {
+			t.Errorf("case=%d: failed code=%d: %s", i, code, w.Body.String())
+		}
+	}
+}
+
+func TestUploadAPIHandler_MixedForbidden(t *testing.T) {
+	params := memoryParams(t, encrypt.NewMemoryStorage())
+	forbidden := false
+	for _, withText := range []bool{true, false} {
+		fields := map[string]string{"ttl": "600", "times": "1"}
+		if withText {
+			fields["text"] = "text"
+		}
+		r := multipartRequest(t, fields, "test.txt", "", "file content")
+		w := httptest.NewRecorder()
+		p := params(r)
+		p.Settings.AllowMixed = &forbidden
+		expected := http.StatusCreated
+		if withText {
+			expected = http.StatusBadRequest
+		}
+		if code := Main(r.Context(), w, p); code != expected {
+			t.Errorf("failed code=%d for text=%v: %s", code, withText, w.Body.String())
+		}
+	}
+}
+
+func TestUploadAPIHandler_StoreOrigin(t *testing.T) {
+	params := memoryParams(t, encrypt.NewMemoryStorage())
+	for _, store := range []bool{false, true} {
+		r := postForm("/api/upload", url.Values{"text": {"text"}, "ttl": {"600"}, "times": {"1"}})
+		r.Header.Set("User-Agent", "test-agent")
+		w := httptest.NewRecorder()
+		p := params(r)
+		p.Settings.StoreOrigin = store
+		if code := Main(r.Context(), w, p); code != http.StatusCreated {
+			t.Fatalf("failed upload code=%d: %s", code, w.Body.String())
+		}
+		data := &UploadData{}
+		if err := json.NewDecoder(w.Body).Decode(data); err != nil {
+			t.Fatal(err)
+		}
+		var originIP, originAgent string
+		err := p.DB.QueryRowContext(r.Context(), "SELECT `origin_ip`, `origin_agent` FROM `storage` WHERE `key`=?;", path.Base(data.URL)).
+			Scan(&originIP, &originAgent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !store {
+			if originIP != "" || originAgent != "" {
+				t.Errorf("origin is stored: %s, %s", originIP, originAgent)
+			}
+			continue
+		}
+		if originIP != p.Settings.OriginHash(p.clientIP()) || originAgent != p.Settings.OriginHash("test-agent") {
+			t.Errorf("failed origin: %s, %s", originIP, originAgent)
+		}
+		if strings.Contains(originIP, p.clientIP()) {
+			t.Error("plaintext IP address is stored")
+		}
+	}
+}
+
+func TestDownloadHandler_Confirm(t *testing.T) {
+	params := memoryParams(t, encrypt.NewMemoryStorage())
+	r := postForm("/api/upload", url.Values{"text": {"text"}, "ttl": {"600"}, "one_time": {"true"}})
+	w := httptest.NewRecorder()
+	if code := Main(r.Context(), w, params(r)); code != http.StatusCreated {
+		t.Fatalf("failed upload code=%d: %s", code, w.Body.String())
+	}
+	data := &UploadData{}
+	if err := json.NewDecoder(w.Body).Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	key := path.Base(data.URL)
+	for _, confirm := range []bool{false, true} {
+		r = httptest.NewRequest("GET", "/"+key, nil)
+		w = httptest.NewRecorder()
+		p := params(r)
+		p.Settings.RequireConfirm = confirm
+		if code := Main(r.Context(), w, p); code != http.StatusOK {
+			t.Fatalf("failed download page code=%d", code)
+		}
+		body := w.Body.String()
+		if strings.Contains(body, "reveal_button") != confirm || strings.Contains(body, "reveal_container_id\" hidden") != confirm {
+			t.Errorf("failed page for confirm=%v", confirm)
+		}
+		if !strings.Contains(body, "text_form") {
+			t.Errorf("reading form is absent for confirm=%v", confirm)
+		}
+		if !strings.Contains(body, "in 9 minutes</time>") {
+			t.Errorf("relative expiration time is absent for confirm=%v", confirm)
+		}
+	}
+	item, err := db.Exists(r.Context(), params(r).DB, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.CountText != 1 {
+		t.Errorf("failed text counter=%d", item.CountText)
+	}
+}
+
+func TestUploadAPIHandler_DailyQuota(t *testing.T) {
+	params := memoryParams(t, encrypt.NewMemoryStorage())
+	for i, expected := range []int{http.StatusCreated, http.StatusCreated, http.StatusTooManyRequests} {
+		r := postForm("/api/upload", url.Values{"text": {"text"}, "ttl": {"600"}, "times": {"1"}})
+		w := httptest.NewRecorder()
+		p := params(r)
+		p.Settings.DailyQuota = 2
+		if code := Main(r.Context(), w, p); code != expected {
+			t.Fatalf("upload=%d: failed code=%d: %s", i, code, w.Body.String())
+		}
+		retry := w.Header().Get("Retry-After")
+		if expected != http.StatusTooManyRequests {
+			if retry != "" {
+				t.Errorf("upload=%d: unexpected Retry-After=%s", i, retry)
+			}
+			continue
+		}
+		seconds, err := strconv.Atoi(retry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seconds < 1 || seconds > 24*3600 {
+			t.Errorf("failed Retry-After=%d", seconds)
+		}
+	}
+}
+
+func TestUploadAPIHandler_DailyQuotaForwarded(t *testing.T) {
+	params := memoryParams(t, encrypt.NewMemoryStorage())
+	cases := []struct {
+		remote    string
+		forwarded string
+		code      int
+	}{
+		{remote: "@", forwarded: "192.0.2.1", code: http.StatusCreated},
+		{remote: "@", forwarded: "192.0.2.1", code: http.StatusTooManyRequests},
+		// other clients behind the same proxy have own quotas
+		{remote: "@", forwarded: "192.0.2.2", code: http.StatusCreated},
+		{remote: "127.0.0.1:1234", forwarded: "192.0.2.3", code: http.StatusCreated},
+		// forwarded header is not trusted from remote clients
+		{remote: "192.0.2.1:1234", forwarded: "192.0.2.4", code: http.StatusTooManyRequests},
+	}
+	for i, c := range cases {
+		r := postForm("/api/upload", url.Values{"text": {"text"}, "ttl": {"600"}, "times": {"1"}})
+		r.RemoteAddr = c.remote
+		r.Header.Set("X-Forwarded-For", c.forwarded)
+		w := httptest.NewRecorder()
+		p := params(r)
+		p.Settings.DailyQuota = 1
+		if code := Main(r.Context(), w, p); code != c.code {
+			t.Errorf("case=%d: failed code=%d: %s", i, code, w.Body.String())
+		}
+	}
+}
+
+func TestStatusAPIHandler_Category(t *testing.T) {
+	params := memoryParams(t, encrypt.NewMemoryStorage())
+	r := multipartRequest(t, map[string]string{"ttl": "600", "times": "1"}, "photo.png", "image/png", "png content")
+	w := httptest.NewRecorder()
+	p := params(r)
+	p.Settings.PublicFileInfo = true
+	if code := Main(r.Context(), w, p); code != http.StatusCreated {
+		t.Fatalf("failed upload code=%d: %s", code, w.Body.String())
+	}
+	data := &UploadData{}
+	if err := json.NewDecoder(w.Body).Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	r = httptest.NewRequest("POST", "/api/status", strings.NewReader(fmt.Sprintf("[%q]", data.Key())))
+	w = httptest.NewRecorder()
+	if code := Main(r.Context(), w, params(r)); code != http.StatusOK {
+		t.Fatalf("failed status code=%d: %s", code, w.Body.String())
+	}
+	var statuses []ItemStatus
+	if err := json.NewDecoder(w.Body).Decode(&statuses); err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 1 || !statuses[0].Exists || statuses[0].Category != CategoryImage {
+		t.Errorf("failed statuses: %+v", statuses)
+	}
+}
+
+func TestUploadAPIHandler_Checksum(t *testing.T) {
+	const fileContent = "file content"
+	sum := sha256.Sum256([]byte(fileContent))
+	valid := hex.EncodeToString(sum[:])
+	cases := []struct {
+		checksum string
+		code     int
+	}{
+		{checksum: "sha256:" + valid, code: http.StatusCreated},
+		{checksum: "SHA256:" + strings.ToUpper(valid), code: http.StatusCreated},
+		{checksum: "sha256:" + strings.Repeat("0", 64), code: http.StatusUnprocessableEntity},
+		{checksum: "md5:" + valid[:32], code: http.StatusBadRequest},
+		{checksum: "sha256:bad", code: http.StatusBadRequest},
+	}
+	for i, c := range cases {
+		files := encrypt.NewMemoryStorage()
+		params := memoryParams(t, files)
+		fields := map[string]string{"ttl": "600", "times": "1", "checksum": c.checksum}
+		r := multipartRequest(t, fields, "test.txt", "", fileContent)
+		w := httptest.NewRecorder()
+		if code := Main(r.Context(), w, params(r)); code != c.code {
+			t.Errorf("case=%d: failed code=%d: %s", i, code, w.Body.String())
+		}
+		expected := 0
+		if c.code == http.StatusCreated {
+			expected = 1
+		}
+		if n := files.Len(); n != expected {
+			t.Errorf("case=%d: failed number of stored files=%d", i, n)
+		}
+	}
+}
+
+func TestUploadAPIHandler_CategoryTTL(t *testing.T) {
+	cases := []struct {
+		contentType string
+		ttl         string
+		code        int
+		expected    time.Duration
+	}{
+		{contentType: "image/png", code: http.StatusCreated, expected: time.Hour},
+		{contentType: "image/png", ttl: "600", code: http.StatusCreated, expected: 10 * time.Minute},
+		{contentType: "image/png", ttl: "7201", code: http.StatusBadRequest},
+		{contentType: "application/zip", code: http.StatusCreated, expected: 2 * time.Hour},
+		{contentType: "application/zip", ttl: "86400", code: http.StatusCreated, expected: 24 * time.Hour},
+		{contentType: "application/pdf", code: http.StatusBadRequest},
+		{contentType: "application/pdf", ttl: "86400", code: http.StatusCreated, expected: 24 * time.Hour},
+		{contentType: "", code: http.StatusBadRequest}, // text
+	}
+	for i, c := range cases {
+		params := memoryParams(t, encrypt.NewMemoryStorage())
+		fields := map[string]string{"times": "1"}
+		if c.ttl != "" {
+			fields["ttl"] = c.ttl
+		}
+		fileName := "test"
+		if c.contentType == "" {
+			fields["text"], fileName = "text", ""
+		}
+		r := multipartRequest(t, fields, fileName, c.contentType, "file content")
+		w := httptest.NewRecorder()
+		p := params(r)
+		p.Settings.TTL = 86400
+		p.Settings.CategoryTTLs = map[string]cfg.CategoryTTL{
+			CategoryImage:   {Default: 3600, Max: 7200},
+			CategoryArchive: {Default: 7200},
+		}
+		start := time.Now()
+		if code := Main(r.Context(), w, p); code != c.code {
+			t.Errorf("case=%d: failed code=%d: %s", i, code, w.Body.String())
+			continue
+		}
+		if c.code != http.StatusCreated {
+			continue
+		}
+		data := &UploadData{}
+		if err := json.NewDecoder(w.Body).Decode(data); err != nil {
+			t.Fatal(err)
+		}
+		if ttl := data.ExpiresAt.Sub(start).Round(time.Minute); ttl != c.expected {
+			t.Errorf("case=%d: failed ttl=%v", i, ttl)
+		}
+	}
+}
+
+func TestUploadAPIHandler_Reservation(t *testing.T) {
+	cases := []struct {
+		fields map[string]string
+		code   int
+	}{
+		{fields: map[string]string{"ttl": "0", "times": "1"}, code: http.StatusBadRequest},
+		{fields: map[string]string{"ttl": "600", "times": "0"}, code: http.StatusBadRequest},
+		{fields: map[string]string{"ttl": "600", "times": "1", "hint": strings.Repeat("a", maxHintLength+1)}, code: http.StatusBadRequest},
+		{fields: map[string]string{"ttl": "600", "times": "1", "allowed_ips": "bad"}, code: http.StatusBadRequest},
+		{fields: map[string]string{"ttl": "600", "times": "1", "checksum": "sha256:" + strings.Repeat("0", 64)}, code: http.StatusUnprocessableEntity},
+	}
+	for i, c := range cases {
+		files := encrypt.NewMemoryStorage()
+		params := memoryParams(t, files)
+		r := multipartRequest(t, c.fields, "test.txt", "", "file content")
+		w := httptest.NewRecorder()
+		p := params(r)
+		if code := Main(r.Context(), w, p); code != c.code {
+			t.Errorf("case=%d: failed code=%d: %s", i, code, w.Body.String())
+		}
+		if n := files.Len(); n != 0 {
+			t.Errorf("case=%d: failed number of stored files=%d", i, n)
+		}
+		if s := p.Storage.String(); !strings.Contains(s, "limit=0/") {
+			t.Errorf("case=%d: storage reservation is not released: %s", i, s)
+		}
+	}
+}
+
+func TestUploadAPIHandler_Digest(t *testing.T) {
+	form := multipartRequest(t, map[string]string{"text": "text", "ttl": "600", "times": "1"}, "", "", "")
+	content, err := io.ReadAll(form.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(content)
+	corrupted := sha256.Sum256(append([]byte("x"), content...))
+	md5Sum := md5.Sum(content)
+	cases := []struct {
+		headers map[string]string
+		code    int
+	}{
+		{code: http.StatusCreated},
+		{headers: map[string]string{"Digest": "sha-256=" + base64.StdEncoding.EncodeToString(sum[:])}, code: http.StatusCreated},
+		{headers: map[string]string{"Digest": "md5=abc, SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])}, code: http.StatusCreated},
+		{headers: map[string]string{"Content-MD5": base64.StdEncoding.EncodeToString(md5Sum[:])}, code: http.StatusCreated},
+		{headers: map[string]string{"Digest": "sha-256=" + base64.StdEncoding.EncodeToString(corrupted[:])}, code: http.StatusBadRequest},
+		{headers: map[string]string{"Content-MD5": base64.StdEncoding.EncodeToString(corrupted[:16])}, code: http.StatusBadRequest},
+		{headers: map[string]string{"Digest": "sha-512=abc"}, code: http.StatusBadRequest},
+		{headers: map[string]string{"Digest": "sha-256=bad"}, code: http.StatusBadRequest},
+	}
+	params := memoryParams(t, encrypt.NewMemoryStorage())
+	for i, c := range cases {
+		r := httptest.NewRequest("POST", "/api/upload", bytes.NewReader(content))
+		r.Header.Set("Content-Type", form.Header.Get("Content-Type"))
+		for name, value := range c.headers {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		if code := Main(r.Context(), w, params(r)); code != c.code {
+			t.Errorf("case=%d: failed code=%d: %s", i, code, w.Body.String())
+		}
+	}
+}
+
+func TestMain_SignedURLs(t *testing.T) {
+	const password = "secret"
+	params := memoryParams(t, encrypt.NewMemoryStorage())
+	r := postForm("/api/upload", url.Values{"text": {"text"}, "ttl": {"600"}, "times": {"5"}, "password": {password}})
+	w := httptest.NewRecorder()
+	if code := Main(r.Context(), w, params(r)); code != http.StatusCreated {
+		t.Fatalf("failed upload code=%d: %s", code, w.Body.String())
+	}
+	data := &UploadData{}
+	if err := json.NewDecoder(w.Body).Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	key := path.Base(data.URL)
+	settings := &cfg.Settings{Salt: "salt"}
+	expires := time.Now().Add(time.Minute).Unix()
+	signature := settings.SignKey(key, expires)
+	expired := time.Now().Add(-time.Minute).Unix()
+	// signature with modified first hex digit
+	invalid := "0" + signature[1:]
+	if signature[0] == '0' {
+		invalid = "1" + signature[1:]
+	}
+	cases := []struct {
+		signed bool
+		values url.Values
+		code   int
+	}{
+		{code: http.StatusOK},
+		{signed: true, code: http.StatusForbidden},
+		{signed: true, values: url.Values{"expires": {fmt.Sprint(expires)}, "signature": {signature}}, code: http.StatusOK},
+		{signed: true, values: url.Values{"expires": {fmt.Sprint(expires + 1)}, "signature": {signature}}, code: http.StatusForbidden},
+		{signed: true, values: url.Values{"expires": {fmt.Sprint(expires)}, "signature": {invalid}}, code: http.StatusForbidden},
+		{
+			signed: true,
+			values: url.Values{"expires": {fmt.Sprint(expired)}, "signature": {settings.SignKey(key, expired)}},
+			code:   http.StatusForbidden,
+		},
+	}
+	for i, c := range cases {
+		// download page
+		r = httptest.NewRequest("GET", "/"+key+"?"+c.values.Encode(), nil)
+		w = httptest.NewRecorder()
+		p := params(r)
+		p.Settings.SignedURLs, p.Settings.Salt = c.signed, settings.Salt
+		if code := Main(r.Context(), w, p); code != c.code {
+			t.Errorf("case=%d: failed download page code=%d", i, code)
+		}
+		if c.signed && c.code == http.StatusOK && !strings.Contains(w.Body.String(), signature) {
+			t.Errorf("case=%d: signature is not passed to the form", i)
+		}
+		// text
+		values := url.Values{"key": {key}, "password": {password}}
+		for name, value := range c.values {
+			values[name] = value
+		}
+		r = postForm("/api/text", values)
+		w = httptest.NewRecorder()
+		p = params(r)
+		p.Settings.SignedURLs, p.Settings.Salt = c.signed, settings.Salt
+		if code := Main(r.Context(), w, p); code != c.code {
+			t.Errorf("case=%d: failed text code=%d", i, code)
+		}
+	}
+}
+
+func TestMain_Namespaces(t *testing.T) {
+	params := memoryParams(t, encrypt.NewMemoryStorage())
+	request := func(r *http.Request, ns string) (int, *httptest.ResponseRecorder) {
+		if ns != "" {
+			r.Header.Set(namespaceHeader, ns)
+		}
+		w := httptest.NewRecorder()
+		p := params(r)
+		p.Settings.Namespaces = []string{"team-a", "team-b"}
+		return Main(r.Context(), w, p), w
+	}
+	code, w := request(postForm("/api/upload", url.Values{"text": {"some text"}, "ttl": {"3600"}, "times": {"1"}, "password": {"secret"}}), "team-a")
+	if code != http.StatusCreated {
+		t.Fatalf("failed upload code=%d: %s", code, w.Body.String())
+	}
+	data := &UploadData{}
+	if err := json.NewDecoder(w.Body).Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	key := data.Key()
+	cases := []struct {
+		ns   string
+		code int
+	}{
+		{ns: "team-c", code: http.StatusBadRequest},
+		{ns: "", code: http.StatusNotFound},
+		{ns: "team-b", code: http.StatusNotFound},
+		{ns: "team-a", code: http.StatusOK},
+	}
+	for i, c := range cases {
+		code, w = request(postForm("/api/text", url.Values{"key": {key}, "password": {"secret"}}), c.ns)
+		if code != c.code {
+			t.Errorf("case=%d: failed code=%d: %s", i, code, w.Body.String())
+		}
+	}
+}
+
+func TestMain_NoStore(t *testing.T) {
+	params := memoryParams(t, encrypt.NewMemoryStorage())
+	r := postForm("/api/upload", url.Values{"text": {"some text"}, "ttl": {"3600"}, "times": {"2"}, "password": {"secret"}})
+	w := httptest.NewRecorder()
+	if code := Main(r.Context(), w, params(r)); code != http.StatusCreated {
+		t.Fatalf("failed upload code=%d: %s", code, w.Body.String())
+	}
+	data := &UploadData{}
+	if err := json.NewDecoder(w.Body).Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	key := data.Key()
+	requests := []*http.Request{
+		httptest.NewRequest("GET", "/"+key, nil),
+		postForm("/api/text", url.Values{"key": {key}, "password": {"secret"}}),
+		postForm("/api/text", url.Values{"key": {key}, "password": {"wrong"}}),
+		postForm("/file", url.Values{"key": {key}, "password": {"secret"}}),
+		httptest.NewRequest("GET", "/"+uuid.New().String(), nil),
+	}
+	for i, r := range requests {
+		w = httptest.NewRecorder()
+		Main(r.Context(), w, params(r))
+		h := w.Header()
+		if h.Get("Cache-Control") != "no-store, no-cache" || h.Get("Pragma") != "no-cache" || h.Get("Expires") != "0" {
+			t.Errorf("case=%d: failed cache headers: %v", i, h)
+		}
+	}
+}
+
+func TestUploadAPIHandler_TextInlineLimit(t *testing.T) {
+	files := encrypt.NewMemoryStorage()
+	params := memoryParams(t, files)
+	cases := []struct {
+		text  string
+		files int
+	}{
+		{text: strings.Repeat("a", 1024), files: 0},
+		{text: strings.Repeat("b", 1025), files: 1},
+		{text: strings.Repeat("\"q\" \\ <\u0442\u0435\u043a\u0441\u0442>\n\t\r\x01", 100), files: 2},
+	}
+	for i, c := range cases {
+		r := postForm("/api/upload", url.Values{"text": {c.text}, "ttl": {"3600"}, "times": {"1"}, "password": {"secret"}})
+		w := httptest.NewRecorder()
+		p := params(r)
+		p.Settings.TextInlineLimit = 1
+		if code := Main(r.Context(), w, p); code != http.StatusCreated {
+			t.Fatalf("case=%d: failed upload code=%d: %s", i, code, w.Body.String())
+		}
+		data := &UploadData{}
+		if err := json.NewDecoder(w.Body).Decode(data); err != nil {
+			t.Fatal(err)
+		}
+		if n := files.Len(); n != c.files {
+			t.Errorf("case=%d: failed number of stored files=%d", i, n)
+		}
+		r = postForm("/api/text", url.Values{"key": {data.Key()}, "password": {"secret"}})
+		w = httptest.NewRecorder()
+		if code := Main(r.Context(), w, params(r)); code != http.StatusOK {
+			t.Fatalf("case=%d: failed text code=%d: %s", i, code, w.Body.String())
+		}
+		textMeta := &TextMeta{}
+		if err := json.NewDecoder(w.Body).Decode(textMeta); err != nil {
+			t.Fatal(err)
+		}
+		if textMeta.Text != c.text {
+			t.Errorf("case=%d: failed text length=%d", i, len(textMeta.Text))
+		}
+	}
+}
+
+func TestUploadAPIHandler_MaxTextSize(t *testing.T) {
+	params := memoryParams(t, encrypt.NewMemoryStorage())
+	cases := []struct {
+		text string
+		code int
+	}{
+		{text: strings.Repeat("a", 1024), code: http.StatusCreated},
+		{text: strings.Repeat("a", 1025), code: http.StatusRequestEntityTooLarge},
+	}
+	for i, c := range cases {
+		r := postForm("/api/upload", url.Values{"text": {c.text}, "ttl": {"3600"}, "times": {"1"}})
+		w := httptest.NewRecorder()
+		p := params(r)
+		p.Settings.MaxTextSize = 1
+		if code := Main(r.Context(), w, p); code != c.code {
+			t.Errorf("case=%d: failed code=%d: %s", i, code, w.Body.String())
+		}
+	}
+}
+
+func TestUploadAPIHandler_Throttled(t *testing.T) {
+	params := memoryParams(t, encrypt.NewMemoryStorage())
+	values := url.Values{"text": {"some text"}, "ttl": {"3600"}, "times": {"1"}}
+	r := postForm("/api/upload", values)
+	p := params(r)
+	p.Storage.HighMark, p.Storage.LowMark = 1, 0
+	dir, err := p.Storage.Place(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	if code := Main(r.Context(), w, p); code != http.StatusServiceUnavailable {
+		t.Fatalf("failed code=%d: %s", code, w.Body.String())
+	}
+	if retry := w.Header().Get("Retry-After"); retry != strconv.Itoa(p.Settings.GC) {
+		t.Errorf("failed Retry-After=%q", retry)
+	}
+	p.Storage.Release(dir, 1<<20)
+	r = postForm("/api/upload", values)
+	w = httptest.NewRecorder()
+	if code := Main(r.Context(), w, params(r)); code != http.StatusCreated {
+		t.Errorf("failed code=%d: %s", code, w.Body.String())
+	}
+}
+
+func TestUploadAPIHandler_HideSize(t *testing.T) {
+	params := memoryParams(t, encrypt.NewMemoryStorage())
+	publicParams := func(r *http.Request) *Params {
+		p := params(r)
+		p.Settings.PublicFileInfo = true
+		return p
+	}
+	for _, hide := range []bool{false, true} {
+		fields := map[string]string{"ttl": "600", "times": "1", "hide_size": strconv.FormatBool(hide)}
+		key := uploadMultipart(t, publicParams, fields, "test.txt", "file content")
+		r := httptest.NewRequest("GET", "/"+key, nil)
+		item, err := db.Exists(r.Context(), params(r).DB, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fi, err := DecodeInfo(item.FileInfo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (fi.Size == 0) != hide || fi.ContentType == "" {
+			t.Errorf("failed file info for hide=%v: %+v", hide, fi)
+		}
+		w := httptest.NewRecorder()
+		if code := Main(r.Context(), w, params(r)); code != http.StatusOK {
+			t.Fatalf("failed download page code=%d", code)
+		}
+		if strings.Contains(w.Body.String(), "12 B") == hide {
+			t.Errorf("failed file size on download page for hide=%v", hide)
+		}
+	}
+}
+
+// interruptedRecorder is a response recorder which fails body writes as a broken connection.
+type interruptedRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (interruptedRecorder) Write([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestFileHandler_Resume(t *testing.T) {
+	const (
+		password    = "secret"
+		fileContent = "file content"
+	)
+	params := memoryParams(t, encrypt.NewMemoryStorage())
+	fields := map[string]string{"ttl": "600", "times": "1", "password": password}
+	key := uploadMultipart(t, params, fields, "test.txt", fileContent)
+	download := func(w http.ResponseWriter, cookies ...*http.Cookie) int {
+		r := postForm("/file", url.Values{"key": {key}, "password": {password}})
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		p := params(r)
+		p.Settings.ResumeWindow = 60
+		return Main(r.Context(), w, p)
+	}
+	interrupted := interruptedRecorder{httptest.NewRecorder()}
+	if code := download(interrupted); code != http.StatusOK {
+		t.Fatalf("failed code=%d", code)
+	}
+	cookies := interrupted.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != resumeCookiePrefix+key || !cookies[0].HttpOnly {
+		t.Fatalf("failed cookies: %v", cookies)
+	}
+	// the only attempt is reserved for the interrupted download
+	if code := download(httptest.NewRecorder()); code != http.StatusNotFound {
+		t.Errorf("failed code=%d", code)
+	}
+	w := httptest.NewRecorder()
+	if code := download(w, cookies[0]); code != http.StatusOK {
+		t.Fatalf("failed resume code=%d: %s", code, w.Body.String())
+	}
+	if s := w.Body.String(); s != fileContent {
+		t.Errorf("failed file content=%s", s)
+	}
+	// the attempt is used after the successful download
+	if code := download(httptest.NewRecorder(), cookies[0]); code != http.StatusNotFound {
+		t.Errorf("failed code=%d", code)
+	}
+}
+
+func TestDownloadHandler_NormalizeKeys(t *testing.T) {
+	const password = "secret"
+	params := memoryParams(t, encrypt.NewMemoryStorage())
+	r := postForm("/api/upload", url.Values{"text": {"text"}, "ttl": {"600"}, "times": {"5"}, "password": {password}})
+	w := httptest.NewRecorder()
+	if code := Main(r.Context(), w, params(r)); code != http.StatusCreated {
+		t.Fatalf("failed upload code=%d: %s", code, w.Body.String())
+	}
+	data := &UploadData{}
+	if err := json.NewDecoder(w.Body).Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	key := path.Base(data.URL)
+	upperKey := strings.ToUpper(key)
+	cases := []struct {
+		target    string
+		normalize bool
+		code      int
+	}{
+		{target: "/" + key + "/", code: http.StatusOK},
+		{target: "/" + upperKey, code: http.StatusNotFound},
+		{target: "/" + upperKey, normalize: true, code: http.StatusOK},
+		{target: "/" + upperKey + "/", normalize: true, code: http.StatusOK},
+		{target: "/" + strings.ReplaceAll(key, "-", ""), normalize: true, code: http.StatusOK},
+		{target: "/" + key + "/extra", normalize: true, code: http.StatusNotFound},
+	}
+	for i, c := range cases {
+		r = httptest.NewRequest("GET", c.target, nil)
+		w = httptest.NewRecorder()
+		p := params(r)
+		p.Settings.NormalizeKeys = c.normalize
+		if code := Main(r.Context(), w, p); code != c.code {
+			t.Errorf("case=%d: failed code=%d", i, code)
+		}
+		if c.code == http.StatusOK && !strings.Contains(w.Body.String(), key) {
+			t.Errorf("case=%d: no normalized key on the page", i)
+		}
+	}
+	// reading form value is normalized too
+	r = postForm("/api/text", url.Values{"key": {upperKey}, "password": {password}})
+	w = httptest.NewRecorder()
+	p := params(r)
+	p.Settings.NormalizeKeys = true
+	if code := Main(r.Context(), w, p); code != http.StatusOK {
+		t.Errorf("failed text code=%d: %s", code, w.Body.String())
+	}
+}
@@ -22,6 +22,9 @@ function successText(data, withFile) {
         const method = "LoadFile('" + data.file.name + "')";
         content += "<h4>Download file</h4><a href=\"#\" onclick=\"" + method + "\">" + data.file.name + "</a>&nbsp;";
         content += HumanSize(data.file.size);
+        if (data.file.checksum) {
+            content += "<br><small>SHA-256: <code>" + data.file.checksum + "</code></small>";
+        }
     }
     return content;
 }
@@ -31,6 +34,10 @@ function LoadText(form, withFile) {
     let formData = new FormData();
     formData.append("key", form.key.value);
     formData.append("password", form.password.value);
+    if (form.signature) {
+        formData.append("expires", form.expires.value);
+        formData.append("signature", form.signature.value);
+    }
 
     const myInit = {method: form.method, cache: 'no-store', body: formData}
     const t = document.getElementById("text_container_id");
@@ -55,6 +62,10 @@ function LoadFile(fileName) {
     let formData = new FormData();
     formData.append("key", form.key.value);
     formData.append("password", form.password.value);
+    if (form.signature) {
+        formData.append("expires", form.expires.value);
+        formData.append("signature", form.signature.value);
+    }
     formData.append("ajax", "true");
 
     const t = document.getElementById("file_container_id");
@@ -80,3 +91,32 @@ function LoadFile(fileName) {
         });
     return false;
 }
+
+function Confirm(form) {
+    let myRequest = new Request(form.action);
+    let formData = new FormData();
+    formData.append("key", form.key.value);
+    formData.append("token", form.token.value);
+
+    const myInit = {method: form.method, cache: 'no-store', body: formData}
+    const t = document.getElementById("confirm_container_id");
+    fetch(myRequest, myInit)
+        .then(response => response.json())
+        .then(result => {
+            if (result.error === undefined) {
+                t.innerHTML = "<div class='alert alert-success'>The link is active.</div>";
+            } else {
+                t.innerHTML = "<div class='alert alert-danger'>" + result.error + "</div>";
+            }
+        })
+        .catch(error => {
+            t.innerHTML = "<div class='alert alert-danger'>internal error</div>";
+        });
+    return false;
+}
+
+function Reveal(containerId, button) {
+    document.getElementById(containerId).hidden = false;
+    button.hidden = true;
+    return false;
+}
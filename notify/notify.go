@@ -0,0 +1,162 @@
+package notify
+
+// Package notify sends email notifications with shared links to recipients.
+// Passwords are never sent, so recipients should get them by another channel.
+
+import (
+	"errors"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/z0rr0/send/logging"
+)
+
+// limitPeriod is a period of notifications rate limit.
+const limitPeriod = time.Hour
+
+// maxLimitKeys is a number of senders after which old limits are cleaned.
+const maxLimitKeys = 1024
+
+var (
+	// ErrAddress is an error when email address is incorrect.
+	ErrAddress = errors.New("incorrect email address")
+	// ErrLimit is an error when sender's notifications limit is reached.
+	ErrLimit = errors.New("notifications limit is reached")
+)
+
+// sendFunc is a signature of smtp.SendMail.
+type sendFunc func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+
+// window is a number of notifications of one sender since start.
+type window struct {
+	start time.Time
+	n     int
+}
+
+// Limiter limits a number of notifications per sender during an hour.
+type Limiter struct {
+	limit   int
+	senders map[string]*window
+	m       sync.Mutex
+}
+
+// NewLimiter returns a new limiter with max limit notifications per hour.
+func NewLimiter(limit int) *Limiter {
+	return &Limiter{limit: limit, senders: make(map[string]*window)}
+}
+
+// clean removes expired windows, it should be called under the mutex.
+func (l *Limiter) clean(now time.Time) {
+	for sender, w := range l.senders {
+		if now.Sub(w.start) >= limitPeriod {
+			delete(l.senders, sender)
+		}
+	}
+}
+
+// Allow returns true and counts the notification if the sender's limit is not reached.
+func (l *Limiter) Allow(sender string) bool {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	now := time.Now()
+	if len(l.senders) >= maxLimitKeys {
+		l.clean(now)
+	}
+	w, ok := l.senders[sender]
+	if !ok || now.Sub(w.start) >= limitPeriod {
+		w = &window{start: now}
+		l.senders[sender] = w
+	}
+	if w.n >= l.limit {
+		return false
+	}
+	w.n++
+	return true
+}
+
+// Mailer sends notifications using SMTP server.
+type Mailer struct {
+	addr    string
+	from    string
+	auth    smtp.Auth
+	limiter *Limiter
+	send    sendFunc
+	wg      sync.WaitGroup
+}
+
+// New returns a new Mailer. Plain authentication is used only if the user is set,
+// it requires TLS connection for not local servers.
+func New(host string, port int, user, password, from string, limit int) *Mailer {
+	m := &Mailer{
+		addr:    net.JoinHostPort(host, strconv.Itoa(port)),
+		from:    from,
+		limiter: NewLimiter(limit),
+		send:    smtp.SendMail,
+	}
+	if user != "" {
+		m.auth = smtp.PlainAuth("", user, password, host)
+	}
+	return m
+}
+
+// ParseAddress validates email address, only a bare address without a name is allowed.
+func ParseAddress(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", ErrAddress
+	}
+	return addr.Address, nil
+}
+
+// Allow checks the rate limit of the sender identified by its IP address.
+func (m *Mailer) Allow(ip string) error {
+	if !m.limiter.Allow(ip) {
+		return ErrLimit
+	}
+	return nil
+}
+
+// message returns email message with the link.
+func message(from, to, link string) []byte {
+	lines := []string{
+		"From: " + from,
+		"To: " + to,
+		"Subject: Shared data for you",
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		"Somebody shared data with you, it is available by the link:",
+		link,
+		"",
+		"The link has limited lifetime and number of attempts.",
+		"The password is not sent by email, ask the sender for it.",
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// Notify sends the link to the recipient asynchronously, errors are only logged.
+func (m *Mailer) Notify(to, link string, l *logging.Log) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		err := m.send(m.addr, m.auth, m.from, []string{to}, message(m.from, to, link))
+		if err != nil {
+			l.Error("notification sending by smtp %s: %v", m.addr, err)
+			return
+		}
+		l.Info("notification is sent")
+	}()
+}
+
+// Wait waits for all started notifications.
+func (m *Mailer) Wait() {
+	m.wg.Wait()
+}
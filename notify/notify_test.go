@@ -0,0 +1,86 @@
+package notify
+
+import (
+	"errors"
+	"net/smtp"
+	"strings"
+	"testing"
+
+	"github.com/z0rr0/send/logging"
+)
+
+func TestParseAddress(t *testing.T) {
+	cases := []struct {
+		email    string
+		expected string
+		fail     bool
+	}{
+		{email: "user@example.com", expected: "user@example.com"},
+		{email: " user@example.com ", expected: "user@example.com"},
+		{email: "User <user@example.com>", fail: true},
+		{email: "user@example.com\r\nBcc: other@example.com", fail: true},
+		{email: "user", fail: true},
+		{email: "", fail: true},
+	}
+	for i, c := range cases {
+		email, err := ParseAddress(c.email)
+		if c.fail {
+			if !errors.Is(err, ErrAddress) {
+				t.Errorf("case=%d: unexpected error: %v", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case=%d: unexpected error: %v", i, err)
+			continue
+		}
+		if email != c.expected {
+			t.Errorf("case=%d: failed email=%s", i, email)
+		}
+	}
+}
+
+func TestLimiter_Allow(t *testing.T) {
+	l := NewLimiter(2)
+	for i := 0; i < 2; i++ {
+		if !l.Allow("127.0.0.1") {
+			t.Errorf("attempt=%d is not allowed", i)
+		}
+	}
+	if l.Allow("127.0.0.1") {
+		t.Error("limit is not applied")
+	}
+	if !l.Allow("127.0.0.2") {
+		t.Error("another sender is not allowed")
+	}
+}
+
+func TestMailer_Notify(t *testing.T) {
+	const link = "https://localhost/key"
+	var (
+		recipients []string
+		msg        string
+	)
+	m := New("localhost", 25, "", "", "send@localhost", 1)
+	m.send = func(addr string, a smtp.Auth, from string, to []string, b []byte) error {
+		if addr != "localhost:25" || a != nil || from != "send@localhost" {
+			t.Errorf("failed send params: %s, %v, %s", addr, a, from)
+		}
+		recipients, msg = to, string(b)
+		return nil
+	}
+	if err := m.Allow("127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Allow("127.0.0.1"); !errors.Is(err, ErrLimit) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	m.Notify("user@example.com", link, logging.New("test"))
+	m.Wait()
+	if len(recipients) != 1 || recipients[0] != "user@example.com" {
+		t.Errorf("failed recipients: %v", recipients)
+	}
+	if !strings.Contains(msg, "\r\n\r\n") || !strings.Contains(msg, link) || !strings.Contains(msg, "To: user@example.com") {
+		t.Errorf("failed message: %q", msg)
+	}
+}
@@ -0,0 +1,74 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/z0rr0/send/logging"
+)
+
+// webhookTimeout is a timeout of one webhook request.
+const webhookTimeout = 10 * time.Second
+
+// webhook events
+const (
+	EventExpired  = "expired"  // item expired without full reading
+	EventConsumed = "consumed" // all item's attempts were used
+)
+
+// Event is a webhook notification about an item deleted by the garbage collector.
+type Event struct {
+	Key   string `json:"key"`
+	Event string `json:"event"`
+}
+
+// Webhook sends events of deleted items to the configured URL.
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewWebhook returns a new webhook sender, it is nil if url is empty.
+func NewWebhook(url string) *Webhook {
+	if url == "" {
+		return nil
+	}
+	return &Webhook{URL: url, Client: &http.Client{Timeout: webhookTimeout}}
+}
+
+// Send posts events as a JSON array, any response status except 2xx is an error.
+func (wh *Webhook) Send(ctx context.Context, events []Event) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return fmt.Errorf("webhook encode: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", wh.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := wh.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	if err = resp.Body.Close(); err != nil {
+		return fmt.Errorf("webhook response close: %w", err)
+	}
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("webhook failed status=%d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Notify sends events and logs errors by l, it is used by the garbage collector without a client.
+func (wh *Webhook) Notify(events []Event, l *logging.Log) {
+	if err := wh.Send(context.Background(), events); err != nil {
+		l.Error("failed expiry webhook for %d item(s): %v", len(events), err)
+		return
+	}
+	l.Info("expiry webhook sent for %d item(s)", len(events))
+}
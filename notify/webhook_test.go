@@ -0,0 +1,43 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewWebhook(t *testing.T) {
+	if wh := NewWebhook(""); wh != nil {
+		t.Error("webhook is not nil for empty URL")
+	}
+}
+
+func TestWebhook_Send(t *testing.T) {
+	var received []Event
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("failed request: %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	wh := NewWebhook(server.URL)
+	events := []Event{{Key: "a", Event: EventExpired}, {Key: "b", Event: EventConsumed}}
+	if err := wh.Send(context.Background(), events); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 2 || received[0] != events[0] || received[1] != events[1] {
+		t.Errorf("failed received events: %+v", received)
+	}
+	status = http.StatusInternalServerError
+	if err := wh.Send(context.Background(), events); err == nil {
+		t.Error("expected error for failed status")
+	}
+}
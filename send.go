@@ -12,13 +12,17 @@ import (
 	"os/signal"
 	"runtime"
 	"runtime/debug"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/z0rr0/send/cfg"
+	"github.com/z0rr0/send/client"
 	"github.com/z0rr0/send/db"
+	"github.com/z0rr0/send/encrypt"
 	"github.com/z0rr0/send/handle"
 	"github.com/z0rr0/send/logging"
+	"github.com/z0rr0/send/notify"
 )
 
 const (
@@ -26,6 +30,8 @@ const (
 	Name = "Send"
 	// Config is default configuration file name.
 	Config = "config.toml"
+	// ServerEnv is environment variable name with server URL for uploading from the terminal.
+	ServerEnv = "SEND_SERVER"
 )
 
 var (
@@ -48,6 +54,92 @@ func versionInfo(ver *handle.Version) string {
 	return fmt.Sprintf("%s\n%s", Name, ver.String())
 }
 
+// securityHeaders is a middleware that adds security headers to web and API responses.
+func securityHeaders(h http.Handler, web, api map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers := web
+		if strings.HasPrefix(r.URL.Path, "/api") {
+			headers = api
+		}
+		for name, value := range headers {
+			w.Header().Set(name, value)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// compressResponses is a middleware that compresses responses by gzip if the client accepts it.
+func compressResponses(h http.Handler, logger *logging.Log) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == "HEAD" || !handle.AcceptsGzip(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		gw := handle.NewGzipWriter(w)
+		defer func() {
+			if err := gw.Close(); err != nil {
+				logger.Error("gzip response close: %v", err)
+			}
+		}()
+		h.ServeHTTP(gw, r)
+	})
+}
+
+// expiryHook returns GC hook which sends keys of deleted items to the webhook, it is nil if webhook is not set.
+func expiryHook(wh *notify.Webhook, logger *logging.Log) db.DeleteHook {
+	if wh == nil {
+		return nil
+	}
+	return func(deleted []db.Deleted) {
+		events := make([]notify.Event, len(deleted))
+		for i, item := range deleted {
+			events[i] = notify.Event{Key: item.Key, Event: notify.EventExpired}
+			if item.Consumed {
+				events[i].Event = notify.EventConsumed
+			}
+		}
+		wh.Notify(events, logger)
+	}
+}
+
+// upload sends the file or text to the server and prints the link and password.
+// It returns a process exit code.
+func upload(server string, u *client.Upload) int {
+	if server == "" {
+		fmt.Fprintf(os.Stderr, "server URL is not set, use -server flag or %s environment variable\n", ServerEnv)
+		return 2
+	}
+	c := &client.Client{Server: server, Progress: os.Stderr}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	result, err := c.Upload(ctx, u)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("url: %s\npassword: %s\n", result.URL, result.Password)
+	return 0
+}
+
+// purge expires all items of the namespace, GC deletes them after the server start.
+func purge(c *cfg.Config, namespace string) int {
+	defer func() {
+		if e := c.Close(); e != nil {
+			fmt.Fprintln(os.Stderr, e)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout())
+	defer cancel()
+	n, err := db.Purge(ctx, c.Storage.Db, namespace)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("purged items: %d\n", n)
+	return 0
+}
+
 func main() {
 	defer func() {
 		if r := recover(); r != nil {
@@ -57,8 +149,22 @@ func main() {
 	version := flag.Bool("version", false, "show version")
 	config := flag.String("config", Config, "configuration file")
 	logFile := flag.String("log", "", "log file name (default stdout)")
+	// client mode flags
+	uploadMode := flag.Bool("upload", false, "upload data to the server and exit")
+	server := flag.String("server", os.Getenv(ServerEnv), "server URL for uploading (default $"+ServerEnv+")")
+	file := flag.String("file", "", "file to upload")
+	text := flag.String("text", "", "text to upload")
+	ttl := flag.Duration("ttl", time.Hour, "time to live of uploaded data")
+	times := flag.Int("times", 1, "number of reading attempts of uploaded data")
+	password := flag.String("password", "", "password of uploaded data (generated by the server if empty)")
+	// admin flags
+	purgeNamespace := flag.String("purge", "", "expire all items of the namespace and exit")
 	flag.Parse()
 
+	if *uploadMode {
+		os.Exit(upload(*server, &client.Upload{File: *file, Text: *text, TTL: *ttl, Times: *times, Password: *password}))
+	}
+
 	ver := &handle.Version{Version: Version, Revision: Revision, Build: BuildDate, Environment: GoVersion}
 	info := versionInfo(ver)
 	if *version {
@@ -81,11 +187,38 @@ func main() {
 		}()
 	}
 	logger := logging.New("main")
+	staticFS, err := fs.Sub(staticFiles, "html/static")
+	if err != nil {
+		panic(err)
+	}
 	// read config and check html templates
-	c, err := cfg.New(*config, &cfg.TemplateEntry{Dir: "html", Fs: tpls})
+	templates := &cfg.TemplateEntry{Dir: "html", Fs: tpls, Static: staticFS}
+	c, err := cfg.New(*config, templates)
+	if err != nil {
+		panic(err)
+	}
+	if *purgeNamespace != "" {
+		os.Exit(purge(c, *purgeNamespace))
+	}
+	staticFS, assets := c.StaticFiles()
+	encrypt.SetUpFiles(c.Storage.NameSize, c.Storage.NameAttempts, c.Storage.Mode())
+	ctx, cancel := context.WithTimeout(context.Background(), c.DbPeriod())
+	err = c.Storage.CleanUp(ctx, logger)
+	cancel()
 	if err != nil {
 		panic(err)
 	}
+	encrypt.SetUpBuffer(c.Storage.BufferBytes())
+	masterKey, err := c.MasterKey()
+	if err != nil {
+		panic(err)
+	}
+	encrypt.SetUpMaster(masterKey)
+	if err = encrypt.SetUpSuite(c.Settings.Cipher); err != nil {
+		panic(err)
+	}
+	encrypt.SetUpMetrics(c.Settings.Metrics, c.Settings.SlowKeyDuration(), logger)
+	db.SetUpSecret(c.Secret)
 	delItem := make(chan db.Item, 1) // to delete items after attempts expirations
 	defer func() {
 		if e := c.Close(); e != nil {
@@ -93,50 +226,92 @@ func main() {
 		}
 	}()
 	timeout := c.Timeout()
+	tlsConfig, err := c.TLS()
+	if err != nil {
+		panic(err)
+	}
+	webHeaders, apiHeaders := c.SecurityHeaders()
+	handler := securityHeaders(http.DefaultServeMux, webHeaders, apiHeaders)
+	if c.Settings.Compress {
+		handler = compressResponses(handler, logger)
+	}
 	srv := &http.Server{
-		Addr:           c.Addr(),
-		Handler:        http.DefaultServeMux,
-		ReadTimeout:    timeout,
-		WriteTimeout:   timeout,
-		MaxHeaderBytes: c.MaxFileSize(),
-		ErrorLog:       logging.ErrorLog(),
+		Addr:              c.Addr(),
+		Handler:           handler,
+		ReadTimeout:       timeout,
+		ReadHeaderTimeout: c.HeaderTimeout(),
+		WriteTimeout:      timeout,
+		MaxHeaderBytes:    c.MaxFileSize(),
+		ErrorLog:          logging.ErrorLog(),
+		TLSConfig:         tlsConfig,
 	}
 	logger.Info("\n%v\n%s\nlisten addr: %v", info, c.Storage.String(), srv.Addr)
-	staticFS, err := fs.Sub(staticFiles, "html/static")
-	if err != nil {
-		panic(err)
+	for _, warning := range c.Warnings() {
+		logger.Info("config warning: %s", warning)
+	}
+	if staticFS != nil {
+		http.Handle(cfg.StaticPrefix, handle.StaticHandler(staticFS, assets))
 	}
-	fileServer := http.FileServer(http.FS(staticFS))
-	http.Handle("/static/", http.StripPrefix("/static", fileServer))
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		start, code := time.Now(), http.StatusOK
+	updates := handle.NewUpdateChecker(c.Settings.UpdateCheckURL)
+	stats := handle.NewStatsCache()
+	mailer := c.Mailer()
+	http.HandleFunc("/", func(rw http.ResponseWriter, r *http.Request) {
+		start, w := time.Now(), handle.NewStatusWriter(rw)
 		reqLogger := logging.New("")
-		reqLogger.Info("request\t%s", r.URL.String())
+		if e := c.ReloadTemplates(); e != nil {
+			// previous valid templates are used
+			reqLogger.Error("%v", e)
+		}
+		settings := c.CurrentSettings()
+		if settings.SlowRequest == 0 {
+			reqLogger.Info("request\t%s", settings.LogURL(r.URL))
+		}
 		params := &handle.Params{
-			Log: reqLogger, DB: c.Storage.Db, Settings: &c.Settings, Request: r,
+			Log: reqLogger, DB: c.Storage.Db, Settings: settings, Request: r,
 			Version: ver, DelItem: delItem, Storage: &c.Storage, Secure: c.Server.Secure,
+			ClientAuth: c.ClientAuth(), Updates: updates, Stats: stats, Mailer: mailer,
 		}
 		r.BasicAuth()
 
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		var (
+			ctx    context.Context
+			cancel context.CancelFunc
+		)
+		if d := settings.HandlingTimeout(r.URL.Path, timeout); d > 0 {
+			ctx, cancel = context.WithTimeout(context.Background(), d)
+		} else {
+			ctx, cancel = context.WithCancel(context.Background())
+		}
+		code := http.StatusOK
 		defer func() {
-			var checkCode bool
-			if rc := recover(); rc != nil {
+			rc := recover()
+			if rc != nil {
 				reqLogger.Error("request panic: %v", rc)
-				code, checkCode = http.StatusInternalServerError, true
 				reqLogger.Error("stack:\n%v\n", string(debug.Stack()))
+				code = http.StatusInternalServerError
 			}
-			reqLogger.Info("%-5v %v\t%-12v\t%v", r.Method, code, time.Since(start), r.URL.String())
-			if checkCode && code == http.StatusInternalServerError {
+			// error response only if nothing was sent
+			if code >= http.StatusInternalServerError && !w.Written() {
 				if params.IsAPI() {
-					w.WriteHeader(code)
+					w.WriteHeader(http.StatusInternalServerError)
 					if _, e := fmt.Fprint(w, "{\"error\": \"internal error\"}"); e != nil {
 						reqLogger.Error("failed error response: %v", e)
 					}
 				} else {
-					http.Error(w, "internal error", code)
+					http.Error(w, "internal error", http.StatusInternalServerError)
 				}
 			}
+			// handler's error code is logged even if another status was already sent
+			status := w.Status()
+			if code > status {
+				status = code
+			}
+			if d := time.Since(start); rc != nil || settings.IsLoggedRequest(d, status) {
+				reqLogger.Info(
+					"%-5v %v\t%-12v\t%v\tin=%d out=%d",
+					r.Method, status, d, settings.LogURL(r.URL), r.ContentLength, w.Size(),
+				)
+			}
 			cancel()
 		}()
 		if params.IsAPI() {
@@ -145,9 +320,24 @@ func main() {
 		code = handle.Main(ctx, w, params)
 	})
 	// run GC monitoring
+	hook := expiryHook(notify.NewWebhook(c.Settings.ExpiryWebhook), logger)
 	gcShutdown := make(chan struct{}) // to close GC monitor
 	gcStopped := make(chan struct{})  // to wait GC stopping
-	go db.GCMonitor(delItem, gcShutdown, gcStopped, c.Storage.Db, c.GCPeriod(), c.DbPeriod(), logger)
+	go db.GCMonitor(delItem, gcShutdown, gcStopped, c.Storage.Db, c.GCPeriod(), c.DbPeriod(), c.GracePeriod(), c.VacuumPeriod(), c.Settings.GCBatch, hook, logger)
+
+	// reload settings by SIGHUP
+	go func() {
+		sighup := make(chan os.Signal, 1)
+		signal.Notify(sighup, syscall.SIGHUP)
+		for range sighup {
+			changes, e := c.Reload(*config, templates)
+			if e != nil {
+				logger.Error("config reload: %v", e)
+				continue
+			}
+			logger.Info("config reloaded: %s", changes.String())
+		}
+	}()
 
 	idleConnsClosed := make(chan struct{}) // to wait http server shutdown
 	go func() {
@@ -165,11 +355,28 @@ func main() {
 		close(idleConnsClosed)
 		close(gcShutdown)
 	}()
-	if e := srv.ListenAndServe(); e != http.ErrServerClosed {
-		logger.Error("HTTP server ListenAndServe: %v", e)
+	listener, err := c.Listen()
+	if err != nil {
+		panic(err)
+	}
+	if c.Server.MaxConns > 0 {
+		listener = handle.NewLimitListener(listener, c.Server.MaxConns, logging.New("listener"))
+	}
+	if tlsConfig != nil {
+		// certificates are already loaded to TLSConfig
+		err = srv.ServeTLS(listener, "", "")
+	} else {
+		err = srv.Serve(listener)
+	}
+	if err != http.ErrServerClosed {
+		logger.Error("HTTP server Serve: %v", err)
 	}
 	<-idleConnsClosed
 	<-gcStopped
 	close(delItem)
+	if mailer != nil {
+		// wait already started notifications
+		mailer.Wait()
+	}
 	logger.Info("service %v stopped", Name)
 }